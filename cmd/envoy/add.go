@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+var (
+	addNames  []string
+	addNotes  []string
+	addTags   []string
+	addRunTUI bool
+)
+
+// Returns the trimmed value paired with the i-th tracking number, if any
+func pairedValue(values []string, i int) string {
+	if i >= len(values) {
+		return ""
+	}
+	return strings.TrimSpace(values[i])
+}
+
+// Build parcels for the tracking numbers. Names and notes pair positionally
+// with the tracking numbers, and parcels without a name are named after their
+// tracking number. Every parcel is labeled with all of the tags. Numbers are
+// normalized and deduplicated, and any whose carrier cannot be detected are
+// rejected.
+func newAddedParcels(trackingNumbers, names, notes, tags []string) ([]*envoy.Parcel, error) {
+	if len(names) > len(trackingNumbers) {
+		return nil, fmt.Errorf("got %d names for %d tracking numbers", len(names), len(trackingNumbers))
+	}
+	if len(notes) > len(trackingNumbers) {
+		return nil, fmt.Errorf("got %d notes for %d tracking numbers", len(notes), len(trackingNumbers))
+	}
+
+	var (
+		parcels []*envoy.Parcel
+		unknown []string
+	)
+	seen := make(map[string]struct{})
+	for i, arg := range trackingNumbers {
+		tn := envoy.NormalizeTrackingNumber(arg)
+		if tn == "" {
+			continue
+		}
+		if _, ok := seen[tn]; ok {
+			continue
+		}
+		seen[tn] = struct{}{}
+
+		carrier := argCarrier(tn)
+		if carrier == envoy.CarrierUnknown {
+			unknown = append(unknown, tn)
+			continue
+		}
+		name := pairedValue(names, i)
+		if name == "" {
+			name = tn
+		}
+		p := envoy.NewParcel(name, carrier, tn, "")
+		p.Note = pairedValue(notes, i)
+		p.AddTags(tags...)
+		parcels = append(parcels, p)
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("could not detect carrier for %s", strings.Join(unknown, ", "))
+	}
+	return parcels, nil
+}
+
+// Persist parcels for the tracking numbers with their names, notes and tags,
+// printing a line for each. Nothing is stored if any number is rejected, and
+// parcels which are already stored are left as they are.
+func addParcels(w io.Writer, trackingNumbers, names, notes, tags []string) error {
+	parcels, err := newAddedParcels(trackingNumbers, names, notes, tags)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range parcels {
+		existing, err := getParcel(p.TrackingNumber)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			fmt.Fprintf(w, "%s: already tracked\n", p.TrackingNumber)
+			continue
+		}
+		if err := createParcel(p); err != nil {
+			return fmt.Errorf("could not add %s: %w", p.TrackingNumber, err)
+		}
+		fmt.Fprintf(w, "%s: added (%s)\n", p.TrackingNumber, p.Carrier)
+	}
+	return nil
+}
+
+func Add(cmd *cobra.Command, args []string) {
+	if err := addParcels(os.Stdout, args, addNames, addNotes, addTags); err != nil {
+		log.Fatalf("Error adding parcels: %v", err)
+	}
+}
+
+func AddAndRunTUI(cmd *cobra.Command, args []string) {
+	Add(cmd, args)
+	runTUI(groupByCarrier(args))
+}
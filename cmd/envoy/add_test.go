@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestAddParcels(t *testing.T) {
+	openTestDB(t)
+
+	var out bytes.Buffer
+	if err := addParcels(&out, []string{"1z5r 8939 0357 5671 27", "441259201412", "1Z5R89390357567127"}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1Z5R89390357567127: added (UPS)\n441259201412: added (FedEx)\n"; out.String() != want {
+		t.Errorf("Expected output %q, got %q", want, out.String())
+	}
+
+	p, err := getParcel("1Z5R89390357567127")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.Name != "1Z5R89390357567127" || p.Carrier != envoy.CarrierUPS {
+		t.Errorf("Expected UPS parcel named after its tracking number, got %+v", p)
+	}
+
+	t.Run("already stored", func(t *testing.T) {
+		p.Name = "Books"
+		if err := upsertParcel(p); err != nil {
+			t.Fatal(err)
+		}
+		out.Reset()
+		if err := addParcels(&out, []string{"1Z5R89390357567127"}, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "already tracked") {
+			t.Errorf("Expected parcel to be reported as tracked, got %q", out.String())
+		}
+		if p, _ := getParcel("1Z5R89390357567127"); p == nil || p.Name != "Books" {
+			t.Errorf("Expected stored parcel to be unchanged, got %+v", p)
+		}
+	})
+
+	t.Run("unknown carrier", func(t *testing.T) {
+		err := addParcels(&out, []string{"9400123456789012345674", "NOTATRACKINGNUMBER"}, nil, nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "NOTATRACKINGNUMBER") {
+			t.Fatalf("Expected an error naming the rejected number, got %v", err)
+		}
+		if p, _ := getParcel("9400123456789012345674"); p != nil {
+			t.Errorf("Expected nothing to be stored, got %+v", p)
+		}
+	})
+}
+
+func TestNewAddedParcelsNames(t *testing.T) {
+	parcels, err := newAddedParcels(
+		[]string{"1Z5R89390357567127", "441259201412", "9400123456789012345674"},
+		[]string{"New shoes", ""},
+		[]string{"", "Gift for mom"},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, 0, len(parcels))
+	for _, p := range parcels {
+		got = append(got, p.Name)
+	}
+	want := []string{"New shoes", "441259201412", "9400123456789012345674"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected names %q, got %q", want, got)
+	}
+
+	if parcels[0].Note != "" || parcels[1].Note != "Gift for mom" {
+		t.Errorf("Expected the note on the second parcel, got %q and %q", parcels[0].Note, parcels[1].Note)
+	}
+
+	if _, err := newAddedParcels([]string{"441259201412"}, []string{"Lamp", "Books"}, nil, nil); err == nil {
+		t.Error("Expected an error for more names than tracking numbers")
+	}
+	if _, err := newAddedParcels([]string{"441259201412"}, nil, []string{"Lamp", "Books"}, nil); err == nil {
+		t.Error("Expected an error for more notes than tracking numbers")
+	}
+}
+
+func TestAddParcelsTags(t *testing.T) {
+	openTestDB(t)
+
+	var out bytes.Buffer
+	if err := addParcels(&out, []string{"1Z5R89390357567127", "441259201412"}, nil, nil, []string{"Work", "gifts", "work"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tn := range []string{"1Z5R89390357567127", "441259201412"} {
+		p, err := getParcel(tn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(p.Tags, []string{"work", "gifts"}) {
+			t.Errorf("Expected %s to be tagged work and gifts, got %q", tn, p.Tags)
+		}
+	}
+}
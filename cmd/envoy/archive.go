@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+var (
+	lsArchived bool
+	lsStatus   string
+	lsTags     []string
+)
+
+// Report whether a delivered parcel has been delivered for at least the
+// delay, falling back to when it was last synced if it has no delivered
+// event
+func dueForArchive(p *envoy.Parcel, delay time.Duration, now time.Time) bool {
+	if !isDelivered(p) {
+		return false
+	}
+	at := p.DeliveredAt()
+	if at.IsZero() {
+		at = p.LastSyncedAt
+	}
+	return !at.IsZero() && now.Sub(at) >= delay
+}
+
+// Archive the stored parcels delivered at least the delay ago, returning how
+// many were archived. Archived parcels are kept, but no longer listed or
+// refreshed with the active ones.
+func sweepArchive(delay time.Duration, now time.Time) (int, error) {
+	parcels, err := fetchParcels()
+	if err != nil {
+		return 0, err
+	}
+
+	archived := 0
+	for _, p := range parcels {
+		if !dueForArchive(p, delay, now) {
+			continue
+		}
+		if err := archiveParcel(p); err != nil {
+			return archived, fmt.Errorf("could not archive %s: %w", p.TrackingNumber, err)
+		}
+		log.Debugf("%s: archived", p.TrackingNumber)
+		archived++
+	}
+	return archived, nil
+}
+
+// Archive delivered parcels if archive_delivered_after is configured
+func runArchiveSweep() {
+	if conf.ArchiveDeliveredAfter <= 0 {
+		return
+	}
+	if _, err := sweepArchive(conf.ArchiveDeliveredAfter, time.Now()); err != nil {
+		log.Warnf("error archiving delivered parcels: %v", err)
+	}
+}
+
+func List(cmd *cobra.Command, args []string) {
+	format, err := parseOutputFormat(outputFormatFlag)
+	if err != nil {
+		log.Fatalf("invalid --format: %v", err)
+	}
+	status, err := parseStatusFilter(lsStatus)
+	if err != nil {
+		log.Fatalf("invalid --status: %v", err)
+	}
+
+	fetch := fetchParcels
+	if lsArchived {
+		fetch = fetchArchivedParcels
+	}
+	parcels, err := fetch()
+	if err != nil {
+		log.Fatalf("error fetching parcels: %v", err)
+	}
+
+	byID := make(map[string]*envoy.Parcel, len(parcels))
+	for _, p := range filterParcelsByTags(filterParcels(parcels, status), lsTags) {
+		byID[p.TrackingNumber] = p
+	}
+	// Structured output stays parseable when there is nothing to list
+	if len(byID) == 0 && format == outputFormatText {
+		switch {
+		case len(parcels) > 0:
+			fmt.Println("No matching parcels")
+		case lsArchived:
+			fmt.Println("No archived parcels")
+		default:
+			fmt.Println("No parcels stored yet, add one with `envoy add TRACKING_NUMBER`")
+		}
+		return
+	}
+	if err := printParcels(byID, format); err != nil {
+		log.Fatalf("Error printing parcels: %v", err)
+	}
+}
+
+// Resolve each tracking number against the parcels and move the match with
+// the function, then print a summary of the outcome
+func moveResolved(args []string, parcels []*envoy.Parcel, action string, move func(*envoy.Parcel) error) {
+	summary := batchSummary{action: action}
+	for _, tn := range args {
+		p, err := resolvePartial(parcels, tn)
+		if err != nil {
+			log.Fatalf("error resolving parcel %s: %v", tn, err)
+		}
+		if p == nil {
+			log.Warnf("no matching parcel %s", tn)
+			summary.skipped++
+			continue
+		}
+		if err := move(p); err != nil {
+			log.Warnf("could not move %s: %v", p.TrackingNumber, err)
+			summary.skipped++
+			continue
+		}
+		summary.done++
+	}
+	fmt.Println(summary)
+}
+
+func Archive(cmd *cobra.Command, args []string) {
+	parcels, err := fetchParcels()
+	if err != nil {
+		log.Fatalf("error fetching parcels: %v", err)
+	}
+	moveResolved(args, parcels, "archived", archiveParcel)
+}
+
+func Unarchive(cmd *cobra.Command, args []string) {
+	archived, err := fetchArchivedParcels()
+	if err != nil {
+		log.Fatalf("error fetching archived parcels: %v", err)
+	}
+	moveResolved(args, archived, "restored", unarchiveParcel)
+}
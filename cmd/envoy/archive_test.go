@@ -0,0 +1,210 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func trackingNumbers(parcels []*envoy.Parcel) []string {
+	ids := make([]string, 0, len(parcels))
+	for _, p := range parcels {
+		ids = append(ids, p.TrackingNumber)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestArchiveSweepAndRestore(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	delivered := func(name, tn string, at time.Time) *envoy.Parcel {
+		p := envoy.NewParcel(name, envoy.CarrierUPS, tn, "")
+		p.Data = &envoy.ParcelData{
+			Delivered: true,
+			Events:    []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: at}},
+		}
+		return p
+	}
+	old := delivered("Books", "1Z5R89390357567127", now.Add(-10*24*time.Hour))
+	old.AddTags("work")
+	recent := delivered("Lamp", "1ZW701150378674373", now.Add(-time.Hour))
+	pending := envoy.NewParcel("Scarf", envoy.CarrierFedEx, "441259201412", "")
+	pending.Data = &envoy.ParcelData{}
+	for _, p := range []*envoy.Parcel{old, recent, pending} {
+		if err := upsertParcel(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := sweepArchive(7*24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 parcel archived, got %d", n)
+	}
+
+	active, err := fetchParcels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := trackingNumbers(active); !slices.Equal(ids, []string{"1ZW701150378674373", "441259201412"}) {
+		t.Errorf("Expected recent and pending parcels to stay active, got %v", ids)
+	}
+	archived, err := fetchArchivedParcels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || archived[0].TrackingNumber != old.TrackingNumber || !archived[0].HasTag("work") {
+		t.Fatalf("Expected the old parcel to be archived intact, got %+v", archived)
+	}
+
+	if err := unarchiveParcel(archived[0]); err != nil {
+		t.Fatal(err)
+	}
+	if archived, _ := fetchArchivedParcels(); len(archived) != 0 {
+		t.Errorf("Expected the archive to be empty after restoring, got %v", trackingNumbers(archived))
+	}
+	if p, err := getParcel(old.TrackingNumber); err != nil || p == nil || p.Name != "Books" {
+		t.Errorf("Expected the parcel to be active again, got %+v, %v", p, err)
+	}
+}
+
+func TestDueForArchive(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	delay := 24 * time.Hour
+
+	synced := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	synced.Data = &envoy.ParcelData{Delivered: true}
+	synced.LastSyncedAt = now.Add(-48 * time.Hour)
+	if !dueForArchive(synced, delay, now) {
+		t.Error("Expected a parcel without delivered events to fall back to its sync time")
+	}
+
+	unsynced := envoy.NewParcel("Lamp", envoy.CarrierUPS, "1ZW701150378674373", "")
+	unsynced.Data = &envoy.ParcelData{Delivered: true}
+	if dueForArchive(unsynced, delay, now) {
+		t.Error("Expected a parcel with no known delivery time not to be archived")
+	}
+}
+
+func TestListStatusFilter(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+	defer func(status string, line bool) { lsStatus, oneline = status, line }(lsStatus, oneline)
+	oneline = true
+
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	withEvent := func(name, tn string, t envoy.ParcelEventType) *envoy.Parcel {
+		p := envoy.NewParcel(name, envoy.CarrierUPS, tn, "")
+		p.Data = &envoy.ParcelData{
+			Delivered: t == envoy.ParcelEventTypeDelivered,
+			Events:    []envoy.ParcelEvent{{Type: t, Description: string(t), Timestamp: now}},
+		}
+		return p
+	}
+	seeded := []*envoy.Parcel{
+		withEvent("Books", "1Z5R89390357567127", envoy.ParcelEventTypeDelivered),
+		withEvent("Lamp", "1ZW701150378674373", envoy.ParcelEventTypeInTransit),
+		withEvent("Scarf", "1Z0000000000000001", envoy.ParcelEventTypeOutForDelivery),
+		withEvent("Mug", "1Z0000000000000002", envoy.ParcelEventTypeReturnedToSender),
+		withEvent("Desk", "1Z0000000000000003", envoy.ParcelEventTypeUndeliverable),
+	}
+	for _, p := range seeded {
+		if err := createParcel(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		status string
+		want   []string
+	}{
+		{"all", []string{"1Z0000000000000001", "1Z0000000000000002", "1Z0000000000000003", "1Z5R89390357567127", "1ZW701150378674373"}},
+		{"active", []string{"1Z0000000000000001", "1ZW701150378674373"}},
+		{"delivered", []string{"1Z5R89390357567127"}},
+		{"exception", []string{"1Z0000000000000002", "1Z0000000000000003"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			lsStatus = tt.status
+			out := captureStdout(t, func() { List(&cobra.Command{}, nil) })
+
+			var got []string
+			for _, p := range seeded {
+				if strings.Contains(out, p.TrackingNumber) {
+					got = append(got, p.TrackingNumber)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v listed, got %v in %q", tt.want, got, out)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+	defer func(status string, line bool) { lsStatus, oneline = status, line }(lsStatus, oneline)
+	lsStatus = string(statusFilterAll)
+
+	if out := captureStdout(t, func() { List(&cobra.Command{}, nil) }); !strings.Contains(out, "No parcels stored yet") {
+		t.Errorf("Expected a hint for an empty database, got %q", out)
+	}
+
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	p := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	p.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypePickedUp, Description: "Picked up", Location: "SEATTLE, WA", Timestamp: now.Add(-time.Hour)},
+			{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Location: "FRESNO, CA", Timestamp: now},
+		},
+	}
+	if err := createParcel(p); err != nil {
+		t.Fatal(err)
+	}
+
+	oneline = false
+	if out, want := captureStdout(t, func() { List(&cobra.Command{}, nil) }), formatEventHistory(p); !strings.Contains(out, want) {
+		t.Errorf("Expected the stored history %q, got %q", want, out)
+	}
+	oneline = true
+	out := captureStdout(t, func() { List(&cobra.Command{}, nil) })
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 1 || !strings.Contains(out, "In transit") {
+		t.Errorf("Expected the latest event on one line, got %q", out)
+	}
+
+	lsStatus = string(statusFilterDelivered)
+	if out := captureStdout(t, func() { List(&cobra.Command{}, nil) }); !strings.Contains(out, "No matching parcels") {
+		t.Errorf("Expected no matches for the status, got %q", out)
+	}
+}
+
+func TestListOnelineUnsynced(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+	defer func(status string, line bool) { lsStatus, oneline = status, line }(lsStatus, oneline)
+	lsStatus = string(statusFilterAll)
+	oneline = true
+
+	// Added but never synced, so it has no data
+	if err := createParcel(envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")); err != nil {
+		t.Fatal(err)
+	}
+	out := captureStdout(t, func() { List(&cobra.Command{}, nil) })
+	if want := "1Z5R89390357567127: not tracked yet"; !strings.Contains(out, want) {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// Version of the backup format written by backup. Bump it when the format
+// changes, and teach migrateBackup to upgrade older versions.
+//
+// Version 2 marks backups written after UPS events were timestamped from
+// their GMT fields. Version 3 adds the archived parcels.
+const backupVersion = 3
+
+// backup is a portable snapshot of every stored parcel
+type backup struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Parcels   []*envoy.Parcel `json:"parcels"`
+	Archived  []*envoy.Parcel `json:"archived,omitempty"`
+}
+
+// Write the active and archived parcels as a backup
+func writeBackup(w io.Writer, parcels, archived []*envoy.Parcel, now time.Time) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(backup{
+		Version:   backupVersion,
+		CreatedAt: now,
+		Parcels:   parcels,
+		Archived:  archived,
+	})
+}
+
+// Read a backup, decompressing it if it is gzipped and upgrading it to the
+// current version
+func readBackup(r io.Reader) (*backup, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	var b backup
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	if err := migrateBackup(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Upgrade a backup written by an older version of envoy to the current format
+func migrateBackup(b *backup) error {
+	switch {
+	case b.Version < 1:
+		return fmt.Errorf("invalid backup: missing version")
+	case b.Version > backupVersion:
+		return fmt.Errorf("backup version %d is newer than supported version %d", b.Version, backupVersion)
+	}
+	if b.Version < 2 {
+		// Restored as they are, the UPS events would be kept beside the
+		// same events fetched again
+		for _, p := range slices.Concat(b.Parcels, b.Archived) {
+			scrubLocalUPSEvents(p)
+		}
+	}
+	b.Version = backupVersion
+	return nil
+}
+
+// Store the backed up parcels, replacing any stored copies in full
+func restoreParcels(b *backup) error {
+	for _, p := range b.Parcels {
+		if err := restoreParcel(p, false); err != nil {
+			return fmt.Errorf("could not restore %s: %w", p.TrackingNumber, err)
+		}
+	}
+	for _, p := range b.Archived {
+		if err := restoreParcel(p, true); err != nil {
+			return fmt.Errorf("could not restore %s: %w", p.TrackingNumber, err)
+		}
+	}
+	return nil
+}
+
+// Store a parcel in the active parcels or the archive, removing any copy
+// stored in the other, in a single transaction
+func restoreParcel(p *envoy.Parcel, archived bool) error {
+	if db == nil {
+		log.Fatal("Error:  DB is not initialized")
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	to, other := tx, tx.From(archiveNode)
+	if archived {
+		to, other = other, to
+	}
+	if err := to.Save(p); err != nil {
+		return err
+	}
+	if err := other.DeleteStruct(p); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return tx.Commit()
+}
+
+func Backup(cmd *cobra.Command, args []string) {
+	parcels, err := fetchParcels()
+	if err != nil {
+		log.Fatalf("error fetching parcels: %v", err)
+	}
+	archived, err := fetchArchivedParcels()
+	if err != nil {
+		log.Fatalf("error fetching archived parcels: %v", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if args[0] != "-" {
+		f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			log.Fatalf("could not create %s: %v", args[0], err)
+		}
+		defer f.Close()
+		out = f
+
+		if strings.HasSuffix(args[0], ".gz") {
+			gz := gzip.NewWriter(f)
+			defer gz.Close()
+			out = gz
+		}
+	}
+
+	if err := writeBackup(out, parcels, archived, time.Now()); err != nil {
+		log.Fatalf("error writing %s: %v", args[0], err)
+	}
+	log.Infof("backed up %d parcels and %d archived parcels", len(parcels), len(archived))
+}
+
+func Restore(cmd *cobra.Command, args []string) {
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatalf("could not open %s: %v", args[0], err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	b, err := readBackup(in)
+	if err != nil {
+		log.Fatalf("error reading %s: %v", args[0], err)
+	}
+	if err := restoreParcels(b); err != nil {
+		log.Fatalf("error restoring parcels: %v", err)
+	}
+	fmt.Printf("restored %d parcels and %d archived parcels from backup of %s\n",
+		len(b.Parcels), len(b.Archived), formatTimestamp(b.CreatedAt))
+}
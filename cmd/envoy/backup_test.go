@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestBackupRestore(t *testing.T) {
+	openTestDB(t)
+
+	synced := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	tracked := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "https://fedex.example/441259201412")
+	tracked.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Location: "DENVER, CO", Timestamp: synced},
+		},
+		Notices: []envoy.ParcelNotice{{Code: "DELAY", Message: "Weather delay"}},
+	}
+	tracked.LastSyncedAt = synced
+	tracked.NextRefreshAt = synced.Add(time.Hour)
+	tracked.AlternateTrackingNumbers = []string{"9612345678901234567890"}
+	failed := envoy.NewParcel("Lamp", envoy.CarrierUSPS, "9400123456789012345674", "")
+	failed.Error = errors.New("unexpected status code: 404")
+	archived := envoy.NewParcel("Kettle", envoy.CarrierUPS, "1Z12345E0205271688", "")
+	archived.Data = &envoy.ParcelData{
+		Delivered: true,
+		Events:    []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: synced}},
+	}
+	for _, p := range []*envoy.Parcel{tracked, failed, archived} {
+		if err := upsertParcel(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archiveParcel(archived); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := fetchParcels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArchived, err := fetchArchivedParcels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := writeBackup(gz, want, wantArchived, synced); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+
+	openTestDB(t)
+	stale := envoy.NewParcel("441259201412", envoy.CarrierUPS, "441259201412", "")
+	stale.Error = errors.New("timeout")
+	// Still active here, so restoring moves it to the archive
+	unarchived := envoy.NewParcel("1Z12345E0205271688", envoy.CarrierUPS, "1Z12345E0205271688", "")
+	for _, p := range []*envoy.Parcel{stale, unarchived} {
+		if err := upsertParcel(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := readBackup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.CreatedAt.Equal(synced) {
+		t.Errorf("Expected backup time %v, got %v", synced, b.CreatedAt)
+	}
+	if err := restoreParcels(b); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fetchParcels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected restored parcels to equal the originals\nwant: %+v\ngot:  %+v", want, got)
+	}
+	gotArchived, err := fetchArchivedParcels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotArchived, wantArchived) {
+		t.Errorf("Expected restored archived parcels to equal the originals\nwant: %+v\ngot:  %+v", wantArchived, gotArchived)
+	}
+}
+
+func TestReadBackupVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{"current", `{"version": 3, "parcels": [], "archived": []}`, ""},
+		{"without archive", `{"version": 2, "parcels": []}`, ""},
+		{"older version", `{"version": 1, "parcels": []}`, ""},
+		{"missing version", `{"parcels": []}`, "missing version"},
+		{"newer version", `{"version": 99, "parcels": []}`, "newer than supported"},
+		{"not json", `tracking_number,name`, "invalid backup"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readBackup(strings.NewReader(tt.in))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestReadBackupScrubsUPSEvents(t *testing.T) {
+	at := time.Date(2025, 2, 25, 10, 15, 0, 0, time.UTC)
+	moving := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	moving.ShipmentStartedAt = at
+	moving.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypePickedUp, Description: "Picked up", Timestamp: at, SourceCarrier: envoy.CarrierUPS}},
+	}
+	delivered := envoy.NewParcel("Kettle", envoy.CarrierUPS, "1Z12345E0205271688", "")
+	delivered.Data = &envoy.ParcelData{
+		Delivered: true,
+		Events:    []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: at, SourceCarrier: envoy.CarrierUPS}},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(backup{Version: 1, CreatedAt: at, Parcels: []*envoy.Parcel{moving, delivered}}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := readBackup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Version != backupVersion {
+		t.Errorf("Expected backup to be upgraded to version %d, got %d", backupVersion, b.Version)
+	}
+	if got := b.Parcels[0]; len(got.Data.Events) != 0 || !got.ShipmentStartedAt.IsZero() {
+		t.Errorf("Expected the moving parcel's UPS events to be dropped, got %+v", got.Data.Events)
+	}
+	if got := b.Parcels[1]; len(got.Data.Events) != 1 || !got.Data.StaleEventTimes {
+		t.Errorf("Expected the delivered parcel to keep its events marked stale, got %+v", got.Data)
+	}
+}
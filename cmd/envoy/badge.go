@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+var badgeSVG bool
+
+// Colors of badges by the severity of a parcel's status, named as shields.io
+// names them
+var badgeColors = map[envoy.Severity]string{
+	envoy.SeveritySuccess: "brightgreen",
+	envoy.SeverityError:   "red",
+	envoy.SeverityNormal:  "blue",
+}
+
+// Hex values of the badge colors, for rendering SVGs
+var badgeHexColors = map[string]string{
+	"brightgreen": "#4c1",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"lightgrey":   "#9f9f9f",
+	"grey":        "#555",
+}
+
+// badge is a shields.io endpoint badge, see https://shields.io/badges/endpoint-badge
+type badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// Construct the badge showing the status of a parcel, colored like its status
+// icon in the TUI
+func parcelBadge(p *envoy.Parcel) badge {
+	b := badge{SchemaVersion: 1, Label: p.Name, Message: "NO DATA", Color: "lightgrey"}
+	switch {
+	case p.HasError():
+		b.Message, b.Color = "ERROR", badgeColors[envoy.SeverityError]
+	case isDelivered(p):
+		b.Message, b.Color = string(envoy.ParcelEventTypeDelivered), badgeColors[envoy.SeveritySuccess]
+	default:
+		if e := p.LastTrackingEvent(); e != nil {
+			b.Message, b.Color = formatEventType(e), badgeColors[e.Type.Severity()]
+		}
+	}
+	return b
+}
+
+// Approximate width in pixels of text in the 11px Verdana used by badges
+func badgeTextWidth(s string) int {
+	return utf8.RuneCountInString(s)*7 + 10
+}
+
+// Write a badge as a flat shields.io style SVG
+func writeBadgeSVG(w io.Writer, b badge) error {
+	lw, mw := badgeTextWidth(b.Label), badgeTextWidth(b.Message)
+	label, message := html.EscapeString(b.Label), html.EscapeString(b.Message)
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<rect width="%[2]d" height="20" fill="%[6]s"/>
+<rect x="%[2]d" width="%[3]d" height="20" fill="%[7]s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[8]d" y="14">%[4]s</text>
+<text x="%[9]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, lw+mw, lw, mw, label, message, badgeHexColors["grey"], badgeHexColors[b.Color], lw/2, lw+mw/2)
+	return err
+}
+
+// Write the badge of a parcel as shields.io endpoint JSON, or as an SVG
+func writeBadge(w io.Writer, p *envoy.Parcel, svg bool) error {
+	b := parcelBadge(p)
+	if svg {
+		return writeBadgeSVG(w, b)
+	}
+	enc := json.NewEncoder(w)
+	return enc.Encode(b)
+}
+
+func Badge(cmd *cobra.Command, args []string) {
+	p, err := resolveParcel(args[0])
+	if err != nil {
+		log.Fatalf("error resolving parcel %s: %v", args[0], err)
+	}
+	if p == nil {
+		log.Fatalf("no stored parcel %s", args[0])
+	}
+	if err := writeBadge(os.Stdout, p, badgeSVG); err != nil {
+		log.Fatalf("error writing badge: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+func TestWriteBadge(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	parcel := func(types ...envoy.ParcelEventType) *envoy.Parcel {
+		p := envoy.NewParcel("New <shoes>", envoy.CarrierFedEx, "441259201412", "")
+		p.Data = &envoy.ParcelData{}
+		for i, typ := range types {
+			p.Data.Events = append(p.Data.Events, envoy.ParcelEvent{Type: typ, Timestamp: timeNow.Add(time.Duration(i) * time.Hour)})
+		}
+		p.Data.Delivered = p.HasDeliveredEvent()
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		parcel  *envoy.Parcel
+		message string
+		color   string
+		hex     string
+	}{
+		{"delivered", parcel(envoy.ParcelEventTypeInTransit, envoy.ParcelEventTypeDelivered), "DELIVERED", "brightgreen", "#4c1"},
+		{"in transit", parcel(envoy.ParcelEventTypePickedUp, envoy.ParcelEventTypeInTransit), "IN TRANSIT", "blue", "#007ec6"},
+		{"exception", parcel(envoy.ParcelEventTypeInTransit, envoy.ParcelEventTypeDelayed), "DELAYED", "red", "#e05d44"},
+		{"no data", envoy.NewParcel("New <shoes>", envoy.CarrierFedEx, "441259201412", ""), "NO DATA", "lightgrey", "#9f9f9f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeBadge(&buf, tt.parcel, false); err != nil {
+				t.Fatal(err)
+			}
+			var b badge
+			if err := json.Unmarshal(buf.Bytes(), &b); err != nil {
+				t.Fatal(err)
+			}
+			want := badge{SchemaVersion: 1, Label: "New <shoes>", Message: tt.message, Color: tt.color}
+			if b != want {
+				t.Errorf("Expected badge %+v, got %+v", want, b)
+			}
+
+			buf.Reset()
+			if err := writeBadge(&buf, tt.parcel, true); err != nil {
+				t.Fatal(err)
+			}
+			svg := buf.String()
+			if !strings.Contains(svg, `fill="`+tt.hex+`"`) || !strings.Contains(svg, ">"+tt.message+"<") {
+				t.Errorf("Expected a %s %s badge, got:\n%s", tt.color, tt.message, svg)
+			}
+			if !strings.Contains(svg, "New &lt;shoes&gt;") {
+				t.Errorf("Expected the label to be escaped, got:\n%s", svg)
+			}
+		})
+	}
+}
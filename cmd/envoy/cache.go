@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// Stored parcels synced more recently than this are used without fetching.
+// Every tracking number is fetched unless --max-age is given.
+const defaultTrackMaxAge time.Duration = 0
+
+var (
+	trackMaxAge time.Duration
+	trackForce  bool
+)
+
+// Returns whether a stored parcel was synced within maxAge of now
+func isFresh(p *envoy.Parcel, maxAge time.Duration, now time.Time) bool {
+	if p == nil || !p.HasData() || p.LastSyncedAt.IsZero() {
+		return false
+	}
+	return now.Sub(p.LastSyncedAt) <= maxAge
+}
+
+// Split grouped tracking numbers into the stored parcels which are fresh
+// enough to answer from, and the groups which must be fetched from carriers.
+// When force is set or maxAge is not positive, every tracking number is
+// fetched.
+func lookupCachedParcels(
+	groups map[envoy.Carrier][]string,
+	maxAge time.Duration,
+	force bool,
+	get func(trackingNumber string) (*envoy.Parcel, error),
+	now time.Time,
+) (map[string]*envoy.Parcel, map[envoy.Carrier][]string) {
+	cached := make(map[string]*envoy.Parcel)
+	if force || maxAge <= 0 {
+		return cached, groups
+	}
+
+	stale := make(map[envoy.Carrier][]string)
+	for carrier, trackingNumbers := range groups {
+		for _, tn := range trackingNumbers {
+			p, err := get(tn)
+			if err != nil {
+				log.Warnf("could not read stored parcel %s: %v", tn, err)
+			}
+			if isFresh(p, maxAge, now) {
+				log.Debugf("%s: served from cache (synced %s ago)", tn, now.Sub(p.LastSyncedAt).Round(time.Second))
+				cached[tn] = p
+				continue
+			}
+			stale[carrier] = append(stale[carrier], tn)
+		}
+	}
+	return cached, stale
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestLookupCachedParcels(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	now := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+
+	stored := map[string]*envoy.Parcel{}
+	store := func(trackingNumber string, syncedAgo time.Duration) {
+		p := envoy.NewParcel("", envoy.CarrierFedEx, trackingNumber, "")
+		p.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeInTransit}}}
+		p.LastSyncedAt = now.Add(-syncedAgo)
+		stored[trackingNumber] = p
+	}
+	store("271163815799", time.Minute)
+	store("281958973124", time.Hour)
+
+	var lookups []string
+	get := func(trackingNumber string) (*envoy.Parcel, error) {
+		lookups = append(lookups, trackingNumber)
+		return stored[trackingNumber], nil
+	}
+
+	t.Run("fresh", func(t *testing.T) {
+		groups := map[envoy.Carrier][]string{envoy.CarrierFedEx: {"271163815799"}}
+		cached, stale := lookupCachedParcels(groups, 5*time.Minute, false, get, now)
+		if len(stale) != 0 {
+			t.Errorf("Expected no network fetch, got %v", stale)
+		}
+		if cached["271163815799"] != stored["271163815799"] {
+			t.Errorf("Expected stored parcel to be served from cache, got %v", cached)
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		groups := map[envoy.Carrier][]string{envoy.CarrierFedEx: {"281958973124", "271198840120"}}
+		cached, stale := lookupCachedParcels(groups, 5*time.Minute, false, get, now)
+		if len(cached) != 0 {
+			t.Errorf("Expected nothing served from cache, got %v", cached)
+		}
+		if got := stale[envoy.CarrierFedEx]; len(got) != 2 {
+			t.Errorf("Expected stale and unknown parcels to be fetched, got %v", got)
+		}
+	})
+
+	t.Run("force", func(t *testing.T) {
+		lookups = nil
+		groups := map[envoy.Carrier][]string{envoy.CarrierFedEx: {"271163815799"}}
+		cached, stale := lookupCachedParcels(groups, 5*time.Minute, true, get, now)
+		if len(cached) != 0 || len(stale[envoy.CarrierFedEx]) != 1 {
+			t.Errorf("Expected forced fetch, got cached=%v stale=%v", cached, stale)
+		}
+		if len(lookups) != 0 {
+			t.Errorf("Expected store not to be consulted, got %v", lookups)
+		}
+	})
+	t.Run("default fetches", func(t *testing.T) {
+		lookups = nil
+		groups := map[envoy.Carrier][]string{envoy.CarrierFedEx: {"271163815799"}}
+		cached, stale := lookupCachedParcels(groups, defaultTrackMaxAge, false, get, now)
+		if len(cached) != 0 || len(stale[envoy.CarrierFedEx]) != 1 {
+			t.Errorf("Expected a fetch without --max-age, got cached=%v stale=%v", cached, stale)
+		}
+		if len(lookups) != 0 {
+			t.Errorf("Expected store not to be consulted, got %v", lookups)
+		}
+	})
+}
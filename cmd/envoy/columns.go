@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// parcelColumn describes a column that may be shown in the parcels table
+type parcelColumn struct {
+	key   string
+	title string
+	width int
+	value func(p *envoy.Parcel) string
+	// Styles the value of a cell, for columns colored by the parcel
+	style func(p *envoy.Parcel) lipgloss.Style
+	// Orders two parcels by this column, for those the table can be sorted by
+	compare func(a, b *envoy.Parcel) int
+}
+
+var (
+	parcelColumns = []parcelColumn{
+		{
+			key:   "name",
+			title: "PARCEL NAME",
+			width: 16,
+			value: func(p *envoy.Parcel) string {
+				if p.HasError() {
+					return iconException + " " + p.Name
+				}
+				return p.Name
+			},
+			compare: func(a, b *envoy.Parcel) int {
+				return strings.Compare(strings.ToLower(parcelName(a)), strings.ToLower(parcelName(b)))
+			},
+		},
+		{
+			key:   "notices",
+			title: "!",
+			width: 3,
+			value: func(p *envoy.Parcel) string {
+				if !p.HasData() || len(p.Data.Notices) == 0 {
+					return ""
+				}
+				return indeterminateStyle.Render(fmt.Sprintf("!%d", len(p.Data.Notices)))
+			},
+		},
+		{
+			key:   "carrier",
+			title: "CARRIER",
+			width: 14,
+			value: formatCarrier,
+			compare: func(a, b *envoy.Parcel) int {
+				return strings.Compare(string(a.Carrier), string(b.Carrier))
+			},
+		},
+		{
+			key:   "service",
+			title: "SERVICE",
+			width: 18,
+			value: formatServiceLevel,
+			compare: func(a, b *envoy.Parcel) int {
+				return strings.Compare(formatServiceLevel(a), formatServiceLevel(b))
+			},
+		},
+		{
+			key:   "tracking",
+			title: "TRACKING NO.",
+			width: 16,
+			value: func(p *envoy.Parcel) string {
+				return p.TrackingNumber
+			},
+		},
+		{
+			key:   "status",
+			title: "STATUS",
+			width: 16,
+			value: parcelStatus,
+			style: func(p *envoy.Parcel) lipgloss.Style {
+				if p.HasError() {
+					return errorStyle
+				}
+				if e := p.LastTrackingEvent(); e != nil {
+					return statusStyle(e.Type)
+				}
+				return lipgloss.NewStyle()
+			},
+			compare: func(a, b *envoy.Parcel) int {
+				return strings.Compare(parcelStatus(a), parcelStatus(b))
+			},
+		},
+		{
+			key:   "location",
+			title: "LOCATION",
+			width: 20,
+			value: func(p *envoy.Parcel) string {
+				if e := p.LastTrackingEvent(); e != nil {
+					return e.Location
+				}
+				return envoy.EmDash
+			},
+		},
+		{
+			key:   "tags",
+			title: "TAGS",
+			width: 16,
+			value: formatTags,
+		},
+		{
+			key:   "eta",
+			title: "ETA",
+			width: 24,
+			value: func(p *envoy.Parcel) string {
+				return formatETACountdown(p, time.Now())
+			},
+		},
+		{
+			key:   "size",
+			title: "SIZE",
+			width: 20,
+			value: formatSize,
+		},
+		{
+			key:   "date",
+			title: "DATE",
+			width: 28,
+			value: func(p *envoy.Parcel) string {
+				if p.HasError() {
+					return formatTimestamp(time.Now())
+				}
+				if e := p.LastTrackingEvent(); e != nil {
+					return formatTimestamp(e.Timestamp)
+				}
+				return ""
+			},
+			compare: func(a, b *envoy.Parcel) int {
+				return lastEventTime(a).Compare(lastEventTime(b))
+			},
+		},
+	}
+	defaultParcelColumns = []string{"name", "notices", "carrier", "tracking", "status", "tags", "date"}
+)
+
+// The columns cycled through when sorting the parcels table from the keyboard
+var sortKeys = []string{"name", "carrier", "status", "date"}
+
+// parcelSort orders the parcels table by a sortable column
+type parcelSort struct {
+	key  string
+	desc bool
+}
+
+// The most recently updated parcels are shown first by default
+var defaultParcelSort = parcelSort{key: "date", desc: true}
+
+// Returns the sort by the next of the sortKeys, wrapping around
+func (s parcelSort) next() parcelSort {
+	i := slices.Index(sortKeys, s.key)
+	return sortByColumn(sortKeys[(i+1)%len(sortKeys)])
+}
+
+// Returns the sort for a click on a column header, which reverses the current
+// sort when it is by the same column
+func (s parcelSort) byColumn(key string) parcelSort {
+	if s.key == key {
+		return parcelSort{key: key, desc: !s.desc}
+	}
+	return sortByColumn(key)
+}
+
+// Sort by a column, with dates descending and everything else ascending
+func sortByColumn(key string) parcelSort {
+	return parcelSort{key: key, desc: key == "date"}
+}
+
+// Returns a comparator ordering parcels by the sort. Ties are broken by
+// tracking number so that the order is stable between refreshes.
+func compareParcels(s parcelSort) func(a, b *envoy.Parcel) int {
+	var compare func(a, b *envoy.Parcel) int
+	for _, c := range parcelColumns {
+		if c.key == s.key {
+			compare = c.compare
+		}
+	}
+
+	return func(a, b *envoy.Parcel) int {
+		if compare != nil {
+			c := compare(a, b)
+			if s.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.TrackingNumber, b.TrackingNumber)
+	}
+}
+
+func parcelName(p *envoy.Parcel) string {
+	if p.Name == "" {
+		return p.TrackingNumber
+	}
+	return p.Name
+}
+
+// Returns the error or latest event description of a parcel
+func parcelStatus(p *envoy.Parcel) string {
+	if p.HasError() {
+		return p.Error.Error()
+	}
+	if e := p.LastTrackingEvent(); e != nil {
+		return strings.ToUpper(e.Description)
+	}
+	return ""
+}
+
+func lastEventTime(p *envoy.Parcel) time.Time {
+	if e := p.LastTrackingEvent(); e != nil {
+		return e.Timestamp
+	}
+	return time.Time{}
+}
+
+// Resolve the configured column keys to their definitions, falling back to
+// the default set when none are configured
+func resolveParcelColumns(keys []string) ([]parcelColumn, error) {
+	if len(keys) == 0 {
+		keys = defaultParcelColumns
+	}
+
+	columns := make([]parcelColumn, 0, len(keys))
+	for _, k := range keys {
+		k = strings.ToLower(strings.TrimSpace(k))
+		idx := -1
+		for i, c := range parcelColumns {
+			if c.key == k {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			available := make([]string, 0, len(parcelColumns))
+			for _, c := range parcelColumns {
+				available = append(available, c.key)
+			}
+			return nil, fmt.Errorf(
+				"unknown column %q (available: %s)",
+				k,
+				strings.Join(available, ", "),
+			)
+		}
+		columns = append(columns, parcelColumns[idx])
+	}
+	return columns, nil
+}
+
+func (c parcelColumn) tableColumn() table.Column {
+	return table.Column{Title: c.title, Width: c.width}
+}
+
+// Returns the cell of the column for a parcel, styled when the column is. The
+// table truncates cells by their raw width, escape codes included, so long
+// styled values are shortened to leave room for the codes rather than losing
+// the one resetting the style.
+func (c parcelColumn) cell(p *envoy.Parcel) string {
+	value := c.value(p)
+	if c.style == nil {
+		return value
+	}
+
+	style := c.style(p)
+	styled := style.Render(value)
+	overhead := runewidth.StringWidth(styled) - runewidth.StringWidth(value)
+	if overhead == 0 || runewidth.StringWidth(styled) <= c.width {
+		return styled
+	}
+	return style.Render(runewidth.Truncate(value, max(c.width-overhead, 0), "…"))
+}
+
+// Returns the column title, marked with the direction when sorted by it
+func (c parcelColumn) sortedTitle(s parcelSort) string {
+	switch {
+	case c.key != s.key:
+		return c.title
+	case s.desc:
+		return c.title + " ▼"
+	default:
+		return c.title + " ▲"
+	}
+}
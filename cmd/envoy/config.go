@@ -1,11 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"runtime"
+	"strings"
+	"time"
 
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
 )
 
 func ConfigDir() (string, error) {
@@ -32,7 +38,67 @@ type Config struct {
 		FedEx CarrierConfig `yaml:"fedex"`
 		UPS   CarrierConfig `yaml:"ups"`
 		USPS  CarrierConfig `yaml:"usps"`
+		DHL   CarrierConfig `yaml:"dhl"`
 	}
+	TUI    TUIConfig    `yaml:"tui"`
+	Detect DetectConfig `yaml:"detect"`
+	HTTP   HTTPConfig   `yaml:"http"`
+	// How delivered status is derived: "any" (default), "carrier", or "events"
+	DeliveredStrategy string `mapstructure:"delivered_strategy" yaml:"delivered_strategy"`
+	// The shortest interval any polling loop may use (default 60s)
+	MinPollInterval time.Duration `mapstructure:"min_poll_interval" yaml:"min_poll_interval"`
+	// The most requests a carrier service makes at once when tracking many
+	// parcels (default 4)
+	MaxConcurrency int `mapstructure:"max_concurrency" yaml:"max_concurrency"`
+	// A file rewritten after every polling cycle with its time and any error,
+	// so that monitors can alert if polling stops
+	HeartbeatFile string `mapstructure:"heartbeat_file" yaml:"heartbeat_file"`
+	// How long after delivery parcels are moved to the archive when tracking,
+	// e.g. "168h". Zero (default) never archives them automatically.
+	ArchiveDeliveredAfter time.Duration `mapstructure:"archive_delivered_after" yaml:"archive_delivered_after"`
+	// Whether to keep the history of earlier shipments when a tracking number is reused
+	ArchiveShipments bool `mapstructure:"archive_shipments" yaml:"archive_shipments"`
+	// Whether the TUI shows desktop notifications when a parcel goes out for
+	// delivery or is delivered
+	Notifications bool `yaml:"notifications"`
+	// A URL POSTed a JSON payload whenever a parcel's status changes, signed
+	// with webhook_secret in the X-Envoy-Signature header if it is set
+	WebhookURL    string `mapstructure:"webhook_url" yaml:"webhook_url"`
+	WebhookSecret string `mapstructure:"webhook_secret" yaml:"webhook_secret"`
+	// Event types to emphasize in timelines, e.g. ["OUT FOR DELIVERY", "DELAYED"]
+	HighlightEvents []string `mapstructure:"highlight_events" yaml:"highlight_events"`
+	// The units weights and dimensions are shown in: "imperial", "metric", or
+	// empty (default) for the units reported by each carrier
+	Units string `yaml:"units"`
+}
+
+type TUIConfig struct {
+	// Columns shown in the parcels table, in order
+	Columns []string `yaml:"columns"`
+	// How often to refresh parcels while the TUI is open, e.g. "15m". Zero
+	// (default) only refreshes on start and when r is pressed.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval" yaml:"refresh_interval"`
+}
+
+type HTTPConfig struct {
+	// Headers added to every carrier request, e.g. {"X-Corp-Auth": "..."} for
+	// a corporate proxy. They never override headers set by the carrier client.
+	Headers map[string]string `yaml:"headers"`
+	// A PEM file of CA certificates trusted in addition to the system's, such
+	// as that of a TLS-inspecting proxy
+	CACert string `mapstructure:"ca_cert" yaml:"ca_cert"`
+	// PEM files of a certificate and key presented for mutual TLS
+	ClientCert string `mapstructure:"client_cert" yaml:"client_cert"`
+	ClientKey  string `mapstructure:"client_key" yaml:"client_key"`
+	// Disables verification of server certificates. This is insecure and
+	// should only be used to diagnose certificate errors.
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+type DetectConfig struct {
+	// Carriers preferred when a tracking number is ambiguous, most preferred
+	// first, e.g. ["USPS", "UPS"]. Unlisted carriers keep the default order.
+	Order []string `yaml:"order"`
 }
 
 type CarrierConfig struct {
@@ -41,6 +107,105 @@ type CarrierConfig struct {
 	Extra  string `yaml:"extra"`
 }
 
+// Returns the credentials configured for a carrier, if it is supported
+func (c *Config) carrier(carrier envoy.Carrier) *CarrierConfig {
+	switch carrier {
+	case envoy.CarrierFedEx:
+		return &c.Carriers.FedEx
+	case envoy.CarrierUPS:
+		return &c.Carriers.UPS
+	case envoy.CarrierUSPS:
+		return &c.Carriers.USPS
+	case envoy.CarrierDHL:
+		return &c.Carriers.DHL
+	default:
+		return nil
+	}
+}
+
+// Returns the path of the config file in use, or where one should be created
+func configFilePath() (string, error) {
+	if used := viper.ConfigFileUsed(); used != "" {
+		return used, nil
+	}
+	if confPath != "" {
+		return confPath, nil
+	}
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, "envoy.yaml"), nil
+}
+
+// Persist carrier credentials to the config file, which is only readable by
+// the current user since it contains secrets
+func writeCarrierConfig(creds map[envoy.Carrier]CarrierConfig) (string, error) {
+	p, err := configFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	for carrier, c := range creds {
+		prefix := "carriers." + strings.ToLower(string(carrier))
+		viper.Set(prefix+".key", c.Key)
+		viper.Set(prefix+".secret", c.Secret)
+	}
+
+	viper.SetConfigPermissions(0600)
+	if err := viper.WriteConfigAs(p); err != nil {
+		return "", err
+	}
+	// Permissions only apply on creation, so tighten a pre-existing file too
+	if err := os.Chmod(p, 0600); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// Reports whether any carrier secrets are configured
+func (c *Config) hasSecrets() bool {
+	for _, carrier := range carrierServices {
+		if cc := c.carrier(carrier); cc != nil && cc.Secret != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a warning if the file at path is accessible to users other than
+// its owner, or an empty string if its permissions are acceptable
+func configPermissionsWarning(p string) string {
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return ""
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Sprintf(
+			"config file %s contains secrets but has permissions %04o; run `envoy config fix-perms` to restrict it to 0600",
+			p,
+			perm,
+		)
+	}
+	return ""
+}
+
+func FixConfigPermissions(cmd *cobra.Command, args []string) {
+	p := viper.ConfigFileUsed()
+	if p == "" {
+		fmt.Println("No config file found")
+		return
+	}
+	if err := os.Chmod(p, 0600); err != nil {
+		log.Fatalf("could not change permissions of %s: %v", p, err)
+	}
+	fmt.Printf("Restricted permissions of %s to 0600\n", p)
+}
+
 func initConfig() Config {
 	if confPath != "" {
 		// Use config file from the flag.
@@ -72,5 +237,11 @@ func initConfig() Config {
 		log.Fatalf("unable to decode config: %v", err)
 	}
 
+	if p := viper.ConfigFileUsed(); p != "" && config.hasSecrets() {
+		if warning := configPermissionsWarning(p); warning != "" {
+			log.Warn(warning)
+		}
+	}
+
 	return config
 }
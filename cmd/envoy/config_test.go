@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"testing"
+)
+
+func TestConfigPermissionsWarning(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not enforced on Windows")
+	}
+
+	p := path.Join(t.TempDir(), "envoy.yaml")
+	if err := os.WriteFile(p, []byte("carriers:\n  fedex:\n    secret: shh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(p, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if warning := configPermissionsWarning(p); warning == "" {
+		t.Error("Expected a warning for a world-readable config file")
+	}
+
+	if err := os.Chmod(p, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if warning := configPermissionsWarning(p); warning != "" {
+		t.Errorf("Expected no warning for a private config file, got %s", warning)
+	}
+}
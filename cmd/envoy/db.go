@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"path"
+	"slices"
+	"time"
 
 	"github.com/asdine/storm/v3"
 	"github.com/spf13/cobra"
@@ -21,6 +25,129 @@ func initDB(_ *cobra.Command, _ []string) {
 	if db, err = storm.Open(dbPath); err != nil {
 		log.Fatal(err)
 	}
+	if err := migrate(db); err != nil {
+		log.Fatalf("error migrating database: %v", err)
+	}
+}
+
+// Name of the storm node holding metadata about the database itself, and the
+// key of the schema version within it
+const (
+	metaNode         = "meta"
+	schemaVersionKey = "schema_version"
+)
+
+// Each migration upgrades the database from the schema version of its index
+// to the next, so the current version is the number of migrations. Databases
+// without a version predate versioning, and are at version 0.
+var migrations = []func(tx storm.Node) error{
+	// Version 1 is the shape of parcels when versioning was introduced.
+	// Parcels are saved again so that they are all encoded by it.
+	func(tx storm.Node) error {
+		for _, node := range []storm.Node{tx, tx.From(archiveNode)} {
+			var parcels []*envoy.Parcel
+			if err := node.All(&parcels); err != nil {
+				return err
+			}
+			for _, p := range parcels {
+				if err := node.Save(p); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	},
+	// Version 2 handles stored UPS events, which were timestamped from their
+	// local time as if it were UTC before the GMT fields were parsed, so the
+	// same events fetched again would not match them. Archived parcels are
+	// not synced again, so they are left as they are.
+	func(tx storm.Node) error {
+		var parcels []*envoy.Parcel
+		if err := tx.All(&parcels); err != nil {
+			return err
+		}
+		for _, p := range parcels {
+			if !scrubLocalUPSEvents(p) {
+				continue
+			}
+			if err := tx.Save(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// Handle the UPS events of a parcel stored before their GMT offsets were
+// parsed, reporting whether it changed. Parcels which are still moving are
+// refreshed soon, so their events are dropped to be fetched afresh. Finished
+// parcels are not refreshed again, and UPS forgets old shipments, so their
+// events are kept but marked stale, to be replaced if they are ever fetched.
+func scrubLocalUPSEvents(p *envoy.Parcel) bool {
+	if !p.HasData() {
+		return false
+	}
+	isUPS := func(e envoy.ParcelEvent) bool {
+		return e.SourceCarrier == envoy.CarrierUPS ||
+			(e.SourceCarrier == "" && p.Carrier == envoy.CarrierUPS)
+	}
+	if !slices.ContainsFunc(p.Data.Events, isUPS) {
+		return false
+	}
+	if isTerminal(p) {
+		p.Data.StaleEventTimes = true
+		return true
+	}
+	p.Data.Events = slices.DeleteFunc(p.Data.Events, isUPS)
+	p.ShipmentStartedAt = time.Time{}
+	return true
+}
+
+// Returns the schema version of a database
+func schemaVersion(node storm.Node) (int, error) {
+	var version int
+	err := node.Get(metaNode, schemaVersionKey, &version)
+	if errors.Is(err, storm.ErrNotFound) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Upgrade a database to the current schema version, applying each migration
+// in its own transaction so that an interrupted upgrade resumes where it left
+// off
+func migrate(db *storm.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf(
+			"database schema version %d is newer than this version of envoy supports (%d), upgrade envoy to open it",
+			version,
+			len(migrations),
+		)
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin(true)
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrating to version %d: %w", version+1, err)
+		}
+		if err := tx.Set(metaNode, schemaVersionKey, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Infof("migrated database to schema version %d", version+1)
+	}
+	return nil
 }
 
 func fetchParcels() ([]*envoy.Parcel, error) {
@@ -34,6 +161,90 @@ func fetchParcels() ([]*envoy.Parcel, error) {
 	return parcels, nil
 }
 
+// Fetch a single stored parcel, returning nil if it has never been stored
+func getParcel(trackingNumber string) (*envoy.Parcel, error) {
+	if db == nil {
+		log.Fatal("Error:  DB is not initialized")
+	}
+	var p envoy.Parcel
+	err := db.One("TrackingNumber", trackingNumber, &p)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Apply a freshly fetched parcel to its stored copy, returning the parcel to
+// persist. Parcels which have not been stored before are returned as is.
+// Stored parcels are passed to each onRefresh along with the type of their
+// last event before the refresh.
+func refreshStored(fetched *envoy.Parcel, onRefresh ...func(before envoy.ParcelEventType, p *envoy.Parcel)) *envoy.Parcel {
+	stored, err := getParcel(fetched.TrackingNumber)
+	if err != nil {
+		log.Warnf("could not read stored parcel %s: %v", fetched.TrackingNumber, err)
+		return fetched
+	}
+	if stored == nil {
+		return fetched
+	}
+	before := lastEventType(stored)
+	stored.Refresh(fetched, conf.ArchiveShipments)
+	for _, f := range onRefresh {
+		f(before, stored)
+	}
+	return stored
+}
+
+// Name of the storm node holding archived parcels, apart from the active ones
+const archiveNode = "archive"
+
+func fetchArchivedParcels() ([]*envoy.Parcel, error) {
+	if db == nil {
+		log.Fatal("Error:  DB is not initialized")
+	}
+	var parcels []*envoy.Parcel
+	if err := db.From(archiveNode).All(&parcels); err != nil {
+		return nil, err
+	}
+	return parcels, nil
+}
+
+// Move a parcel from the active parcels to the archive
+func archiveParcel(p *envoy.Parcel) error {
+	return moveParcel(p, true)
+}
+
+// Move a parcel from the archive back to the active parcels
+func unarchiveParcel(p *envoy.Parcel) error {
+	return moveParcel(p, false)
+}
+
+// Move a parcel into or out of the archive in a single transaction
+func moveParcel(p *envoy.Parcel, toArchive bool) error {
+	if db == nil {
+		log.Fatal("Error:  DB is not initialized")
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	from, to := tx, tx.From(archiveNode)
+	if !toArchive {
+		from, to = to, from
+	}
+	if err := to.Save(p); err != nil {
+		return err
+	}
+	if err := from.DeleteStruct(p); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func createParcel(p *envoy.Parcel) error {
 	if db == nil {
 		log.Fatal("Error:  DB is not initialized")
@@ -68,6 +279,9 @@ func upsertParcels(parcels []*envoy.Parcel) error {
 	return nil
 }
 
+// Store a parcel, accumulating the events of the stored copy so that history
+// the carrier no longer reports is kept. A reused tracking number replaces the
+// stored history instead, as the events belong to a different shipment.
 func upsertParcel(p *envoy.Parcel) error {
 	var exists envoy.Parcel
 	err := db.One("TrackingNumber", p.TrackingNumber, &exists)
@@ -78,6 +292,13 @@ func upsertParcel(p *envoy.Parcel) error {
 		log.Fatalf("Error checking if parcel %s exists: %v\n", p.TrackingNumber, err)
 		return err
 	} else {
+		if p.HasData() && exists.HasData() && !p.IsNewShipmentOf(&exists) {
+			// Stale events are replaced by fresh ones rather than kept beside them
+			if !p.Data.StaleEventTimes && len(p.Data.Events) > 0 {
+				exists.Data.DropStaleEvents(p.Carrier)
+			}
+			p.Data.Events = envoy.UnionEvents(exists.Data.Events, p.Data.Events)
+		}
 		return db.Update(p)
 	}
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"go.uber.org/zap"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	path := filepath.Join(t.TempDir(), "envoy.db")
+
+	// A database written before versioning has parcels but no version key
+	v0, err := storm.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := envoy.NewParcel("Books", envoy.CarrierFedEx, "441259201412", "")
+	active.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: time.Now()}},
+	}
+	archived := envoy.NewParcel("Lamp", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	if err := v0.Save(active); err != nil {
+		t.Fatal(err)
+	}
+	if err := v0.From(archiveNode).Save(archived); err != nil {
+		t.Fatal(err)
+	}
+	v0.Close()
+
+	opened, err := storm.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	if version, err := schemaVersion(opened); err != nil || version != 0 {
+		t.Fatalf("Expected an unversioned database at version 0, got %d (%v)", version, err)
+	}
+
+	if err := migrate(opened); err != nil {
+		t.Fatalf("migrate() error = %v", err)
+	}
+	if version, err := schemaVersion(opened); err != nil || version != len(migrations) {
+		t.Errorf("Expected the database at version %d, got %d (%v)", len(migrations), version, err)
+	}
+
+	var p envoy.Parcel
+	if err := opened.One("TrackingNumber", active.TrackingNumber, &p); err != nil {
+		t.Fatalf("Expected the active parcel to survive migration: %v", err)
+	}
+	if p.Name != active.Name || p.LastTrackingEvent() == nil {
+		t.Errorf("Expected the active parcel intact, got %+v", p)
+	}
+	if err := opened.From(archiveNode).One("TrackingNumber", archived.TrackingNumber, &p); err != nil {
+		t.Errorf("Expected the archived parcel to survive migration: %v", err)
+	}
+
+	// Migrating again is a no-op
+	if err := migrate(opened); err != nil {
+		t.Errorf("Expected migrating a current database to succeed, got %v", err)
+	}
+}
+
+func TestMigrateDropsUPSEvents(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	opened, err := storm.Open(filepath.Join(t.TempDir(), "envoy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	if err := opened.Set(metaNode, schemaVersionKey, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2025, 2, 25, 10, 15, 0, 0, time.UTC)
+	ups := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	ups.ShipmentStartedAt = at
+	ups.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypePickedUp, Description: "Picked up", Timestamp: at, SourceCarrier: envoy.CarrierUPS},
+			{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: at.Add(time.Hour)},
+			// Handed off to USPS for the last mile
+			{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: at.Add(24 * time.Hour), SourceCarrier: envoy.CarrierUSPS},
+		},
+	}
+	fedex := envoy.NewParcel("Lamp", envoy.CarrierFedEx, "441259201412", "")
+	fedex.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: at, SourceCarrier: envoy.CarrierFedEx}},
+	}
+	delivered := envoy.NewParcel("Kettle", envoy.CarrierUPS, "1Z12345E0205271688", "")
+	delivered.Data = &envoy.ParcelData{
+		Delivered: true,
+		Events:    []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: at, SourceCarrier: envoy.CarrierUPS}},
+	}
+	archived := envoy.NewParcel("Shoes", envoy.CarrierUPS, "1ZW701150378674373", "")
+	archived.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: at, SourceCarrier: envoy.CarrierUPS}},
+	}
+	for _, p := range []*envoy.Parcel{ups, fedex, delivered} {
+		if err := opened.Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := opened.From(archiveNode).Save(archived); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(opened); err != nil {
+		t.Fatalf("migrate() error = %v", err)
+	}
+
+	var p envoy.Parcel
+	if err := opened.One("TrackingNumber", ups.TrackingNumber, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data.Events) != 1 || p.Data.Events[0].SourceCarrier != envoy.CarrierUSPS {
+		t.Errorf("Expected only the USPS event of the UPS parcel to be kept, got %+v", p.Data.Events)
+	}
+	if !p.ShipmentStartedAt.IsZero() {
+		t.Errorf("Expected the shipment start to be reset, got %v", p.ShipmentStartedAt)
+	}
+	if p.Data.StaleEventTimes {
+		t.Error("Expected the UPS parcel not to be marked stale once its events were dropped")
+	}
+	if err := opened.One("TrackingNumber", delivered.TrackingNumber, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Data.Events) != 1 || !p.Data.StaleEventTimes {
+		t.Errorf("Expected the delivered UPS parcel to keep its events marked stale, got %+v", p.Data)
+	}
+	if err := opened.One("TrackingNumber", fedex.TrackingNumber, &p); err != nil || len(p.Data.Events) != 1 {
+		t.Errorf("Expected the FedEx parcel to keep its events, got %+v (%v)", p.Data, err)
+	}
+	if err := opened.From(archiveNode).One("TrackingNumber", archived.TrackingNumber, &p); err != nil || len(p.Data.Events) != 1 {
+		t.Errorf("Expected the archived parcel to keep its events, got %+v (%v)", p.Data, err)
+	}
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	opened, err := storm.Open(filepath.Join(t.TempDir(), "envoy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+
+	if err := opened.Set(metaNode, schemaVersionKey, len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(opened); err == nil || !strings.Contains(err.Error(), "upgrade envoy") {
+		t.Errorf("Expected an error asking to upgrade envoy, got %v", err)
+	}
+}
+
+func TestUpsertParcelAccumulatesEvents(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+
+	base := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	pickedUp := envoy.ParcelEvent{Type: envoy.ParcelEventTypePickedUp, Description: "Picked up", Location: "SEATTLE, WA", Timestamp: base}
+	departed := envoy.ParcelEvent{Type: envoy.ParcelEventTypeDeparted, Description: "Departed", Location: "SEATTLE, WA", Timestamp: base.Add(time.Hour)}
+	arrived := envoy.ParcelEvent{Type: envoy.ParcelEventTypeArrived, Description: "Arrived", Location: "FRESNO, CA", Timestamp: base.Add(5 * time.Hour)}
+	// The same scan reported at another facility is a different event
+	arrivedElsewhere := arrived
+	arrivedElsewhere.Location = "MODESTO, CA"
+	outForDelivery := envoy.ParcelEvent{Type: envoy.ParcelEventTypeOutForDelivery, Description: "Out for delivery", Location: "LOS ANGELES, CA", Timestamp: base.Add(20 * time.Hour)}
+
+	first := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	first.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{pickedUp, departed, arrived}}
+	if err := upsertParcel(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// The carrier has since truncated the oldest scan and reordered the rest
+	second := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	second.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{outForDelivery, arrivedElsewhere, arrived, departed}}
+	if err := upsertParcel(second); err != nil {
+		t.Fatal(err)
+	}
+	// Upserting the same events again changes nothing
+	if err := upsertParcel(second); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := getParcel(first.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []envoy.ParcelEvent{pickedUp, departed, arrived, arrivedElsewhere, outForDelivery}
+	if len(stored.Data.Events) != len(want) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(want), len(stored.Data.Events), stored.Data.Events)
+	}
+	for i, e := range stored.Data.Events {
+		if e.Type != want[i].Type || e.Location != want[i].Location || !e.Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("Expected event %d to be %+v, got %+v", i, want[i], e)
+		}
+	}
+}
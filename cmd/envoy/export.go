@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+var exportOutput string
+
+// Header of exported CSVs. The first columns match those read by import, so
+// that an export can be imported again.
+var exportHeader = []string{
+	importColumnTrackingNumber,
+	importColumnName,
+	importColumnCarrier,
+	"status",
+	"last_event_at",
+	"delivered",
+	"delivery_projection",
+	"tracking_url",
+}
+
+// Format a parcel as an export row, leaving tracking data empty for parcels
+// which have never been fetched
+func exportRow(p *envoy.Parcel) []string {
+	var status, lastEventAt, delivered, projection string
+	if e := p.LastTrackingEvent(); e != nil {
+		status = e.Description
+		lastEventAt = e.Timestamp.Format(time.RFC3339)
+	}
+	if p.HasData() {
+		delivered = strconv.FormatBool(p.Data.Delivered)
+		if p.Data.DeliveryProjection != nil {
+			projection = p.Data.DeliveryProjection.Format(time.RFC3339)
+		}
+	}
+
+	return []string{
+		p.TrackingNumber,
+		p.Name,
+		string(p.Carrier),
+		status,
+		lastEventAt,
+		delivered,
+		projection,
+		p.TrackingURL,
+	}
+}
+
+// Write the parcels as CSV with a header row
+func writeExportCSV(w io.Writer, parcels []*envoy.Parcel) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportHeader); err != nil {
+		return err
+	}
+	for _, p := range parcels {
+		if err := writer.Write(exportRow(p)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func Export(cmd *cobra.Command, args []string) {
+	parcels, err := fetchParcels()
+	if err != nil {
+		log.Fatalf("error fetching parcels: %v", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if exportOutput != "" && exportOutput != "-" {
+		f, err := os.Create(exportOutput)
+		if err != nil {
+			log.Fatalf("could not create %s: %v", exportOutput, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := writeExportCSV(out, parcels); err != nil {
+		log.Fatalf("error writing %s: %v", exportOutput, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm/v3"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+// Open an empty database for the duration of the test
+func openTestDB(t *testing.T) {
+	t.Helper()
+	opened, err := storm.Open(filepath.Join(t.TempDir(), "envoy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = opened
+	t.Cleanup(func() {
+		opened.Close()
+		if db == opened {
+			db = nil
+		}
+	})
+}
+
+func TestWriteExportCSV(t *testing.T) {
+	openTestDB(t)
+
+	at := time.Date(2024, 3, 1, 14, 30, 0, 0, time.UTC)
+	projected := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	delivered := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "https://ups.example/1Z5R89390357567127")
+	delivered.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: at},
+		},
+		Delivered:          true,
+		DeliveryProjection: &projected,
+	}
+	pending := envoy.NewParcel("Gift", envoy.CarrierFedEx, "441259201412", "")
+	for _, p := range []*envoy.Parcel{delivered, pending} {
+		if err := upsertParcel(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parcels, err := fetchParcels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeExportCSV(&buf, parcels); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got %d records", len(records))
+	}
+	if !slices.Equal(records[0], exportHeader) {
+		t.Errorf("Expected header %v, got %v", exportHeader, records[0])
+	}
+
+	rows := map[string][]string{}
+	for _, r := range records[1:] {
+		rows[r[0]] = r
+	}
+	want := []string{
+		"1Z5R89390357567127", "Books", "UPS", "Delivered", "2024-03-01T14:30:00Z",
+		"true", "2024-03-02T00:00:00Z", "https://ups.example/1Z5R89390357567127",
+	}
+	if got := rows[delivered.TrackingNumber]; !slices.Equal(got, want) {
+		t.Errorf("Expected row %q, got %q", want, got)
+	}
+	want = []string{"441259201412", "Gift", "FedEx", "", "", "", "", ""}
+	if got := rows[pending.TrackingNumber]; !slices.Equal(got, want) {
+		t.Errorf("Expected row %q, got %q", want, got)
+	}
+}
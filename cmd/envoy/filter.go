@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// statusFilter selects parcels by their overall tracking status
+type statusFilter string
+
+const (
+	statusFilterAll       statusFilter = "all"
+	statusFilterActive    statusFilter = "active"
+	statusFilterDelivered statusFilter = "delivered"
+	statusFilterException statusFilter = "exception"
+)
+
+var statusFilters = []statusFilter{
+	statusFilterAll,
+	statusFilterActive,
+	statusFilterDelivered,
+	statusFilterException,
+}
+
+func parseStatusFilter(s string) (statusFilter, error) {
+	if s == "" {
+		return statusFilterAll, nil
+	}
+	for _, f := range statusFilters {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+
+	names := make([]string, 0, len(statusFilters))
+	for _, f := range statusFilters {
+		names = append(names, string(f))
+	}
+	return "", fmt.Errorf("unknown status %q (expected one of: %s)", s, strings.Join(names, ", "))
+}
+
+func (f statusFilter) matches(p *envoy.Parcel) bool {
+	switch f {
+	case statusFilterDelivered:
+		return isDelivered(p)
+	case statusFilterException:
+		return isException(p)
+	case statusFilterActive:
+		return !isDelivered(p) && !isException(p)
+	default:
+		return true
+	}
+}
+
+func filterParcels(parcels []*envoy.Parcel, f statusFilter) []*envoy.Parcel {
+	var filtered []*envoy.Parcel
+	for _, p := range parcels {
+		if f.matches(p) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+func isDelivered(p *envoy.Parcel) bool {
+	return p.HasData() && p.Data.Delivered
+}
+
+func isException(p *envoy.Parcel) bool {
+	if p.HasError() {
+		return true
+	}
+	if e := p.LastTrackingEvent(); e != nil {
+		return isExceptionEvent(e)
+	}
+	return false
+}
+
+func isExceptionEvent(e *envoy.ParcelEvent) bool {
+	return e.Type.Severity() == envoy.SeverityError
+}
+
+// Reports whether a parcel's name, tracking number, or carrier fuzzily
+// matches a query, with its characters appearing in order but not
+// necessarily adjacent. Case and spaces in the query are ignored.
+func matchesQuery(p *envoy.Parcel, query string) bool {
+	query = strings.ToLower(strings.ReplaceAll(query, " ", ""))
+	for _, s := range []string{p.Name, p.TrackingNumber, string(p.Carrier)} {
+		if fuzzyMatch(strings.ToLower(s), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the runes of the query appear in s in order
+func fuzzyMatch(s, query string) bool {
+	for _, r := range query {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+utf8.RuneLen(r):]
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// The directory carrier responses are recorded to when tracking, for use as
+// test fixtures. Empty unless set by the hidden --record-fixtures flag.
+var recordFixturesDir string
+
+// recordingTransport saves the JSON body of every carrier response, other
+// than authentication, to dir as <carrier>-<hash>.json. The hash is of the
+// request, so that files identify the tracking numbers without revealing them.
+type recordingTransport struct {
+	dir     string
+	carrier envoy.Carrier
+	base    http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, err := t.fixtureName(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil || strings.Contains(req.URL.Path, "/oauth") ||
+		!strings.Contains(res.Header.Get("Content-Type"), "json") {
+		return res, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := t.record(name, body); err != nil {
+		log.Warnf("could not record %s response: %v", t.carrier, err)
+	}
+	return res, nil
+}
+
+// Returns the file name for the response to req, from a hash of its URL and
+// body
+func (t *recordingTransport) fixtureName(req *http.Request) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(req.URL.String()))
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+	}
+	sum := hex.EncodeToString(h.Sum(nil))[:16]
+	return fmt.Sprintf("%s-%s.json", strings.ToLower(string(t.carrier)), sum), nil
+}
+
+// Write a scrubbed, indented copy of a response body to the directory
+func (t *recordingTransport) record(name string, body []byte) error {
+	scrubbed := envoy.Scrub(body, t.carrier)
+	if scrubbed == nil {
+		return errors.New("response is not JSON")
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, scrubbed, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(t.dir, name), out.Bytes(), 0600)
+}
+
+// Construct the client for tracking with a carrier, which records its
+// responses if --record-fixtures is set
+func trackingClient(carrier envoy.Carrier) *http.Client {
+	client := newHTTPClient(0)
+	if recordFixturesDir != "" {
+		client.Transport = &recordingTransport{dir: recordFixturesDir, carrier: carrier, base: client.Transport}
+	}
+	return client
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(r.URL.Path, "/oauth") {
+			w.Write([]byte(`{"access_token":"secret"}`))
+			return
+		}
+		w.Write([]byte(`{"output":{"recipient":{"personName":"Jane Doe","city":"AUSTIN"}},"events":[{"type":"DL"}]}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	client := &http.Client{Transport: &recordingTransport{dir: dir, carrier: envoy.CarrierFedEx, base: http.DefaultTransport}}
+	for _, p := range []string{"/oauth/token", "/track/v1/441259201412"} {
+		res, err := client.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v map[string]any
+		if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+			t.Errorf("Expected the response body to still be readable, got %v", err)
+		}
+		res.Body.Close()
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected one recorded response, got %d", len(entries))
+	}
+	name := entries[0].Name()
+	if !strings.HasPrefix(name, "fedex-") || strings.Contains(name, "441259201412") {
+		t.Errorf("Expected a file named by carrier and hash, got %s", name)
+	}
+
+	b, err := os.ReadFile(path.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "Jane Doe") || !strings.Contains(string(b), "AUSTIN") {
+		t.Errorf("Expected the recipient's name to be redacted, got %s", b)
+	}
+}
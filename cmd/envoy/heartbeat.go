@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// heartbeat records the outcome of each polling cycle to a file, so that an
+// external monitor can alert when it goes stale
+type heartbeat struct {
+	path string
+	now  func() time.Time
+
+	// When the last cycle finished, whether or not it succeeded
+	LastPoll time.Time `json:"last_poll"`
+	// When the last cycle finished without error
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// The error of the last cycle, if it failed
+	LastError string `json:"last_error,omitempty"`
+	// The polling interval, after which the next cycle is due
+	Interval string `json:"interval"`
+}
+
+func newHeartbeat(path string, interval time.Duration) *heartbeat {
+	return &heartbeat{
+		path:     path,
+		now:      time.Now,
+		Interval: interval.String(),
+	}
+}
+
+// Record the outcome of a cycle and rewrite the heartbeat file. The file is
+// replaced atomically so that monitors never read a partial write.
+func (h *heartbeat) record(cycleErr error) error {
+	now := h.now()
+	h.LastPoll = now
+	if cycleErr != nil {
+		h.LastError = cycleErr.Error()
+	} else {
+		h.LastSuccess = &now
+		h.LastError = ""
+	}
+
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.path)
+}
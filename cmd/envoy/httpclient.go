@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// The transport underlying every carrier request, configured by newTransport
+var httpTransport http.RoundTripper = http.DefaultTransport
+
+// Construct a transport trusting the configured CA and presenting the
+// configured client certificate, if any
+func newTransport(c HTTPConfig) (http.RoundTripper, error) {
+	if c.CACert == "" && c.ClientCert == "" && c.ClientKey == "" && !c.InsecureSkipVerify {
+		return http.DefaultTransport, nil
+	}
+
+	tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CACert != "" {
+		pem, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("ca_cert: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_cert: no certificates found in %s", c.CACert)
+		}
+		tlsConf.RootCAs = pool
+	}
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, errors.New("client_cert and client_key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client_cert: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	if c.InsecureSkipVerify {
+		log.Warn("http.insecure_skip_verify is enabled: carrier certificates are NOT verified, and credentials may be intercepted")
+		tlsConf.InsecureSkipVerify = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	return transport, nil
+}
+
+// headerTransport adds configured headers to every outbound request, such as
+// those required by a corporate proxy, without overriding headers that the
+// carrier client set itself
+type headerTransport struct {
+	headers http.Header
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) > 0 {
+		// A RoundTripper must not modify the request it was given
+		req = req.Clone(req.Context())
+		for k, vs := range t.headers {
+			if req.Header.Get(k) != "" {
+				continue
+			}
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+	log.Debugf("%s %s %v", req.Method, req.URL.Redacted(), t.redact(req.Header))
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// Returns a copy of h fit for logging, with credentials and configured header
+// values replaced
+func (t *headerTransport) redact(h http.Header) http.Header {
+	out := h.Clone()
+	for k := range out {
+		if _, ok := t.headers[k]; ok || isCredentialHeader(k) {
+			out[k] = []string{redacted}
+		}
+	}
+	return out
+}
+
+// Whether a header carries a credential, such as the bearer tokens of FedEx,
+// UPS, and USPS, or the API key DHL expects in a header of its own
+func isCredentialHeader(k string) bool {
+	k = strings.ToLower(k)
+	switch k {
+	case "authorization", "dhl-api-key":
+		return true
+	}
+	return strings.HasSuffix(k, "-key") || strings.HasSuffix(k, "-token")
+}
+
+// Construct the client used for carrier requests, with the configured headers
+func newHTTPClient(timeout time.Duration) *http.Client {
+	headers := make(http.Header, len(conf.HTTP.Headers))
+	for k, v := range conf.HTTP.Headers {
+		headers.Set(k, v)
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &headerTransport{headers: headers, base: httpTransport},
+	}
+}
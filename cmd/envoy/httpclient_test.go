@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHTTPClientHeaders(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	defer func(c Config) { conf = c }(conf)
+	conf.HTTP.Headers = map[string]string{
+		"x-corp-auth":   "corp-token",
+		"Authorization": "Basic corp",
+	}
+
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer carrier")
+	res, err := newHTTPClient(0).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if v := got.Get("X-Corp-Auth"); v != "corp-token" {
+		t.Errorf("Expected X-Corp-Auth corp-token, got %q", v)
+	}
+	if v := got.Get("Authorization"); v != "Bearer carrier" {
+		t.Errorf("Expected the carrier's Authorization to be kept, got %q", v)
+	}
+	if v := req.Header.Get("X-Corp-Auth"); v != "" {
+		t.Errorf("Expected the original request to be unmodified, got X-Corp-Auth %q", v)
+	}
+}
+
+func TestHeaderTransportRedact(t *testing.T) {
+	tr := &headerTransport{headers: http.Header{"X-Corp-Auth": {"corp-token"}}}
+	h := http.Header{
+		"X-Corp-Auth":   {"corp-token"},
+		"Authorization": {"Bearer carrier"},
+		"Accept":        {"application/json"},
+	}
+
+	out := tr.redact(h)
+	for _, k := range []string{"X-Corp-Auth", "Authorization"} {
+		if v := out.Get(k); v != redacted {
+			t.Errorf("Expected %s to be redacted, got %q", k, v)
+		}
+	}
+	if v := out.Get("Accept"); v != "application/json" {
+		t.Errorf("Expected Accept to be kept, got %q", v)
+	}
+	if v := h.Get("Authorization"); v != "Bearer carrier" {
+		t.Errorf("Expected the headers to be unmodified, got Authorization %q", v)
+	}
+}
+
+func TestHeaderTransportRedactsCarrierKeys(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	defer func(l *zap.SugaredLogger) { log = l }(log)
+	log = zap.New(core).Sugar()
+	defer func(c Config) { conf = c }(conf)
+	conf.HTTP.Headers = nil
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// Shaped like a DHL Shipment Tracking request
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/track/shipments?trackingNumber=00340434292135100186", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("DHL-API-Key", "dhl-secret")
+	req.Header.Set("X-Session-Token", "session-secret")
+	res, err := newHTTPClient(0).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	entries := logs.All()
+	if len(entries) == 0 {
+		t.Fatal("Expected the request to be logged")
+	}
+	for _, e := range entries {
+		for _, secret := range []string{"dhl-secret", "session-secret"} {
+			if strings.Contains(e.Message, secret) {
+				t.Errorf("Expected %q to be redacted, got %s", secret, e.Message)
+			}
+		}
+		if !strings.Contains(e.Message, "application/json") {
+			t.Errorf("Expected other headers to be logged, got %s", e.Message)
+		}
+	}
+}
+
+// Writes a self-signed certificate and its key as PEM files, returning their paths
+func writeTestKeyPair(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "envoy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath := path.Join(dir, "client.pem"), path.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestNewTransport(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	if tr, err := newTransport(HTTPConfig{}); err != nil || tr != http.DefaultTransport {
+		t.Errorf("Expected the default transport without TLS config, got %v, %v", tr, err)
+	}
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	caPath := path.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := writeTestKeyPair(t)
+
+	tr, err := newTransport(HTTPConfig{CACert: caPath, ClientCert: certPath, ClientKey: keyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf := tr.(*http.Transport).TLSClientConfig
+	if tlsConf.RootCAs == nil || len(tlsConf.Certificates) != 1 || tlsConf.InsecureSkipVerify {
+		t.Errorf("Expected a CA pool and client certificate, got %+v", tlsConf)
+	}
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Expected the configured CA to be trusted, got %v", err)
+	}
+	res.Body.Close()
+
+	tr, err = newTransport(HTTPConfig{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.(*http.Transport).TLSClientConfig.InsecureSkipVerify {
+		t.Error("Expected certificate verification to be skipped")
+	}
+}
+
+func TestNewTransportInvalid(t *testing.T) {
+	certPath, keyPath := writeTestKeyPair(t)
+	notPEM := path.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]HTTPConfig{
+		"missing CA":       {CACert: path.Join(t.TempDir(), "missing.pem")},
+		"invalid CA":       {CACert: notPEM},
+		"cert without key": {ClientCert: certPath},
+		"key without cert": {ClientKey: keyPath},
+		"mismatched pair":  {ClientCert: certPath, ClientKey: certPath},
+	} {
+		if _, err := newTransport(c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// Recognized CSV header names for parcel imports
+const (
+	importColumnTrackingNumber = "tracking_number"
+	importColumnName           = "name"
+	importColumnCarrier        = "carrier"
+)
+
+// importResult describes the outcome of importing a single CSV row
+type importResult struct {
+	line           int
+	trackingNumber string
+	parcel         *envoy.Parcel
+	warning        string
+	err            error
+}
+
+func (r importResult) String() string {
+	switch {
+	case r.err != nil:
+		return fmt.Sprintf("line %d: skipped: %v", r.line, r.err)
+	case r.warning != "":
+		return fmt.Sprintf("line %d: %s: imported (%s)", r.line, r.trackingNumber, r.warning)
+	default:
+		return fmt.Sprintf("line %d: %s: imported", r.line, r.trackingNumber)
+	}
+}
+
+// Parse the carrier named in an import row, case-insensitively
+func parseImportCarrier(s string) (envoy.Carrier, bool) {
+	for _, c := range carrierServices {
+		if strings.EqualFold(s, string(c)) {
+			return c, true
+		}
+	}
+	return envoy.CarrierUnknown, false
+}
+
+// Read parcels from a CSV with a header row. Columns are mapped by header
+// name, so they may appear in any order and unrecognized columns are ignored.
+// Only the tracking_number column is required. An explicit carrier takes
+// precedence over detection.
+func readImportCSV(r io.Reader) ([]importResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := columns[importColumnTrackingNumber]; !ok {
+		return nil, fmt.Errorf("missing %s column", importColumnTrackingNumber)
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var results []importResult
+	seen := make(map[string]struct{})
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				results = append(results, importResult{line: parseErr.StartLine, err: parseErr.Err})
+				continue
+			}
+			return results, err
+		}
+		// FieldPos may only be called after a successful Read
+		line, _ := reader.FieldPos(0)
+
+		result := importResult{line: line}
+		tn := envoy.NormalizeTrackingNumber(field(record, importColumnTrackingNumber))
+		result.trackingNumber = tn
+		if tn == "" {
+			result.err = errors.New("empty tracking number")
+			results = append(results, result)
+			continue
+		}
+		if _, ok := seen[tn]; ok {
+			result.err = fmt.Errorf("duplicate tracking number %s", tn)
+			results = append(results, result)
+			continue
+		}
+
+		carrier := envoy.CarrierUnknown
+		if name := field(record, importColumnCarrier); name != "" {
+			var ok bool
+			if carrier, ok = parseImportCarrier(name); !ok {
+				result.warning = fmt.Sprintf("unknown carrier %q, detecting instead", name)
+			}
+		}
+		if carrier == envoy.CarrierUnknown {
+			carrier = envoy.DetectCarrier(tn)
+		}
+		if carrier == envoy.CarrierUnknown {
+			result.err = fmt.Errorf("could not detect carrier for %s", tn)
+			results = append(results, result)
+			continue
+		}
+
+		name := field(record, importColumnName)
+		if name == "" {
+			name = tn
+		}
+		seen[tn] = struct{}{}
+		result.parcel = envoy.NewParcel(name, carrier, tn, "")
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func Import(cmd *cobra.Command, args []string) {
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatalf("could not open %s: %v", args[0], err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	results, err := readImportCSV(in)
+	if err != nil {
+		log.Fatalf("error reading %s: %v", args[0], err)
+	}
+
+	summary := batchSummary{action: "imported"}
+	for _, r := range results {
+		if r.parcel != nil {
+			if existing, err := getParcel(r.trackingNumber); err == nil && existing != nil {
+				existing.Name = r.parcel.Name
+				existing.Carrier = r.parcel.Carrier
+				r.parcel = existing
+			}
+			if err := upsertParcel(r.parcel); err != nil {
+				r.err = err
+			}
+		}
+		if r.err != nil {
+			summary.skipped++
+		} else {
+			summary.done++
+		}
+		fmt.Println(r)
+	}
+	fmt.Println(summary)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestReadImportCSV(t *testing.T) {
+	t.Run("reordered headers with extra columns", func(t *testing.T) {
+		in := "notes,carrier,name,tracking_number\n" +
+			"gift,ups,Birthday,441259201412\n" +
+			"\"\",FedEx,Books,271278612814\n"
+		results, err := readImportCSV(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("Expected 2 results, got %d", len(results))
+		}
+
+		p := results[0].parcel
+		if p == nil || p.Name != "Birthday" || p.Carrier != envoy.CarrierUPS || p.TrackingNumber != "441259201412" {
+			t.Errorf("Expected explicit UPS parcel named Birthday, got %+v", p)
+		}
+		if p := results[1].parcel; p == nil || p.Carrier != envoy.CarrierFedEx || p.Name != "Books" {
+			t.Errorf("Expected FedEx parcel named Books, got %+v", p)
+		}
+	})
+
+	t.Run("missing optional columns", func(t *testing.T) {
+		in := "Tracking_Number\n1Z5R89390357567127\n"
+		results, err := readImportCSV(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].parcel == nil {
+			t.Fatalf("Expected 1 imported parcel, got %+v", results)
+		}
+		p := results[0].parcel
+		if p.Carrier != envoy.CarrierUPS || p.Name != "1Z5R89390357567127" {
+			t.Errorf("Expected detected UPS parcel named after its tracking number, got %+v", p)
+		}
+	})
+
+	t.Run("missing tracking number column", func(t *testing.T) {
+		if _, err := readImportCSV(strings.NewReader("name,carrier\nBooks,FedEx\n")); err == nil {
+			t.Error("Expected an error for a missing tracking_number column")
+		}
+	})
+
+	t.Run("malformed rows", func(t *testing.T) {
+		in := "tracking_number,name,carrier\n" +
+			",Empty,FedEx\n" +
+			"271278612814,Bad\"quote,FedEx\n" +
+			"441259201412,Unknown carrier,Pigeon\n" +
+			"441259201412,Duplicate,FedEx\n" +
+			"short\n"
+		results, err := readImportCSV(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var imported, skipped int
+		for _, r := range results {
+			if r.err != nil {
+				skipped++
+			} else {
+				imported++
+			}
+		}
+		if imported != 1 {
+			t.Errorf("Expected 1 imported row, got %d: %v", imported, results)
+		}
+		if skipped == 0 {
+			t.Errorf("Expected malformed rows to be skipped, got %v", results)
+		}
+		if results[0].err == nil || results[0].line != 2 {
+			t.Errorf("Expected empty tracking number on line 2 to be skipped, got %v", results[0])
+		}
+	})
+
+	t.Run("malformed tracking number", func(t *testing.T) {
+		for _, row := range []string{"\"abc,Books,FedEx\n", "a\"b,Books,FedEx\n"} {
+			in := "tracking_number,name,carrier\n" + row + "441259201412,Shoes,FedEx\n"
+			results, err := readImportCSV(strings.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) == 0 || results[0].err == nil || results[0].line != 2 {
+				t.Errorf("Expected row %q on line 2 to be skipped, got %v", row, results)
+			}
+		}
+	})
+
+	t.Run("unknown carrier warns and detects", func(t *testing.T) {
+		in := "tracking_number,carrier\n441259201412,Pigeon\n"
+		results, err := readImportCSV(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := results[0]
+		if r.err != nil || r.warning == "" || r.parcel.Carrier != envoy.CarrierFedEx {
+			t.Errorf("Expected detected FedEx parcel with a warning, got %v", r)
+		}
+	})
+}
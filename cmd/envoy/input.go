@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	inputStdin bool
+	inputFile  string
+)
+
+// Read tracking numbers from r, ignoring blank lines and anything after a #.
+// Each line holds one number unless split is set, when every line is split
+// on whitespace so numbers may also be separated by spaces.
+func readTrackingNumbers(r io.Reader, split bool) ([]string, error) {
+	var numbers []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if split {
+			numbers = append(numbers, strings.Fields(line)...)
+		} else if line = strings.TrimSpace(line); line != "" {
+			numbers = append(numbers, line)
+		}
+	}
+	return numbers, scanner.Err()
+}
+
+// Append the tracking numbers read from stdin when useStdin is set, and from
+// the file if one is named, to the positional arguments
+func mergeInputArgs(args []string, stdin io.Reader, useStdin bool, file string) ([]string, error) {
+	merged := append([]string(nil), args...)
+	if useStdin {
+		numbers, err := readTrackingNumbers(stdin, true)
+		if err != nil {
+			return nil, fmt.Errorf("could not read stdin: %w", err)
+		}
+		merged = append(merged, numbers...)
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		numbers, err := readTrackingNumbers(f, false)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", file, err)
+		}
+		merged = append(merged, numbers...)
+	}
+	return merged, nil
+}
+
+// Merge the tracking numbers given with --stdin and --file into the
+// positional arguments, exiting if they cannot be read
+func inputArgs(args []string) []string {
+	merged, err := mergeInputArgs(args, os.Stdin, inputStdin, inputFile)
+	if err != nil {
+		log.Fatalf("error reading tracking numbers: %v", err)
+	}
+	return merged
+}
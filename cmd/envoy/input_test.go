@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadTrackingNumbers(t *testing.T) {
+	input := "# orders\n1Z5R89390357567127 441259201412\n\n  9400123456789012345674  # lamp\n#1ZW701150378674373\n"
+
+	tests := []struct {
+		name  string
+		split bool
+		want  []string
+	}{
+		{"split", true, []string{"1Z5R89390357567127", "441259201412", "9400123456789012345674"}},
+		{"one per line", false, []string{"1Z5R89390357567127 441259201412", "9400123456789012345674"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readTrackingNumbers(strings.NewReader(input), tt.split)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMergeInputArgs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "orders.txt")
+	if err := os.WriteFile(file, []byte("# from the shop\n1z5r 8939 0357 5671 27\n\n441259201412 # desk\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdin := strings.NewReader("9400123456789012345674\n1ZW701150378674373 # lamp\n")
+
+	got, err := mergeInputArgs([]string{"1Z0000000000000001"}, stdin, true, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1Z0000000000000001", "9400123456789012345674", "1ZW701150378674373", "1z5r 8939 0357 5671 27", "441259201412"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Stdin is left alone unless asked for
+	got, err = mergeInputArgs(nil, strings.NewReader("9400123456789012345674\n"), false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Expected no tracking numbers, got %q", got)
+	}
+
+	if _, err := mergeInputArgs(nil, nil, false, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
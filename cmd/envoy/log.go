@@ -6,6 +6,8 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
 )
 
 var log *zap.SugaredLogger
@@ -20,6 +22,7 @@ func initLogger(cmd *cobra.Command) {
 	))
 	defer unsugared.Sync()
 	log = unsugared.Sugar()
+	envoy.Debugf = log.Debugf
 
 	if logLevel, err := cmd.Flags().GetString("log-level"); err != nil {
 		log.Fatalf("could not read log-level: %v", err)
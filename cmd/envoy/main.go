@@ -2,19 +2,19 @@ package main
 
 import (
 	"fmt"
-	"net/http"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
 	envoy "github.com/rektdeckard/envoy/pkg"
-	"github.com/rektdeckard/envoy/pkg/fedex"
-	"github.com/rektdeckard/envoy/pkg/ups"
-	"github.com/rektdeckard/envoy/pkg/usps"
+	"github.com/rektdeckard/envoy/pkg/pool"
 )
 
 const version = "0.1.0"
@@ -34,9 +34,33 @@ var (
 		envoy.CarrierFedEx,
 		envoy.CarrierUPS,
 		envoy.CarrierUSPS,
+		envoy.CarrierDHL,
 	}
 )
 
+// Exit codes of the track command, for scripts
+const (
+	exitOK = 0
+	// Some parcel could not be tracked, reported with --quiet
+	exitTrackingFailed = 1
+	// None of the tracking numbers belong to a supported carrier
+	exitNoCarrier = 2
+)
+
+var (
+	// Whether the track command prints nothing, only reporting failures
+	// through its exit code
+	trackQuiet  bool
+	trackStatus string
+)
+
+var (
+	carrierFlag string
+	// The carrier given with --carrier, which positional tracking numbers are
+	// tracked with instead of their detected carrier
+	carrierOverride envoy.Carrier
+)
+
 func init() {
 	rootCmd.PersistentFlags().
 		StringVarP(
@@ -48,6 +72,38 @@ func init() {
 		)
 	rootCmd.PersistentFlags().
 		StringP("log-level", "l", "warn", "Set log level")
+	rootCmd.PersistentFlags().
+		StringVar(
+			&outputFormatFlag,
+			"format",
+			string(outputFormatText),
+			"Output `FORMAT` of parcels: text, json (one indented array), or ndjson (one parcel per line, safe to append to logs)",
+		)
+
+	rootCmd.PersistentFlags().
+		BoolVar(
+			&collapseTimeline,
+			"collapse",
+			false,
+			"Group consecutive events at the same location into a single line of timelines",
+		)
+
+	rootCmd.PersistentFlags().
+		StringVar(
+			&carrierFlag,
+			"carrier",
+			"",
+			"Track all given tracking numbers with `CARRIER` (fedex, ups, usps, or dhl) instead of detecting it",
+		)
+
+	rootCmd.PersistentFlags().
+		StringVarP(
+			&timezoneFlag,
+			"timezone",
+			"z",
+			"local",
+			"Show timestamps in time `ZONE`, an IANA name such as America/New_York, or local",
+		)
 
 	for _, c := range carrierServices {
 		rootCmd.PersistentFlags().StringSlice(
@@ -58,10 +114,15 @@ func init() {
 	}
 
 	trackCmd := &cobra.Command{
-		Use:        "track",
-		Short:      "Retrieves the current tracking status for one or more packages",
+		Use:   "track",
+		Short: "Retrieves the current tracking status for one or more packages",
+		Long: "Retrieves the current tracking status for one or more packages. With\n" +
+			"--tag, only parcels with every given tag are shown, and all stored parcels\n" +
+			"with the tags are tracked if no tracking numbers are given.\n\n" +
+			"Exits with status 2 if none of the tracking numbers belong to a supported\n" +
+			"carrier. With --quiet, nothing is printed, and the status is 1 if any\n" +
+			"parcel could not be tracked, or 0 if all of them were.",
 		SuggestFor: []string{"tracking", "status"},
-		Args:       cobra.MinimumNArgs(1),
 		ArgAliases: []string{"tracking_number"},
 		Run:        Track,
 	}
@@ -71,15 +132,378 @@ func init() {
 		false,
 		"Display tracking information on a single line",
 	)
+	trackCmd.Flags().DurationVar(
+		&trackMaxAge,
+		"max-age",
+		defaultTrackMaxAge,
+		"Answer from stored parcels synced within `DURATION` instead of fetching (by default, every parcel is fetched)",
+	)
+	trackCmd.Flags().BoolVarP(
+		&trackForce,
+		"force", "f",
+		false,
+		"Always fetch from the carrier, ignoring stored parcels",
+	)
+	trackCmd.Flags().BoolVarP(
+		&trackQuiet,
+		"quiet", "q",
+		false,
+		"Print nothing, exiting with status 1 if any parcel could not be tracked",
+	)
+	trackCmd.Flags().StringVarP(
+		&trackStatus,
+		"status", "s",
+		string(statusFilterAll),
+		"Only show parcels with `STATUS` (all, active, delivered, exception)",
+	)
+	trackCmd.Flags().StringSliceVar(
+		&trackTags,
+		"tag",
+		nil,
+		"Only show parcels with `TAG` (repeatable)",
+	)
+	trackCmd.Flags().BoolVar(
+		&inputStdin,
+		"stdin",
+		false,
+		"Also read whitespace-separated tracking numbers from stdin",
+	)
+	trackCmd.Flags().StringVar(
+		&inputFile,
+		"file",
+		"",
+		"Also read tracking numbers from `PATH`, one per line, # starting a comment",
+	)
+	trackCmd.Flags().StringVar(
+		&recordFixturesDir,
+		"record-fixtures",
+		"",
+		"Save scrubbed carrier responses to `DIR` for use as test fixtures",
+	)
+	trackCmd.Flags().MarkHidden("record-fixtures")
 
-	rootCmd.AddCommand(&cobra.Command{
+	openCmd := &cobra.Command{
+		Use:   "open",
+		Short: "Opens the tracking pages of stored parcels, or any tracking numbers, in the browser",
+		Long: "Opens the tracking pages of stored parcels, or any tracking numbers, in the\n" +
+			"browser. Stored parcels may be given by a unique prefix or suffix of their\n" +
+			"tracking number.",
+		ArgAliases: []string{"tracking_number"},
+		Run:        Open,
+	}
+	openCmd.Flags().StringVarP(
+		&openStatus,
+		"status", "s",
+		string(statusFilterAll),
+		"Only open parcels with `STATUS` (all, active, delivered, exception)",
+	)
+	openCmd.Flags().BoolVarP(
+		&openPrint,
+		"print", "p",
+		false,
+		"Print the tracking URLs instead of opening them",
+	)
+	openCmd.Flags().BoolVarP(
+		&openYes,
+		"yes", "y",
+		false,
+		fmt.Sprintf("Skip confirmation when opening more than %d URLs", openConfirmThreshold),
+	)
+
+	addCmd := &cobra.Command{
 		Use:        "add",
 		Short:      "Adds a new tracking number(s) to the database",
+		ArgAliases: []string{"tracking_number"},
+		Run: func(cmd *cobra.Command, args []string) {
+			args = inputArgs(args)
+			if len(args) == 0 {
+				log.Fatal("specify tracking numbers, --stdin, or --file")
+			}
+			if addRunTUI {
+				AddAndRunTUI(cmd, args)
+			} else {
+				Add(cmd, args)
+			}
+		},
+	}
+	addCmd.Flags().StringArrayVarP(
+		&addNames,
+		"name", "n",
+		nil,
+		"`NAME` of the parcel, paired in order with the tracking numbers (repeatable)",
+	)
+	addCmd.Flags().StringArrayVar(
+		&addNotes,
+		"note",
+		nil,
+		"`NOTE` on the parcel, paired in order with the tracking numbers (repeatable)",
+	)
+	addCmd.Flags().StringSliceVar(
+		&addTags,
+		"tag",
+		nil,
+		"Label every added parcel with `TAG` (repeatable)",
+	)
+	addCmd.Flags().BoolVar(
+		&inputStdin,
+		"stdin",
+		false,
+		"Also read whitespace-separated tracking numbers from stdin",
+	)
+	addCmd.Flags().StringVar(
+		&inputFile,
+		"file",
+		"",
+		"Also read tracking numbers from `PATH`, one per line, # starting a comment",
+	)
+	addCmd.Flags().BoolVarP(
+		&addRunTUI,
+		"tui", "t",
+		false,
+		"Open the TUI after adding",
+	)
+	importCmd := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Imports parcels from a CSV file with tracking_number, name, and carrier columns",
+		Long: "Imports parcels from a CSV file with a header row. Columns are matched by " +
+			"name and may appear in any order; only tracking_number is required. " +
+			"Pass - to read from stdin.",
+		Args: cobra.ExactArgs(1),
+		Run:  Import,
+	}
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Exports stored parcels as CSV",
+		Args:  cobra.NoArgs,
+		Run:   Export,
+	}
+	exportCmd.Flags().StringVarP(
+		&exportOutput,
+		"output", "o",
+		"",
+		"Write the CSV to `FILE` instead of stdout",
+	)
+
+	rmCmd := &cobra.Command{
+		Use:   "rm",
+		Short: "Removes stored parcels from the database",
+		Long: "Removes stored parcels from the database. Parcels may be given by tracking\n" +
+			"number, or by a unique prefix or suffix of one.",
+		ArgAliases: []string{"tracking_number"},
+		Run:        Remove,
+	}
+	rmCmd.Flags().BoolVarP(
+		&removeAll,
+		"all", "a",
+		false,
+		"Remove all stored parcels",
+	)
+	rmCmd.Flags().BoolVarP(
+		&removeDelivered,
+		"delivered", "d",
+		false,
+		"Remove all delivered parcels",
+	)
+	rmCmd.Flags().BoolVarP(
+		&removeYes,
+		"yes", "y",
+		false,
+		"Skip confirmation",
+	)
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Removes delivered parcels from the database",
+		Args:  cobra.NoArgs,
+		Run:   Prune,
+	}
+	pruneCmd.Flags().BoolVarP(
+		&removeYes,
+		"yes", "y",
+		false,
+		"Skip confirmation",
+	)
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:        "sync",
+		Short:      "Refreshes one or more packages and shows what changed since they were stored",
 		Args:       cobra.MinimumNArgs(1),
 		ArgAliases: []string{"tracking_number"},
-		Run:        AddAndRunTUI,
+		Run:        Sync,
 	})
+	watchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Polls stored parcels and prints a line whenever one has a new event",
+		Long: "Polls all stored parcels which have not been delivered on an interval,\n" +
+			"without opening the TUI, and prints a line whenever the latest event of a\n" +
+			"parcel changes. Runs until interrupted, so suits running under a service\n" +
+			"manager or in a terminal multiplexer.",
+		Args: cobra.NoArgs,
+		Run:  Watch,
+	}
+	watchCmd.Flags().DurationVar(
+		&watchInterval,
+		"interval",
+		defaultWatchInterval,
+		"Poll every `DURATION`",
+	)
+	rootCmd.AddCommand(watchCmd)
 	rootCmd.AddCommand(trackCmd)
+	rootCmd.AddCommand(openCmd)
+	rootCmd.AddCommand(addCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "backup FILE",
+		Short: "Backs up all stored parcels to a file",
+		Long: "Backs up all stored parcels, archived ones included, with their names, " +
+			"histories, and sync state, to a JSON file which restore can load on " +
+			"another machine. Files ending in .gz are gzipped. Pass - to write to stdout.",
+		Args: cobra.ExactArgs(1),
+		Run:  Backup,
+	})
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "restore FILE",
+		Short: "Restores parcels from a backup, replacing stored copies",
+		Long: "Restores parcels from a file written by backup, which may be gzipped. " +
+			"Parcels already stored are replaced by their backed up copies, and other " +
+			"stored parcels are kept. Pass - to read from stdin.",
+		Args: cobra.ExactArgs(1),
+		Run:  Restore,
+	})
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "tag TRACKING_NUMBER TAG...",
+		Short: "Adds tags to a stored parcel",
+		Long: "Adds tags to a stored parcel, to organize parcels and filter them with\n" +
+			"track --tag. Tags are case-insensitive, and the parcel may be given by a\n" +
+			"unique prefix or suffix of its tracking number.",
+		Args: cobra.MinimumNArgs(2),
+		Run:  Tag,
+	})
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "untag TRACKING_NUMBER TAG...",
+		Short: "Removes tags from a stored parcel",
+		Args:  cobra.MinimumNArgs(2),
+		Run:   Untag,
+	})
+	noteCmd := &cobra.Command{
+		Use:   "note TRACKING_NUMBER [NOTE]",
+		Short: "Shows or sets the note on a stored parcel",
+		Long: "Shows the note on a stored parcel, or replaces it with NOTE. Pass an empty\n" +
+			"NOTE to clear it, or --append to add NOTE on a new line instead. The parcel\n" +
+			"may be given by a unique prefix or suffix of its tracking number.",
+		Args: cobra.RangeArgs(1, 2),
+		Run:  Note,
+	}
+	noteCmd.Flags().BoolVarP(
+		&noteAppend,
+		"append", "a",
+		false,
+		"Add NOTE to the end of the existing note instead of replacing it",
+	)
+	rootCmd.AddCommand(noteCmd)
+	badgeCmd := &cobra.Command{
+		Use:   "badge TRACKING_NUMBER",
+		Short: "Prints a status badge for a stored parcel",
+		Long: "Prints a status badge for a stored parcel as shields.io endpoint JSON, or\n" +
+			"as an SVG with --svg, colored by its status. Only stored data is used, so\n" +
+			"no carrier is contacted. The parcel may be given by a unique prefix or\n" +
+			"suffix of its tracking number.",
+		Args: cobra.ExactArgs(1),
+		Run:  Badge,
+	}
+	badgeCmd.Flags().BoolVar(
+		&badgeSVG,
+		"svg",
+		false,
+		"Print an SVG instead of JSON",
+	)
+	rootCmd.AddCommand(badgeCmd)
+	podCmd := &cobra.Command{
+		Use:   "pod TRACKING_NUMBER",
+		Short: "Downloads the proof of delivery of a stored parcel",
+		Long: "Downloads the delivery photo, signature, or proof of delivery letter of a\n" +
+			"delivered parcel from its carrier, and records where it was saved. Only UPS\n" +
+			"and FedEx provide proof of delivery. The parcel may be given by a unique\n" +
+			"prefix or suffix of its tracking number.",
+		Args: cobra.ExactArgs(1),
+		Run:  ProofOfDelivery,
+	}
+	podCmd.Flags().StringVarP(
+		&podOutput,
+		"output",
+		"o",
+		"",
+		"File to save to (default TRACKING_NUMBER-pod.EXT)",
+	)
+	rootCmd.AddCommand(podCmd)
+	lsCmd := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "Lists stored parcels without fetching them",
+		Args:    cobra.NoArgs,
+		Run:     List,
+	}
+	lsCmd.Flags().BoolVar(
+		&lsArchived,
+		"archived",
+		false,
+		"List archived parcels instead of active ones",
+	)
+	lsCmd.Flags().StringVarP(
+		&lsStatus,
+		"status", "s",
+		string(statusFilterAll),
+		"Only list parcels with `STATUS` (all, active, delivered, exception)",
+	)
+	lsCmd.Flags().StringSliceVar(
+		&lsTags,
+		"tag",
+		nil,
+		"Only list parcels with `TAG` (repeatable)",
+	)
+	lsCmd.Flags().BoolVarP(
+		&oneline,
+		"oneline", "o",
+		false,
+		"Display tracking information on a single line",
+	)
+	rootCmd.AddCommand(lsCmd)
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "archive TRACKING_NUMBER...",
+		Short: "Moves stored parcels to the archive",
+		Long: "Moves stored parcels to the archive, where they are kept but no longer\n" +
+			"listed or refreshed. Delivered parcels are archived automatically after\n" +
+			"tracking if archive_delivered_after is configured.",
+		Args: cobra.MinimumNArgs(1),
+		Run:  Archive,
+	})
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "unarchive TRACKING_NUMBER...",
+		Short: "Restores archived parcels to the active parcels",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   Unarchive,
+	})
+	rootCmd.AddCommand(rmCmd)
+	rootCmd.AddCommand(pruneCmd)
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manages the envoy config file",
+	}
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "fix-perms",
+		Short: "Restricts the config file to be readable only by the current user",
+		Args:  cobra.NoArgs,
+		Run:   FixConfigPermissions,
+	})
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "setup",
+		Short: "Interactively configures and verifies carrier credentials",
+		Args:  cobra.NoArgs,
+		Run:   Setup,
+	})
 }
 
 func main() {
@@ -91,6 +515,38 @@ func main() {
 func initApplication(cmd *cobra.Command, args []string) error {
 	initLogger(cmd)
 	conf = initConfig()
+	if _, err := resolveParcelColumns(conf.TUI.Columns); err != nil {
+		return fmt.Errorf("invalid tui.columns: %w", err)
+	}
+	strategy, err := envoy.ParseDeliveredStrategy(conf.DeliveredStrategy)
+	if err != nil {
+		return fmt.Errorf("invalid delivered_strategy: %w", err)
+	}
+	envoy.DefaultDeliveredStrategy = strategy
+	if unitSystem, err = envoy.ParseUnitSystem(conf.Units); err != nil {
+		return fmt.Errorf("invalid units: %w", err)
+	}
+	if carrierOverride, err = parseCarrier(carrierFlag); err != nil {
+		return fmt.Errorf("invalid --carrier: %w", err)
+	}
+	if displayLocation, err = parseTimezone(timezoneFlag); err != nil {
+		return fmt.Errorf("invalid --timezone: %w", err)
+	}
+	if envoy.DetectionOrder, err = envoy.ParseDetectionOrder(conf.Detect.Order); err != nil {
+		return fmt.Errorf("invalid detect.order: %w", err)
+	}
+	if highlightedEvents, err = parseHighlightEvents(conf.HighlightEvents); err != nil {
+		return fmt.Errorf("invalid highlight_events: %w", err)
+	}
+	if httpTransport, err = newTransport(conf.HTTP); err != nil {
+		return fmt.Errorf("invalid http config: %w", err)
+	}
+	switch {
+	case conf.MaxConcurrency < 0:
+		return fmt.Errorf("invalid max_concurrency: %d is negative", conf.MaxConcurrency)
+	case conf.MaxConcurrency > 0:
+		pool.DefaultLimit = conf.MaxConcurrency
+	}
 	initDB(cmd, args)
 
 	if err := godotenv.Load(); err != nil {
@@ -102,115 +558,320 @@ func initApplication(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func Add(cmd *cobra.Command, args []string) {
-
+func TUI(cmd *cobra.Command, args []string) {
+	groups := groupTrackingNumbers(args, carrierFlagGroups(cmd))
+	runTUI(groups)
 }
 
-func AddAndRunTUI(cmd *cobra.Command, args []string) {
-
+// Parse the name of a supported carrier, ignoring case. An empty name parses
+// as no carrier.
+func parseCarrier(name string) (envoy.Carrier, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil
+	}
+	names := make([]string, 0, len(carrierServices))
+	for _, c := range carrierServices {
+		if strings.EqualFold(string(c), name) {
+			return c, nil
+		}
+		names = append(names, strings.ToLower(string(c)))
+	}
+	return "", fmt.Errorf("unknown carrier %q, expected one of %s", name, strings.Join(names, ", "))
 }
 
-func TUI(cmd *cobra.Command, args []string) {
-	groups := groupByCarrier(args)
-	for _, provider := range []string{"fedex", "ups", "usps"} {
-		entries, err := cmd.Flags().GetStringSlice(provider)
+// Collect the tracking numbers passed explicitly via the per-carrier flags
+func carrierFlagGroups(cmd *cobra.Command) map[envoy.Carrier][]string {
+	explicit := make(map[envoy.Carrier][]string)
+	for _, c := range carrierServices {
+		entries, err := cmd.Flags().GetStringSlice(strings.ToLower(string(c)))
 		if len(entries) > 0 && err == nil {
-			groups[envoy.DetectCarrier(provider)] = append(groups[envoy.DetectCarrier(provider)], entries...)
+			explicit[c] = append(explicit[c], entries...)
 		}
 	}
-	runTUI(groups)
+	return explicit
 }
 
-func syncParcels(args []string) (map[string]*envoy.Parcel, error) {
-	groups := groupByCarrier(args)
+func syncParcels(groups map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error) {
 	log.Debugf("Groups: %+v\n", groups)
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	allParcels := make(map[string]*envoy.Parcel)
+	tokens := openTokenCache()
 
 	for carrier, trackingNumbers := range groups {
-		var svc envoy.Service
-
-		switch carrier {
-		case envoy.CarrierFedEx:
-			svc = fedex.NewFedexService(
-				&http.Client{},
-				conf.Carriers.FedEx.Key,
-				conf.Carriers.FedEx.Secret,
-			)
-		case envoy.CarrierUPS:
-			svc = ups.NewUPSService(
-				&http.Client{},
-				conf.Carriers.UPS.Key,
-				conf.Carriers.UPS.Secret,
-			)
-		case envoy.CarrierUSPS:
-			svc = usps.NewUSPSService(
-				&http.Client{},
-				conf.Carriers.USPS.Key,
-				conf.Carriers.USPS.Secret,
-			)
-		default:
-			fmt.Printf("Unsupported carrier: %v\n", carrier)
-			os.Exit(1)
+		svc, err := configuredService(trackingClient(carrier), carrier)
+		if err != nil {
+			log.Warnf("unsupported carrier %v for %v", carrier, trackingNumbers)
+			mu.Lock()
+			for _, p := range unsupportedParcels(carrier, trackingNumbers) {
+				allParcels[p.TrackingNumber] = p
+			}
+			mu.Unlock()
+			continue
 		}
+		key := conf.carrier(carrier).Key
+		tokens.restore(carrier, key, svc)
 
 		wg.Add(1)
-		go func() {
+		go func(carrier envoy.Carrier, svc envoy.Service, trackingNumbers []string) {
 			defer wg.Done()
 			parcels, err := svc.Track(trackingNumbers)
+			tokens.capture(carrier, key, svc)
 			if err != nil {
-				fmt.Printf("Err: %+v\n", err)
+				log.Warnf("could not track %s parcels: %v", carrier, err)
+				mu.Lock()
+				for _, p := range failedParcels(carrier, trackingNumbers, err) {
+					if _, ok := allParcels[p.TrackingNumber]; !ok {
+						allParcels[p.TrackingNumber] = p
+					}
+				}
+				mu.Unlock()
 				return
 			}
 			for _, p := range parcels {
+				if p.HasError() {
+					// Report the failure without overwriting what is stored
+					mu.Lock()
+					allParcels[p.TrackingNumber] = p
+					mu.Unlock()
+					continue
+				}
 				if !p.HasData() {
 					continue
 				}
+				p = refreshStored(p, fireWebhook)
+				p.LastSyncedAt = time.Now()
 				if e := p.LastTrackingEvent(); e != nil {
 					mu.Lock()
-					allParcels[p.TrackingNumber] = p
+					if existing, ok := allParcels[p.TrackingNumber]; ok {
+						existing.Merge(p)
+						p = existing
+					} else {
+						allParcels[p.TrackingNumber] = p
+					}
 					mu.Unlock()
 					err := upsertParcel(p)
 					if err != nil {
-						fmt.Printf("Error upserting parcel %s: %v\n", p.TrackingNumber, err)
+						log.Warnf("could not store parcel %s: %v", p.TrackingNumber, err)
 					}
 				}
 			}
-		}()
+		}(carrier, svc, trackingNumbers)
 	}
 
 	wg.Wait()
+	if err := tokens.save(); err != nil {
+		log.Warnf("could not cache tokens: %v", err)
+	}
+
+	for alt, primary := range collapseAlternates(allParcels) {
+		log.Infof("merged %s into %s, which reports it as an alternate number", alt, primary.TrackingNumber)
+		if err := upsertParcel(primary); err != nil {
+			log.Warnf("could not store parcel %s: %v", primary.TrackingNumber, err)
+			continue
+		}
+		if stored, err := getParcel(alt); err == nil && stored != nil {
+			if err := deleteParcel(stored); err != nil {
+				log.Warnf("could not delete merged parcel %s: %v", alt, err)
+			}
+		}
+	}
 	return allParcels, nil
 }
 
 func Track(cmd *cobra.Command, args []string) {
+	format, err := parseOutputFormat(outputFormatFlag)
+	if err != nil {
+		log.Fatalf("invalid --format: %v", err)
+	}
+	status, err := parseStatusFilter(trackStatus)
+	if err != nil {
+		log.Fatalf("invalid --status: %v", err)
+	}
+	args = inputArgs(args)
 	initDB(cmd, args)
 
-	allParcels, err := syncParcels(args)
-	if err != nil {
-		log.Fatalf("Error syncing parcels: %v", err)
+	if code := track(cmd, args, format, status); code != exitOK {
+		os.Exit(code)
 	}
+}
 
-	for id, p := range allParcels {
-		if p.HasError() {
-			fmt.Printf("%s: %v\n", id, p.Error)
-			continue
+// Track the parcels given to the track command, returning its exit code
+func track(cmd *cobra.Command, args []string, format outputFormat, status statusFilter) int {
+	explicit := carrierFlagGroups(cmd)
+	if len(args) == 0 && len(explicit) == 0 {
+		if len(trackTags) == 0 {
+			log.Fatal("specify tracking numbers, --stdin, --file, or --tag")
+		}
+		stored, err := fetchParcels()
+		if err != nil {
+			log.Fatalf("error fetching parcels: %v", err)
+		}
+		for _, p := range filterParcelsByTags(stored, trackTags) {
+			args = append(args, p.TrackingNumber)
 		}
-		if oneline {
-			fmt.Println(formatEventOneline(p.TrackingNumber, p.LastTrackingEvent()))
-		} else {
-			fmt.Println(formatEventHistory(p))
+		if len(args) == 0 {
+			if !trackQuiet {
+				fmt.Println("No matching parcels")
+			}
+			return exitOK
+		}
+	}
+	groups := groupTrackingNumbers(args, explicit)
+	allParcels, stale := lookupCachedParcels(groups, trackMaxAge, trackForce, getParcel, time.Now())
+	if len(stale) > 0 {
+		exempt := explicitTrackingNumbers(explicit)
+		if carrierOverride != "" {
+			// The carrier was given, so other candidates are not tried
+			for _, tn := range args {
+				exempt[envoy.NormalizeTrackingNumber(tn)] = struct{}{}
+			}
+		}
+		fetched, err := syncWithFallback(stale, exempt, syncParcels)
+		if err != nil {
+			log.Fatalf("Error syncing parcels: %v", err)
+		}
+		for id, p := range fetched {
+			log.Debugf("%s: fetched from %s", id, p.Carrier)
+			allParcels[id] = p
+		}
+	}
+
+	maps.DeleteFunc(allParcels, func(_ string, p *envoy.Parcel) bool {
+		return !hasAllTags(p, trackTags) || !status.matches(p)
+	})
+	if !trackQuiet {
+		if err := printParcels(allParcels, format); err != nil {
+			log.Fatalf("Error printing parcels: %v", err)
 		}
 	}
+	runArchiveSweep()
+	drainWebhooks(webhookDrainTimeout)
+
+	return trackExitCode(groups, allParcels, trackQuiet)
+}
+
+// Returns the exit code of the track command for the tracked parcels. Failed
+// parcels only change the code with --quiet, as they are otherwise printed.
+func trackExitCode(groups map[envoy.Carrier][]string, parcels map[string]*envoy.Parcel, quiet bool) int {
+	supported := false
+	for carrier := range groups {
+		if slices.Contains(carrierServices, carrier) {
+			supported = true
+		}
+	}
+	if !supported {
+		return exitNoCarrier
+	}
+	if quiet {
+		for _, p := range parcels {
+			if p.HasError() {
+				return exitTrackingFailed
+			}
+		}
+	}
+	return exitOK
+}
+
+// Collect the normalized tracking numbers whose carrier was given explicitly
+func explicitTrackingNumbers(explicit map[envoy.Carrier][]string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, tns := range explicit {
+		for _, tn := range tns {
+			set[envoy.NormalizeTrackingNumber(tn)] = struct{}{}
+		}
+	}
+	return set
+}
+
+// Sync the grouped parcels, retrying any detected tracking numbers which
+// return no data, or only an error, with the next candidate carrier, until one
+// of them does or the candidates are exhausted. Numbers whose carrier was given explicitly are
+// not retried.
+func syncWithFallback(
+	groups map[envoy.Carrier][]string,
+	explicit map[string]struct{},
+	sync func(groups map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error),
+) (map[string]*envoy.Parcel, error) {
+	allParcels := make(map[string]*envoy.Parcel)
+	tried := make(map[string][]envoy.Carrier)
+
+	for len(groups) > 0 {
+		fetched, err := sync(groups)
+		if err != nil {
+			return allParcels, err
+		}
+		for id, p := range fetched {
+			allParcels[id] = p
+		}
+
+		next := make(map[envoy.Carrier][]string)
+		for carrier, trackingNumbers := range groups {
+			for _, tn := range trackingNumbers {
+				tried[tn] = append(tried[tn], carrier)
+				// A failure is kept only if no other candidate tracks it
+				if p, ok := allParcels[tn]; ok && (p.HasData() || !p.HasError()) {
+					continue
+				}
+				if _, ok := explicit[tn]; ok {
+					continue
+				}
+				for _, c := range envoy.DetectCarriers(tn) {
+					if slices.Contains(carrierServices, c) && !slices.Contains(tried[tn], c) {
+						log.Debugf("%s: no data from %s, trying %s", tn, carrier, c)
+						next[c] = append(next[c], tn)
+						break
+					}
+				}
+			}
+		}
+		groups = next
+	}
+	return allParcels, nil
 }
 
 func groupByCarrier(trackingNumbers []string) map[envoy.Carrier][]string {
+	return groupTrackingNumbers(trackingNumbers, nil)
+}
+
+// Group tracking numbers by carrier, preferring the carrier given explicitly
+// over the one given with --carrier, and that over the detected one. Numbers
+// are normalized and deduplicated, preserving the order in which they were
+// first seen.
+func groupTrackingNumbers(trackingNumbers []string, explicit map[envoy.Carrier][]string) map[envoy.Carrier][]string {
 	groups := make(map[envoy.Carrier][]string)
-	for _, trackingNumber := range trackingNumbers {
-		carrier := envoy.DetectCarrier(trackingNumber)
-		groups[carrier] = append(groups[carrier], trackingNumber)
+	seen := make(map[string]struct{})
+
+	add := func(carrier envoy.Carrier, trackingNumber string) {
+		tn := envoy.NormalizeTrackingNumber(trackingNumber)
+		if tn == "" {
+			return
+		}
+		if _, ok := seen[tn]; ok {
+			return
+		}
+		seen[tn] = struct{}{}
+		groups[carrier] = append(groups[carrier], tn)
+	}
+
+	for _, c := range carrierServices {
+		for _, tn := range explicit[c] {
+			add(c, tn)
+		}
+	}
+	for _, tn := range trackingNumbers {
+		add(argCarrier(tn), tn)
 	}
 	return groups
 }
+
+// Returns the carrier of a tracking number given on the command line, which
+// is the carrier given with --carrier if any, and otherwise the detected one
+func argCarrier(trackingNumber string) envoy.Carrier {
+	if carrierOverride != "" {
+		return carrierOverride
+	}
+	return envoy.DetectCarrier(trackingNumber)
+}
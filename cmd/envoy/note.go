@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+var noteAppend bool
+
+// Replace the note on a parcel and store it. An empty note clears it.
+func saveNote(p *envoy.Parcel, note string) error {
+	p.Note = strings.TrimSpace(note)
+	// Saved in full, as updates skip empty fields and so could not clear the
+	// note
+	return createParcel(p)
+}
+
+// Add text to the end of a note on its own line, or start the note with it
+func appendedNote(existing, text string) string {
+	text = strings.TrimSpace(text)
+	if existing == "" || text == "" {
+		return existing + text
+	}
+	return existing + "\n" + text
+}
+
+// Replace the note on the stored parcel matching the fragment, or add to it
+// when appending, returning the updated parcel
+func setNote(fragment, note string, appending bool) (*envoy.Parcel, error) {
+	p, err := resolveParcel(fragment)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, fmt.Errorf("no stored parcel %s", fragment)
+	}
+	if appending {
+		note = appendedNote(p.Note, note)
+	}
+	if err := saveNote(p, note); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func Note(cmd *cobra.Command, args []string) {
+	if len(args) == 1 {
+		p, err := resolveParcel(args[0])
+		if err != nil {
+			log.Fatalf("error resolving parcel %s: %v", args[0], err)
+		}
+		if p == nil {
+			log.Fatalf("no stored parcel %s", args[0])
+		}
+		fmt.Println(p.Note)
+		return
+	}
+
+	p, err := setNote(args[0], args[1], noteAppend)
+	if err != nil {
+		log.Fatalf("error setting note: %v", err)
+	}
+	if p.Note == "" {
+		fmt.Printf("%s: note cleared\n", p.TrackingNumber)
+	} else {
+		fmt.Printf("%s: %s\n", p.TrackingNumber, p.Note)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestNotePersistsAcrossResync(t *testing.T) {
+	openTestDB(t)
+
+	p := envoy.NewParcel("Scarf", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	if err := upsertParcel(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setNote("567127", "  gift for mom, don't spoil ", false); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := envoy.NewParcel(p.TrackingNumber, envoy.CarrierUPS, p.TrackingNumber, "https://ups.example/1Z5R89390357567127")
+	fetched.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: time.Now()}},
+	}
+	if err := upsertParcel(refreshStored(fetched)); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := getParcel(p.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TrackingURL != fetched.TrackingURL || !stored.HasDeliveredEvent() {
+		t.Errorf("Expected the resync to update the parcel, got %+v", stored)
+	}
+	if stored.Note != "gift for mom, don't spoil" {
+		t.Errorf("Expected the note to survive a resync, got %q", stored.Note)
+	}
+
+	if _, err := setNote(p.TrackingNumber, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := getParcel(p.TrackingNumber); stored.Note != "" {
+		t.Errorf("Expected the note to be cleared, got %q", stored.Note)
+	}
+}
+
+func TestAppendNote(t *testing.T) {
+	openTestDB(t)
+
+	p := envoy.NewParcel("Scarf", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	if err := upsertParcel(p); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		text string
+		want string
+	}{
+		{" gift for mom ", "gift for mom"},
+		{"leave with neighbor", "gift for mom\nleave with neighbor"},
+		{"  ", "gift for mom\nleave with neighbor"},
+	}
+	for _, step := range steps {
+		if _, err := setNote(p.TrackingNumber, step.text, true); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := getParcel(p.TrackingNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Note != step.want {
+			t.Errorf("Expected note %q after appending %q, got %q", step.want, step.text, stored.Note)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/notify"
+)
+
+// Shows a desktop notification. Tests replace it to observe notifications.
+var notifier = notify.Notify
+
+// Titles of the notifications shown when a parcel transitions to an event type
+var notificationTitles = map[envoy.ParcelEventType]string{
+	envoy.ParcelEventTypeOutForDelivery: "Out for delivery",
+	envoy.ParcelEventTypeDelivered:      "Delivered",
+}
+
+// Returns a command notifying that a parcel has transitioned from the event
+// type it was in before being refreshed, or nil if its state has not changed
+// to one worth a notification
+func transitionNotification(before envoy.ParcelEventType, p *envoy.Parcel) tea.Cmd {
+	e := p.LastTrackingEvent()
+	if e == nil || e.Type == before {
+		return nil
+	}
+	title, ok := notificationTitles[e.Type]
+	if !ok {
+		return nil
+	}
+
+	body := p.Name
+	if p.Name != p.TrackingNumber {
+		body = fmt.Sprintf("%s (%s)", p.Name, p.TrackingNumber)
+	}
+	if e.Location != "" {
+		body += "\n" + e.Location
+	}
+	return func() tea.Msg {
+		if err := notifier(title, body); err != nil {
+			log.Warnf("could not show notification: %v", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	zone "github.com/lrstanley/bubblezone"
+	"go.uber.org/zap"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// Runs a command and any commands it batches, discarding their messages
+func runCmd(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	if batch, ok := cmd().(tea.BatchMsg); ok {
+		for _, c := range batch {
+			runCmd(c)
+		}
+	}
+}
+
+func TestTransitionNotifications(t *testing.T) {
+	zone.NewGlobal()
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+	defer func(c Config) { conf = c }(conf)
+	conf.Notifications = true
+
+	var titles []string
+	defer func(f func(string, string) error) { notifier = f }(notifier)
+	notifier = func(title, body string) error {
+		titles = append(titles, title)
+		return nil
+	}
+
+	columns, err := resolveParcelColumns([]string{"name", "status"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	stored := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	stored.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: timeNow}},
+	}
+	var m tea.Model = model{
+		parcels:      map[string]*envoy.Parcel{stored.TrackingNumber: stored},
+		parcelIDs:    []string{stored.TrackingNumber},
+		parcelsTable: makeParcelsTable([]*envoy.Parcel{stored}, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+	}
+
+	fetch := func(events ...envoy.ParcelEvent) {
+		t.Helper()
+		fetched := envoy.NewParcel(stored.TrackingNumber, envoy.CarrierFedEx, stored.TrackingNumber, "")
+		fetched.Data = &envoy.ParcelData{Events: events}
+		var cmd tea.Cmd
+		m, cmd = m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{fetched.TrackingNumber: fetched}})
+		runCmd(cmd)
+	}
+
+	inTransit := stored.Data.Events[0]
+	outForDelivery := envoy.ParcelEvent{Type: envoy.ParcelEventTypeOutForDelivery, Description: "On vehicle for delivery", Timestamp: timeNow.Add(time.Hour)}
+	delivered := envoy.ParcelEvent{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: timeNow.Add(2 * time.Hour)}
+
+	fetch(inTransit)
+	if len(titles) != 0 {
+		t.Errorf("Expected no notification without a state change, got %v", titles)
+	}
+
+	fetch(inTransit, outForDelivery)
+	fetch(inTransit, outForDelivery)
+	if len(titles) != 1 || titles[0] != "Out for delivery" {
+		t.Errorf("Expected one out for delivery notification, got %v", titles)
+	}
+
+	conf.Notifications = false
+	fetch(inTransit, outForDelivery, delivered)
+	if len(titles) != 1 {
+		t.Errorf("Expected no notification when disabled, got %v", titles)
+	}
+}
+
+func TestTransitionNotificationsAcrossLaunches(t *testing.T) {
+	zone.NewGlobal()
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+	defer func(c Config) { conf = c }(conf)
+	conf.Notifications = true
+
+	var titles []string
+	defer func(f func(string, string) error) { notifier = f }(notifier)
+	notifier = func(title, body string) error {
+		titles = append(titles, title)
+		return nil
+	}
+
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	inTransit := envoy.ParcelEvent{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: timeNow}
+	outForDelivery := envoy.ParcelEvent{Type: envoy.ParcelEventTypeOutForDelivery, Description: "On vehicle for delivery", Timestamp: timeNow.Add(time.Hour)}
+	stored := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	stored.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{inTransit}}
+	if err := upsertParcel(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each launch loads the stored parcels and fetches them afresh
+	launch := func() {
+		t.Helper()
+		m := initialModel(nil)
+		fetched := envoy.NewParcel(stored.TrackingNumber, envoy.CarrierFedEx, stored.TrackingNumber, "")
+		fetched.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{inTransit, outForDelivery}}
+		_, cmd := m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{fetched.TrackingNumber: fetched}})
+		runCmd(cmd)
+	}
+
+	launch()
+	launch()
+	if len(titles) != 1 || titles[0] != "Out for delivery" {
+		t.Errorf("Expected the change to be announced once, got %v", titles)
+	}
+}
+
+func TestTransitionNotification(t *testing.T) {
+	p := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	p.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Timestamp: time.Now()}},
+	}
+
+	if cmd := transitionNotification(envoy.ParcelEventTypeDelivered, p); cmd != nil {
+		t.Error("Expected no notification when the state is unchanged")
+	}
+	if cmd := transitionNotification(envoy.ParcelEventTypeOutForDelivery, p); cmd == nil {
+		t.Error("Expected a notification on delivery")
+	}
+
+	p.Data.Events[0].Type = envoy.ParcelEventTypeDelayed
+	if cmd := transitionNotification(envoy.ParcelEventTypeInTransit, p); cmd != nil {
+		t.Error("Expected no notification for other event types")
+	}
+}
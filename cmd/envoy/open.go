@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/skratchdot/open-golang/open"
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// Opening more than this many URLs at once requires confirmation
+const openConfirmThreshold = 5
+
+// Open a URL in the default browser. Tests replace it to record what would be
+// opened.
+var openURL = open.Run
+
+// The status filters chosen from with a key when opening tracking pages from
+// the TUI, as with open --status
+var openStatusKeys = map[string]statusFilter{
+	"e": statusFilterException,
+	"d": statusFilterDelivered,
+	"a": statusFilterActive,
+	"*": statusFilterAll,
+}
+
+var (
+	openStatus string
+	openPrint  bool
+	openYes    bool
+)
+
+// Select the tracking URLs of parcels matching the filter, optionally
+// restricted to the given tracking numbers. Tracking numbers which are not
+// stored get a URL for their detected carrier, so no API call is needed.
+func selectTrackingURLs(parcels []*envoy.Parcel, f statusFilter, trackingNumbers []string) []string {
+	var urls []string
+	add := func(u string) {
+		if !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+
+	for _, p := range filterParcels(parcels, f) {
+		if len(trackingNumbers) > 0 && !slices.Contains(trackingNumbers, p.TrackingNumber) {
+			continue
+		}
+		add(parcelTrackingURL(p))
+	}
+
+	for _, tn := range trackingNumbers {
+		stored := slices.ContainsFunc(parcels, func(p *envoy.Parcel) bool {
+			return p.TrackingNumber == tn
+		})
+		if !stored {
+			add(envoy.TrackingURL(argCarrier(tn), tn))
+		}
+	}
+	return urls
+}
+
+// The tracking URL of a parcel, generated from its carrier if it has not
+// been fetched yet
+func parcelTrackingURL(p *envoy.Parcel) string {
+	if p.TrackingURL != "" {
+		return p.TrackingURL
+	}
+	carrier := p.Carrier
+	if carrier == "" || carrier == envoy.CarrierUnknown {
+		carrier = envoy.DetectCarrier(p.TrackingNumber)
+	}
+	return envoy.TrackingURL(carrier, p.TrackingNumber)
+}
+
+func Open(cmd *cobra.Command, args []string) {
+	f, err := parseStatusFilter(openStatus)
+	if err != nil {
+		log.Fatalf("invalid --status: %v", err)
+	}
+
+	parcels, err := fetchParcels()
+	if err != nil {
+		log.Fatalf("error fetching parcels: %v", err)
+	}
+
+	trackingNumbers := make([]string, 0, len(args))
+	for _, arg := range args {
+		p, err := resolvePartial(parcels, arg)
+		if err != nil {
+			log.Fatalf("error resolving parcel %s: %v", arg, err)
+		}
+		if p != nil {
+			trackingNumbers = append(trackingNumbers, p.TrackingNumber)
+		} else {
+			trackingNumbers = append(trackingNumbers, envoy.NormalizeTrackingNumber(arg))
+		}
+	}
+
+	urls := selectTrackingURLs(parcels, f, trackingNumbers)
+	if len(urls) == 0 {
+		fmt.Println("No matching parcels")
+		return
+	}
+
+	if openPrint {
+		for _, u := range urls {
+			fmt.Println(u)
+		}
+		return
+	}
+
+	if len(urls) > openConfirmThreshold && !openYes {
+		if !confirm(os.Stdin, os.Stdout, fmt.Sprintf("Open %d tracking URLs?", len(urls))) {
+			return
+		}
+	}
+
+	for _, u := range urls {
+		if err := openURL(u); err != nil {
+			log.Warnf("could not open %s: %v", u, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestSelectTrackingURLs(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	newParcel := func(trackingNumber string, eventType envoy.ParcelEventType, delivered bool) *envoy.Parcel {
+		p := envoy.NewParcel(
+			trackingNumber,
+			envoy.CarrierFedEx,
+			trackingNumber,
+			"https://www.fedex.com/apps/fedextrack/?tracknumbers="+trackingNumber,
+		)
+		p.Data = &envoy.ParcelData{
+			Events:    []envoy.ParcelEvent{{Type: eventType, Timestamp: timeNow}},
+			Delivered: delivered,
+		}
+		return p
+	}
+
+	parcels := []*envoy.Parcel{
+		newParcel("271278612814", envoy.ParcelEventTypeDelivered, true),
+		newParcel("281958973124", envoy.ParcelEventTypeParcelHeld, false),
+		newParcel("271198840120", envoy.ParcelEventTypeInTransit, false),
+		newParcel("271245206460", envoy.ParcelEventTypeReturnedToSender, false),
+	}
+
+	tests := []struct {
+		filter          statusFilter
+		trackingNumbers []string
+		want            []string
+	}{
+		{statusFilterAll, nil, []string{"271278612814", "281958973124", "271198840120", "271245206460"}},
+		{statusFilterDelivered, nil, []string{"271278612814"}},
+		{statusFilterException, nil, []string{"281958973124", "271245206460"}},
+		{statusFilterActive, nil, []string{"271198840120"}},
+		{statusFilterException, []string{"271245206460"}, []string{"271245206460"}},
+		{statusFilterAll, []string{"271245206460", "441259201412"}, []string{"271245206460", "441259201412"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.filter), func(t *testing.T) {
+			var want []string
+			for _, tn := range tt.want {
+				want = append(want, "https://www.fedex.com/apps/fedextrack/?tracknumbers="+tn)
+			}
+
+			got := selectTrackingURLs(parcels, tt.filter, tt.trackingNumbers)
+			if !slices.Equal(got, want) {
+				t.Errorf("Expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestSelectTrackingURLsNotStored(t *testing.T) {
+	stored := envoy.NewParcel("Stored", envoy.CarrierUPS, "1ZW701150378674373", "")
+
+	tests := []struct {
+		name            string
+		trackingNumbers []string
+		want            []string
+	}{
+		{"UPS", []string{"1Z5R89390357567127"}, []string{"https://www.ups.com/track?tracknum=1Z5R89390357567127"}},
+		{"USPS", []string{"9400123456789012345674"}, []string{"https://tools.usps.com/go/TrackConfirmAction?tLabels=9400123456789012345674"}},
+		{"unknown", []string{"NOTATRACKINGNUMBER"}, []string{"https://www.google.com/search?q=NOTATRACKINGNUMBER+tracking"}},
+		{"stored without URL", []string{"1ZW701150378674373"}, []string{"https://www.ups.com/track?tracknum=1ZW701150378674373"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectTrackingURLs([]*envoy.Parcel{stored}, statusFilterAll, tt.trackingNumbers)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// outputFormat determines how commands print parcels
+type outputFormat string
+
+const (
+	outputFormatText   outputFormat = "text"
+	outputFormatJSON   outputFormat = "json"
+	outputFormatNDJSON outputFormat = "ndjson"
+)
+
+var outputFormats = []outputFormat{
+	outputFormatText,
+	outputFormatJSON,
+	outputFormatNDJSON,
+}
+
+var outputFormatFlag string
+
+func parseOutputFormat(s string) (outputFormat, error) {
+	if s == "" {
+		return outputFormatText, nil
+	}
+	for _, f := range outputFormats {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+
+	names := make([]string, 0, len(outputFormats))
+	for _, f := range outputFormats {
+		names = append(names, string(f))
+	}
+	return "", fmt.Errorf("unknown format %q (expected one of: %s)", s, strings.Join(names, ", "))
+}
+
+// Print the parcels to stdout in the given format, ordered by tracking
+// number so that output is stable across runs.
+//
+// JSON output is a single indented array, so it must be read as a whole.
+// NDJSON prints one compact parcel per line, so it can be streamed and is
+// safe to append to logs.
+func printParcels(parcels map[string]*envoy.Parcel, format outputFormat) error {
+	ids := slices.Sorted(maps.Keys(parcels))
+
+	switch format {
+	case outputFormatJSON:
+		sorted := make([]*envoy.Parcel, 0, len(ids))
+		for _, id := range ids {
+			sorted = append(sorted, parcels[id])
+		}
+		data, err := json.MarshalIndent(sorted, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case outputFormatNDJSON:
+		for _, id := range ids {
+			data, err := json.Marshal(parcels[id])
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		}
+	default:
+		for _, id := range ids {
+			p := parcels[id]
+			if p.HasError() {
+				fmt.Printf("%s: %v\n", id, p.Error)
+				continue
+			}
+			if oneline {
+				last := p.LastTrackingEvent()
+				if last == nil {
+					// Parcels added or imported have no events until synced
+					fmt.Printf("%s: not tracked yet\n", id)
+					continue
+				}
+				e := annotateEvent(p, *last)
+				fmt.Println(formatEventOneline(p.TrackingNumber, &e))
+			} else {
+				fmt.Println(formatEventHistory(p))
+			}
+		}
+	}
+	return nil
+}
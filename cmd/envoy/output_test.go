@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+// Capture everything written to stdout while fn runs
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// A fixed set of parcels covering tracked, failed, and unfetched parcels
+func outputTestParcels() map[string]*envoy.Parcel {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	eta := timeNow.Add(48 * time.Hour)
+
+	tracked := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	tracked.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Location: "DENVER, CO", Timestamp: timeNow},
+		},
+		DeliveryProjection: &eta,
+		ServiceLevel:       "FedEx Ground",
+	}
+	failed := envoy.NewParcel("Lamp", envoy.CarrierUSPS, "9400123456789012345674", "")
+	failed.Error = errors.New("unexpected status code: 404")
+	pending := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+
+	return map[string]*envoy.Parcel{
+		tracked.TrackingNumber: tracked,
+		failed.TrackingNumber:  failed,
+		pending.TrackingNumber: pending,
+	}
+}
+
+func TestPrintParcelsGolden(t *testing.T) {
+	for _, format := range []outputFormat{outputFormatJSON, outputFormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			out := captureStdout(t, func() {
+				if err := printParcels(outputTestParcels(), format); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			golden := filepath.Join("testdata", "parcels.golden."+string(format))
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(out), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != string(want) {
+				t.Errorf("Output does not match %s:\n%s", golden, out)
+			}
+		})
+	}
+}
+
+func TestPrintParcels(t *testing.T) {
+	parcels := outputTestParcels()
+	tracked := parcels["441259201412"]
+	eta := *tracked.Data.DeliveryProjection
+
+	t.Run("text", func(t *testing.T) {
+		out := captureStdout(t, func() {
+			if err := printParcels(parcels, outputFormatText); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if !strings.Contains(out, "DENVER, CO") {
+			t.Errorf("Expected event history, got %q", out)
+		}
+		if !strings.Contains(out, "9400123456789012345674: unexpected status code: 404") {
+			t.Errorf("Expected error line, got %q", out)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		out := captureStdout(t, func() {
+			if err := printParcels(parcels, outputFormatJSON); err != nil {
+				t.Fatal(err)
+			}
+		})
+		var decoded []map[string]any
+		if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+			t.Fatalf("Expected a valid JSON array, got %v: %q", err, out)
+		}
+		if len(decoded) != 3 || decoded[1]["TrackingNumber"] != tracked.TrackingNumber {
+			t.Fatalf("Expected parcels ordered by tracking number, got %v", decoded)
+		}
+		data, _ := decoded[1]["Data"].(map[string]any)
+		if events, _ := data["Events"].([]any); len(events) != 1 {
+			t.Errorf("Expected 1 event, got %v", data["Events"])
+		}
+		if data["Delivered"] != false || data["DeliveryProjection"] != eta.Format(time.RFC3339) {
+			t.Errorf("Expected delivered flag and projection, got %v", data)
+		}
+		if got := decoded[2]["Error"]; got != "unexpected status code: 404" {
+			t.Errorf("Expected error as a string, got %#v", got)
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		out := captureStdout(t, func() {
+			if err := printParcels(parcels, outputFormatNDJSON); err != nil {
+				t.Fatal(err)
+			}
+		})
+		var ids []string
+		scanner := bufio.NewScanner(strings.NewReader(out))
+		for scanner.Scan() {
+			var p envoy.Parcel
+			if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+				t.Fatalf("Expected a parcel per line, got %v: %q", err, scanner.Text())
+			}
+			ids = append(ids, p.TrackingNumber)
+		}
+		if strings.Join(ids, ",") != "1Z5R89390357567127,441259201412,9400123456789012345674" {
+			t.Errorf("Expected one parcel per line in order, got %v", ids)
+		}
+	})
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	for in, want := range map[string]outputFormat{"": outputFormatText, "JSON": outputFormatJSON, "ndjson": outputFormatNDJSON} {
+		if got, err := parseOutputFormat(in); err != nil || got != want {
+			t.Errorf("parseOutputFormat(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseOutputFormat("yaml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+var podOutput string
+
+// Fetch the proof of delivery of a stored parcel from its carrier
+func fetchProofOfDelivery(p *envoy.Parcel) (*envoy.ProofOfDelivery, error) {
+	svc, err := configuredService(trackingClient(p.Carrier), p.Carrier)
+	if err != nil {
+		return nil, err
+	}
+	podSvc, ok := svc.(envoy.ProofOfDeliveryService)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v does not provide proof of delivery", envoy.ErrNoProofOfDelivery, p.Carrier)
+	}
+
+	tokens := openTokenCache()
+	key := conf.carrier(p.Carrier).Key
+	tokens.restore(p.Carrier, key, svc)
+	pod, err := podSvc.ProofOfDelivery(p.TrackingNumber)
+	tokens.capture(p.Carrier, key, svc)
+	if err := tokens.save(); err != nil {
+		log.Warnf("could not cache tokens: %v", err)
+	}
+	return pod, err
+}
+
+// Write a proof of delivery to a file and record its path on the parcel. An
+// empty path names the file after the tracking number in the current
+// directory.
+func saveProofOfDelivery(p *envoy.Parcel, pod *envoy.ProofOfDelivery, path string) (string, error) {
+	if path == "" {
+		path = p.TrackingNumber + "-pod" + pod.Ext
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, pod.Data, 0644); err != nil {
+		return "", err
+	}
+
+	if p.Data == nil {
+		p.Data = &envoy.ParcelData{}
+	}
+	p.Data.ProofOfDeliveryPath = path
+	if err := createParcel(p); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func ProofOfDelivery(cmd *cobra.Command, args []string) {
+	p, err := resolveParcel(args[0])
+	if err != nil {
+		log.Fatalf("error resolving parcel %s: %v", args[0], err)
+	}
+	if p == nil {
+		log.Fatalf("no stored parcel %s", args[0])
+	}
+
+	pod, err := fetchProofOfDelivery(p)
+	if errors.Is(err, envoy.ErrNoProofOfDelivery) {
+		fmt.Fprintf(os.Stderr, "No proof of delivery is available for %s (%v) yet\n", p.TrackingNumber, p.Carrier)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("error fetching proof of delivery for %s: %v", p.TrackingNumber, err)
+	}
+
+	path, err := saveProofOfDelivery(p, pod, podOutput)
+	if err != nil {
+		log.Fatalf("error saving proof of delivery: %v", err)
+	}
+	fmt.Printf("%s: proof of delivery saved to %s\n", p.TrackingNumber, path)
+}
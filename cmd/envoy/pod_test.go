@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+// A 1x1 transparent PNG
+const testPODImage = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+func TestSaveProofOfDelivery(t *testing.T) {
+	openTestDB(t)
+
+	p := envoy.NewParcel("Boots", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	if err := upsertParcel(p); err != nil {
+		t.Fatal(err)
+	}
+	pod, err := envoy.DecodeProofOfDelivery(testPODImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := filepath.Join(t.TempDir(), "boots"+pod.Ext)
+	path, err := saveProofOfDelivery(p, pod, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != want {
+		t.Errorf("Expected the proof of delivery at %s, got %s", want, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, pod.Data) {
+		t.Errorf("Expected the decoded image to be written, got % x", data)
+	}
+
+	stored, err := getParcel(p.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Data == nil || stored.Data.ProofOfDeliveryPath != path {
+		t.Errorf("Expected the path to be stored, got %+v", stored.Data)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// The floor applied to polling intervals when none is configured
+const defaultMinPollInterval = 60 * time.Second
+
+// Raise a requested polling interval to the floor, if it is below it
+func enforcePollInterval(requested, floor time.Duration) time.Duration {
+	if floor <= 0 {
+		floor = defaultMinPollInterval
+	}
+	if requested < floor {
+		log.Warnf(
+			"poll interval %s is below the minimum of %s; using %s",
+			requested, floor, floor,
+		)
+		return floor
+	}
+	return requested
+}
+
+// poller repeatedly invokes a function on an interval that is never shorter
+// than the configured minimum. All polling loops (e.g. watch and serve) must
+// be constructed through newPoller so that the floor cannot be bypassed.
+type poller struct {
+	interval time.Duration
+	poll     func(ctx context.Context) error
+	// Records each cycle for external monitors, if a heartbeat file is configured
+	heartbeat *heartbeat
+}
+
+func newPoller(requested time.Duration, poll func(ctx context.Context) error) *poller {
+	p := &poller{
+		interval: enforcePollInterval(requested, conf.MinPollInterval),
+		poll:     poll,
+	}
+	if conf.HeartbeatFile != "" {
+		p.heartbeat = newHeartbeat(conf.HeartbeatFile, p.interval)
+	}
+	return p
+}
+
+// Poll immediately, then on every interval until the context is cancelled
+func (p *poller) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.cycle(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.cycle(ctx)
+		}
+	}
+}
+
+// Poll once, recording the outcome to the heartbeat unless the poll was
+// interrupted by cancellation
+func (p *poller) cycle(ctx context.Context) {
+	err := p.poll(ctx)
+	if err != nil {
+		log.Warnf("poll failed: %v", err)
+	}
+	if p.heartbeat == nil || ctx.Err() != nil {
+		return
+	}
+	if err := p.heartbeat.record(err); err != nil {
+		log.Warnf("could not write heartbeat to %s: %v", p.heartbeat.path, err)
+	}
+}
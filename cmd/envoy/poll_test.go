@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestEnforcePollInterval(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	if got := enforcePollInterval(5*time.Second, 0); got != defaultMinPollInterval {
+		t.Errorf("Expected 5s to be clamped to %s, got %s", defaultMinPollInterval, got)
+	}
+	if got := enforcePollInterval(5*time.Second, 2*time.Minute); got != 2*time.Minute {
+		t.Errorf("Expected 5s to be clamped to 2m, got %s", got)
+	}
+	if got := enforcePollInterval(15*time.Minute, 0); got != 15*time.Minute {
+		t.Errorf("Expected 15m to be kept, got %s", got)
+	}
+}
+
+func TestPollerHeartbeat(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	start := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	now := start
+	path := filepath.Join(t.TempDir(), "heartbeat.json")
+
+	pollErr := errors.New("error fetching parcels: db locked")
+	p := &poller{
+		interval:  time.Minute,
+		poll:      func(ctx context.Context) error { return pollErr },
+		heartbeat: newHeartbeat(path, time.Minute),
+	}
+	p.heartbeat.now = func() time.Time { return now }
+
+	read := func() heartbeat {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Expected heartbeat file to be written: %v", err)
+		}
+		var h heartbeat
+		if err := json.Unmarshal(data, &h); err != nil {
+			t.Fatalf("Expected heartbeat to be JSON: %v", err)
+		}
+		return h
+	}
+
+	p.cycle(context.Background())
+	h := read()
+	if !h.LastPoll.Equal(start) || h.LastSuccess != nil || h.LastError != pollErr.Error() {
+		t.Errorf("Expected a failed poll at %v, got %+v", start, h)
+	}
+
+	now = start.Add(time.Minute)
+	pollErr = nil
+	p.cycle(context.Background())
+	h = read()
+	if !h.LastPoll.Equal(now) || h.LastSuccess == nil || !h.LastSuccess.Equal(now) || h.LastError != "" {
+		t.Errorf("Expected a successful poll at %v, got %+v", now, h)
+	}
+	if h.Interval != "1m0s" {
+		t.Errorf("Expected interval to be recorded, got %q", h.Interval)
+	}
+
+	// Polls interrupted by shutdown are not recorded
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	now = start.Add(2 * time.Minute)
+	p.cycle(ctx)
+	if h = read(); !h.LastPoll.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected cancelled poll not to be recorded, got %+v", h)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var errConfirmationRequired = errors.New("not running interactively, pass --yes to confirm")
+
+// Ask the user to confirm an action on stdin, defaulting to no
+func confirm(in io.Reader, out io.Writer, prompt string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", prompt)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Returns whether f is attached to a terminal
+func isInteractive(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Ask the user to confirm a destructive action, unless yes was given. When
+// not running interactively the action is refused rather than prompted for.
+func confirmDestructive(in io.Reader, out io.Writer, interactive, yes bool, prompt string) (bool, error) {
+	if yes {
+		return true, nil
+	}
+	if !interactive {
+		return false, errConfirmationRequired
+	}
+	return confirm(in, out, prompt), nil
+}
+
+// batchSummary counts the outcome of an action applied to many parcels
+type batchSummary struct {
+	action  string
+	done    int
+	skipped int
+}
+
+func (s batchSummary) String() string {
+	return fmt.Sprintf("%d %s, %d skipped", s.done, s.action, s.skipped)
+}
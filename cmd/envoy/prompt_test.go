@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestConfirmDestructive(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		interactive bool
+		yes         bool
+		want        bool
+		wantErr     error
+		wantPrompt  bool
+	}{
+		{"yes skips prompt", "", false, true, true, nil, false},
+		{"noninteractive without yes aborts", "y\n", false, false, false, errConfirmationRequired, false},
+		{"interactive accepted", "y\n", true, false, true, nil, true},
+		{"interactive declined", "\n", true, false, false, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			got, err := confirmDestructive(strings.NewReader(tt.input), &out, tt.interactive, tt.yes, "Delete?")
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("confirmDestructive() = (%v, %v), want (%v, %v)", got, err, tt.want, tt.wantErr)
+			}
+			if prompted := out.Len() > 0; prompted != tt.wantPrompt {
+				t.Errorf("Expected prompt shown = %v, got %q", tt.wantPrompt, out.String())
+			}
+		})
+	}
+}
+
+func TestRemoveParcelsSummary(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	parcels := []*envoy.Parcel{
+		envoy.NewParcel("", envoy.CarrierFedEx, "271278612814", ""),
+		envoy.NewParcel("", envoy.CarrierFedEx, "281958973124", ""),
+		envoy.NewParcel("", envoy.CarrierFedEx, "271163815799", ""),
+	}
+
+	var deleted []string
+	summary := removeParcels(parcels, func(p *envoy.Parcel) error {
+		if p.TrackingNumber == "281958973124" {
+			return errors.New("not found")
+		}
+		deleted = append(deleted, p.TrackingNumber)
+		return nil
+	})
+
+	if summary.done != 2 || summary.skipped != 1 {
+		t.Errorf("Expected 2 deleted and 1 skipped, got %+v", summary)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("Expected 2 parcels to be deleted, got %v", deleted)
+	}
+	if got, want := summary.String(), "2 deleted, 1 skipped"; got != want {
+		t.Errorf("Expected summary %q, got %q", want, got)
+	}
+}
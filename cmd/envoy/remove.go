@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+var (
+	removeAll       bool
+	removeDelivered bool
+	removeYes       bool
+)
+
+// Delete each of the parcels, counting those which could not be deleted as
+// skipped
+func removeParcels(parcels []*envoy.Parcel, del func(p *envoy.Parcel) error) batchSummary {
+	summary := batchSummary{action: "deleted"}
+	for _, p := range parcels {
+		if err := del(p); err != nil {
+			log.Warnf("could not delete %s: %v", p.TrackingNumber, err)
+			summary.skipped++
+			continue
+		}
+		summary.done++
+	}
+	return summary
+}
+
+// Confirm and delete the parcels, then print a summary of the outcome
+func confirmAndRemove(parcels []*envoy.Parcel, skipped int, prompt string) {
+	if len(parcels) == 0 {
+		fmt.Println("No matching parcels")
+		return
+	}
+
+	ok, err := confirmDestructive(os.Stdin, os.Stdout, isInteractive(os.Stdin), removeYes, prompt)
+	if err != nil {
+		log.Fatalf("aborting: %v", err)
+	}
+	if !ok {
+		fmt.Println("Aborted, no parcels deleted")
+		return
+	}
+
+	summary := removeParcels(parcels, deleteParcel)
+	summary.skipped += skipped
+	fmt.Println(summary)
+}
+
+// Select the stored parcels to remove: all of them, those delivered, or
+// those matching the tracking numbers. Also returns the tracking numbers which
+// matched no stored parcel.
+func selectRemovals(trackingNumbers []string, all, delivered bool) ([]*envoy.Parcel, []string, error) {
+	if all || delivered {
+		parcels, err := fetchParcels()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error fetching parcels: %w", err)
+		}
+		if delivered {
+			parcels = filterParcels(parcels, statusFilterDelivered)
+		}
+		return parcels, nil, nil
+	}
+
+	var (
+		parcels []*envoy.Parcel
+		missing []string
+	)
+	for _, tn := range trackingNumbers {
+		p, err := resolveParcel(tn)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error resolving parcel %s: %w", tn, err)
+		}
+		if p == nil {
+			missing = append(missing, tn)
+			continue
+		}
+		if slices.ContainsFunc(parcels, func(q *envoy.Parcel) bool {
+			return q.TrackingNumber == p.TrackingNumber
+		}) {
+			continue
+		}
+		parcels = append(parcels, p)
+	}
+	return parcels, missing, nil
+}
+
+func Remove(cmd *cobra.Command, args []string) {
+	modes := 0
+	for _, set := range []bool{removeAll, removeDelivered, len(args) > 0} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		log.Fatal("specify either tracking numbers, --all, or --delivered")
+	}
+
+	parcels, missing, err := selectRemovals(args, removeAll, removeDelivered)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, tn := range missing {
+		log.Warnf("no stored parcel %s", tn)
+	}
+
+	prompt := fmt.Sprintf("Delete %d parcels?", len(parcels))
+	if removeDelivered {
+		prompt = fmt.Sprintf("Delete %d delivered parcels?", len(parcels))
+	}
+	confirmAndRemove(parcels, len(missing), prompt)
+}
+
+func Prune(cmd *cobra.Command, args []string) {
+	delivered, _, err := selectRemovals(nil, false, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	confirmAndRemove(delivered, 0, fmt.Sprintf("Delete %d delivered parcels?", len(delivered)))
+}
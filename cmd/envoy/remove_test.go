@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+// Seed the test database with a delivered and an undelivered parcel
+func seedRemovalParcels(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+
+	delivered := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	delivered.Data = &envoy.ParcelData{Delivered: true}
+	pending := envoy.NewParcel("Lamp", envoy.CarrierFedEx, "441259201412", "")
+	pending.Data = &envoy.ParcelData{}
+	for _, p := range []*envoy.Parcel{delivered, pending} {
+		if err := upsertParcel(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The tracking numbers of all stored parcels
+func storedTrackingNumbers(t *testing.T) []string {
+	t.Helper()
+	parcels, err := fetchParcels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, p := range parcels {
+		ids = append(ids, p.TrackingNumber)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestRemoveTargeted(t *testing.T) {
+	seedRemovalParcels(t)
+
+	parcels, missing, err := selectRemovals([]string{"441259201412", "5671 27", "9400123456789012345674"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(missing, []string{"9400123456789012345674"}) {
+		t.Errorf("Expected the unstored number to be reported, got %v", missing)
+	}
+
+	summary := removeParcels(parcels, deleteParcel)
+	if summary.done != 2 || summary.skipped != 0 {
+		t.Errorf("Expected 2 parcels deleted, got %+v", summary)
+	}
+	if ids := storedTrackingNumbers(t); len(ids) != 0 {
+		t.Errorf("Expected no stored parcels, got %v", ids)
+	}
+}
+
+func TestRemoveDelivered(t *testing.T) {
+	seedRemovalParcels(t)
+
+	parcels, missing, err := selectRemovals(nil, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parcels) != 1 || parcels[0].TrackingNumber != "1Z5R89390357567127" || len(missing) != 0 {
+		t.Fatalf("Expected only the delivered parcel, got %v (missing %v)", parcels, missing)
+	}
+
+	removeParcels(parcels, deleteParcel)
+	if ids := storedTrackingNumbers(t); !slices.Equal(ids, []string{"441259201412"}) {
+		t.Errorf("Expected only the undelivered parcel to remain, got %v", ids)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// Fragments shorter than this must match a tracking number exactly
+const minPartialLength = 4
+
+var errAmbiguousFragment = errors.New("ambiguous tracking number")
+
+// Resolve a tracking number, or a unique prefix or suffix of one, against the
+// parcels. An exact match always wins, but a fragment shared by several
+// parcels is an error. Returns nil if nothing matches.
+func resolvePartial(parcels []*envoy.Parcel, fragment string) (*envoy.Parcel, error) {
+	fragment = envoy.NormalizeTrackingNumber(fragment)
+	if fragment == "" {
+		return nil, nil
+	}
+
+	var matches []*envoy.Parcel
+	for _, p := range parcels {
+		if p.TrackingNumber == fragment {
+			return p, nil
+		}
+		if len(fragment) >= minPartialLength &&
+			(strings.HasPrefix(p.TrackingNumber, fragment) || strings.HasSuffix(p.TrackingNumber, fragment)) {
+			matches = append(matches, p)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	default:
+		candidates := make([]string, 0, len(matches))
+		for _, p := range matches {
+			candidates = append(candidates, p.TrackingNumber)
+		}
+		return nil, fmt.Errorf(
+			"%w: %s matches %s",
+			errAmbiguousFragment, fragment, strings.Join(candidates, ", "),
+		)
+	}
+}
+
+// Resolve a tracking number or fragment of one against the stored parcels,
+// returning nil if nothing matches
+func resolveParcel(fragment string) (*envoy.Parcel, error) {
+	parcels, err := fetchParcels()
+	if err != nil {
+		return nil, err
+	}
+	return resolvePartial(parcels, fragment)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestResolvePartial(t *testing.T) {
+	parcels := []*envoy.Parcel{
+		envoy.NewParcel("Shoes", envoy.CarrierFedEx, "271278612814", ""),
+		envoy.NewParcel("Books", envoy.CarrierFedEx, "271245206460", ""),
+		envoy.NewParcel("Lamp", envoy.CarrierUPS, "1Z5R89390357567127", ""),
+		envoy.NewParcel("Rug", envoy.CarrierUSPS, "1Z5R8939", ""),
+	}
+
+	tests := []struct {
+		name     string
+		fragment string
+		want     string
+		wantErr  error
+	}{
+		{"exact", "271278612814", "271278612814", nil},
+		{"exact wins over prefix", "1Z5R8939", "1Z5R8939", nil},
+		{"unique suffix", "612814", "271278612814", nil},
+		{"unique prefix", "27124", "271245206460", nil},
+		{"normalized", "1z5r-8939-0357", "1Z5R89390357567127", nil},
+		{"ambiguous prefix", "2712", "", errAmbiguousFragment},
+		{"no match", "999999", "", nil},
+		{"too short", "814", "", nil},
+		{"empty", "", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePartial(parcels, tt.fragment)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("resolvePartial() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("Expected no match, got %s", got.TrackingNumber)
+				}
+				return
+			}
+			if got == nil || got.TrackingNumber != tt.want {
+				t.Errorf("Expected %s, got %v", tt.want, got)
+			}
+		})
+	}
+}
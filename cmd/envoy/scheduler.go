@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"time"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// Refresh intervals for parcels in each state
+const (
+	refreshIntervalOutForDelivery = 15 * time.Minute
+	refreshIntervalMoving         = time.Hour
+	refreshIntervalStale          = 6 * time.Hour
+	// Parcels without a new event for this long are considered stale
+	staleAfter = 24 * time.Hour
+)
+
+// scheduledRefresh is a parcel to be refreshed at an offset into a cycle
+type scheduledRefresh struct {
+	parcel *envoy.Parcel
+	offset time.Duration
+}
+
+// scheduler spreads parcel refreshes across a polling window with per-parcel
+// jitter, so that carriers do not receive a burst of requests on every tick
+type scheduler struct {
+	window  time.Duration
+	rand    *rand.Rand
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) bool
+	refresh func(ctx context.Context, p *envoy.Parcel) error
+}
+
+func newScheduler(window time.Duration, refresh func(ctx context.Context, p *envoy.Parcel) error) *scheduler {
+	return &scheduler{
+		window:  window,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:     time.Now,
+		sleep:   sleepContext,
+		refresh: refresh,
+	}
+}
+
+// Sleep for d, returning false if the context was cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// Returns whether a parcel will not change any further
+func isTerminal(p *envoy.Parcel) bool {
+	if isDelivered(p) {
+		return true
+	}
+	if e := p.LastTrackingEvent(); e != nil {
+		return e.Type == envoy.ParcelEventTypeReturnedToSender
+	}
+	return false
+}
+
+// Returns how long to wait before refreshing a parcel again, based on its
+// state, or false if it never needs to be refreshed again
+func refreshInterval(p *envoy.Parcel, now time.Time) (time.Duration, bool) {
+	if isTerminal(p) {
+		return 0, false
+	}
+
+	e := p.LastTrackingEvent()
+	switch {
+	case e == nil:
+		return refreshIntervalMoving, true
+	case e.Type == envoy.ParcelEventTypeOutForDelivery || e.Type == envoy.ParcelEventTypeOnVehicle:
+		return refreshIntervalOutForDelivery, true
+	case now.Sub(e.Timestamp) > staleAfter:
+		return refreshIntervalStale, true
+	default:
+		return refreshIntervalMoving, true
+	}
+}
+
+// Returns whether a parcel is due to be refreshed
+func isDue(p *envoy.Parcel, now time.Time) bool {
+	return !isTerminal(p) && !now.Before(p.NextRefreshAt)
+}
+
+// Plan the refreshes for the current cycle. Only parcels that are due are
+// refreshed, and each is given its own slot in the window, jittered within
+// the slot.
+func (s *scheduler) plan(parcels []*envoy.Parcel) []scheduledRefresh {
+	now := s.now()
+	var due []*envoy.Parcel
+	for _, p := range parcels {
+		if isDue(p, now) {
+			due = append(due, p)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	slot := s.window / time.Duration(len(due))
+	planned := make([]scheduledRefresh, 0, len(due))
+	for i, p := range due {
+		offset := time.Duration(i) * slot
+		if slot > 0 {
+			offset += time.Duration(s.rand.Int63n(int64(slot)))
+		}
+		planned = append(planned, scheduledRefresh{parcel: p, offset: offset})
+	}
+	slices.SortFunc(planned, func(a, b scheduledRefresh) int {
+		return cmp.Compare(a.offset, b.offset)
+	})
+	return planned
+}
+
+// Refresh the given parcels over the course of one window, returning the
+// errors of any refreshes which failed
+func (s *scheduler) runCycle(ctx context.Context, parcels []*envoy.Parcel) error {
+	var (
+		elapsed time.Duration
+		errs    []error
+	)
+	for _, r := range s.plan(parcels) {
+		if !s.sleep(ctx, r.offset-elapsed) {
+			return ctx.Err()
+		}
+		elapsed = r.offset
+		if err := s.refresh(ctx, r.parcel); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Refresh a single stored parcel from its carrier and persist the result
+func refreshStoredParcel(client *http.Client) func(ctx context.Context, p *envoy.Parcel) error {
+	return func(ctx context.Context, p *envoy.Parcel) error {
+		creds := conf.carrier(p.Carrier)
+		if creds == nil {
+			log.Debugf("skipping refresh of %s: unsupported carrier %s", p.TrackingNumber, p.Carrier)
+			return nil
+		}
+		svc, err := newCarrierService(client, p.Carrier, *creds)
+		if err != nil {
+			return fmt.Errorf("could not refresh %s: %w", p.TrackingNumber, err)
+		}
+
+		parcels, err := svc.Track([]string{p.TrackingNumber})
+		if err != nil {
+			return fmt.Errorf("could not refresh %s: %w", p.TrackingNumber, err)
+		}
+		for _, updated := range parcels {
+			p.Refresh(updated, conf.ArchiveShipments)
+			now := time.Now()
+			p.LastSyncedAt = now
+			if interval, ok := refreshInterval(p, now); ok {
+				p.NextRefreshAt = now.Add(interval)
+			}
+			if err := upsertParcel(p); err != nil {
+				log.Warnf("error upserting parcel %s: %v", p.TrackingNumber, err)
+			}
+		}
+		return nil
+	}
+}
+
+// Construct a poller that refreshes all stored parcels, spreading the
+// requests across each polling interval
+func newStorePoller(requested time.Duration, client *http.Client) *poller {
+	p := newPoller(requested, nil)
+	s := newScheduler(p.interval, refreshStoredParcel(client))
+	p.poll = func(ctx context.Context) error {
+		parcels, err := fetchParcels()
+		if err != nil {
+			return fmt.Errorf("error fetching parcels: %w", err)
+		}
+		return s.runCycle(ctx, parcels)
+	}
+	return p
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestSchedulerSpreadsRefreshes(t *testing.T) {
+	window := 10 * time.Minute
+	start := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	now := start
+
+	var requests []time.Time
+	s := &scheduler{
+		window: window,
+		rand:   rand.New(rand.NewSource(1)),
+		now:    func() time.Time { return now },
+		sleep: func(_ context.Context, d time.Duration) bool {
+			now = now.Add(d)
+			return true
+		},
+		refresh: func(_ context.Context, p *envoy.Parcel) error {
+			requests = append(requests, now)
+			return nil
+		},
+	}
+
+	newParcel := func(trackingNumber string, eventType envoy.ParcelEventType, delivered bool) *envoy.Parcel {
+		p := envoy.NewParcel("", envoy.CarrierFedEx, trackingNumber, "")
+		p.Data = &envoy.ParcelData{
+			Events:    []envoy.ParcelEvent{{Type: eventType, Timestamp: start}},
+			Delivered: delivered,
+		}
+		return p
+	}
+	notDue := newParcel("271163815799", envoy.ParcelEventTypeInTransit, false)
+	notDue.NextRefreshAt = start.Add(time.Hour)
+	parcels := []*envoy.Parcel{
+		newParcel("271278612814", envoy.ParcelEventTypeDelivered, true),
+		notDue,
+		newParcel("281958973124", envoy.ParcelEventTypeInTransit, false),
+		newParcel("271198840120", envoy.ParcelEventTypeOutForDelivery, false),
+		newParcel("271245206460", envoy.ParcelEventTypeArrived, false),
+		newParcel("271163815798", envoy.ParcelEventTypeDeparted, false),
+	}
+
+	s.runCycle(context.Background(), parcels)
+
+	if len(requests) != 4 {
+		t.Fatalf("Expected 4 requests, got %d", len(requests))
+	}
+	for i := 1; i < len(requests); i++ {
+		if !requests[i].After(requests[i-1]) {
+			t.Errorf("Expected request %d to follow request %d, got %v and %v", i, i-1, requests[i-1], requests[i])
+		}
+	}
+	if spread := requests[len(requests)-1].Sub(requests[0]); spread < window/2 || spread > window {
+		t.Errorf("Expected requests to be spread across the %s window, got %s", window, spread)
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	now := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		eventType envoy.ParcelEventType
+		age       time.Duration
+		delivered bool
+		want      time.Duration
+		wantOk    bool
+	}{
+		{"out for delivery", envoy.ParcelEventTypeOutForDelivery, time.Hour, false, 15 * time.Minute, true},
+		{"in transit", envoy.ParcelEventTypeInTransit, 3 * time.Hour, false, time.Hour, true},
+		{"stale", envoy.ParcelEventTypeArrived, 3 * 24 * time.Hour, false, 6 * time.Hour, true},
+		{"delivered", envoy.ParcelEventTypeDelivered, time.Hour, true, 0, false},
+		{"returned", envoy.ParcelEventTypeReturnedToSender, time.Hour, false, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := envoy.NewParcel("", envoy.CarrierUPS, "1ZW701150378674373", "")
+			p.Data = &envoy.ParcelData{
+				Events:    []envoy.ParcelEvent{{Type: tt.eventType, Timestamp: now.Add(-tt.age)}},
+				Delivered: tt.delivered,
+			}
+
+			got, ok := refreshInterval(p, now)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Expected (%s, %v), got (%s, %v)", tt.want, tt.wantOk, got, ok)
+			}
+		})
+	}
+}
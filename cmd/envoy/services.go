@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/tracking"
+)
+
+var errUnsupportedCarrier = tracking.ErrUnsupportedCarrier
+
+// Construct the tracking service for a carrier from its credentials
+func newCarrierService(client *http.Client, carrier envoy.Carrier, creds CarrierConfig) (envoy.Service, error) {
+	return tracking.NewService(client, carrier, tracking.CarrierCredentials{Key: creds.Key, Secret: creds.Secret})
+}
+
+// Construct the tracking service for a carrier with its configured
+// credentials. Tests replace it to track against fakes.
+var configuredService = func(client *http.Client, carrier envoy.Carrier) (envoy.Service, error) {
+	creds := conf.carrier(carrier)
+	if creds == nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedCarrier, carrier)
+	}
+	return newCarrierService(client, carrier, *creds)
+}
+
+// Verify a carrier's credentials by requesting a fresh access token
+func checkCredentials(client *http.Client, carrier envoy.Carrier, creds CarrierConfig) error {
+	svc, err := newCarrierService(client, carrier, creds)
+	if err != nil {
+		return err
+	}
+	return svc.Reauthenticate()
+}
+
+// Construct parcels recording that their carrier is not supported, so that
+// they can be reported alongside the parcels which were tracked
+func unsupportedParcels(carrier envoy.Carrier, trackingNumbers []string) []*envoy.Parcel {
+	return failedParcels(carrier, trackingNumbers, fmt.Errorf("%w: %v", errUnsupportedCarrier, carrier))
+}
+
+// Construct parcels recording that tracking them failed with err, for when a
+// carrier fails a whole request instead of reporting each parcel
+func failedParcels(carrier envoy.Carrier, trackingNumbers []string, err error) []*envoy.Parcel {
+	parcels := make([]*envoy.Parcel, 0, len(trackingNumbers))
+	for _, tn := range trackingNumbers {
+		p := envoy.NewParcel(tn, carrier, tn, "")
+		p.Error = err
+		parcels = append(parcels, p)
+	}
+	return parcels
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+type setupStatus int
+
+const (
+	setupStatusPending setupStatus = iota
+	setupStatusChecking
+	setupStatusPassed
+	setupStatusFailed
+	setupStatusSkipped
+)
+
+type setupResult struct {
+	status setupStatus
+	err    error
+}
+
+type authResultMsg struct {
+	carrier envoy.Carrier
+	err     error
+}
+
+type setupModel struct {
+	client   *http.Client
+	carriers []envoy.Carrier
+	current  int
+	inputs   []textinput.Model
+	focus    int
+	results  map[envoy.Carrier]*setupResult
+	creds    map[envoy.Carrier]CarrierConfig
+	aborted  bool
+}
+
+func newSetupModel(carriers []envoy.Carrier) setupModel {
+	key := textinput.New()
+	key.Placeholder = "API key"
+	key.Prompt = "Key:    "
+
+	secret := textinput.New()
+	secret.Placeholder = "API secret"
+	secret.Prompt = "Secret: "
+	secret.EchoMode = textinput.EchoPassword
+	secret.EchoCharacter = '•'
+
+	m := setupModel{
+		client:   newHTTPClient(10 * time.Second),
+		carriers: carriers,
+		inputs:   []textinput.Model{key, secret},
+		results:  make(map[envoy.Carrier]*setupResult),
+		creds:    make(map[envoy.Carrier]CarrierConfig),
+	}
+	for _, c := range carriers {
+		m.results[c] = &setupResult{status: setupStatusPending}
+	}
+	m.resetInputs()
+	return m
+}
+
+func (m setupModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m setupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case authResultMsg:
+		result := m.results[msg.carrier]
+		if msg.err != nil {
+			result.status = setupStatusFailed
+			result.err = msg.err
+			return m, nil
+		}
+		result.status = setupStatusPassed
+		result.err = nil
+		m.creds[msg.carrier] = CarrierConfig{
+			Key:    strings.TrimSpace(m.inputs[0].Value()),
+			Secret: strings.TrimSpace(m.inputs[1].Value()),
+		}
+		return m.advance()
+	case tea.KeyMsg:
+		if m.done() {
+			return m, tea.Quit
+		}
+		if m.results[m.carrier()].status == setupStatusChecking {
+			if msg.String() == "ctrl+c" {
+				m.aborted = true
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "ctrl+c":
+			m.aborted = true
+			return m, tea.Quit
+		case "esc":
+			m.results[m.carrier()].status = setupStatusSkipped
+			return m.advance()
+		case "tab", "shift+tab", "up", "down":
+			m.setFocus(1 - m.focus)
+			return m, nil
+		case "enter":
+			if m.focus == 0 {
+				m.setFocus(1)
+				return m, nil
+			}
+			carrier := m.carrier()
+			creds := CarrierConfig{
+				Key:    strings.TrimSpace(m.inputs[0].Value()),
+				Secret: strings.TrimSpace(m.inputs[1].Value()),
+			}
+			m.results[carrier].status = setupStatusChecking
+			return m, func() tea.Msg {
+				return authResultMsg{
+					carrier: carrier,
+					err:     checkCredentials(m.client, carrier, creds),
+				}
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+	return m, cmd
+}
+
+func (m setupModel) View() string {
+	sb := strings.Builder{}
+	sb.WriteString("Envoy setup\n\n")
+
+	for i, c := range m.carriers {
+		result := m.results[c]
+		icon := dimStyle.Render("•")
+		note := ""
+		switch result.status {
+		case setupStatusChecking:
+			icon = iconUnknown
+			note = dimStyle.Render("checking...")
+		case setupStatusPassed:
+			icon = iconDelivered
+			note = successStyle.Render("ok")
+		case setupStatusFailed:
+			icon = iconException
+			note = errorStyle.Render(result.err.Error())
+		case setupStatusSkipped:
+			note = dimStyle.Render("skipped")
+		}
+		sb.WriteString(fmt.Sprintf("%s %-6s %s\n", icon, c, note))
+
+		if i == m.current && !m.done() {
+			for _, input := range m.inputs {
+				sb.WriteString("    " + input.View() + "\n")
+			}
+		}
+	}
+
+	sb.WriteString("\n")
+	if m.done() {
+		sb.WriteString(dimStyle.Render("Press any key to save and exit"))
+	} else {
+		sb.WriteString(dimStyle.Render("enter: next/check • tab: switch field • esc: skip carrier • ctrl+c: quit"))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func (m *setupModel) carrier() envoy.Carrier {
+	return m.carriers[m.current]
+}
+
+func (m *setupModel) done() bool {
+	return m.current >= len(m.carriers)
+}
+
+func (m setupModel) advance() (tea.Model, tea.Cmd) {
+	m.current++
+	if m.done() {
+		return m, nil
+	}
+	m.resetInputs()
+	return m, textinput.Blink
+}
+
+// Prefill the inputs with the current carrier's configured credentials
+func (m *setupModel) resetInputs() {
+	if m.done() {
+		return
+	}
+	if existing := conf.carrier(m.carrier()); existing != nil {
+		m.inputs[0].SetValue(existing.Key)
+		m.inputs[1].SetValue(existing.Secret)
+	} else {
+		m.inputs[0].Reset()
+		m.inputs[1].Reset()
+	}
+	m.setFocus(0)
+}
+
+func (m *setupModel) setFocus(i int) {
+	m.focus = i
+	for j := range m.inputs {
+		if j == i {
+			m.inputs[j].Focus()
+		} else {
+			m.inputs[j].Blur()
+		}
+	}
+}
+
+func Setup(cmd *cobra.Command, args []string) {
+	final, err := tea.NewProgram(newSetupModel(carrierServices)).Run()
+	if err != nil {
+		log.Fatalf("error running setup: %v", err)
+	}
+
+	m := final.(setupModel)
+	if m.aborted {
+		fmt.Println("Setup aborted, no changes written")
+		return
+	}
+	if len(m.creds) == 0 {
+		fmt.Println("No credentials verified, no changes written")
+		return
+	}
+
+	p, err := writeCarrierConfig(m.creds)
+	if err != nil {
+		log.Fatalf("error writing config: %v", err)
+	}
+	fmt.Printf("Wrote credentials to %s\n", p)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// parcelUpdate pairs a freshly fetched parcel with what changed since it was
+// stored
+type parcelUpdate struct {
+	parcel *envoy.Parcel
+	diff   envoy.ParcelDiff
+}
+
+// Fetch the grouped parcels, diffing each against its state in the store
+// before the fetch
+func syncUpdates(
+	groups map[envoy.Carrier][]string,
+	get func(trackingNumber string) (*envoy.Parcel, error),
+	sync func(groups map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error),
+) ([]parcelUpdate, error) {
+	stored := make(map[string]*envoy.Parcel)
+	var order []string
+	for _, c := range slices.Concat(carrierServices, []envoy.Carrier{envoy.CarrierUnknown}) {
+		for _, tn := range groups[c] {
+			order = append(order, tn)
+			p, err := get(tn)
+			if err != nil {
+				log.Warnf("could not read stored parcel %s: %v", tn, err)
+			}
+			if p == nil {
+				p = envoy.NewParcel(tn, c, tn, "")
+			}
+			stored[tn] = p
+		}
+	}
+
+	fetched, err := sync(groups)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make([]parcelUpdate, 0, len(order))
+	for _, tn := range order {
+		p, ok := fetched[tn]
+		if !ok {
+			continue
+		}
+		updates = append(updates, parcelUpdate{parcel: p, diff: stored[tn].Diff(p)})
+	}
+	return updates, nil
+}
+
+// Format the changes to a parcel, or "no updates." if nothing changed
+func formatParcelUpdate(u parcelUpdate) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s (%s) %s\n", u.parcel.Name, u.parcel.Carrier, u.parcel.TrackingNumber))
+
+	if u.parcel.HasError() {
+		sb.WriteString(fmt.Sprintf("  %s %v\n", iconException, u.parcel.Error))
+		return sb.String()
+	}
+	if u.diff.IsEmpty() {
+		sb.WriteString(dimStyle.Render("  no updates.") + "\n")
+		return sb.String()
+	}
+
+	if u.diff.NewShipment {
+		sb.WriteString("  tracking number reused for a new shipment\n")
+	}
+	for _, e := range u.diff.NewEvents {
+		sb.WriteString(fmt.Sprintf(
+			"  + %s %s\n",
+			formatEventIcon(&e),
+			formatHighlighted(e.Type, formatEventOneline("", &e)),
+		))
+	}
+	if u.diff.Delivered {
+		sb.WriteString(fmt.Sprintf("  %s delivered\n", iconDelivered))
+	}
+	if u.diff.ETAChanged {
+		sb.WriteString(fmt.Sprintf("  ETA: %s → %s\n", formatETA(u.diff.PreviousETA), formatETA(u.diff.ETA)))
+	}
+	return sb.String()
+}
+
+// Fold parcels tracked under an alternate number of another parcel into that
+// parcel, which is treated as the primary. Returns the primary each folded
+// tracking number was merged into.
+func collapseAlternates(parcels map[string]*envoy.Parcel) map[string]*envoy.Parcel {
+	merged := make(map[string]*envoy.Parcel)
+	// Visit parcels in a stable order so the primary does not depend on map order
+	for _, id := range slices.Sorted(maps.Keys(parcels)) {
+		p, ok := parcels[id]
+		if !ok || p.HasError() {
+			continue
+		}
+		for _, alt := range p.AlternateNumbers() {
+			other, ok := parcels[alt]
+			if !ok || other == p {
+				continue
+			}
+			p.Merge(other)
+			if p.Name == p.TrackingNumber && other.Name != other.TrackingNumber {
+				p.Name = other.Name
+			}
+			delete(parcels, alt)
+			merged[alt] = p
+		}
+	}
+	return merged
+}
+
+func Sync(cmd *cobra.Command, args []string) {
+	groups := groupTrackingNumbers(args, carrierFlagGroups(cmd))
+	updates, err := syncUpdates(groups, getParcel, syncParcels)
+	if err != nil {
+		log.Fatalf("Error syncing parcels: %v", err)
+	}
+	if len(updates) == 0 {
+		fmt.Println("no updates.")
+		return
+	}
+
+	for _, u := range updates {
+		fmt.Print(formatParcelUpdate(u))
+	}
+}
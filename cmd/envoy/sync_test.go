@@ -0,0 +1,137 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestSyncUpdatesShowsOnlyNewEvents(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	known := envoy.ParcelEvent{
+		Type:        envoy.ParcelEventTypePickedUp,
+		Description: "Picked up",
+		Location:    "FRESNO, CA",
+		Timestamp:   timeNow,
+	}
+	update := envoy.ParcelEvent{
+		Type:        envoy.ParcelEventTypeInTransit,
+		Description: "In transit",
+		Location:    "BAKERSFIELD, CA",
+		Timestamp:   timeNow.Add(6 * time.Hour),
+	}
+
+	get := func(trackingNumber string) (*envoy.Parcel, error) {
+		p := envoy.NewParcel("Books", envoy.CarrierFedEx, trackingNumber, "")
+		p.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{known}}
+		return p, nil
+	}
+	sync := func(groups map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error) {
+		fetched := make(map[string]*envoy.Parcel)
+		for carrier, tns := range groups {
+			for _, tn := range tns {
+				p := envoy.NewParcel("Books", carrier, tn, "")
+				p.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{update, known}}
+				fetched[tn] = p
+			}
+		}
+		return fetched, nil
+	}
+
+	groups := map[envoy.Carrier][]string{envoy.CarrierFedEx: {"441259201412"}}
+	updates, err := syncUpdates(groups, get, sync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("Expected 1 update, got %d", len(updates))
+	}
+
+	diff := updates[0].diff
+	if len(diff.NewEvents) != 1 || diff.NewEvents[0].Description != "In transit" {
+		t.Errorf("Expected only the new event, got %+v", diff.NewEvents)
+	}
+
+	out := formatParcelUpdate(updates[0])
+	if !strings.Contains(out, formatEventOneline("", &update)) {
+		t.Errorf("Expected new event in output, got %q", out)
+	}
+	if strings.Contains(out, formatEventOneline("", &known)) {
+		t.Errorf("Expected known event to be omitted, got %q", out)
+	}
+
+	// Nothing changes when fetched again
+	get = func(trackingNumber string) (*envoy.Parcel, error) {
+		p := envoy.NewParcel("Books", envoy.CarrierFedEx, trackingNumber, "")
+		p.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{known, update}}
+		return p, nil
+	}
+	updates, err = syncUpdates(groups, get, sync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := formatParcelUpdate(updates[0]); !strings.Contains(out, "no updates.") {
+		t.Errorf("Expected no updates, got %q", out)
+	}
+}
+
+func TestCollapseAlternates(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	primary := envoy.NewParcel("1Z5R89390357567127", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	primary.AlternateTrackingNumbers = []string{"9400 1234 5678 9012 3456 74"}
+	primary.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{
+		{Type: envoy.ParcelEventTypePickedUp, Description: "Picked up", Timestamp: timeNow},
+		{Type: envoy.ParcelEventTypeInTransit, Description: "Handed off to USPS", Timestamp: timeNow.Add(24 * time.Hour)},
+	}}
+
+	alternate := envoy.NewParcel("Birthday gift", envoy.CarrierUSPS, "9400123456789012345674", "")
+	alternate.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{
+		{Type: envoy.ParcelEventTypeInTransit, Description: "Accepted at USPS facility", Timestamp: timeNow.Add(26 * time.Hour)},
+		{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: timeNow.Add(48 * time.Hour)},
+	}}
+
+	unrelated := envoy.NewParcel("Shoes", envoy.CarrierFedEx, "441259201412", "")
+	unrelated.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{
+		{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: timeNow},
+	}}
+
+	parcels := map[string]*envoy.Parcel{
+		primary.TrackingNumber:   primary,
+		alternate.TrackingNumber: alternate,
+		unrelated.TrackingNumber: unrelated,
+	}
+
+	merged := collapseAlternates(parcels)
+	if len(merged) != 1 || merged[alternate.TrackingNumber] != primary {
+		t.Fatalf("Expected %s to be merged into %s, got %v", alternate.TrackingNumber, primary.TrackingNumber, merged)
+	}
+	if len(parcels) != 2 || parcels[alternate.TrackingNumber] != nil {
+		t.Fatalf("Expected the alternate to be removed, got %v", slices.Collect(maps.Keys(parcels)))
+	}
+
+	p := parcels[primary.TrackingNumber]
+	if len(p.Data.Events) != 4 {
+		t.Errorf("Expected events to be unioned, got %d", len(p.Data.Events))
+	}
+	if e := p.LastTrackingEvent(); e == nil || e.Description != "Delivered" {
+		t.Errorf("Expected the latest event to come from the alternate, got %v", e)
+	}
+	if p.Carrier != envoy.CarrierUPS {
+		t.Errorf("Expected the primary carrier to be kept, got %s", p.Carrier)
+	}
+	if p.Name != "Birthday gift" {
+		t.Errorf("Expected the alternate's name to be adopted, got %q", p.Name)
+	}
+	if parcels[unrelated.TrackingNumber] != unrelated || len(unrelated.Data.Events) != 1 {
+		t.Error("Expected the unrelated parcel to be untouched")
+	}
+}
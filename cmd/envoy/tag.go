@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+var trackTags []string
+
+// Report whether the parcel is labeled with every one of the tags
+func hasAllTags(p *envoy.Parcel, tags []string) bool {
+	for _, t := range tags {
+		if !p.HasTag(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select the parcels labeled with every one of the tags
+func filterParcelsByTags(parcels []*envoy.Parcel, tags []string) []*envoy.Parcel {
+	var filtered []*envoy.Parcel
+	for _, p := range parcels {
+		if hasAllTags(p, tags) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// Add or remove tags on the stored parcel matching the fragment, returning
+// the updated parcel
+func updateTags(fragment string, tags []string, remove bool) (*envoy.Parcel, error) {
+	p, err := resolveParcel(fragment)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, fmt.Errorf("no stored parcel %s", fragment)
+	}
+
+	changed := false
+	if remove {
+		changed = p.RemoveTags(tags...)
+	} else {
+		changed = p.AddTags(tags...)
+	}
+	if !changed {
+		return p, nil
+	}
+	// Saved in full, as updates skip empty fields and so could not clear the
+	// last tag
+	if err := createParcel(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Format the tags of a parcel for display
+func formatTags(p *envoy.Parcel) string {
+	return strings.Join(p.Tags, ", ")
+}
+
+func Tag(cmd *cobra.Command, args []string) {
+	p, err := updateTags(args[0], args[1:], false)
+	if err != nil {
+		log.Fatalf("error tagging parcel: %v", err)
+	}
+	fmt.Printf("%s: %s\n", p.TrackingNumber, formatTags(p))
+}
+
+func Untag(cmd *cobra.Command, args []string) {
+	p, err := updateTags(args[0], args[1:], true)
+	if err != nil {
+		log.Fatalf("error untagging parcel: %v", err)
+	}
+	fmt.Printf("%s: %s\n", p.TrackingNumber, formatTags(p))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestUpdateTags(t *testing.T) {
+	openTestDB(t)
+
+	p := envoy.NewParcel("Books", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	if err := upsertParcel(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := updateTags("567127", []string{"Work", "urgent"}, false); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := getParcel(p.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(stored.Tags, []string{"work", "urgent"}) {
+		t.Errorf("Expected tags to be stored, got %q", stored.Tags)
+	}
+
+	// Tags survive syncs, which upsert the refreshed stored parcel
+	fetched := envoy.NewParcel(p.TrackingNumber, envoy.CarrierUPS, p.TrackingNumber, "")
+	fetched.Data = &envoy.ParcelData{Delivered: true}
+	if err := upsertParcel(refreshStored(fetched)); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := getParcel(p.TrackingNumber); !slices.Equal(stored.Tags, []string{"work", "urgent"}) {
+		t.Errorf("Expected tags to survive a sync, got %q", stored.Tags)
+	}
+	// Fetched parcels carry no tags, so upserting one directly keeps them too
+	if err := upsertParcel(fetched); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := getParcel(p.TrackingNumber); !slices.Equal(stored.Tags, []string{"work", "urgent"}) {
+		t.Errorf("Expected tags to survive an upsert, got %q", stored.Tags)
+	}
+
+	if _, err := updateTags(p.TrackingNumber, []string{"work", "URGENT"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := getParcel(p.TrackingNumber); len(stored.Tags) != 0 {
+		t.Errorf("Expected all tags to be removed, got %q", stored.Tags)
+	}
+
+	if _, err := updateTags("9400123456789012345674", []string{"work"}, false); err == nil {
+		t.Error("Expected an error tagging an unstored parcel")
+	}
+}
+
+func TestFilterParcelsByTags(t *testing.T) {
+	work := envoy.NewParcel("Monitor", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	work.AddTags("work", "urgent")
+	personal := envoy.NewParcel("Books", envoy.CarrierFedEx, "441259201412", "")
+	personal.AddTags("personal")
+	untagged := envoy.NewParcel("Lamp", envoy.CarrierUSPS, "9400123456789012345674", "")
+	parcels := []*envoy.Parcel{work, personal, untagged}
+
+	tests := []struct {
+		tags []string
+		want []*envoy.Parcel
+	}{
+		{nil, parcels},
+		{[]string{"Work"}, []*envoy.Parcel{work}},
+		{[]string{"work", "urgent"}, []*envoy.Parcel{work}},
+		{[]string{"work", "personal"}, nil},
+	}
+	for _, tt := range tests {
+		if got := filterParcelsByTags(parcels, tt.tags); !slices.Equal(got, tt.want) {
+			t.Errorf("filterParcelsByTags(%q) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestListTagFilter(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+	defer func(status string, tags []string, line bool) {
+		lsStatus, lsTags, oneline = status, tags, line
+	}(lsStatus, lsTags, oneline)
+	lsStatus, oneline = string(statusFilterAll), true
+
+	work := envoy.NewParcel("Monitor", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	work.AddTags("work")
+	gift := envoy.NewParcel("Books", envoy.CarrierFedEx, "441259201412", "")
+	gift.AddTags("gifts")
+	for _, p := range []*envoy.Parcel{work, gift} {
+		p.Data = &envoy.ParcelData{
+			Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeInTransit, Description: "In transit"}},
+		}
+		if err := createParcel(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lsTags = []string{"Work"}
+	out := captureStdout(t, func() { List(&cobra.Command{}, nil) })
+	if !strings.Contains(out, work.TrackingNumber) || strings.Contains(out, gift.TrackingNumber) {
+		t.Errorf("Expected only the work parcel listed, got %q", out)
+	}
+
+	lsTags = []string{"personal"}
+	if out := captureStdout(t, func() { List(&cobra.Command{}, nil) }); !strings.Contains(out, "No matching parcels") {
+		t.Errorf("Expected no matching parcels, got %q", out)
+	}
+}
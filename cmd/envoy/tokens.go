@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// Name of the file in the config dir caching carrier access tokens
+const tokenCacheFile = "tokens.json"
+
+// An access token cached for a carrier, along with a fingerprint of the API
+// key it was issued to, so that it is not reused after credentials change
+type cachedToken struct {
+	KeyHash string             `json:"key_hash"`
+	Token   *envoy.CachedToken `json:"token"`
+}
+
+// tokenCache holds the access tokens of carriers between runs, so that each
+// run does not need to reauthenticate. It is safe for concurrent use.
+type tokenCache struct {
+	path    string
+	mu      sync.Mutex
+	tokens  map[envoy.Carrier]cachedToken
+	changed bool
+}
+
+// Returns a fingerprint of an API key, which does not reveal it
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Load the token cache at p. A missing file yields an empty cache.
+func loadTokenCache(p string) (*tokenCache, error) {
+	c := &tokenCache{path: p, tokens: make(map[envoy.Carrier]cachedToken)}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.tokens); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load the token cache from the config dir, logging rather than failing if it
+// cannot be read, since tokens can always be requested again
+func openTokenCache() *tokenCache {
+	dir, err := ConfigDir()
+	if err == nil {
+		var c *tokenCache
+		if c, err = loadTokenCache(path.Join(dir, tokenCacheFile)); err == nil {
+			return c
+		}
+	}
+	log.Warnf("could not load cached tokens: %v", err)
+	return &tokenCache{tokens: make(map[envoy.Carrier]cachedToken)}
+}
+
+// Give a service the cached token of its carrier, if it has not expired and
+// was issued to the same API key
+func (c *tokenCache) restore(carrier envoy.Carrier, key string, svc envoy.Service) {
+	cacher, ok := svc.(envoy.TokenCacher)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[carrier]; ok && t.KeyHash == keyHash(key) && t.Token.IsValid() {
+		cacher.SetCachedToken(t.Token)
+	}
+}
+
+// Record the current token of a service, if it has a new one
+func (c *tokenCache) capture(carrier envoy.Carrier, key string, svc envoy.Service) {
+	cacher, ok := svc.(envoy.TokenCacher)
+	if !ok {
+		return
+	}
+	token := cacher.CachedToken()
+	if !token.IsValid() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[carrier]; ok && t.Token != nil && *t.Token == *token {
+		return
+	}
+	c.tokens[carrier] = cachedToken{KeyHash: keyHash(key), Token: token}
+	c.changed = true
+}
+
+// Write the cache if any tokens have changed. The file is only readable by
+// the current user since tokens grant access to carrier accounts.
+func (c *tokenCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed || c.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.changed = false
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// tokenService is a service which only holds a token
+type tokenService struct {
+	token *envoy.CachedToken
+}
+
+func (s *tokenService) Track([]string) ([]*envoy.Parcel, error) { return nil, nil }
+func (s *tokenService) Reauthenticate() error                   { return nil }
+func (s *tokenService) CachedToken() *envoy.CachedToken         { return s.token }
+func (s *tokenService) SetCachedToken(t *envoy.CachedToken)     { s.token = t }
+
+func TestTokenCache(t *testing.T) {
+	p := path.Join(t.TempDir(), tokenCacheFile)
+	cache, err := loadTokenCache(p)
+	if err != nil {
+		t.Fatalf("Expected a missing cache to load empty, got %v", err)
+	}
+
+	valid := &envoy.CachedToken{Value: "fedex-token", Expiration: time.Now().Add(time.Hour)}
+	expired := &envoy.CachedToken{Value: "ups-token", Expiration: time.Now().Add(-time.Minute)}
+	cache.capture(envoy.CarrierFedEx, "fedex-key", &tokenService{token: valid})
+	cache.capture(envoy.CarrierUPS, "ups-key", &tokenService{token: expired})
+	cache.capture(envoy.CarrierUSPS, "usps-key", &tokenService{})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(p); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("Expected the cache to be private, got %v, %v", info.Mode(), err)
+		}
+	}
+
+	cache, err = loadTokenCache(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &tokenService{}
+	cache.restore(envoy.CarrierFedEx, "fedex-key", svc)
+	if svc.token == nil || svc.token.Value != "fedex-token" || !svc.token.Expiration.Equal(valid.Expiration) {
+		t.Errorf("Expected the cached token to be restored, got %+v", svc.token)
+	}
+
+	svc = &tokenService{}
+	cache.restore(envoy.CarrierFedEx, "other-key", svc)
+	if svc.token != nil {
+		t.Errorf("Expected no token for a different API key, got %+v", svc.token)
+	}
+	for _, carrier := range []envoy.Carrier{envoy.CarrierUPS, envoy.CarrierUSPS} {
+		svc = &tokenService{}
+		cache.restore(carrier, "key", svc)
+		if svc.token != nil {
+			t.Errorf("Expected no token for %s, got %+v", carrier, svc.token)
+		}
+	}
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	p := path.Join(t.TempDir(), tokenCacheFile)
+	cache, err := loadTokenCache(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := &envoy.CachedToken{Value: "token", Expiration: time.Now().Add(50 * time.Millisecond)}
+	cache.capture(envoy.CarrierFedEx, "key", &tokenService{token: token})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if cache, err = loadTokenCache(p); err != nil {
+		t.Fatal(err)
+	}
+	svc := &tokenService{}
+	cache.restore(envoy.CarrierFedEx, "key", svc)
+	if svc.token != nil {
+		t.Errorf("Expected an expired token not to be restored, got %+v", svc.token)
+	}
+}
@@ -1,23 +1,23 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"slices"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/asdine/storm/v3"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	zone "github.com/lrstanley/bubblezone"
-	"github.com/skratchdot/open-golang/open"
 
 	"github.com/rektdeckard/envoy/pkg"
-	"github.com/rektdeckard/envoy/pkg/fedex"
-	"github.com/rektdeckard/envoy/pkg/ups"
-	"github.com/rektdeckard/envoy/pkg/usps"
 )
 
 const (
@@ -71,25 +71,85 @@ type fetchMsg struct {
 	parcels map[string]*envoy.Parcel
 }
 
+// refreshTickMsg is sent when parcels are due to be refreshed automatically
+type refreshTickMsg struct{}
+
 type model struct {
-	client           *http.Client
-	parcels          map[string]*envoy.Parcel
-	parcelsSelection map[int]struct{}
-	currentView      view
-	parcelsTable     table.Model
-	eventsTable      table.Model
+	client      *http.Client
+	parcels     map[string]*envoy.Parcel
+	parcelIDs   []string
+	pendingOpen []string
+	// Whether the status of the parcels whose tracking pages open is being
+	// chosen
+	choosingOpenStatus bool
+	pendingDelete      *envoy.Parcel
+	noteParcel         *envoy.Parcel
+	noteInput          textinput.Model
+	parcelsSelection   map[int]struct{}
+	currentView        view
+	parcelsTable       table.Model
+	eventsTable        table.Model
+	columns            []parcelColumn
+	sort               parcelSort
+	detailView         *viewport.Model
+	// Whether the filter input is open, and the query narrowing the parcels
+	// table as it is typed
+	filtering   bool
+	filterInput textinput.Model
+	filter      string
+	// Whether consecutive events at the same location share a row
+	collapsed       bool
+	refreshInterval time.Duration
+	refreshing      bool
+	width           int
+	height          int
 }
 
 func (m model) Init() tea.Cmd {
 	zone.NewGlobal()
 	m.parcelsTable.Focus()
 
-	ids := make([]string, 0, len(m.parcels))
-	for _, p := range m.parcels {
-		ids = append(ids, p.TrackingNumber)
+	return tea.Batch(
+		initParcels(m.client, m.trackingGroups()),
+		m.scheduleRefresh(),
+	)
+}
+
+// Fetch all loaded parcels again, unless a fetch is already in flight
+func (m *model) refresh() tea.Cmd {
+	if m.refreshing {
+		return nil
+	}
+	m.refreshing = true
+	return initParcels(m.client, m.trackingGroups())
+}
+
+// Group the loaded parcels by their stored carrier, so that a --carrier
+// override only applies to the tracking numbers it was given with. The
+// carrier is detected for parcels stored without one.
+func (m model) trackingGroups() map[envoy.Carrier][]string {
+	groups := make(map[envoy.Carrier][]string)
+	for _, id := range m.parcelIDs {
+		carrier := envoy.CarrierUnknown
+		if p, ok := m.parcels[id]; ok {
+			carrier = p.Carrier
+		}
+		if carrier == "" || carrier == envoy.CarrierUnknown {
+			carrier = envoy.DetectCarrier(id)
+		}
+		groups[carrier] = append(groups[carrier], id)
 	}
-	groups := groupByCarrier(ids)
-	return initParcels(m.client, groups)
+	return groups
+}
+
+// Schedule the next automatic refresh, if they are enabled
+func (m model) scheduleRefresh() tea.Cmd {
+	if m.refreshInterval <= 0 {
+		return nil
+	}
+	return tea.Tick(m.refreshInterval, func(time.Time) tea.Msg {
+		return refreshTickMsg{}
+	})
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -98,6 +158,63 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmds []tea.Cmd
 	)
 
+	if m.detailView != nil {
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "ctrl+c":
+				return m, tea.Quit
+			case "esc", "q", "J", "N":
+				m.detailView = nil
+				return m, nil
+			}
+		}
+		if msg, ok := msg.(tea.WindowSizeMsg); ok {
+			m.detailView.Width, m.detailView.Height = detailViewSize(msg.Width, msg.Height)
+		}
+		vp, cmd := m.detailView.Update(msg)
+		m.detailView = &vp
+		return m, cmd
+	}
+
+	if m.noteParcel != nil {
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "ctrl+c":
+				return m, tea.Quit
+			case "enter":
+				if err := saveNote(m.noteParcel, m.noteInput.Value()); err != nil {
+					log.Warnf("could not save note for %s: %v", m.noteParcel.TrackingNumber, err)
+				}
+				m.noteParcel = nil
+			case "esc":
+				m.noteParcel = nil
+			default:
+				m.noteInput, cmd = m.noteInput.Update(msg)
+				return m, cmd
+			}
+			return m, nil
+		}
+	}
+
+	if m.filtering {
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "ctrl+c":
+				return m, tea.Quit
+			case "enter":
+				m.filtering = false
+			case "esc":
+				m.filtering = false
+				m.setFilter("")
+			default:
+				m.filterInput, cmd = m.filterInput.Update(msg)
+				m.setFilter(m.filterInput.Value())
+				return m, cmd
+			}
+			return m, nil
+		}
+	}
+
 	m.parcelsTable, cmd = m.parcelsTable.Update(msg)
 	cmds = append(cmds, cmd)
 
@@ -106,17 +223,50 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case fetchMsg:
+		m.refreshing = false
 		for _, p := range msg.parcels {
-			if e := p.LastTrackingEvent(); e != nil {
+			if e := p.LastTrackingEvent(); e != nil || p.HasError() {
+				// Loaded parcels keep their names and tags
+				if existing, ok := m.parcels[p.TrackingNumber]; ok {
+					before := lastEventType(existing)
+					existing.Refresh(p, conf.ArchiveShipments)
+					// Stored before announcing any change, so that the next
+					// launch compares against it and does not announce it again
+					if p.HasData() {
+						existing.LastSyncedAt = time.Now()
+						if err := upsertParcel(existing); err != nil {
+							log.Warnf("could not store parcel %s: %v", existing.TrackingNumber, err)
+						}
+					}
+					fireWebhook(before, existing)
+					if conf.Notifications {
+						cmds = append(cmds, transitionNotification(before, existing))
+					}
+					continue
+				}
+				m.parcelIDs = append(m.parcelIDs, p.TrackingNumber)
 				m.parcels[p.TrackingNumber] = p
 			}
 		}
+		for alt := range collapseAlternates(m.parcels) {
+			m.parcelIDs = slices.DeleteFunc(m.parcelIDs, func(id string) bool {
+				return id == alt
+			})
+		}
+		m.sortParcels()
+		m.refreshEventRows()
+	case refreshTickMsg:
+		// Countdowns are relative to now, so they are recomputed even before
+		// the refreshed parcels arrive
+		m.refreshParcelRows()
+		cmds = append(cmds, m.refresh(), m.scheduleRefresh())
 	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
 		w, h := baseStyle.GetFrameSize()
 
 		m.parcelsTable.SetWidth(msg.Width - w - 2)
 		cols := m.parcelsTable.Columns()
-		cols[len(cols)-1].Width = msg.Width - w - 68
+		cols[len(cols)-1].Width = msg.Width - w - 2 - fixedColumnsWidth(cols)
 		m.parcelsTable.SetColumns(cols)
 
 		m.eventsTable.SetWidth(msg.Width - w - 2)
@@ -125,6 +275,30 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.eventsTable.SetColumns(cols)
 		m.eventsTable.SetHeight(msg.Height - (2 * h) - m.parcelsTable.Height() - 7)
 	case tea.KeyMsg:
+		if m.choosingOpenStatus {
+			m.choosingOpenStatus = false
+			if f, ok := openStatusKeys[msg.String()]; ok {
+				m.openTrackingURLs(f)
+			}
+			return m, nil
+		}
+		if m.pendingOpen != nil {
+			if msg.String() == "y" {
+				for _, u := range m.pendingOpen {
+					openURL(u)
+				}
+			}
+			m.pendingOpen = nil
+			return m, nil
+		}
+		if m.pendingDelete != nil {
+			if msg.String() == "y" {
+				m.removeParcel(m.pendingDelete)
+			}
+			m.pendingDelete = nil
+			return m, nil
+		}
+
 		switch msg.String() {
 		case "q", "ctrl+c":
 			return m, tea.Quit
@@ -135,13 +309,61 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			cmd := m.setEventsView()
 			cmds = append(cmds, cmd)
 		case "esc", "h", "left":
+			if msg.String() == "esc" && m.filter != "" {
+				m.setFilter("")
+			}
 			cmd := m.setParcelsView()
 			cmds = append(cmds, cmd)
+		case "/":
+			m.filtering = true
+			m.filterInput = textinput.New()
+			m.filterInput.Prompt = "/"
+			m.filterInput.Placeholder = "name, tracking number, or carrier"
+			m.filterInput.SetValue(m.filter)
+			cmds = append(cmds, m.filterInput.Focus())
 		case "o":
-			if s := m.parcelsTable.SelectedRow(); s != nil {
-				parcel := m.parcels[s[2]]
-				open.Run(parcel.TrackingURL)
+			if parcel := m.selectedParcel(); parcel != nil {
+				openURL(parcelTrackingURL(parcel))
 			}
+		case "e":
+			if parcel := m.selectedParcel(); parcel != nil {
+				m.noteParcel = parcel
+				m.noteInput = textinput.New()
+				m.noteInput.Prompt = "Note: "
+				m.noteInput.Placeholder = "what is this parcel for?"
+				m.noteInput.SetValue(parcel.Note)
+				cmds = append(cmds, m.noteInput.Focus())
+			}
+		case "r":
+			cmds = append(cmds, m.refresh())
+		case "x":
+			m.pendingDelete = m.selectedParcel()
+		case "c":
+			m.collapsed = !m.collapsed
+			m.refreshEventRows()
+		case "s":
+			m.setSort(m.sort.next())
+		case "J":
+			if parcel := m.selectedParcel(); parcel != nil {
+				vp := viewport.New(detailViewSize(m.width, m.height))
+				vp.SetContent(formatRawParcel(parcel))
+				m.detailView = &vp
+			}
+		case "N":
+			if parcel := m.selectedParcel(); parcel != nil {
+				vp := viewport.New(detailViewSize(m.width, m.height))
+				content := formatProgress(parcel)
+				if service := formatServiceLevel(parcel); service != envoy.EmDash {
+					content += "\n" + dimStyle.Render("Service: "+service)
+				}
+				if size := formatSize(parcel); size != envoy.EmDash {
+					content += "\n" + dimStyle.Render("Size: "+size)
+				}
+				vp.SetContent(content + "\n\n" + formatNotices(parcel, vp.Width))
+				m.detailView = &vp
+			}
+		case "O":
+			m.choosingOpenStatus = true
 		}
 		if len(m.parcels) > 0 && key.Matches(msg,
 			m.parcelsTable.KeyMap.LineUp,
@@ -153,36 +375,68 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.parcelsTable.KeyMap.GotoTop,
 			m.parcelsTable.KeyMap.GotoBottom,
 		) {
-			parcel := m.parcels[m.parcelsTable.SelectedRow()[2]]
-
-			var eRows []table.Row
-			for _, p := range parcel.Data.Events {
-				eRows = append(eRows, table.Row{
-					string(p.Type),
-					p.Location,
-					p.Timestamp.Format(timeFormat),
-					p.Description,
-				})
-			}
-			m.eventsTable.SetRows(eRows)
+			m.refreshEventRows()
 		}
 	case tea.MouseMsg:
 		if msg.Action != tea.MouseActionRelease || msg.Button != tea.MouseButtonLeft {
 			return m, nil
 		}
-	default:
-		log.Fatalf("invalid message: %+v", msg)
+		// The header is the first line inside the border of the parcels table
+		if x, y := zone.Get("parcels").Pos(msg); y == 1 {
+			if i := columnAt(m.parcelsTable.Columns(), x-1); i >= 0 && m.columns[i].compare != nil {
+				m.setSort(m.sort.byColumn(m.columns[i].key))
+			}
+		}
 	}
 
+	// Messages from components, such as cursor blinks, need no handling here
+	// beyond the commands the tables returned for them
 	return m, tea.Batch(cmds...)
 }
 
 func (m model) View() string {
+	if m.detailView != nil {
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			baseStyle.Render(m.detailView.View()),
+			dimStyle.Render(fmt.Sprintf("%3.f%% • ↑/↓: scroll • esc: back", m.detailView.ScrollPercent()*100)),
+		)
+	}
+
+	footer := m.eventsTable.HelpView()
+	if m.choosingOpenStatus {
+		footer = indeterminateStyle.Render(
+			"Open tracking pages of shown parcels: (e)xceptions, (d)elivered, (a)ctive, (*) all",
+		)
+	}
+	if m.pendingOpen != nil {
+		footer = indeterminateStyle.Render(
+			fmt.Sprintf("Open %d tracking URLs? (y/N)", len(m.pendingOpen)),
+		)
+	}
+	if m.pendingDelete != nil {
+		footer = indeterminateStyle.Render(
+			fmt.Sprintf("Delete %s? (y/N)", m.pendingDelete.Name),
+		)
+	}
+	if m.refreshing {
+		footer += dimStyle.Render(" • refreshing…")
+	}
+	if m.filter != "" {
+		footer += dimStyle.Render(fmt.Sprintf(" • filter: %s (esc: clear)", m.filter))
+	}
+	if m.noteParcel != nil {
+		footer = m.noteInput.View()
+	}
+	if m.filtering {
+		footer = m.filterInput.View()
+	}
+
 	view := lipgloss.JoinVertical(
 		lipgloss.Left,
 		zone.Mark("parcels", baseStyle.Render(m.parcelsTable.View())),
 		zone.Mark("events", baseStyle.Render(m.eventsTable.View())),
-		m.eventsTable.HelpView(),
+		footer,
 	)
 	return zone.Scan(view)
 }
@@ -190,93 +444,72 @@ func (m model) View() string {
 func initParcels(client *http.Client, groups map[envoy.Carrier][]string) func() tea.Msg {
 	return func() tea.Msg {
 
-		wg := sync.WaitGroup{}
+		var (
+			wg sync.WaitGroup
+			mu sync.Mutex
+		)
 		allParcels := make(map[string]*envoy.Parcel)
+		var unsupported []*envoy.Parcel
 
 		for carrier, trackingNumbers := range groups {
-			var svc envoy.Service
-
-			switch carrier {
-			case envoy.CarrierFedEx:
-				svc = fedex.NewFedexService(
-					client,
-					conf.Carriers.FedEx.Key,
-					conf.Carriers.FedEx.Secret,
-				)
-			case envoy.CarrierUPS:
-				svc = ups.NewUPSService(
-					&http.Client{},
-					conf.Carriers.UPS.Key,
-					conf.Carriers.UPS.Secret,
-				)
-			case envoy.CarrierUSPS:
-				svc = usps.NewUSPSService(
-					&http.Client{},
-					conf.Carriers.USPS.Key,
-					conf.Carriers.USPS.Secret,
-				)
-			default:
-				log.Fatalf("unsupported carrier: %v\n", carrier)
+			svc, err := configuredService(client, carrier)
+			if err != nil {
+				log.Warnf("unsupported carrier %v for %v", carrier, trackingNumbers)
+				unsupported = append(unsupported, unsupportedParcels(carrier, trackingNumbers)...)
+				continue
 			}
 
 			wg.Add(1)
-			go func() {
+			go func(carrier envoy.Carrier, svc envoy.Service, trackingNumbers []string) {
 				defer wg.Done()
 				parcels, err := svc.Track(trackingNumbers)
 				if err != nil {
-					log.Infof("error tracking parcels: %+v\n", err)
+					log.Warnf("could not track %s parcels: %v", carrier, err)
+					parcels = failedParcels(carrier, trackingNumbers, err)
 				}
+				mu.Lock()
+				defer mu.Unlock()
 				for _, p := range parcels {
-					if e := p.LastTrackingEvent(); e != nil {
+					existing, ok := allParcels[p.TrackingNumber]
+					switch {
+					case p.LastTrackingEvent() != nil:
+						if ok && existing.HasData() {
+							existing.Merge(p)
+						} else {
+							allParcels[p.TrackingNumber] = p
+						}
+					case p.HasError() && !ok:
+						// Shown as failed, unless another carrier tracked it
 						allParcels[p.TrackingNumber] = p
 					}
 				}
-			}()
+			}(carrier, svc, trackingNumbers)
 		}
 
 		wg.Wait()
+		for _, p := range unsupported {
+			allParcels[p.TrackingNumber] = p
+		}
 		return fetchMsg{parcels: allParcels}
 	}
 }
 
-func makeParcelsTable(parcels []*envoy.Parcel) table.Model {
-	columns := []table.Column{
-		{Title: "PARCEL NAME", Width: 16},
-		{Title: "CARRIER", Width: 8},
-		{Title: "TRACKING NO.", Width: 16},
-		{Title: "STATUS", Width: 16},
-		{Title: "DATE", Width: 28},
+func makeParcelsTable(parcels []*envoy.Parcel, parcelColumns []parcelColumn) table.Model {
+	columns := make([]table.Column, 0, len(parcelColumns))
+	for _, c := range parcelColumns {
+		columns = append(columns, c.tableColumn())
 	}
 
 	var rows []table.Row
 	for _, p := range parcels {
-		if p.HasError() {
-			rows = append(rows, table.Row{
-				formatEventIcon(p.LastTrackingEvent()) + " " + p.Name,
-				string(p.Carrier),
-				p.TrackingNumber,
-				errorStyle.Render(p.Error.Error()),
-				time.Now().Format(timeFormat),
-			})
-			continue
-		}
-
 		if p.Name == "" {
 			p.Name = p.TrackingNumber
 		}
-		name := p.Name
-		status := strings.ToUpper(p.LastTrackingEvent().Description)
-		// TODO: figure out conditional styling per cell
-		// if p.Data.Delivered {
-		// 	status = successStyle.Inline(true).Render(status)
-		// }
-		rows = append(rows, table.Row{
-			name,
-			string(p.Carrier),
-			p.TrackingNumber,
-			status,
-			p.LastTrackingEvent().Timestamp.Format(timeFormat),
-		})
+		row := make(table.Row, 0, len(parcelColumns))
+		for _, c := range parcelColumns {
+			row = append(row, c.cell(p))
+		}
+		rows = append(rows, row)
 	}
 
 	return table.New(
@@ -297,14 +530,7 @@ func makeEventsTable(parcels []*envoy.Parcel) table.Model {
 	}
 	var eRows []table.Row
 	if len(parcels) > 0 {
-		for _, p := range parcels[0].Data.Events {
-			eRows = append(eRows, table.Row{
-				string(p.Type),
-				p.Location,
-				p.Timestamp.Format(timeFormat),
-				p.Description,
-			})
-		}
+		eRows = eventRows(parcels[0], collapseTimeline)
 	}
 
 	s2 := tableWithInctiveSelectedStyle
@@ -318,45 +544,237 @@ func makeEventsTable(parcels []*envoy.Parcel) table.Model {
 }
 
 func initialModel(groups map[envoy.Carrier][]string) model {
-	client := http.Client{
-		Timeout: 10 * time.Second,
-	}
+	client := newHTTPClient(10 * time.Second)
 
 	allParcels, err := fetchParcels()
 	if err != nil {
 		log.Fatalf("error fetching parcels: %v\n", err)
 	}
-	slices.SortStableFunc(allParcels, func(a, b *envoy.Parcel) int {
-		aTime := func() time.Time {
-			if e := a.LastTrackingEvent(); e != nil {
-				return e.Timestamp
-			} else {
-				return time.Time{}
-			}
-		}()
-		bTime := func() time.Time {
-			if e := b.LastTrackingEvent(); e != nil {
-				return e.Timestamp
-			} else {
-				return time.Time{}
-			}
-		}()
-
-		return bTime.Compare(aTime)
-	})
+	slices.SortStableFunc(allParcels, compareParcels(defaultParcelSort))
 
 	parcelsMap := make(map[string]*envoy.Parcel)
+	parcelIDs := make([]string, 0, len(allParcels))
 	for _, p := range allParcels {
 		parcelsMap[p.TrackingNumber] = p
+		parcelIDs = append(parcelIDs, p.TrackingNumber)
+	}
+
+	columns, err := resolveParcelColumns(conf.TUI.Columns)
+	if err != nil {
+		log.Fatalf("invalid tui.columns: %v\n", err)
+	}
+	var refreshInterval time.Duration
+	if conf.TUI.RefreshInterval > 0 {
+		refreshInterval = enforcePollInterval(conf.TUI.RefreshInterval, conf.MinPollInterval)
 	}
 
-	return model{
-		client:       &client,
+	m := model{
+		client:       client,
 		parcels:      parcelsMap,
-		parcelsTable: makeParcelsTable(allParcels),
+		parcelIDs:    parcelIDs,
+		parcelsTable: makeParcelsTable(allParcels, columns),
+		columns:      columns,
+		sort:         defaultParcelSort,
 		eventsTable:  makeEventsTable(allParcels),
 		currentView:  viewParcels,
+		collapsed:    collapseTimeline,
+		// The first fetch is started by Init
+		refreshing:      true,
+		refreshInterval: refreshInterval,
+	}
+	m.refreshParcelColumns()
+	return m
+}
+
+// Returns the parcel under the cursor in the parcels table, if any
+func (m *model) selectedParcel() *envoy.Parcel {
+	parcels := m.visibleParcels()
+	i := m.parcelsTable.Cursor()
+	if i < 0 || i >= len(parcels) {
+		return nil
+	}
+	return parcels[i]
+}
+
+// Rebuild the rows of the parcels table from the parcels matching the filter
+func (m *model) refreshParcelRows() {
+	m.parcelsTable.SetRows(makeParcelsTable(m.visibleParcels(), m.columns).Rows())
+}
+
+// Narrow the parcels table to those matching a query, keeping the cursor on
+// the selected parcel while it still matches
+func (m *model) setFilter(query string) {
+	selected := m.selectedParcel()
+	m.filter = query
+	m.refreshParcelRows()
+	m.parcelsTable.SetCursor(max(slices.Index(m.visibleParcels(), selected), 0))
+	m.refreshEventRows()
+}
+
+// Sort the parcels table, keeping the cursor on the selected parcel
+func (m *model) setSort(s parcelSort) {
+	m.sort = s
+	m.sortParcels()
+	m.refreshParcelColumns()
+	m.refreshEventRows()
+}
+
+// Reorder the parcels and rebuild the rows of the parcels table by the current
+// sort, keeping the cursor on the selected parcel
+func (m *model) sortParcels() {
+	selected := m.selectedParcel()
+	parcels := m.allParcels()
+	slices.SortStableFunc(parcels, compareParcels(m.sort))
+
+	m.parcelIDs = m.parcelIDs[:0]
+	for _, p := range parcels {
+		m.parcelIDs = append(m.parcelIDs, p.TrackingNumber)
+	}
+	m.refreshParcelRows()
+	if selected != nil {
+		if i := slices.Index(m.visibleParcels(), selected); i >= 0 {
+			m.parcelsTable.SetCursor(i)
+		}
+	}
+}
+
+// Mark the sorted column in the header of the parcels table, keeping the
+// widths set when the window was resized
+func (m *model) refreshParcelColumns() {
+	cols := m.parcelsTable.Columns()
+	for i, c := range m.columns {
+		if i < len(cols) {
+			cols[i].Title = c.sortedTitle(m.sort)
+		}
+	}
+	m.parcelsTable.SetColumns(cols)
+}
+
+// Rebuild the rows of the events table from the selected parcel
+func (m *model) refreshEventRows() {
+	parcel := m.selectedParcel()
+	if parcel == nil || !parcel.HasData() {
+		m.eventsTable.SetRows(nil)
+		return
+	}
+
+	m.eventsTable.SetRows(eventRows(parcel, m.collapsed))
+}
+
+// Returns the rows of the events table for a parcel. When collapsed,
+// consecutive events at the same location share a row noting their count and
+// time range.
+func eventRows(parcel *envoy.Parcel, collapse bool) []table.Row {
+	if !parcel.HasData() {
+		return nil
+	}
+
+	var rows []table.Row
+	if !collapse {
+		for _, e := range parcel.Data.Events {
+			rows = append(rows, table.Row{
+				formatHighlighted(e.Type, formatEventType(&e)),
+				e.Location,
+				formatTimestamp(e.Timestamp),
+				formatEventNotes(parcel, &e),
+			})
+		}
+		return rows
+	}
+
+	for _, g := range envoy.CollapseTimeline(parcel.Timeline()) {
+		e := &g.Last().Event
+		notes := formatEventNotes(parcel, e)
+		if len(g.Nodes) > 1 {
+			notes = fmt.Sprintf("%d events, %s: %s", len(g.Nodes), formatEventGroupRange(&g), notes)
+		}
+		rows = append(rows, table.Row{
+			formatHighlighted(e.Type, formatEventType(e)),
+			e.Location,
+			formatTimestamp(e.Timestamp),
+			notes,
+		})
+	}
+	return rows
+}
+
+// Delete a parcel from the database and the parcels table. Parcels which were
+// never stored, such as those with unsupported carriers, are only removed
+// from the table.
+func (m *model) removeParcel(p *envoy.Parcel) {
+	if err := deleteParcel(p); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		log.Warnf("could not delete %s: %v", p.TrackingNumber, err)
+		return
+	}
+
+	delete(m.parcels, p.TrackingNumber)
+	m.parcelIDs = slices.DeleteFunc(m.parcelIDs, func(id string) bool {
+		return id == p.TrackingNumber
+	})
+	m.refreshParcelRows()
+	if last := len(m.visibleParcels()) - 1; m.parcelsTable.Cursor() > last {
+		m.parcelsTable.SetCursor(max(last, 0))
+	}
+	m.refreshEventRows()
+}
+
+// Returns all parcels in the order they appear in the parcels table
+func (m *model) allParcels() []*envoy.Parcel {
+	parcels := make([]*envoy.Parcel, 0, len(m.parcelIDs))
+	for _, id := range m.parcelIDs {
+		if p, ok := m.parcels[id]; ok {
+			parcels = append(parcels, p)
+		}
+	}
+	return parcels
+}
+
+// Open the tracking pages of the parcels shown in the table with a status,
+// asking for confirmation first if there are many
+func (m *model) openTrackingURLs(f statusFilter) {
+	urls := selectTrackingURLs(m.visibleParcels(), f, nil)
+	if len(urls) > openConfirmThreshold {
+		m.pendingOpen = urls
+		return
+	}
+	for _, u := range urls {
+		openURL(u)
+	}
+}
+
+// Returns the index of the column at an offset into the table, including cell
+// padding, or -1 when there is none
+func columnAt(cols []table.Column, x int) int {
+	if x < 0 {
+		return -1
+	}
+	for i, c := range cols {
+		x -= c.Width + 2
+		if x < 0 {
+			return i
+		}
 	}
+	return -1
+}
+
+// Returns the parcels shown in the parcels table, in order
+func (m *model) visibleParcels() []*envoy.Parcel {
+	parcels := m.allParcels()
+	if m.filter == "" {
+		return parcels
+	}
+	return slices.DeleteFunc(parcels, func(p *envoy.Parcel) bool {
+		return !matchesQuery(p, m.filter)
+	})
+}
+
+// Returns the total width taken by all but the last column, including cell padding
+func fixedColumnsWidth(cols []table.Column) int {
+	width := 2 * len(cols)
+	for _, c := range cols[:len(cols)-1] {
+		width += c.Width
+	}
+	return width
 }
 
 func (m *model) toggleView() tea.Cmd {
@@ -383,3 +801,11 @@ func (m *model) setEventsView() tea.Cmd {
 	m.eventsTable.Focus()
 	return nil
 }
+
+// Returns the size of the raw JSON or notices viewport for a window, leaving
+// room for the border and footer. The border of baseStyle is implicit, so is
+// not counted by its frame size.
+func detailViewSize(width, height int) (int, int) {
+	w, h := baseStyle.Border(lipgloss.NormalBorder()).GetFrameSize()
+	return max(width-w, 0), max(height-h-1, 0)
+}
@@ -0,0 +1,911 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	zone "github.com/lrstanley/bubblezone"
+	"github.com/mattn/go-runewidth"
+	"github.com/muesli/termenv"
+	"go.uber.org/zap"
+
+	"github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/fedex"
+)
+
+func TestMakeParcelsTableWithColumns(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+	// The ETA column counts down from the current time
+	eta := time.Now().Add(48 * time.Hour)
+
+	parcel := &envoy.Parcel{
+		Name:           "New shoes",
+		Carrier:        envoy.CarrierFedEx,
+		TrackingNumber: "441259201412",
+		Data: &envoy.ParcelData{
+			Events: []envoy.ParcelEvent{
+				{
+					Timestamp:   timeNow,
+					Description: "Package arrived at FedEx location",
+					Location:    "LOS ANGELES, CA",
+					Type:        envoy.ParcelEventTypeArrived,
+				},
+			},
+			DeliveryProjection: &eta,
+		},
+	}
+
+	columns, err := resolveParcelColumns([]string{"name", "carrier", "status", "eta"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tbl := makeParcelsTable([]*envoy.Parcel{parcel}, columns)
+
+	expectedTitles := []string{"PARCEL NAME", "CARRIER", "STATUS", "ETA"}
+	cols := tbl.Columns()
+	if len(cols) != len(expectedTitles) {
+		t.Fatalf("Expected %d columns, got %d", len(expectedTitles), len(cols))
+	}
+	for i, title := range expectedTitles {
+		if cols[i].Title != title {
+			t.Errorf("Expected column %d to be %s, got %s", i, title, cols[i].Title)
+		}
+	}
+
+	expectedRow := []string{"New shoes", "FedEx", "PACKAGE ARRIVED AT FEDEX LOCATION", eta.Format("Mon, Jan 02") + " (in 2 days)"}
+	rows := tbl.Rows()
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(rows))
+	}
+	for i, cell := range expectedRow {
+		if rows[0][i] != cell {
+			t.Errorf("Expected cell %d to be %s, got %s", i, cell, rows[0][i])
+		}
+	}
+
+	if _, err := resolveParcelColumns([]string{"name", "bogus"}); err == nil {
+		t.Error("Expected an error for an unknown column")
+	}
+}
+
+func TestFetchMsgWithUnsupportedCarrier(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	columns, err := resolveParcelColumns([]string{"name", "carrier", "status"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := model{
+		parcels:      make(map[string]*envoy.Parcel),
+		parcelsTable: makeParcelsTable(nil, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+	}
+
+	supported := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	supported.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: timeNow}},
+	}
+	unsupported := unsupportedParcels(envoy.CarrierUnknown, []string{"NOTATRACKINGNUMBER"})
+
+	updated, _ := m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{
+		supported.TrackingNumber:      supported,
+		unsupported[0].TrackingNumber: unsupported[0],
+	}})
+	m = updated.(model)
+
+	rows := m.parcelsTable.Rows()
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d: %v", len(rows), rows)
+	}
+	var sawSupported, sawUnsupported bool
+	for _, row := range rows {
+		switch {
+		case row[0] == "New shoes" && row[2] == "IN TRANSIT":
+			sawSupported = true
+		case strings.Contains(row[0], "NOTATRACKINGNUMBER") && strings.Contains(row[2], "unsupported carrier"):
+			sawUnsupported = true
+		}
+	}
+	if !sawSupported {
+		t.Errorf("Expected supported parcel to be tracked, got %v", rows)
+	}
+	if !sawUnsupported {
+		t.Errorf("Expected unsupported parcel to be shown as an error, got %v", rows)
+	}
+	if !errors.Is(unsupported[0].Error, errUnsupportedCarrier) {
+		t.Errorf("Expected errUnsupportedCarrier, got %v", unsupported[0].Error)
+	}
+}
+
+func TestOpenTrackingURLsOfFilteredRows(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	var opened []string
+	defer func(f func(string) error) { openURL = f }(openURL)
+	openURL = func(u string) error {
+		opened = append(opened, u)
+		return nil
+	}
+
+	columns, err := resolveParcelColumns([]string{"name", "carrier", "status"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := model{
+		parcels:      make(map[string]*envoy.Parcel),
+		parcelsTable: makeParcelsTable(nil, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+	}
+
+	delivered := func(name string, carrier envoy.Carrier, tn, url string) *envoy.Parcel {
+		p := envoy.NewParcel(name, carrier, tn, url)
+		p.Data = &envoy.ParcelData{
+			Events:    []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: timeNow}},
+			Delivered: true,
+		}
+		return p
+	}
+	shoes := delivered("Shoes", envoy.CarrierFedEx, "441259201412", "https://fedex.test/shoes")
+	books := delivered("Books", envoy.CarrierUPS, "1Z5R89390357567127", "https://ups.test/books")
+	lamp := envoy.NewParcel("Lamp", envoy.CarrierFedEx, "271163815799", "https://fedex.test/lamp")
+	lamp.Error = errors.New("unexpected status code: 404")
+
+	updated, _ := m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{
+		shoes.TrackingNumber: shoes,
+		books.TrackingNumber: books,
+		lamp.TrackingNumber:  lamp,
+	}})
+	m = updated.(model)
+	m.setFilter("fedex")
+
+	send := func(keys ...string) {
+		for _, k := range keys {
+			updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+			m = updated.(model)
+		}
+	}
+
+	tests := []struct {
+		keys []string
+		want []string
+	}{
+		{[]string{"O", "d"}, []string{"https://fedex.test/shoes"}},
+		{[]string{"O", "e"}, []string{"https://fedex.test/lamp"}},
+		{[]string{"O", "x"}, nil},
+	}
+	for _, tt := range tests {
+		opened = nil
+		send(tt.keys...)
+		slices.Sort(opened)
+		if !slices.Equal(opened, tt.want) {
+			t.Errorf("After %v, expected %v opened, got %v", tt.keys, tt.want, opened)
+		}
+		if m.choosingOpenStatus {
+			t.Errorf("Expected the status prompt to close after %v", tt.keys)
+		}
+	}
+}
+
+func TestNoticesView(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	columns, err := resolveParcelColumns([]string{"name", "notices", "status"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := model{
+		parcels:      make(map[string]*envoy.Parcel),
+		parcelsTable: makeParcelsTable(nil, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+		width:        60,
+		height:       30,
+	}
+
+	parcel := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	parcel.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelayed, Description: "Delay", Timestamp: timeNow}},
+		Notices: []envoy.ParcelNotice{
+			{Code: "WEATHER_DELAY", Message: "A local weather event has delayed delivery of this package by one business day."},
+			{Code: "HELD_PACKAGE", Message: "Your package is available for pickup at a nearby FedEx location."},
+			{Message: "Signature required."},
+		},
+	}
+
+	updated, _ := m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{parcel.TrackingNumber: parcel}})
+	m = updated.(model)
+	if cell := m.parcelsTable.Rows()[0][1]; !strings.Contains(cell, "!3") {
+		t.Errorf("Expected notices column to count 3 notices, got %q", cell)
+	}
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("N")})
+	m = updated.(model)
+	if m.detailView == nil {
+		t.Fatal("Expected notices view to open")
+	}
+
+	view := m.View()
+	for _, want := range []string{"WEATHER_DELAY", "HELD_PACKAGE", "NOTICE", "one business day", "Signature required."} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected notices view to contain %q, got:\n%s", want, view)
+		}
+	}
+	for _, line := range strings.Split(view, "\n") {
+		if w := lipgloss.Width(line); w > m.width {
+			t.Errorf("Expected lines to fit within %d columns, got %d: %q", m.width, w, line)
+		}
+	}
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if updated.(model).detailView != nil {
+		t.Error("Expected esc to close the notices view")
+	}
+}
+
+func TestDeleteSelectedParcel(t *testing.T) {
+	openTestDB(t)
+	zone.NewGlobal()
+
+	columns, err := resolveParcelColumns([]string{"name", "carrier", "status"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	shoes := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	lamp := envoy.NewParcel("Lamp", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	for _, p := range []*envoy.Parcel{shoes, lamp} {
+		p.Data = &envoy.ParcelData{}
+		if err := upsertParcel(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := model{
+		parcels:      map[string]*envoy.Parcel{shoes.TrackingNumber: shoes, lamp.TrackingNumber: lamp},
+		parcelIDs:    []string{shoes.TrackingNumber, lamp.TrackingNumber},
+		parcelsTable: makeParcelsTable([]*envoy.Parcel{shoes, lamp}, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+	}
+
+	press := func(k string) {
+		t.Helper()
+		updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+		m = updated.(model)
+	}
+
+	press("x")
+	if m.pendingDelete != shoes {
+		t.Fatalf("Expected deletion of the selected parcel to await confirmation, got %+v", m.pendingDelete)
+	}
+	if view := m.View(); !strings.Contains(view, "Delete New shoes? (y/N)") {
+		t.Errorf("Expected a confirmation prompt, got:\n%s", view)
+	}
+
+	press("n")
+	if m.pendingDelete != nil || len(m.parcels) != 2 {
+		t.Fatalf("Expected declining to keep the parcel, got %d parcels", len(m.parcels))
+	}
+
+	press("x")
+	press("y")
+	if _, ok := m.parcels[shoes.TrackingNumber]; ok || len(m.parcelIDs) != 1 {
+		t.Errorf("Expected parcel to be removed from the model, got %v", m.parcelIDs)
+	}
+	if rows := m.parcelsTable.Rows(); len(rows) != 1 || rows[0][0] != "Lamp" {
+		t.Errorf("Expected only the remaining parcel in the table, got %v", rows)
+	}
+	if p, err := getParcel(shoes.TrackingNumber); err != nil || p != nil {
+		t.Errorf("Expected parcel to be deleted from the database, got %+v, %v", p, err)
+	}
+
+	press("x")
+	press("y")
+	if len(m.parcels) != 0 || len(m.parcelsTable.Rows()) != 0 {
+		t.Errorf("Expected no parcels left, got %v", m.parcelIDs)
+	}
+	press("x")
+	if m.pendingDelete != nil {
+		t.Error("Expected no deletion prompt with an empty selection")
+	}
+}
+
+func TestRefreshParcels(t *testing.T) {
+	openTestDB(t)
+	zone.NewGlobal()
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	columns, err := resolveParcelColumns([]string{"name", "carrier", "status"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	stored.AddTags("personal")
+	m := model{
+		parcels:         map[string]*envoy.Parcel{stored.TrackingNumber: stored},
+		parcelIDs:       []string{stored.TrackingNumber},
+		parcelsTable:    makeParcelsTable([]*envoy.Parcel{stored}, columns),
+		eventsTable:     makeEventsTable(nil),
+		columns:         columns,
+		refreshInterval: time.Minute,
+		refreshing:      true,
+	}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	m = updated.(model)
+	if cmd != nil && cmd() != nil {
+		t.Error("Expected no second fetch while one is in flight")
+	}
+	if !strings.Contains(m.View(), "refreshing…") {
+		t.Error("Expected a refreshing indicator while fetching")
+	}
+
+	fetched := envoy.NewParcel(stored.TrackingNumber, envoy.CarrierFedEx, stored.TrackingNumber, "")
+	fetched.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: timeNow}},
+	}
+	updated, _ = m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{fetched.TrackingNumber: fetched}})
+	m = updated.(model)
+	if m.refreshing || strings.Contains(m.View(), "refreshing…") {
+		t.Error("Expected the refreshing indicator to clear after fetching")
+	}
+	if p := m.parcels[stored.TrackingNumber]; p.Name != "New shoes" || !p.HasTag("personal") || !p.HasData() {
+		t.Errorf("Expected fetched data merged into the loaded parcel, got %+v", p)
+	}
+	if rows := m.parcelsTable.Rows(); len(rows) != 1 || rows[0][2] != "IN TRANSIT" {
+		t.Errorf("Expected refreshed status in the table, got %v", rows)
+	}
+
+	updated, cmd = m.Update(refreshTickMsg{})
+	m = updated.(model)
+	if !m.refreshing || cmd == nil {
+		t.Error("Expected an automatic refresh to start a fetch")
+	}
+}
+
+func TestEditNote(t *testing.T) {
+	openTestDB(t)
+	zone.NewGlobal()
+
+	columns, err := resolveParcelColumns([]string{"name", "status"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parcel := envoy.NewParcel("Scarf", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	parcel.Note = "gift"
+	m := model{
+		parcels:      map[string]*envoy.Parcel{parcel.TrackingNumber: parcel},
+		parcelIDs:    []string{parcel.TrackingNumber},
+		parcelsTable: makeParcelsTable([]*envoy.Parcel{parcel}, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+	}
+
+	send := func(msg tea.Msg) {
+		t.Helper()
+		updated, _ := m.Update(msg)
+		m = updated.(model)
+	}
+
+	send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	if m.noteParcel != parcel {
+		t.Fatal("Expected the note editor to open for the selected parcel")
+	}
+	// Keys are typed into the note rather than handled by the table
+	send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(" for mom, don't spoil")})
+	if !strings.Contains(m.View(), "Note: gift for mom, don't spoil") {
+		t.Errorf("Expected the note being edited in the footer, got:\n%s", m.View())
+	}
+	send(tea.KeyMsg{Type: tea.KeyEnter})
+
+	if m.noteParcel != nil {
+		t.Error("Expected enter to close the note editor")
+	}
+	if parcel.Note != "gift for mom, don't spoil" {
+		t.Errorf("Expected the note to be updated, got %q", parcel.Note)
+	}
+	if stored, err := getParcel(parcel.TrackingNumber); err != nil || stored == nil || stored.Note != parcel.Note {
+		t.Errorf("Expected the note to be stored, got %+v, %v", stored, err)
+	}
+	if notices := formatNotices(parcel, 0); !strings.Contains(notices, "gift for mom, don't spoil") {
+		t.Errorf("Expected the note in the notices view, got %q", notices)
+	}
+
+	send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("!")})
+	send(tea.KeyMsg{Type: tea.KeyEsc})
+	if m.noteParcel != nil || parcel.Note != "gift for mom, don't spoil" {
+		t.Errorf("Expected esc to discard the edit, got %q", parcel.Note)
+	}
+}
+
+// fakeService tracks parcels without a carrier API, after every fake sharing
+// the start group has been asked to track, so that they run concurrently
+type fakeService struct {
+	carrier envoy.Carrier
+	start   *sync.WaitGroup
+	tracked []string
+}
+
+func (s *fakeService) Track(trackingNumbers []string) ([]*envoy.Parcel, error) {
+	s.tracked = trackingNumbers
+	s.start.Done()
+	s.start.Wait()
+
+	parcels := make([]*envoy.Parcel, 0, len(trackingNumbers))
+	for _, tn := range trackingNumbers {
+		p := envoy.NewParcel(tn, s.carrier, tn, "")
+		p.Data = &envoy.ParcelData{
+			Events: []envoy.ParcelEvent{{
+				Type:          envoy.ParcelEventTypeInTransit,
+				Description:   fmt.Sprintf("In transit with %s", s.carrier),
+				Timestamp:     time.Now(),
+				SourceCarrier: s.carrier,
+			}},
+		}
+		parcels = append(parcels, p)
+	}
+	return parcels, nil
+}
+
+func (s *fakeService) Reauthenticate() error {
+	return nil
+}
+
+// Run with -race to detect unsynchronized writes to the fetched parcels
+func TestInitParcelsConcurrentCarriers(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	var start sync.WaitGroup
+	start.Add(2)
+	defer func(f func(*http.Client, envoy.Carrier) (envoy.Service, error)) { configuredService = f }(configuredService)
+	configuredService = func(_ *http.Client, carrier envoy.Carrier) (envoy.Service, error) {
+		return &fakeService{carrier: carrier, start: &start}, nil
+	}
+
+	groups := map[envoy.Carrier][]string{envoy.CarrierFedEx: {}, envoy.CarrierUPS: {}}
+	for i := range 50 {
+		groups[envoy.CarrierFedEx] = append(groups[envoy.CarrierFedEx], fmt.Sprintf("FEDEX%03d", i))
+		groups[envoy.CarrierUPS] = append(groups[envoy.CarrierUPS], fmt.Sprintf("UPS%03d", i))
+	}
+	// Reported by both carriers, as after a handoff
+	groups[envoy.CarrierFedEx] = append(groups[envoy.CarrierFedEx], "SHARED")
+	groups[envoy.CarrierUPS] = append(groups[envoy.CarrierUPS], "SHARED")
+
+	msg := initParcels(&http.Client{}, groups)().(fetchMsg)
+	if len(msg.parcels) != 101 {
+		t.Errorf("Expected 101 parcels, got %d", len(msg.parcels))
+	}
+	if shared := msg.parcels["SHARED"]; shared == nil || len(shared.Data.Events) != 2 {
+		t.Errorf("Expected the shared parcel to merge both carriers' events, got %+v", shared)
+	}
+}
+
+func TestInitParcelsTracksEachCarriersNumbers(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	groups := map[envoy.Carrier][]string{
+		envoy.CarrierFedEx: {"FEDEX001", "FEDEX002"},
+		envoy.CarrierUPS:   {"UPS001"},
+		envoy.CarrierUSPS:  {"USPS001", "USPS002", "USPS003"},
+	}
+
+	var start sync.WaitGroup
+	start.Add(len(groups))
+	services := make(map[envoy.Carrier]*fakeService)
+	defer func(f func(*http.Client, envoy.Carrier) (envoy.Service, error)) { configuredService = f }(configuredService)
+	configuredService = func(_ *http.Client, carrier envoy.Carrier) (envoy.Service, error) {
+		svc := &fakeService{carrier: carrier, start: &start}
+		services[carrier] = svc
+		return svc, nil
+	}
+
+	initParcels(&http.Client{}, groups)()
+	for carrier, trackingNumbers := range groups {
+		svc, ok := services[carrier]
+		if !ok {
+			t.Errorf("Expected a service for %s", carrier)
+			continue
+		}
+		if !slices.Equal(svc.tracked, trackingNumbers) {
+			t.Errorf("Expected %s to track %v, got %v", carrier, trackingNumbers, svc.tracked)
+		}
+	}
+}
+
+func TestInitParcelsKeepsFailedParcels(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	var start sync.WaitGroup
+	start.Add(1)
+	defer func(f func(*http.Client, envoy.Carrier) (envoy.Service, error)) { configuredService = f }(configuredService)
+	configuredService = func(_ *http.Client, carrier envoy.Carrier) (envoy.Service, error) {
+		if carrier == envoy.CarrierFedEx {
+			return failingService{}, nil
+		}
+		return &fakeService{carrier: carrier, start: &start}, nil
+	}
+
+	groups := map[envoy.Carrier][]string{
+		envoy.CarrierFedEx: {"FEDEX001", "SHARED"},
+		envoy.CarrierUPS:   {"UPS001", "SHARED"},
+	}
+	msg := initParcels(&http.Client{}, groups)().(fetchMsg)
+
+	if p := msg.parcels["FEDEX001"]; p == nil || !p.HasError() {
+		t.Errorf("Expected the FedEx parcel to be reported as failed, got %+v", p)
+	}
+	if p := msg.parcels["UPS001"]; p == nil || p.LastTrackingEvent() == nil {
+		t.Errorf("Expected the UPS parcel to be tracked, got %+v", p)
+	}
+	if p := msg.parcels["SHARED"]; p == nil || p.HasError() || p.LastTrackingEvent() == nil {
+		t.Errorf("Expected the shared parcel to keep the UPS tracking, got %+v", p)
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	p := envoy.NewParcel("New shoes", envoy.CarrierUPS, "1Z999AA10123456784", "")
+	p.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypePickedUp, Timestamp: timeNow},
+			{Type: envoy.ParcelEventTypeOutForDelivery, Timestamp: timeNow.Add(time.Hour)},
+			{Type: envoy.ParcelEventTypeUndeliverable, Timestamp: timeNow.Add(2 * time.Hour)},
+		},
+	}
+
+	progress := formatProgress(p)
+	for _, want := range []string{"● Label Created", "● Picked Up", "● In Transit", "✕ Out for Delivery", "○ Delivered"} {
+		if !strings.Contains(progress, want) {
+			t.Errorf("Expected %q in the progress bar, got %q", want, progress)
+		}
+	}
+}
+
+func TestFedExExceptionReasonNotice(t *testing.T) {
+	fixture, err := os.ReadFile("../../pkg/fedex/testdata/exception.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(fixture)
+	}))
+	defer srv.Close()
+	defer func(u *url.URL) { fedex.BaseURL = u }(fedex.BaseURL)
+	fedex.BaseURL, _ = url.Parse(srv.URL)
+
+	svc := fedex.NewFedexService(srv.Client(), "key", "secret")
+	svc.Token = &fedex.Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+	parcels, err := svc.Track([]string{"794843185304"})
+	if err != nil || len(parcels) != 1 {
+		t.Fatalf("Expected one parcel, got %v, %v", parcels, err)
+	}
+
+	notices := formatNotices(parcels[0], 0)
+	for _, want := range []string{"Customer not available or business closed", "Refused by recipient"} {
+		if !strings.Contains(notices, want) {
+			t.Errorf("Expected %q in the notices view, got:\n%s", want, notices)
+		}
+	}
+}
+
+func TestCollapseEventsToggle(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	columns, err := resolveParcelColumns([]string{"name", "status"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := model{
+		parcels:      make(map[string]*envoy.Parcel),
+		parcelsTable: makeParcelsTable(nil, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+	}
+
+	parcel := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	parcel.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypeArrived, Description: "Arrived", Location: "MEMPHIS, TN", Timestamp: timeNow},
+			{Type: envoy.ParcelEventTypeInTransit, Description: "Processing", Location: "MEMPHIS, TN", Timestamp: timeNow.Add(time.Hour)},
+			{Type: envoy.ParcelEventTypeDeparted, Description: "Departed", Location: "MEMPHIS, TN", Timestamp: timeNow.Add(2 * time.Hour)},
+			{Type: envoy.ParcelEventTypeArrived, Description: "Arrived", Location: "DENVER, CO", Timestamp: timeNow.Add(20 * time.Hour)},
+		},
+	}
+
+	updated, _ := m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{parcel.TrackingNumber: parcel}})
+	m = updated.(model)
+	if n := len(m.eventsTable.Rows()); n != 4 {
+		t.Fatalf("Expected a row per event, got %d", n)
+	}
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	m = updated.(model)
+	rows := m.eventsTable.Rows()
+	if len(rows) != 2 {
+		t.Fatalf("Expected a row per location when collapsed, got %d", len(rows))
+	}
+	if notes := rows[0][3]; !strings.Contains(notes, "3 events") || !strings.Contains(notes, "Departed") {
+		t.Errorf("Expected the collapsed row to note the count and latest event, got %q", notes)
+	}
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	if n := len(updated.(model).eventsTable.Rows()); n != 4 {
+		t.Errorf("Expected the toggle to expand the events again, got %d rows", n)
+	}
+	if n := len(parcel.Data.Events); n != 4 {
+		t.Errorf("Expected collapsing to leave the events intact, got %d", n)
+	}
+}
+
+func TestTrackingGroupsUseStoredCarrier(t *testing.T) {
+	defer func(c envoy.Carrier) { carrierOverride = c }(carrierOverride)
+	carrierOverride = envoy.CarrierDHL
+
+	shoes := envoy.NewParcel("Shoes", envoy.CarrierUPS, "441259201412", "")
+	lamp := envoy.NewParcel("Lamp", envoy.CarrierUnknown, "1ZW701150378674373", "")
+	m := model{
+		parcels:   map[string]*envoy.Parcel{shoes.TrackingNumber: shoes, lamp.TrackingNumber: lamp},
+		parcelIDs: []string{shoes.TrackingNumber, lamp.TrackingNumber},
+	}
+
+	groups := m.trackingGroups()
+	want := []string{shoes.TrackingNumber, lamp.TrackingNumber}
+	if len(groups) != 1 || !slices.Equal(groups[envoy.CarrierUPS], want) {
+		t.Errorf("Expected stored parcels grouped by stored or detected carrier, got %v", groups)
+	}
+}
+
+func TestCompareParcels(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	withEvent := func(name string, carrier envoy.Carrier, trackingNumber, description string, age time.Duration) *envoy.Parcel {
+		p := envoy.NewParcel(name, carrier, trackingNumber, "")
+		p.Data = &envoy.ParcelData{
+			Events: []envoy.ParcelEvent{{Description: description, Timestamp: timeNow.Add(-age)}},
+		}
+		return p
+	}
+	shoes := withEvent("shoes", envoy.CarrierUPS, "1ZW701150378674373", "Out for delivery", 2*time.Hour)
+	lamp := withEvent("Lamp", envoy.CarrierFedEx, "441259201412", "In transit", time.Hour)
+	book := withEvent("Book", envoy.CarrierUSPS, "9400123456789012345674", "Delivered", 3*time.Hour)
+	// A parcel which was never tracked sorts as the oldest
+	desk := envoy.NewParcel("Desk", envoy.CarrierDHL, "7777777770", "")
+	// Ties are broken by tracking number
+	chair := withEvent("Chair", envoy.CarrierFedEx, "441259201400", "In transit", time.Hour)
+
+	tests := []struct {
+		sort parcelSort
+		want []*envoy.Parcel
+	}{
+		{defaultParcelSort, []*envoy.Parcel{chair, lamp, shoes, book, desk}},
+		{parcelSort{key: "date"}, []*envoy.Parcel{desk, book, shoes, chair, lamp}},
+		{parcelSort{key: "name"}, []*envoy.Parcel{book, chair, desk, lamp, shoes}},
+		{parcelSort{key: "name", desc: true}, []*envoy.Parcel{shoes, lamp, desk, chair, book}},
+		{parcelSort{key: "carrier"}, []*envoy.Parcel{desk, chair, lamp, shoes, book}},
+		{parcelSort{key: "status"}, []*envoy.Parcel{desk, book, chair, lamp, shoes}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s desc=%v", tt.sort.key, tt.sort.desc), func(t *testing.T) {
+			parcels := []*envoy.Parcel{desk, shoes, book, lamp, chair}
+			slices.SortStableFunc(parcels, compareParcels(tt.sort))
+			for i, p := range parcels {
+				if p != tt.want[i] {
+					t.Errorf("Expected %s at %d, got %s", tt.want[i].Name, i, p.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestSortParcelsTable(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	columns, err := resolveParcelColumns([]string{"name", "notices", "date"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := model{
+		parcels:      make(map[string]*envoy.Parcel),
+		parcelsTable: makeParcelsTable(nil, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+		sort:         defaultParcelSort,
+	}
+
+	older := envoy.NewParcel("Apples", envoy.CarrierFedEx, "441259201412", "")
+	older.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{{Description: "In transit", Timestamp: timeNow}}}
+	newer := envoy.NewParcel("Bananas", envoy.CarrierFedEx, "441259201400", "")
+	newer.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{{Description: "In transit", Timestamp: timeNow.Add(time.Hour)}}}
+
+	updated, _ := m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{
+		older.TrackingNumber: older,
+		newer.TrackingNumber: newer,
+	}})
+	m = updated.(model)
+	if !slices.Equal(m.parcelIDs, []string{newer.TrackingNumber, older.TrackingNumber}) {
+		t.Fatalf("Expected the most recently updated parcel first, got %v", m.parcelIDs)
+	}
+
+	m.parcelsTable.SetCursor(1)
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	m = updated.(model)
+	if m.sort.key != "name" || m.parcelsTable.Rows()[0][0] != "Apples" {
+		t.Errorf("Expected s to sort by name, got %+v %v", m.sort, m.parcelsTable.Rows())
+	}
+	if p := m.selectedParcel(); p != older {
+		t.Errorf("Expected the cursor to follow the selected parcel, got %v", p)
+	}
+	if title := m.parcelsTable.Columns()[0].Title; title != "PARCEL NAME ▲" {
+		t.Errorf("Expected the sorted column to be marked, got %q", title)
+	}
+
+	// Header clicks are mapped to columns by their padded widths
+	cols := m.parcelsTable.Columns()
+	if i := columnAt(cols, 0); i != 0 {
+		t.Errorf("Expected the first cell to be in column 0, got %d", i)
+	}
+	if i := columnAt(cols, cols[0].Width+2); i != 1 {
+		t.Errorf("Expected the cell after the padding to be in column 1, got %d", i)
+	}
+	if m.sort = m.sort.byColumn("name"); !m.sort.desc {
+		t.Errorf("Expected clicking the sorted column to reverse it, got %+v", m.sort)
+	}
+	if s := m.sort.byColumn("date"); s != defaultParcelSort {
+		t.Errorf("Expected clicking the date column to sort newest first, got %+v", s)
+	}
+}
+
+func TestMatchesQuery(t *testing.T) {
+	parcel := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"shoes", true},
+		{"SHOES", true},
+		{"nwshs", true},
+		{"new shoes", true},
+		{"4412", true},
+		{"44 12 59", true},
+		{"fedex", true},
+		{"fdx", true},
+		{"sheos", false},
+		{"ups", false},
+		{"9999", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := matchesQuery(parcel, tt.query); got != tt.want {
+				t.Errorf("matchesQuery(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterParcelsTable(t *testing.T) {
+	columns, err := resolveParcelColumns([]string{"name", "carrier"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	shoes := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	lamp := envoy.NewParcel("Lamp", envoy.CarrierUPS, "1ZW701150378674373", "")
+	m := model{
+		parcels:      map[string]*envoy.Parcel{shoes.TrackingNumber: shoes, lamp.TrackingNumber: lamp},
+		parcelIDs:    []string{shoes.TrackingNumber, lamp.TrackingNumber},
+		parcelsTable: makeParcelsTable(nil, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+	}
+	m.refreshParcelRows()
+
+	for _, msg := range []tea.Msg{
+		tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("/")},
+		tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("u")},
+		tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")},
+	} {
+		updated, _ := m.Update(msg)
+		m = updated.(model)
+	}
+	if rows := m.parcelsTable.Rows(); len(rows) != 1 || rows[0][0] != "Lamp" {
+		t.Fatalf("Expected only the UPS parcel while filtering, got %v", rows)
+	}
+	if p := m.selectedParcel(); p != lamp {
+		t.Errorf("Expected the selection to follow the filtered rows, got %v", p)
+	}
+
+	// Closing the input keeps the filter, and esc clears it
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = updated.(model)
+	if m.filtering || m.filter != "up" || len(m.parcelsTable.Rows()) != 1 {
+		t.Fatalf("Expected enter to keep the filter, got %q with %d rows", m.filter, len(m.parcelsTable.Rows()))
+	}
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = updated.(model)
+	if m.filter != "" || len(m.parcelsTable.Rows()) != 2 {
+		t.Errorf("Expected esc to restore the full list, got %q with %d rows", m.filter, len(m.parcelsTable.Rows()))
+	}
+	if p := m.selectedParcel(); p != lamp {
+		t.Errorf("Expected the selection to be kept when clearing the filter, got %v", p)
+	}
+}
+
+func TestStatusCellWidth(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.ANSI)
+	defer lipgloss.SetColorProfile(termenv.Ascii)
+
+	columns, err := resolveParcelColumns([]string{"status"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status := columns[0]
+
+	tests := []struct {
+		name        string
+		eventType   envoy.ParcelEventType
+		description string
+		want        string
+	}{
+		{"fits", envoy.ParcelEventTypeDelivered, "Delivered", successStyle.Render("DELIVERED")},
+		{"truncated", envoy.ParcelEventTypeOutForDelivery, "On FedEx vehicle for delivery", indeterminateStyle.Render("ON FEDEX…")},
+		{"unstyled", envoy.ParcelEventTypeInTransit, "On the way to the destination", "ON THE WAY TO THE DESTINATION"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := envoy.NewParcel("Books", envoy.CarrierFedEx, "441259201412", "")
+			p.Data = &envoy.ParcelData{
+				Events: []envoy.ParcelEvent{{Type: tt.eventType, Description: tt.description}},
+			}
+
+			cell := status.cell(p)
+			if cell != tt.want {
+				t.Errorf("Expected cell %q, got %q", tt.want, cell)
+			}
+			// The table would truncate wider styled cells, cutting off the
+			// reset code
+			if w := runewidth.StringWidth(cell); strings.Contains(cell, "\x1b") && w > status.width {
+				t.Errorf("Expected styled cell to fit %d columns, got %d", status.width, w)
+			}
+		})
+	}
+}
+
+func TestUpdateIgnoresUnknownMessages(t *testing.T) {
+	type customMsg struct{}
+
+	columns, err := resolveParcelColumns(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := model{
+		parcels:      make(map[string]*envoy.Parcel),
+		parcelsTable: makeParcelsTable(nil, columns),
+		eventsTable:  makeEventsTable(nil),
+		columns:      columns,
+	}
+
+	for _, msg := range []tea.Msg{customMsg{}, tea.FocusMsg{}, tea.BlurMsg{}} {
+		updated, _ := m.Update(msg)
+		if _, ok := updated.(model); !ok {
+			t.Fatalf("Expected a model for %T, got %T", msg, updated)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 
@@ -18,6 +22,7 @@ var (
 	indeterminateStyle = lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(3))
 	errorStyle         = lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(1))
 	dimStyle           = lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(8))
+	highlightStyle     = lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(5)).Bold(true)
 
 	iconDefault   = "•"
 	iconDelivered = successStyle.Inline(true).Render("✓")
@@ -31,22 +36,346 @@ var (
 	lor = dimStyle.Render("└───")
 )
 
-func formatEventIcon(e *envoy.ParcelEvent) string {
-	switch e.Type {
+// Event types configured to be emphasized
+var highlightedEvents map[envoy.ParcelEventType]struct{}
+
+func parseHighlightEvents(names []string) (map[envoy.ParcelEventType]struct{}, error) {
+	set := make(map[envoy.ParcelEventType]struct{}, len(names))
+	for _, name := range names {
+		t, err := envoy.ParseParcelEventType(name)
+		if err != nil {
+			return nil, err
+		}
+		set[t] = struct{}{}
+	}
+	return set, nil
+}
+
+// Emphasize s if events of type t are configured to be highlighted
+func formatHighlighted(t envoy.ParcelEventType, s string) string {
+	if _, ok := highlightedEvents[t]; ok {
+		return highlightStyle.Render(s)
+	}
+	return s
+}
+
+// Returns the color of a parcel status by the type of its latest event
+func statusStyle(t envoy.ParcelEventType) lipgloss.Style {
+	switch t {
 	case envoy.ParcelEventTypeDelivered:
+		return successStyle
+	case envoy.ParcelEventTypeOutForDelivery, envoy.ParcelEventTypeOnVehicle:
+		return indeterminateStyle
+	case envoy.ParcelEventTypeUndeliverable, envoy.ParcelEventTypeReturnedToSender:
+		return errorStyle
+	case envoy.ParcelEventTypeUnknown:
+		return dimStyle
+	default:
+		return lipgloss.NewStyle()
+	}
+}
+
+func formatEventIcon(e *envoy.ParcelEvent) string {
+	return formatSeverityIcon(e.Type.Severity())
+}
+
+// Format the type of an event, falling back to the carrier's description for
+// events which could not be mapped to a known type
+func formatEventType(e *envoy.ParcelEvent) string {
+	if e.Type == envoy.ParcelEventTypeUnknown && e.Description != "" {
+		return strings.ToUpper(e.Description)
+	}
+	return string(e.Type)
+}
+
+func formatSeverityIcon(s envoy.Severity) string {
+	switch s {
+	case envoy.SeveritySuccess:
 		return iconDelivered
-	case envoy.ParcelEventTypeParcelHeld,
-		envoy.ParcelEventTypeReturnedToSender,
-		envoy.ParcelEventTypeUndeliverable,
-		envoy.ParcelEventTypeDelayed:
+	case envoy.SeverityError:
 		return iconException
-	case envoy.ParcelEventTypeUnknown:
-		return iconUnknown
 	default:
 		return iconDefault
 	}
 }
 
+// Format the carrier of a parcel, qualifying it when the carrier was only
+// guessed from an ambiguous tracking number and has not yet been confirmed
+func formatCarrier(p *envoy.Parcel) string {
+	if !p.HasData() {
+		carrier, _, confidence := envoy.DetectCarrierDetail(p.TrackingNumber)
+		if carrier == p.Carrier && confidence < 1 {
+			return fmt.Sprintf("%s (likely)", p.Carrier)
+		}
+	}
+	return string(p.Carrier)
+}
+
+// Format the raw carrier response for a parcel as indented JSON, falling back
+// to the parcel itself when no response is available, such as for parcels
+// loaded from the database
+func formatRawParcel(parcel *envoy.Parcel) string {
+	if len(parcel.Raw) > 0 {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, parcel.Raw, "", "  "); err == nil {
+			return buf.String()
+		}
+	}
+
+	data, err := json.MarshalIndent(parcel, "", "  ")
+	if err != nil {
+		return errorStyle.Render(err.Error())
+	}
+	return string(data)
+}
+
+// Format the note and carrier notices of a parcel in full, wrapping them to
+// the given width if it is known
+func formatNotices(parcel *envoy.Parcel, width int) string {
+	style := lipgloss.NewStyle().PaddingLeft(2)
+	if width > 0 {
+		style = style.Width(width)
+	}
+
+	var sections []string
+	if parcel.Note != "" {
+		sections = append(sections, lipgloss.JoinVertical(
+			lipgloss.Left,
+			dimStyle.Render("✎ NOTE"),
+			style.Render(parcel.Note),
+		))
+	}
+	if parcel.HasData() {
+		for _, n := range parcel.Data.Notices {
+			title := n.Code
+			if title == "" {
+				title = "NOTICE"
+			}
+			sections = append(sections, lipgloss.JoinVertical(
+				lipgloss.Left,
+				indeterminateStyle.Render("! "+title),
+				style.Render(n.Message),
+			))
+		}
+	}
+
+	if len(sections) == 0 {
+		return dimStyle.Render("No notices for " + parcel.Name)
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// Format a parcel's progress through the stages of its journey as a bar, with
+// the stages it has reached highlighted, and the current one shown as an
+// error if a problem is holding the parcel there
+func formatProgress(parcel *envoy.Parcel) string {
+	stage, exception := parcel.Stage()
+
+	var b strings.Builder
+	for i, s := range envoy.Stages {
+		if i > 0 {
+			if s <= stage {
+				b.WriteString(successStyle.Render(" ━━ "))
+			} else {
+				b.WriteString(dimStyle.Render(" ── "))
+			}
+		}
+		switch {
+		case s == stage && exception:
+			b.WriteString(errorStyle.Bold(true).Render("✕ " + s.String()))
+		case s == stage:
+			b.WriteString(successStyle.Bold(true).Render("● " + s.String()))
+		case s < stage:
+			b.WriteString(successStyle.Render("● " + s.String()))
+		default:
+			b.WriteString(dimStyle.Render("○ " + s.String()))
+		}
+	}
+	return b.String()
+}
+
+// The unit system weights and dimensions are shown in
+var unitSystem envoy.UnitSystem
+
+// Format a weight in the configured units, rounded to a tenth, falling back
+// to the value reported by the carrier if it cannot be parsed
+func formatWeight(w *envoy.Dimensioned) string {
+	value, units, err := w.Weight(unitSystem)
+	if err != nil {
+		return strings.TrimSpace(w.Value + " " + w.Units)
+	}
+	return strings.TrimSpace(strconv.FormatFloat(value, 'f', 1, 64) + " " + units)
+}
+
+// Format dimensions in the configured units, rounded to whole units
+func formatDimensions(d *envoy.Size) string {
+	l, w, h, units := d.Convert(unitSystem)
+	return strings.TrimSpace(fmt.Sprintf("%.0fx%.0fx%.0f %s", l, w, h, units))
+}
+
+// Format the service a parcel was shipped with, or a dash if it is not known
+func formatServiceLevel(p *envoy.Parcel) string {
+	if !p.HasData() || p.Data.ServiceLevel == "" {
+		return envoy.EmDash
+	}
+	return p.Data.ServiceLevel
+}
+
+// Format the weight and dimensions of a parcel, such as "2.3 KG, 30x20x10 CM",
+// or a dash if neither is known
+func formatSize(p *envoy.Parcel) string {
+	if !p.HasData() {
+		return envoy.EmDash
+	}
+	var parts []string
+	if w := p.Data.Weight; w != nil {
+		parts = append(parts, formatWeight(w))
+	}
+	if d := p.Data.Dimensions; d != nil {
+		parts = append(parts, formatDimensions(d))
+	}
+	if len(parts) == 0 {
+		return envoy.EmDash
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Format a projected delivery date in the zone given with --timezone, or a
+// dash if there is none
+func formatETA(eta *time.Time) string {
+	if eta == nil {
+		return envoy.EmDash
+	}
+	return eta.In(displayLocation).Format("Mon, Jan 02")
+}
+
+// Format when a parcel is projected to arrive along with a countdown, such as
+// "Thu, Feb 27 (in 2 days)", or when it was delivered. A dash is shown if
+// neither is known.
+func formatETACountdown(p *envoy.Parcel, now time.Time) string {
+	if !p.HasData() {
+		return formatETA(nil)
+	}
+	if p.Data.Delivered {
+		if at := p.DeliveredAt(); !at.IsZero() {
+			return at.In(displayLocation).Format("Mon, Jan 02 15:04")
+		}
+	}
+	eta := p.Data.DeliveryProjection
+	if eta == nil || p.Data.Delivered {
+		return formatETA(eta)
+	}
+	return fmt.Sprintf("%s (%s)", formatETA(eta), formatDaysUntil(*eta, now))
+}
+
+// Format the number of calendar days from now until t in the zone given with
+// --timezone, such as "today", "in 2 days", or "3 days ago"
+func formatDaysUntil(t, now time.Time) string {
+	y, m, d := now.In(displayLocation).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	y, m, d = t.In(displayLocation).Date()
+	days := int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(today).Hours() / 24)
+
+	switch {
+	case days == 0:
+		return "today"
+	case days == 1:
+		return "tomorrow"
+	case days == -1:
+		return "yesterday"
+	case days > 1:
+		return fmt.Sprintf("in %d days", days)
+	default:
+		return fmt.Sprintf("%d days ago", -days)
+	}
+}
+
+// Format who signed for or received a delivered parcel, or an empty string if
+// the carrier does not report it
+func formatRecipient(parcel *envoy.Parcel) string {
+	if !parcel.HasData() {
+		return ""
+	}
+	switch {
+	case parcel.Data.SignedBy != "":
+		return "Signed by " + parcel.Data.SignedBy
+	case parcel.Data.ReceivedBy != "":
+		return "Received by " + parcel.Data.ReceivedBy
+	default:
+		return ""
+	}
+}
+
+// Format where a parcel delivered to a locker, access point, or neighbor can
+// be collected, or an empty string if it was delivered to the recipient
+func formatAccessPointDelivery(parcel *envoy.Parcel) string {
+	if !parcel.HasData() || !parcel.Data.DeliveredToAccessPoint {
+		return ""
+	}
+	place := parcel.Data.AccessPoint
+	if place == "" {
+		place = "access point"
+	}
+	s := "Delivered to " + place
+	if parcel.Data.PickupBy != nil {
+		s += " — pickup by " + parcel.Data.PickupBy.Format("Mon, Jan 02")
+	}
+	return s
+}
+
+// Returns the event as it is shown, describing a delivery to an access point
+// in place of a plain "Delivered", so that it is clear the parcel still has to
+// be collected
+func annotateEvent(parcel *envoy.Parcel, e envoy.ParcelEvent) envoy.ParcelEvent {
+	if e.Type == envoy.ParcelEventTypeDelivered {
+		if d := formatAccessPointDelivery(parcel); d != "" {
+			e.Description = d
+		}
+	}
+	return e
+}
+
+// Format the notes for an event, noting the reporting carrier when it differs
+// from the carrier the parcel is tracked under (e.g. after a handoff), and who
+// signed for a delivery
+func formatEventNotes(parcel *envoy.Parcel, e *envoy.ParcelEvent) string {
+	notes := annotateEvent(parcel, *e).Description
+	if e.SourceCarrier != "" && e.SourceCarrier != parcel.Carrier {
+		notes = fmt.Sprintf("%s (via %s)", notes, e.SourceCarrier)
+	}
+	if e.Type == envoy.ParcelEventTypeDelivered {
+		if r := formatRecipient(parcel); r != "" {
+			notes += " — " + r
+		}
+	}
+	return notes
+}
+
+// The time zone given with --timezone, which timestamps are shown in
+var (
+	timezoneFlag    string
+	displayLocation = time.Local
+)
+
+// Parse the IANA name of a time zone, such as "America/New_York", or "local"
+// for the zone of the system
+func parseTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, "local") {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q, expected an IANA name such as America/New_York, or local", name)
+	}
+	return loc, nil
+}
+
+// Format a timestamp in the zone given with --timezone
+func formatTimestamp(t time.Time) string {
+	return t.In(displayLocation).Format(timeFormat)
+}
+
 // Format an event as a single line of text in the format:
 // Tue, 25 Feb 2025 11:48:00 -0800 441259201412 Shipment information sent to FedEx
 func formatEventOneline(nameOrTrackingNumber string, e *envoy.ParcelEvent) string {
@@ -57,13 +386,68 @@ func formatEventOneline(nameOrTrackingNumber string, e *envoy.ParcelEvent) strin
 
 	return fmt.Sprintf(
 		"%s%s %s @ %s",
-		e.Timestamp.Format(timeFormat),
+		formatTimestamp(e.Timestamp),
 		name,
 		e.Description,
 		e.Location,
 	)
 }
 
+// Whether timelines group consecutive events at the same location into a
+// single line
+var collapseTimeline bool
+
+// Returns the timeline of a parcel as it is shown, with each event in its own
+// group, or with consecutive events at the same location grouped together when
+// collapsed
+func timelineGroups(parcel *envoy.Parcel, collapse bool) []envoy.TimelineGroup {
+	nodes := parcel.Timeline()
+	for i := range nodes {
+		nodes[i].Event = annotateEvent(parcel, nodes[i].Event)
+	}
+	if collapse {
+		return envoy.CollapseTimeline(nodes)
+	}
+	groups := make([]envoy.TimelineGroup, 0, len(nodes))
+	for _, n := range nodes {
+		groups = append(groups, envoy.TimelineGroup{
+			Nodes:    []envoy.TimelineNode{n},
+			Position: n.Position,
+			Severity: n.Severity,
+		})
+	}
+	return groups
+}
+
+// Format the time range of a group of events, omitting the date of the end
+// when it is the same day as the start
+func formatEventGroupRange(g *envoy.TimelineGroup) string {
+	first := g.First().Event.Timestamp.In(displayLocation)
+	last := g.Last().Event.Timestamp.In(displayLocation)
+	end := last.Format(timeFormat)
+	if y, m, d := first.Date(); y == last.Year() && m == last.Month() && d == last.Day() {
+		end = last.Format("15:04")
+	}
+	return first.Format(timeFormat) + " – " + end
+}
+
+// Format a group of events as a single line, in the format of
+// formatEventOneline with the time range and count of a collapsed group:
+// Tue, Feb 25 2025 11:48 – 14:24 Departed FedEx hub (3 events) @ Memphis, TN
+func formatEventGroupOneline(g *envoy.TimelineGroup) string {
+	last := &g.Last().Event
+	if len(g.Nodes) == 1 {
+		return formatEventOneline("", last)
+	}
+	return fmt.Sprintf(
+		"%s %s (%d events) @ %s",
+		formatEventGroupRange(g),
+		last.Description,
+		len(g.Nodes),
+		last.Location,
+	)
+}
+
 // Format the event history for a parcel as a timeline of events
 func formatEventHistory(parcel *envoy.Parcel) string {
 	if !parcel.HasData() {
@@ -76,24 +460,23 @@ func formatEventHistory(parcel *envoy.Parcel) string {
 		formatEventIcon(parcel.LastTrackingEvent()),
 		parcel.Name,
 		parcel.Carrier,
-		parcel.LastTrackingEvent().Type,
+		formatEventType(parcel.LastTrackingEvent()),
 	))
-	ct := len(parcel.Data.Events)
-	for i := range ct {
-		e := parcel.Data.Events[ct-i-1]
+	for _, g := range timelineGroups(parcel, collapseTimeline) {
 		prefix := lvr
-		if ct == 1 {
+		switch g.Position {
+		case envoy.TimelinePositionOnly:
 			prefix = lor
-		} else if i == 0 {
+		case envoy.TimelinePositionFirst:
 			prefix = ldr
-		} else if i == len(parcel.Data.Events)-1 {
+		case envoy.TimelinePositionLast:
 			prefix = lur
 		}
 		sb.WriteString(fmt.Sprintf(
 			"%s %s %s\n",
 			prefix,
-			formatEventIcon(&e),
-			formatEventOneline("", &e),
+			formatSeverityIcon(g.Severity),
+			formatHighlighted(g.Last().Event.Type, formatEventGroupOneline(&g)),
 		))
 	}
 	return sb.String()
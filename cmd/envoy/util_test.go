@@ -1,14 +1,28 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
 	"github.com/rektdeckard/envoy/pkg"
 )
 
 func TestFormatEventOneline(t *testing.T) {
 	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+	defer func(loc *time.Location) { displayLocation = loc }(displayLocation)
+	displayLocation = timeNow.Location()
 
 	event := &envoy.ParcelEvent{
 		Timestamp:   timeNow,
@@ -23,8 +37,81 @@ func TestFormatEventOneline(t *testing.T) {
 	}
 }
 
+func TestFormatEventOnelineTimezone(t *testing.T) {
+	defer func(loc *time.Location) { displayLocation = loc }(displayLocation)
+	event := &envoy.ParcelEvent{
+		Timestamp:   time.Date(2025, 2, 25, 19, 48, 0, 0, time.UTC),
+		Description: "Shipment information sent to FedEx",
+		Location:    "Altoona, PA",
+	}
+
+	// The ETA is early on the 26th in UTC, which is still the 25th in Los
+	// Angeles
+	now := event.Timestamp
+	eta := time.Date(2025, 2, 26, 4, 0, 0, 0, time.UTC)
+	inTransit := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	inTransit.Data = &envoy.ParcelData{DeliveryProjection: &eta}
+	delivered := envoy.NewParcel("Lamp", envoy.CarrierFedEx, "271163815799", "")
+	delivered.Data = &envoy.ParcelData{
+		Events:    []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Timestamp: event.Timestamp}},
+		Delivered: true,
+	}
+
+	tests := []struct {
+		zone          string
+		want          string
+		wantETA       string
+		wantDelivered string
+	}{
+		{
+			"America/Los_Angeles",
+			"Tue, Feb 25 2025 11:48 441259201412 Shipment information sent to FedEx @ Altoona, PA",
+			"Tue, Feb 25 (today)",
+			"Tue, Feb 25 11:48",
+		},
+		{
+			"Asia/Tokyo",
+			"Wed, Feb 26 2025 04:48 441259201412 Shipment information sent to FedEx @ Altoona, PA",
+			"Wed, Feb 26 (today)",
+			"Wed, Feb 26 04:48",
+		},
+	}
+	for _, tt := range tests {
+		loc, err := parseTimezone(tt.zone)
+		if err != nil {
+			t.Fatalf("parseTimezone(%q) error = %v", tt.zone, err)
+		}
+		displayLocation = loc
+		if got := formatEventOneline("441259201412", event); got != tt.want {
+			t.Errorf("In %s, expected %s, got %s", tt.zone, tt.want, got)
+		}
+		if got := formatETACountdown(inTransit, now); got != tt.wantETA {
+			t.Errorf("In %s, expected ETA %s, got %s", tt.zone, tt.wantETA, got)
+		}
+		if got := formatETACountdown(delivered, now); got != tt.wantDelivered {
+			t.Errorf("In %s, expected delivery at %s, got %s", tt.zone, tt.wantDelivered, got)
+		}
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	for _, name := range []string{"", "local", "Local"} {
+		if loc, err := parseTimezone(name); err != nil || loc != time.Local {
+			t.Errorf("parseTimezone(%q) = %v, %v, want the local zone", name, loc, err)
+		}
+	}
+	if loc, err := parseTimezone("Europe/Berlin"); err != nil || loc.String() != "Europe/Berlin" {
+		t.Errorf("parseTimezone(Europe/Berlin) = %v, %v", loc, err)
+	}
+	if _, err := parseTimezone("Mars/Olympus_Mons"); err == nil || !strings.Contains(err.Error(), "Mars/Olympus_Mons") {
+		t.Errorf("Expected an error naming the unknown zone, got %v", err)
+	}
+}
+
 func TestFormatEventHistory(t *testing.T) {
 	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+	defer func(loc *time.Location) { displayLocation = loc }(displayLocation)
+	displayLocation = timeNow.Location()
 
 	{
 		event1 := &envoy.ParcelEvent{
@@ -112,3 +199,600 @@ func TestFormatEventHistory(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatEventHistoryUnknownEvent(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+	defer func(loc *time.Location) { displayLocation = loc }(displayLocation)
+	displayLocation = timeNow.Location()
+
+	parcel := &envoy.Parcel{
+		Name:           "New shoes",
+		Carrier:        envoy.CarrierUSPS,
+		TrackingNumber: "9400123456789012345674",
+		Data: &envoy.ParcelData{
+			Events: []envoy.ParcelEvent{{
+				Timestamp:   timeNow,
+				Description: "Processed through facility",
+				Location:    "Denver, CO",
+				Type:        envoy.ParcelEventTypeUnknown,
+			}},
+		},
+	}
+
+	expected := "• New shoes (USPS) PROCESSED THROUGH FACILITY\n"
+	expected += "└─── • Tue, Feb 25 2025 11:48 Processed through facility @ Denver, CO\n"
+	if result := formatEventHistory(parcel); result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+	if icon := formatEventIcon(parcel.LastTrackingEvent()); icon != iconDefault {
+		t.Errorf("Expected neutral icon for an unknown event, got %q", icon)
+	}
+}
+
+func TestGroupTrackingNumbers(t *testing.T) {
+	args := []string{
+		"1ZW701150378674373",
+		"1zw701150378674373",
+		"9405511105503530533479",
+		"9405 5111 0550 3530 5334 79",
+	}
+	explicit := map[envoy.Carrier][]string{
+		envoy.CarrierUSPS: {"9405511105503530533479"},
+		envoy.CarrierUPS:  {"1ZW701150378674373"},
+	}
+
+	groups := groupTrackingNumbers(args, explicit)
+
+	total := 0
+	for _, tns := range groups {
+		total += len(tns)
+	}
+	if total != 2 {
+		t.Errorf("Expected 2 tracking numbers, got %d: %v", total, groups)
+	}
+	if got := groups[envoy.CarrierUSPS]; len(got) != 1 || got[0] != "9405511105503530533479" {
+		t.Errorf("Expected a single USPS query, got %v", got)
+	}
+	if got := groups[envoy.CarrierUPS]; len(got) != 1 || got[0] != "1ZW701150378674373" {
+		t.Errorf("Expected a single UPS query, got %v", got)
+	}
+}
+
+func TestSyncWithFallback(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	var calls []envoy.Carrier
+	sync := func(groups map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error) {
+		fetched := make(map[string]*envoy.Parcel)
+		for carrier, tns := range groups {
+			calls = append(calls, carrier)
+			if carrier != envoy.CarrierUPS {
+				continue
+			}
+			for _, tn := range tns {
+				p := envoy.NewParcel(tn, carrier, tn, "")
+				p.Data = &envoy.ParcelData{}
+				fetched[tn] = p
+			}
+		}
+		return fetched, nil
+	}
+
+	groups := map[envoy.Carrier][]string{
+		envoy.CarrierFedEx: {"441259201412", "271278612814"},
+	}
+	explicit := map[string]struct{}{"271278612814": {}}
+	parcels, err := syncWithFallback(groups, explicit, sync)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []envoy.Carrier{envoy.CarrierFedEx, envoy.CarrierUPS}; !slices.Equal(calls, want) {
+		t.Errorf("Expected carriers to be tried in order %v, got %v", want, calls)
+	}
+	if p, ok := parcels["441259201412"]; !ok || p.Carrier != envoy.CarrierUPS {
+		t.Errorf("Expected detected number to fall back to UPS, got %+v", p)
+	}
+	if _, ok := parcels["271278612814"]; ok {
+		t.Error("Expected explicit number not to fall back to another carrier")
+	}
+}
+
+func TestSyncWithFallbackRetriesErrors(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	// Reports each parcel as failed, except by the succeeding carrier
+	syncFailing := func(succeeding envoy.Carrier) func(map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error) {
+		return func(groups map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error) {
+			fetched := make(map[string]*envoy.Parcel)
+			for carrier, tns := range groups {
+				for _, tn := range tns {
+					p := envoy.NewParcel(tn, carrier, tn, "")
+					if carrier == succeeding {
+						p.Data = &envoy.ParcelData{}
+					} else {
+						p.Error = errors.New("not found")
+					}
+					fetched[tn] = p
+				}
+			}
+			return fetched, nil
+		}
+	}
+	groups := func() map[envoy.Carrier][]string {
+		return map[envoy.Carrier][]string{
+			envoy.CarrierFedEx: {"441259201412", "271278612814"},
+		}
+	}
+	explicit := map[string]struct{}{"271278612814": {}}
+
+	t.Run("next candidate", func(t *testing.T) {
+		parcels, err := syncWithFallback(groups(), explicit, syncFailing(envoy.CarrierUPS))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := parcels["441259201412"]; p == nil || p.Carrier != envoy.CarrierUPS || p.HasError() {
+			t.Errorf("Expected failed number to fall back to UPS, got %+v", p)
+		}
+		if p := parcels["271278612814"]; p == nil || p.Carrier != envoy.CarrierFedEx || !p.HasError() {
+			t.Errorf("Expected explicit number to keep its error, got %+v", p)
+		}
+	})
+
+	t.Run("no candidates left", func(t *testing.T) {
+		parcels, err := syncWithFallback(groups(), explicit, syncFailing(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := parcels["441259201412"]; p == nil || !p.HasError() {
+			t.Errorf("Expected the error to be kept once every candidate failed, got %+v", p)
+		}
+	})
+}
+
+func TestFormatRawParcel(t *testing.T) {
+	p := envoy.NewParcel("Books", envoy.CarrierFedEx, "441259201412", "")
+
+	if got := formatRawParcel(p); !strings.Contains(got, `"TrackingNumber": "441259201412"`) {
+		t.Errorf("Expected serialized parcel without a raw response, got %s", got)
+	}
+
+	p.Raw = []byte(`{"trackingNumber":"441259201412","trackResults":[]}`)
+	want := "{\n  \"trackingNumber\": \"441259201412\",\n  \"trackResults\": []\n}"
+	if got := formatRawParcel(p); got != want {
+		t.Errorf("Expected indented raw response %q, got %q", want, got)
+	}
+}
+
+func TestStatusStyle(t *testing.T) {
+	tests := []struct {
+		eventType envoy.ParcelEventType
+		want      lipgloss.TerminalColor
+	}{
+		{envoy.ParcelEventTypeDelivered, lipgloss.ANSIColor(2)},
+		{envoy.ParcelEventTypeOutForDelivery, lipgloss.ANSIColor(3)},
+		{envoy.ParcelEventTypeOnVehicle, lipgloss.ANSIColor(3)},
+		{envoy.ParcelEventTypeUndeliverable, lipgloss.ANSIColor(1)},
+		{envoy.ParcelEventTypeReturnedToSender, lipgloss.ANSIColor(1)},
+		{envoy.ParcelEventTypeUnknown, lipgloss.ANSIColor(8)},
+		{envoy.ParcelEventTypeInTransit, lipgloss.NoColor{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.eventType), func(t *testing.T) {
+			if got := statusStyle(tt.eventType).GetForeground(); got != tt.want {
+				t.Errorf("statusStyle(%s) = %v, want %v", tt.eventType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatEventHistoryHighlights(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.ANSI)
+	defer lipgloss.SetColorProfile(termenv.Ascii)
+
+	var err error
+	if highlightedEvents, err = parseHighlightEvents([]string{"out for delivery", "DELAYED"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { highlightedEvents = nil }()
+
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("PST", -8*60*60))
+	parcel := envoy.NewParcel("Books", envoy.CarrierFedEx, "441259201412", "")
+	parcel.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Location: "FRESNO, CA", Timestamp: timeNow},
+			{Type: envoy.ParcelEventTypeOutForDelivery, Description: "On FedEx vehicle for delivery", Location: "LOS ANGELES, CA", Timestamp: timeNow.Add(time.Hour)},
+		},
+	}
+
+	history := formatEventHistory(parcel)
+	highlighted := highlightStyle.Render(formatEventOneline("", &parcel.Data.Events[1]))
+	plain := formatEventOneline("", &parcel.Data.Events[0])
+	if !strings.Contains(history, highlighted) {
+		t.Errorf("Expected out for delivery event to be highlighted in %q", history)
+	}
+	if strings.Contains(history, highlightStyle.Render(plain)) {
+		t.Errorf("Expected in transit event not to be highlighted in %q", history)
+	}
+	if !strings.Contains(history, plain) {
+		t.Errorf("Expected in transit event to be rendered plainly in %q", history)
+	}
+
+	if _, err := parseHighlightEvents([]string{"TELEPORTED"}); err == nil {
+		t.Error("Expected an error for an unknown event type")
+	}
+}
+
+func TestFormatEventHistoryCollapsedGolden(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.FixedZone("CST", -6*60*60))
+	defer func(loc *time.Location) { displayLocation = loc }(displayLocation)
+	displayLocation = timeNow.Location()
+	scan := func(offset time.Duration, t envoy.ParcelEventType, desc, loc string) envoy.ParcelEvent {
+		return envoy.ParcelEvent{Timestamp: timeNow.Add(offset), Type: t, Description: desc, Location: loc}
+	}
+
+	parcel := envoy.NewParcel("Standing desk", envoy.CarrierFedEx, "441259201412", "")
+	parcel.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			scan(0, envoy.ParcelEventTypeOrderConfirmed, "Shipment information sent to FedEx", ""),
+			scan(2*time.Hour, envoy.ParcelEventTypePickedUp, "Picked up", "ALTOONA, PA"),
+			scan(4*time.Hour, envoy.ParcelEventTypeDeparted, "Left FedEx origin facility", "ALTOONA, PA"),
+			scan(14*time.Hour, envoy.ParcelEventTypeArrived, "Arrived at FedEx hub", "MEMPHIS, TN"),
+			scan(15*time.Hour, envoy.ParcelEventTypeInTransit, "In transit", "MEMPHIS, TN"),
+			scan(16*time.Hour, envoy.ParcelEventTypeInTransit, "In transit", "MEMPHIS, TN"),
+			scan(18*time.Hour, envoy.ParcelEventTypeDeparted, "Departed FedEx hub", "MEMPHIS, TN"),
+			scan(40*time.Hour, envoy.ParcelEventTypeArrived, "At local FedEx facility", "DENVER, CO"),
+			scan(42*time.Hour, envoy.ParcelEventTypeOutForDelivery, "On FedEx vehicle for delivery", "DENVER, CO"),
+			scan(47*time.Hour, envoy.ParcelEventTypeDelivered, "Delivered", "DENVER, CO"),
+		},
+	}
+
+	for name, collapse := range map[string]bool{"full": false, "collapsed": true} {
+		t.Run(name, func(t *testing.T) {
+			defer func(c bool) { collapseTimeline = c }(collapseTimeline)
+			collapseTimeline = collapse
+			out := formatEventHistory(parcel)
+
+			golden := filepath.Join("testdata", "timeline.golden."+name+".txt")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(out), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != string(want) {
+				t.Errorf("Output does not match %s:\n%s", golden, out)
+			}
+		})
+	}
+
+	if n := len(parcel.Data.Events); n != 10 {
+		t.Errorf("Expected collapsing to leave the events intact, got %d", n)
+	}
+}
+
+func TestFormatEventNotesRecipient(t *testing.T) {
+	delivered := envoy.ParcelEvent{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered"}
+	transit := envoy.ParcelEvent{Type: envoy.ParcelEventTypeInTransit, Description: "In transit"}
+
+	parcel := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	parcel.Data = &envoy.ParcelData{
+		Events:     []envoy.ParcelEvent{transit, delivered},
+		ReceivedBy: "J.DOE",
+		SignedBy:   "J. DOE",
+	}
+
+	if notes := formatEventNotes(parcel, &delivered); notes != "Delivered — Signed by J. DOE" {
+		t.Errorf("Expected the signer on the delivered event, got %q", notes)
+	}
+	if notes := formatEventNotes(parcel, &transit); notes != "In transit" {
+		t.Errorf("Expected no recipient on other events, got %q", notes)
+	}
+
+	parcel.Data.SignedBy = ""
+	if notes := formatEventNotes(parcel, &delivered); notes != "Delivered — Received by J.DOE" {
+		t.Errorf("Expected the recipient without a signer, got %q", notes)
+	}
+}
+
+func TestFormatAccessPointDelivery(t *testing.T) {
+	timeNow := time.Date(2025, 2, 26, 14, 35, 0, 0, time.UTC)
+	pickupBy := time.Date(2025, 3, 7, 0, 0, 0, 0, time.UTC)
+
+	parcel := envoy.NewParcel("New shoes", envoy.CarrierUPS, "1Z5R89390357567127", "")
+	parcel.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Location: "DENVER, CO", Timestamp: timeNow},
+		},
+		Delivered:              true,
+		DeliveredToAccessPoint: true,
+		AccessPoint:            "UPS Access Point™",
+		PickupBy:               &pickupBy,
+	}
+
+	want := "Delivered to UPS Access Point™ — pickup by Fri, Mar 07"
+	if notes := formatEventNotes(parcel, &parcel.Data.Events[0]); notes != want {
+		t.Errorf("Expected notes %q, got %q", want, notes)
+	}
+	if history := formatEventHistory(parcel); !strings.Contains(history, want+" @ DENVER, CO") {
+		t.Errorf("Expected the timeline to annotate the delivery, got:\n%s", history)
+	}
+	if parcel.Data.Events[0].Description != "Delivered" {
+		t.Errorf("Expected the stored event to be left alone, got %q", parcel.Data.Events[0].Description)
+	}
+
+	parcel.Data.AccessPoint, parcel.Data.PickupBy = "", nil
+	if notes := formatEventNotes(parcel, &parcel.Data.Events[0]); notes != "Delivered to access point" {
+		t.Errorf("Expected a generic access point without details, got %q", notes)
+	}
+
+	parcel.Data.DeliveredToAccessPoint = false
+	if notes := formatEventNotes(parcel, &parcel.Data.Events[0]); notes != "Delivered" {
+		t.Errorf("Expected a plain delivery to the recipient, got %q", notes)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	parcel := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	if s := formatSize(parcel); s != "—" {
+		t.Errorf("Expected a dash without data, got %q", s)
+	}
+
+	parcel.Data = &envoy.ParcelData{
+		Weight:     &envoy.Dimensioned{Units: "KG", Value: "2.3"},
+		Dimensions: &envoy.Size{Length: 30, Width: 20, Height: 10, Units: "CM"},
+	}
+	if s := formatSize(parcel); s != "2.3 KG, 30x20x10 CM" {
+		t.Errorf("Expected weight and dimensions, got %q", s)
+	}
+
+	parcel.Data.Dimensions = nil
+	if s := formatSize(parcel); s != "2.3 KG" {
+		t.Errorf("Expected only the weight, got %q", s)
+	}
+}
+
+func TestFormatServiceLevel(t *testing.T) {
+	parcel := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	if s := formatServiceLevel(parcel); s != "—" {
+		t.Errorf("Expected a dash without data, got %q", s)
+	}
+
+	parcel.Data = &envoy.ParcelData{ServiceLevel: "FedEx 2Day"}
+	if s := formatServiceLevel(parcel); s != "FedEx 2Day" {
+		t.Errorf("Expected the service level, got %q", s)
+	}
+}
+
+func TestFormatETACountdown(t *testing.T) {
+	defer func(loc *time.Location) { displayLocation = loc }(displayLocation)
+	displayLocation = time.UTC
+	now := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name string
+		eta  *time.Time
+		want string
+	}{
+		{"none", nil, "—"},
+		{"past", at(-72 * time.Hour), "Sat, Feb 22 (3 days ago)"},
+		{"yesterday", at(-24 * time.Hour), "Mon, Feb 24 (yesterday)"},
+		{"today", at(6 * time.Hour), "Tue, Feb 25 (today)"},
+		{"tomorrow", at(13 * time.Hour), "Wed, Feb 26 (tomorrow)"},
+		{"future", at(48 * time.Hour), "Thu, Feb 27 (in 2 days)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parcel := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+			parcel.Data = &envoy.ParcelData{DeliveryProjection: tt.eta}
+			if got := formatETACountdown(parcel, now); got != tt.want {
+				t.Errorf("formatETACountdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatETACountdownDelivered(t *testing.T) {
+	defer func(loc *time.Location) { displayLocation = loc }(displayLocation)
+	displayLocation = time.UTC
+	now := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	eta := now.Add(24 * time.Hour)
+	parcel := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	if got := formatETACountdown(parcel, now); got != "—" {
+		t.Errorf("Expected a dash without data, got %q", got)
+	}
+
+	parcel.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{
+			{Type: envoy.ParcelEventTypeDelivered, Timestamp: now.Add(-2 * time.Hour)},
+		},
+		Delivered:          true,
+		DeliveryProjection: &eta,
+	}
+	if got := formatETACountdown(parcel, now); got != "Tue, Feb 25 09:48" {
+		t.Errorf("Expected the delivery time, got %q", got)
+	}
+}
+
+func TestFormatSizeUnits(t *testing.T) {
+	defer func(u envoy.UnitSystem) { unitSystem = u }(unitSystem)
+
+	fedex := envoy.NewParcel("Desk", envoy.CarrierFedEx, "441259201412", "")
+	fedex.Data = &envoy.ParcelData{
+		Weight:     &envoy.Dimensioned{Units: "KG", Value: "2.3"},
+		Dimensions: &envoy.Size{Length: 30, Width: 20, Height: 10, Units: "CM"},
+	}
+	ups := envoy.NewParcel("Chair", envoy.CarrierUPS, "1ZW701150378674373", "")
+	ups.Data = &envoy.ParcelData{
+		Weight:     &envoy.Dimensioned{Units: "LBS", Value: "5.10"},
+		Dimensions: &envoy.Size{Length: 12, Width: 8, Height: 4, Units: "IN"},
+	}
+
+	tests := []struct {
+		system     envoy.UnitSystem
+		fedex, ups string
+	}{
+		{envoy.UnitSystemCarrier, "2.3 KG, 30x20x10 CM", "5.1 LBS, 12x8x4 IN"},
+		{envoy.UnitSystemMetric, "2.3 KG, 30x20x10 CM", "2.3 KG, 30x20x10 CM"},
+		{envoy.UnitSystemImperial, "5.1 LB, 12x8x4 IN", "5.1 LB, 12x8x4 IN"},
+	}
+	for _, tt := range tests {
+		unitSystem = tt.system
+		if s := formatSize(fedex); s != tt.fedex {
+			t.Errorf("%q: expected FedEx size %q, got %q", tt.system, tt.fedex, s)
+		}
+		if s := formatSize(ups); s != tt.ups {
+			t.Errorf("%q: expected UPS size %q, got %q", tt.system, tt.ups, s)
+		}
+	}
+
+	// Unparseable weights are shown as reported
+	unitSystem = envoy.UnitSystemMetric
+	ups.Data.Weight.Value = "heavy"
+	if s := formatWeight(ups.Data.Weight); s != "heavy LBS" {
+		t.Errorf("Expected the reported weight, got %q", s)
+	}
+}
+
+func TestGroupByCarrierOverride(t *testing.T) {
+	defer func(c envoy.Carrier) { carrierOverride = c }(carrierOverride)
+
+	// Twelve digits fit both FedEx Express and UPS Air
+	args := []string{"441259201412", "1ZW701150378674373"}
+	if groups := groupByCarrier(args); len(groups[envoy.CarrierFedEx]) != 1 {
+		t.Fatalf("Expected the 12-digit number to be detected as FedEx, got %v", groups)
+	}
+
+	var err error
+	if carrierOverride, err = parseCarrier("ups"); err != nil {
+		t.Fatal(err)
+	}
+	groups := groupByCarrier(args)
+	if len(groups) != 1 || !slices.Equal(groups[envoy.CarrierUPS], args) {
+		t.Errorf("Expected every number to be tracked with UPS, got %v", groups)
+	}
+}
+
+func TestParseCarrier(t *testing.T) {
+	for name, want := range map[string]envoy.Carrier{
+		"":      "",
+		"fedex": envoy.CarrierFedEx,
+		"UPS":   envoy.CarrierUPS,
+		" usps": envoy.CarrierUSPS,
+		"Dhl":   envoy.CarrierDHL,
+	} {
+		if got, err := parseCarrier(name); err != nil || got != want {
+			t.Errorf("parseCarrier(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := parseCarrier("amazon"); err == nil || !strings.Contains(err.Error(), "fedex, ups, usps, dhl") {
+		t.Errorf("Expected an error listing the known carriers, got %v", err)
+	}
+}
+
+// failingService fails every request, as when a carrier rejects credentials
+// or is down
+type failingService struct{}
+
+func (failingService) Track([]string) ([]*envoy.Parcel, error) {
+	return nil, errors.New("upstream unavailable")
+}
+
+func (failingService) Reauthenticate() error {
+	return nil
+}
+
+func TestTrackWholeRequestFails(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	openTestDB(t)
+	defer func(maxAge time.Duration, quiet bool) { trackMaxAge, trackQuiet = maxAge, quiet }(trackMaxAge, trackQuiet)
+	trackMaxAge = 0
+	defer func(f func(*http.Client, envoy.Carrier) (envoy.Service, error)) { configuredService = f }(configuredService)
+	configuredService = func(*http.Client, envoy.Carrier) (envoy.Service, error) {
+		return failingService{}, nil
+	}
+
+	trackQuiet = true
+	var code int
+	out := captureStdout(t, func() {
+		code = track(&cobra.Command{}, []string{"441259201412"}, outputFormatText, statusFilterAll)
+	})
+	if code != exitTrackingFailed {
+		t.Errorf("Expected exit code %d, got %d", exitTrackingFailed, code)
+	}
+	if out != "" {
+		t.Errorf("Expected nothing printed with --quiet, got %q", out)
+	}
+
+	trackQuiet = false
+	out = captureStdout(t, func() {
+		track(&cobra.Command{}, []string{"441259201412"}, outputFormatJSON, statusFilterAll)
+	})
+	var parcels []*envoy.Parcel
+	if err := json.Unmarshal([]byte(out), &parcels); err != nil {
+		t.Fatalf("Expected only JSON on stdout, got %q: %v", out, err)
+	}
+	if len(parcels) != 1 || parcels[0].TrackingNumber != "441259201412" {
+		t.Errorf("Expected the failed parcel to be reported, got %+v", parcels)
+	}
+}
+
+func TestTrackExitCode(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	// Keep any token cache out of the real config directory
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	openTestDB(t)
+	defer func(maxAge time.Duration, quiet bool) { trackMaxAge, trackQuiet = maxAge, quiet }(trackMaxAge, trackQuiet)
+	trackMaxAge = time.Hour
+
+	// A parcel synced moments ago is answered without contacting FedEx
+	stored := envoy.NewParcel("Books", envoy.CarrierFedEx, "441259201412", "")
+	stored.LastSyncedAt = time.Now()
+	stored.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: time.Now()}},
+	}
+	if err := createParcel(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		quiet      bool
+		status     statusFilter
+		want       int
+		wantOutput bool
+	}{
+		{"tracked", []string{stored.TrackingNumber}, true, statusFilterAll, exitOK, false},
+		{"mixed", []string{stored.TrackingNumber, "NOTATRACKINGNUMBER"}, true, statusFilterAll, exitTrackingFailed, false},
+		{"mixed without quiet", []string{stored.TrackingNumber, "NOTATRACKINGNUMBER"}, false, statusFilterAll, exitOK, true},
+		{"no carrier", []string{"NOTATRACKINGNUMBER"}, true, statusFilterAll, exitNoCarrier, false},
+		{"filtered by status", []string{stored.TrackingNumber}, false, statusFilterDelivered, exitOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackQuiet = tt.quiet
+			var code int
+			out := captureStdout(t, func() {
+				code = track(&cobra.Command{}, tt.args, outputFormatText, tt.status)
+			})
+			if code != tt.want {
+				t.Errorf("Expected exit code %d, got %d", tt.want, code)
+			}
+			if printed := out != ""; printed != tt.wantOutput {
+				t.Errorf("Expected output %v, got %q", tt.wantOutput, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+const defaultWatchInterval = 15 * time.Minute
+
+var watchInterval time.Duration
+
+// watcher polls stored parcels and reports those whose last event changed
+type watcher struct {
+	out   io.Writer
+	fetch func() ([]*envoy.Parcel, error)
+	sync  func(groups map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error)
+	// The last event reported for each tracking number, seeded from the store
+	// the first time the parcel is seen so that old events are not reported
+	last map[string]*envoy.ParcelEvent
+}
+
+func newWatcher(out io.Writer) *watcher {
+	return &watcher{
+		out:   out,
+		fetch: fetchParcels,
+		sync:  syncParcels,
+		last:  make(map[string]*envoy.ParcelEvent),
+	}
+}
+
+// Report whether two events are the same scan
+func sameEvent(a, b *envoy.ParcelEvent) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Timestamp.Equal(b.Timestamp) &&
+		a.Type == b.Type &&
+		a.Description == b.Description &&
+		a.Location == b.Location
+}
+
+// Refresh every stored parcel which can still change, batched by carrier,
+// and print a line for each whose last event differs from the one last seen
+func (w *watcher) poll(ctx context.Context) error {
+	stored, err := w.fetch()
+	if err != nil {
+		return fmt.Errorf("error fetching parcels: %w", err)
+	}
+
+	groups := make(map[envoy.Carrier][]string)
+	var order []string
+	for _, p := range stored {
+		if _, ok := w.last[p.TrackingNumber]; !ok {
+			w.last[p.TrackingNumber] = p.LastTrackingEvent()
+		}
+		if isTerminal(p) {
+			continue
+		}
+		groups[p.Carrier] = append(groups[p.Carrier], p.TrackingNumber)
+		order = append(order, p.TrackingNumber)
+	}
+	if len(order) == 0 || ctx.Err() != nil {
+		return nil
+	}
+
+	fetched, err := w.sync(groups)
+	if err != nil {
+		return err
+	}
+	for _, tn := range order {
+		p, ok := fetched[tn]
+		if !ok {
+			continue
+		}
+		if p.HasError() {
+			log.Warnf("could not track %s: %v", tn, p.Error)
+			continue
+		}
+		e := p.LastTrackingEvent()
+		if e == nil || sameEvent(e, w.last[tn]) {
+			continue
+		}
+		w.last[tn] = e
+		annotated := annotateEvent(p, *e)
+		fmt.Fprintln(w.out, formatEventOneline(tn, &annotated))
+	}
+	return nil
+}
+
+func Watch(cmd *cobra.Command, args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	newPoller(watchInterval, newWatcher(os.Stdout).poll).run(ctx)
+}
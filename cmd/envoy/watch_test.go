@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestWatcherPrintsChangedEvents(t *testing.T) {
+	log = zap.NewNop().Sugar()
+
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	pickedUp := envoy.ParcelEvent{Type: envoy.ParcelEventTypePickedUp, Description: "Picked up", Location: "SEATTLE, WA", Timestamp: base}
+	arrived := envoy.ParcelEvent{Type: envoy.ParcelEventTypeArrived, Description: "Arrived at facility", Location: "FRESNO, CA", Timestamp: base.Add(6 * time.Hour)}
+	delivered := envoy.ParcelEvent{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Location: "LOS ANGELES, CA", Timestamp: base.Add(24 * time.Hour)}
+
+	withEvents := func(tn string, events ...envoy.ParcelEvent) *envoy.Parcel {
+		p := envoy.NewParcel(tn, envoy.CarrierUPS, tn, "")
+		p.Data = &envoy.ParcelData{
+			Delivered: events[len(events)-1].Type == envoy.ParcelEventTypeDelivered,
+			Events:    events,
+		}
+		return p
+	}
+
+	const (
+		moving = "1Z5R89390357567127"
+		idle   = "1ZW701150378674373"
+		done   = "1Z0000000000000001"
+	)
+	stored := map[string]*envoy.Parcel{
+		moving: withEvents(moving, pickedUp),
+		idle:   withEvents(idle, pickedUp),
+		done:   withEvents(done, pickedUp, delivered),
+	}
+	// Each poll the carrier reports one more event for the moving parcel
+	timeline := [][]envoy.ParcelEvent{
+		{pickedUp},
+		{pickedUp, arrived},
+		{pickedUp, arrived},
+		{pickedUp, arrived, delivered},
+	}
+
+	var (
+		out    bytes.Buffer
+		polls  int
+		synced [][]string
+	)
+	w := newWatcher(&out)
+	w.fetch = func() ([]*envoy.Parcel, error) {
+		return []*envoy.Parcel{stored[moving], stored[idle], stored[done]}, nil
+	}
+	w.sync = func(groups map[envoy.Carrier][]string) (map[string]*envoy.Parcel, error) {
+		synced = append(synced, groups[envoy.CarrierUPS])
+		fetched := map[string]*envoy.Parcel{}
+		for _, tn := range groups[envoy.CarrierUPS] {
+			switch tn {
+			case moving:
+				fetched[tn] = withEvents(tn, timeline[polls]...)
+			case idle:
+				fetched[tn] = withEvents(tn, pickedUp)
+			}
+			// Like syncParcels, the store reflects what was fetched
+			stored[tn] = fetched[tn]
+		}
+		polls++
+		return fetched, nil
+	}
+
+	var printed []string
+	for range timeline {
+		out.Reset()
+		if err := w.poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		printed = append(printed, out.String())
+	}
+
+	if printed[0] != "" || printed[2] != "" {
+		t.Errorf("Expected nothing printed while events are unchanged, got %q", printed)
+	}
+	for i, e := range map[int]envoy.ParcelEvent{1: arrived, 3: delivered} {
+		if strings.Count(printed[i], "\n") != 1 || !strings.Contains(printed[i], moving) || !strings.Contains(printed[i], e.Location) {
+			t.Errorf("Expected poll %d to print one line for %s at %s, got %q", i, moving, e.Location, printed[i])
+		}
+	}
+
+	// The delivered parcel is never refreshed, and neither is the moving one
+	// once it has been delivered
+	for i, tns := range synced {
+		for _, tn := range tns {
+			if tn == done {
+				t.Errorf("Expected delivered parcel not to be refreshed, but was in poll %d", i)
+			}
+		}
+	}
+	out.Reset()
+	if err := w.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if last := synced[len(synced)-1]; len(last) != 1 || last[0] != idle {
+		t.Errorf("Expected only %s to be refreshed after delivery, got %v", idle, last)
+	}
+}
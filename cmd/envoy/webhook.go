@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/retry"
+)
+
+// The header carrying the HMAC-SHA256 of a webhook body, keyed by the
+// configured secret, as "sha256=<hex>"
+const webhookSignatureHeader = "X-Envoy-Signature"
+
+// How long a command waits for webhooks still being delivered before exiting
+const webhookDrainTimeout = 10 * time.Second
+
+// Webhooks being delivered in the background
+var webhooks sync.WaitGroup
+
+// The body POSTed to the webhook when a parcel's status changes
+type webhookPayload struct {
+	TrackingNumber string        `json:"trackingNumber"`
+	Carrier        envoy.Carrier `json:"carrier"`
+	OldStatus      string        `json:"oldStatus"`
+	NewStatus      string        `json:"newStatus"`
+	Timestamp      time.Time     `json:"timestamp"`
+}
+
+// Returns the type of a parcel's last tracking event, or an empty string if
+// it has none
+func lastEventType(p *envoy.Parcel) envoy.ParcelEventType {
+	if e := p.LastTrackingEvent(); e != nil {
+		return e.Type
+	}
+	return ""
+}
+
+// Returns the payload describing a parcel's change from the status it had
+// before being refreshed, if it has changed
+func statusChange(before envoy.ParcelEventType, p *envoy.Parcel) (*webhookPayload, bool) {
+	e := p.LastTrackingEvent()
+	if e == nil || e.Type == before {
+		return nil, false
+	}
+	return &webhookPayload{
+		TrackingNumber: p.TrackingNumber,
+		Carrier:        p.Carrier,
+		OldStatus:      string(before),
+		NewStatus:      string(e.Type),
+		Timestamp:      e.Timestamp,
+	}, true
+}
+
+// Returns the signature of a webhook body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// POST a payload to the webhook, retrying transient failures
+func postWebhook(ctx context.Context, client *http.Client, url, secret string, payload *webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	res, err := retry.Do(ctx, 0, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set(webhookSignatureHeader, signWebhook(secret, body))
+		}
+		return client.Do(req)
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// Notify the configured webhook, if any, in the background if a parcel's
+// status has changed from before. Failures are only logged.
+func fireWebhook(before envoy.ParcelEventType, p *envoy.Parcel) {
+	if conf.WebhookURL == "" {
+		return
+	}
+	payload, ok := statusChange(before, p)
+	if !ok {
+		return
+	}
+
+	url, secret := conf.WebhookURL, conf.WebhookSecret
+	webhooks.Add(1)
+	go func() {
+		defer webhooks.Done()
+		client := &http.Client{Timeout: 10 * time.Second, Transport: httpTransport}
+		if err := postWebhook(context.Background(), client, url, secret, payload); err != nil {
+			log.Warnf("webhook for %s failed: %v", payload.TrackingNumber, err)
+		}
+	}()
+}
+
+// Wait up to timeout for webhooks being delivered in the background
+func drainWebhooks(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		webhooks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("gave up waiting for webhooks to be delivered")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	zone "github.com/lrstanley/bubblezone"
+	"go.uber.org/zap"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+func TestFireWebhook(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	defer func(c Config) { conf = c }(conf)
+
+	type request struct {
+		body      []byte
+		signature string
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{body: body, signature: r.Header.Get(webhookSignatureHeader)}
+	}))
+	defer srv.Close()
+	conf.WebhookURL = srv.URL
+	conf.WebhookSecret = "shh"
+
+	timestamp := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	p := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	p.Data = &envoy.ParcelData{
+		Events: []envoy.ParcelEvent{{Type: envoy.ParcelEventTypeDelivered, Timestamp: timestamp}},
+	}
+
+	fireWebhook(envoy.ParcelEventTypeDelivered, p)
+	fireWebhook(envoy.ParcelEventTypeOutForDelivery, p)
+	drainWebhooks(time.Second)
+
+	var req request
+	select {
+	case req = <-requests:
+	default:
+		t.Fatal("Expected a webhook request")
+	}
+	select {
+	case <-requests:
+		t.Error("Expected no webhook request without a status change")
+	default:
+	}
+
+	if want := signWebhook("shh", req.body); req.signature != want {
+		t.Errorf("Expected signature %s, got %s", want, req.signature)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := webhookPayload{
+		TrackingNumber: "441259201412",
+		Carrier:        envoy.CarrierFedEx,
+		OldStatus:      "OUT FOR DELIVERY",
+		NewStatus:      "DELIVERED",
+		Timestamp:      timestamp,
+	}
+	if payload != want {
+		t.Errorf("Expected payload %+v, got %+v", want, payload)
+	}
+}
+
+func TestTUIWebhookFiresOnce(t *testing.T) {
+	zone.NewGlobal()
+	log = zap.NewNop().Sugar()
+	openTestDB(t)
+	defer func(c Config) { conf = c }(conf)
+
+	var fired atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fired.Add(1)
+	}))
+	defer srv.Close()
+	conf.WebhookURL = srv.URL
+
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	inTransit := envoy.ParcelEvent{Type: envoy.ParcelEventTypeInTransit, Description: "In transit", Timestamp: timeNow}
+	delivered := envoy.ParcelEvent{Type: envoy.ParcelEventTypeDelivered, Description: "Delivered", Timestamp: timeNow.Add(time.Hour)}
+	stored := envoy.NewParcel("New shoes", envoy.CarrierFedEx, "441259201412", "")
+	stored.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{inTransit}}
+	if err := upsertParcel(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(m tea.Model) tea.Model {
+		t.Helper()
+		fetched := envoy.NewParcel(stored.TrackingNumber, envoy.CarrierFedEx, stored.TrackingNumber, "")
+		fetched.Data = &envoy.ParcelData{Events: []envoy.ParcelEvent{inTransit, delivered}}
+		m, _ = m.Update(fetchMsg{parcels: map[string]*envoy.Parcel{fetched.TrackingNumber: fetched}})
+		return m
+	}
+
+	fetch(fetch(initialModel(nil)))
+	// Launched again, the unchanged parcel is compared against what was stored
+	fetch(initialModel(nil))
+	drainWebhooks(time.Second)
+
+	if n := fired.Load(); n != 1 {
+		t.Errorf("Expected the change to fire one webhook, got %d", n)
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac shh
+	want := "sha256=9b7038c05edccf643d722b52dbaf2cea2b159caf339a5e12c0356e0b8b7b0794"
+	if got := signWebhook("shh", []byte("{}")); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestPostWebhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	err := postWebhook(context.Background(), srv.Client(), srv.URL, "", &webhookPayload{})
+	if err == nil {
+		t.Error("Expected an error for a rejected webhook")
+	}
+}
@@ -0,0 +1,59 @@
+package envoy
+
+import "strings"
+
+// Countries whose addresses put the postal code before the city, as in
+// "10115 Berlin"
+var postalCodeFirstCountries = map[string]bool{
+	"AT": true, "BE": true, "CH": true, "CZ": true, "DE": true, "DK": true,
+	"ES": true, "FI": true, "FR": true, "IT": true, "LU": true, "NL": true,
+	"NO": true, "PL": true, "PT": true, "SE": true,
+}
+
+// Countries with postal codes conventionally written without a region, as
+// in "London SW1A 1AA"
+var regionlessCountries = map[string]bool{
+	"GB": true, "IE": true,
+}
+
+// FormatLocality formats the city, region, and postal code of an address on
+// one line, in the order used by its country. The country code is appended
+// unless it is empty or "US", and the result is uppercased to match carrier
+// scan locations. It returns EmDash if every part is empty.
+func FormatLocality(city, region, postalCode, countryCode string) string {
+	countryCode = strings.ToUpper(strings.TrimSpace(countryCode))
+
+	var parts []string
+	switch {
+	case postalCodeFirstCountries[countryCode]:
+		// 10115 BERLIN, BE, DE
+		parts = appendNonEmpty(parts, joinNonEmpty(" ", postalCode, city), region)
+	case regionlessCountries[countryCode]:
+		// LONDON SW1A 1AA, GB
+		parts = appendNonEmpty(parts, joinNonEmpty(" ", city, postalCode))
+	default:
+		// SEATTLE, WA 98101 or CHIYODA-KU, TOKYO 100-0001, JP
+		parts = appendNonEmpty(parts, city, joinNonEmpty(" ", region, postalCode))
+	}
+	if countryCode != "US" {
+		parts = appendNonEmpty(parts, countryCode)
+	}
+
+	if len(parts) == 0 {
+		return EmDash
+	}
+	return strings.ToUpper(strings.Join(parts, ", "))
+}
+
+func appendNonEmpty(parts []string, values ...string) []string {
+	for _, v := range values {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return parts
+}
+
+func joinNonEmpty(sep string, values ...string) string {
+	return strings.Join(appendNonEmpty(nil, values...), sep)
+}
@@ -0,0 +1,39 @@
+package envoy
+
+import "testing"
+
+func TestFormatLocality(t *testing.T) {
+	tests := []struct {
+		name                                  string
+		city, region, postalCode, countryCode string
+		want                                  string
+	}{
+		{"US", "Seattle", "WA", "98101", "US", "SEATTLE, WA 98101"},
+		{"US without country", "Seattle", "WA", "98101", "", "SEATTLE, WA 98101"},
+		{"US state only", "", "WA", "", "US", "WA"},
+		{"Canada", "Toronto", "ON", "M5V 2T6", "CA", "TORONTO, ON M5V 2T6, CA"},
+		{"Germany", "Berlin", "", "10115", "DE", "10115 BERLIN, DE"},
+		{"Germany with region", "Leipzig", "SN", "04109", "de", "04109 LEIPZIG, SN, DE"},
+		{"United Kingdom", "London", "", "SW1A 1AA", "GB", "LONDON SW1A 1AA, GB"},
+		{"United Kingdom with county", "Reading", "Berkshire", "RG1 1AA", "GB", "READING RG1 1AA, GB"},
+		{"Japan", "Chiyoda-ku", "Tokyo", "100-0001", "JP", "CHIYODA-KU, TOKYO 100-0001, JP"},
+		{"country only", "", "", "", "DE", "DE"},
+		{"empty", "", "", "", "", EmDash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatLocality(tt.city, tt.region, tt.postalCode, tt.countryCode); got != tt.want {
+				t.Errorf("FormatLocality() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmDash(t *testing.T) {
+	// Guards against the dash being saved in the wrong encoding, which renders
+	// as "â€”"
+	if EmDash != "\u2014" {
+		t.Errorf("EmDash = %q, want %q", EmDash, "\u2014")
+	}
+}
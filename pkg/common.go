@@ -5,6 +5,25 @@ import (
 	"time"
 )
 
+// EmDash stands in for values that are not known, such as an address with
+// every part empty
+const EmDash = "—"
+
+// Debugf receives diagnostic output from carrier services. It discards
+// everything unless replaced by the caller.
+var Debugf = func(format string, args ...any) {}
+
+// RawJSON marshals a carrier response for debugging, returning nil if it
+// cannot be marshaled
+func RawJSON(v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		Debugf("could not marshal raw response: %v", err)
+		return nil
+	}
+	return data
+}
+
 type Dimensioned struct {
 	Units string `json:"units"`
 	Value string `json:"value"`
@@ -0,0 +1,264 @@
+package dhl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+var (
+	BaseURL, _ = url.Parse("https://api-eu.dhl.com")
+)
+
+type DHLService struct {
+	Client *http.Client
+	APIKey string
+}
+
+// Enforce that DHLService implements the Service interface
+var _ envoy.Service = &DHLService{}
+
+func NewDHLService(client *http.Client, apiKey string) *DHLService {
+	return &DHLService{
+		Client: client,
+		APIKey: apiKey,
+	}
+}
+
+// Reauthenticate only verifies that an API key is configured, as the Shipment
+// Tracking API authenticates each request with the key rather than a token
+func (s *DHLService) Reauthenticate() error {
+	if strings.TrimSpace(s.APIKey) == "" {
+		return fmt.Errorf("%w: no DHL API key configured", envoy.ErrAuth)
+	}
+	return nil
+}
+
+func (s *DHLService) Track(trackingNumbers []string) ([]*envoy.Parcel, error) {
+	responses, err := s.TrackRaw(trackingNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	var parcels []*envoy.Parcel
+	for _, res := range responses {
+		for _, shipment := range res.Shipments {
+			parcels = append(parcels, shipment.parcel())
+		}
+	}
+
+	return parcels, nil
+}
+
+func (s *DHLService) TrackRaw(trackingNumbers []string) ([]*TrackingResponse, error) {
+	const endpoint = "/track/shipments"
+
+	if err := s.Reauthenticate(); err != nil {
+		return nil, err
+	}
+
+	// Requests are made sequentially, as the API is heavily rate limited
+	var responses []*TrackingResponse
+	for _, tn := range trackingNumbers {
+		u := BaseURL.JoinPath(endpoint)
+		u.RawQuery = url.Values{"trackingNumber": []string{tn}}.Encode()
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return responses, err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("DHL-API-Key", s.APIKey)
+
+		res, err := s.Client.Do(req)
+		if err != nil {
+			return responses, err
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return responses, err
+		}
+		if err := envoy.CheckJSONResponse(envoy.CarrierDHL, res, body); err != nil {
+			return responses, err
+		}
+
+		switch res.StatusCode {
+		case http.StatusOK:
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return responses, fmt.Errorf("%w: DHL rejected the API key (status %d)", envoy.ErrAuth, res.StatusCode)
+		case http.StatusNotFound:
+			envoy.Debugf("no DHL shipment found for %s", tn)
+			continue
+		default:
+			envoy.Debugf("unexpected status code %d tracking %s", res.StatusCode, tn)
+			continue
+		}
+
+		var trackingRes TrackingResponse
+		if err := json.Unmarshal(body, &trackingRes); err != nil {
+			envoy.Debugf("failed to unmarshal response for %s: %v", tn, err)
+			continue
+		}
+		responses = append(responses, &trackingRes)
+	}
+
+	return responses, nil
+}
+
+func (s *Shipment) parcel() *envoy.Parcel {
+	p := &envoy.Parcel{
+		Name:           s.ID,
+		Carrier:        envoy.CarrierDHL,
+		TrackingNumber: s.ID,
+		TrackingURL:    envoy.TrackingURL(envoy.CarrierDHL, s.ID),
+		Data:           &envoy.ParcelData{ServiceLevel: s.serviceLevel()},
+		Raw:            envoy.RawJSON(s),
+	}
+	if s.EstimatedTimeOfDelivery != nil {
+		p.Data.DeliveryProjection = &s.EstimatedTimeOfDelivery.Time
+	}
+
+	for _, e := range s.Events {
+		p.Data.Events = append(p.Data.Events, envoy.ParcelEvent{
+			Type:          e.ParcelEventType(),
+			Description:   e.description(),
+			Location:      e.Location.String(),
+			Timestamp:     e.Timestamp.Time,
+			SourceCarrier: envoy.CarrierDHL,
+		})
+	}
+	envoy.DefaultDeliveredStrategy.Resolve(p, s.Status != nil && s.Status.StatusCode == StatusCodeDelivered)
+
+	return p
+}
+
+// serviceNames maps the DHL services a shipment can be tracked with to the
+// names they are marketed under
+var serviceNames = map[string]string{
+	"express":            "DHL Express",
+	"parcel-de":          "DHL Paket",
+	"parcel-nl":          "DHL Parcel",
+	"parcel-pl":          "DHL Parcel",
+	"parcel-uk":          "DHL Parcel UK",
+	"ecommerce":          "DHL eCommerce",
+	"ecommerce-apac":     "DHL eCommerce",
+	"ecommerce-europe":   "DHL eCommerce",
+	"freight":            "DHL Freight",
+	"dgf":                "DHL Global Forwarding",
+	"post-de":            "Deutsche Post",
+	"sameday":            "DHL Same Day",
+	"svb":                "DHL Supply Chain",
+	"ecommerce-ppl":      "PPL",
+	"ecommerce-iberia":   "DHL eCommerce",
+	"post-international": "Deutsche Post International",
+}
+
+// serviceLevel returns the name DHL markets the shipment's service under,
+// falling back to the service as DHL reports it
+func (s *Shipment) serviceLevel() string {
+	if name, ok := serviceNames[strings.ToLower(s.Service)]; ok {
+		return name
+	}
+	return s.Service
+}
+
+// https://developer.dhl.com/api-reference/shipment-tracking
+type TrackingResponse struct {
+	Shipments []*Shipment `json:"shipments"`
+}
+
+type Shipment struct {
+	ID                      string               `json:"id"`
+	Service                 string               `json:"service"`
+	Origin                  *Place               `json:"origin"`
+	Destination             *Place               `json:"destination"`
+	Status                  *Event               `json:"status"`
+	EstimatedTimeOfDelivery *envoy.LocalDateTime `json:"estimatedTimeOfDelivery"`
+	Events                  []*Event             `json:"events"`
+}
+
+type Place struct {
+	Address *Address `json:"address"`
+}
+
+func (p *Place) String() string {
+	if p == nil || p.Address == nil {
+		return ""
+	}
+	return p.Address.String()
+}
+
+type Address struct {
+	CountryCode     string `json:"countryCode"`
+	PostalCode      string `json:"postalCode"`
+	AddressLocality string `json:"addressLocality"`
+}
+
+func (a *Address) String() string {
+	parts := make([]string, 0, 2)
+	if a.AddressLocality != "" {
+		parts = append(parts, a.AddressLocality)
+	}
+	if a.CountryCode != "" {
+		parts = append(parts, a.CountryCode)
+	}
+	return strings.Join(parts, ", ")
+}
+
+type Event struct {
+	Timestamp   envoy.LocalDateTime `json:"timestamp"`
+	Location    *Place              `json:"location"`
+	StatusCode  StatusCode          `json:"statusCode"`
+	Status      string              `json:"status"`
+	Description string              `json:"description"`
+	Remark      string              `json:"remark"`
+}
+
+func (e *Event) description() string {
+	if e.Description != "" {
+		return e.Description
+	}
+	return e.Status
+}
+
+func (e *Event) ParcelEventType() envoy.ParcelEventType {
+	switch e.StatusCode {
+	case StatusCodePreTransit:
+		return envoy.ParcelEventTypeOrderConfirmed
+	case StatusCodeTransit:
+		desc := strings.ToLower(e.description())
+		switch {
+		case strings.Contains(desc, "out for delivery"):
+			return envoy.ParcelEventTypeOutForDelivery
+		case strings.Contains(desc, "picked up"):
+			return envoy.ParcelEventTypePickedUp
+		case strings.Contains(desc, "arrived"):
+			return envoy.ParcelEventTypeArrived
+		case strings.Contains(desc, "departed"):
+			return envoy.ParcelEventTypeDeparted
+		default:
+			return envoy.ParcelEventTypeInTransit
+		}
+	case StatusCodeDelivered:
+		return envoy.ParcelEventTypeDelivered
+	case StatusCodeFailure:
+		return envoy.ParcelEventTypeException
+	default:
+		return envoy.ParcelEventTypeUnknown
+	}
+}
+
+type StatusCode string
+
+const (
+	StatusCodePreTransit StatusCode = "pre-transit"
+	StatusCodeTransit    StatusCode = "transit"
+	StatusCodeDelivered  StatusCode = "delivered"
+	StatusCodeFailure    StatusCode = "failure"
+	StatusCodeUnknown    StatusCode = "unknown"
+)
@@ -0,0 +1,91 @@
+package dhl
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rektdeckard/envoy/pkg"
+)
+
+func TestTrackingResponseUnmarshal(t *testing.T) {
+	data, err := os.ReadFile("testdata/shipment.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res TrackingResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+	if len(res.Shipments) != 1 {
+		t.Fatalf("Expected 1 shipment, got %d", len(res.Shipments))
+	}
+
+	p := res.Shipments[0].parcel()
+	if p.TrackingNumber != "7777777770" || p.Carrier != envoy.CarrierDHL {
+		t.Errorf("Expected DHL parcel 7777777770, got %s %s", p.Carrier, p.TrackingNumber)
+	}
+	if !p.Data.Delivered {
+		t.Error("Expected parcel to be delivered")
+	}
+	if p.Data.DeliveryProjection == nil {
+		t.Error("Expected a delivery projection")
+	}
+
+	want := []envoy.ParcelEventType{
+		envoy.ParcelEventTypeDelivered,
+		envoy.ParcelEventTypeInTransit,
+		envoy.ParcelEventTypeDeparted,
+		envoy.ParcelEventTypeOrderConfirmed,
+	}
+	if len(p.Data.Events) != len(want) {
+		t.Fatalf("Expected %d events, got %d", len(want), len(p.Data.Events))
+	}
+	for i, w := range want {
+		if got := p.Data.Events[i].Type; got != w {
+			t.Errorf("event %d: expected %s, got %s", i, w, got)
+		}
+	}
+
+	e := p.Data.Events[0]
+	if want := time.Date(2025, 2, 27, 10, 41, 0, 0, time.UTC); !e.Timestamp.Equal(want) {
+		t.Errorf("Expected timestamp %v, got %v", want, e.Timestamp)
+	}
+	if e.Location != "LOS ANGELES, CA - USA" {
+		t.Errorf("Expected location to be set, got %q", e.Location)
+	}
+	if last := p.LastTrackingEvent(); last == nil || last.Type != envoy.ParcelEventTypeDelivered {
+		t.Errorf("Expected last event to be delivered, got %+v", last)
+	}
+}
+
+func TestReauthenticateRequiresKey(t *testing.T) {
+	if err := NewDHLService(nil, "").Reauthenticate(); err == nil {
+		t.Error("Expected an error without an API key")
+	}
+	if err := NewDHLService(nil, "key").Reauthenticate(); err != nil {
+		t.Errorf("Expected no error with an API key, got %v", err)
+	}
+}
+
+func TestShipmentServiceLevel(t *testing.T) {
+	tests := []struct {
+		service string
+		want    string
+	}{
+		{"express", "DHL Express"},
+		{"parcel-de", "DHL Paket"},
+		{"ecommerce", "DHL eCommerce"},
+		{"unknown-service", "unknown-service"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		s := &Shipment{ID: "1234567890", Service: tt.service}
+		if got := s.parcel().Data.ServiceLevel; got != tt.want {
+			t.Errorf("ServiceLevel for %q = %q, want %q", tt.service, got, tt.want)
+		}
+	}
+}
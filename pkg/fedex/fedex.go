@@ -2,7 +2,9 @@ package fedex
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/retry"
 )
 
 var (
@@ -22,16 +25,24 @@ type FedexService struct {
 	APIKey    string
 	APISecret string
 	Token     *Token
+	// The number of attempts made for tracking requests which fail transiently
+	MaxAttempts int
 }
 
-// Enforce that FedexService implements the Service interface
-var _ envoy.Service = &FedexService{}
+// Enforce that FedexService implements the Service, TokenCacher, and
+// ProofOfDeliveryService interfaces
+var (
+	_ envoy.Service                = &FedexService{}
+	_ envoy.TokenCacher            = &FedexService{}
+	_ envoy.ProofOfDeliveryService = &FedexService{}
+)
 
 func NewFedexService(client *http.Client, apiKey, apiSecret string) *FedexService {
 	return &FedexService{
-		Client:    client,
-		APIKey:    apiKey,
-		APISecret: apiSecret,
+		Client:      client,
+		APIKey:      apiKey,
+		APISecret:   apiSecret,
+		MaxAttempts: retry.DefaultAttempts,
 	}
 }
 
@@ -61,6 +72,9 @@ func (s *FedexService) Reauthenticate() error {
 	if err != nil {
 		return err
 	}
+	if err := envoy.CheckJSONResponse(envoy.CarrierFedEx, res, body); err != nil {
+		return err
+	}
 
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
@@ -75,13 +89,26 @@ func (s *FedexService) Reauthenticate() error {
 	return nil
 }
 
+// authenticate requests a new token when none is cached or the cached one has
+// expired, guarding against a response which parsed without issuing one
+func (s *FedexService) authenticate() error {
+	if s.Token.IsValid() {
+		return nil
+	}
+	if err := s.Reauthenticate(); err != nil {
+		return err
+	}
+	if s.Token == nil || s.Token.Value == "" {
+		return fmt.Errorf("%w: FedEx issued no access token", envoy.ErrAuth)
+	}
+	return nil
+}
+
 func (s *FedexService) TrackRaw(trackingNumbers []string) (*TrackingResponse, error) {
 	const endpoint = "/track/v1/trackingnumbers"
 
-	if s.Token == nil || !s.Token.IsValid() {
-		if err := s.Reauthenticate(); err != nil {
-			return nil, err
-		}
+	if err := s.authenticate(); err != nil {
+		return nil, err
 	}
 
 	data := newTrackingRequest(trackingNumbers)
@@ -91,16 +118,18 @@ func (s *FedexService) TrackRaw(trackingNumbers []string) (*TrackingResponse, er
 	}
 
 	url := BaseURL.JoinPath(endpoint)
-	req, err := http.NewRequest(http.MethodPost, url.String(), bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, err
-	}
+	res, err := retry.Do(context.Background(), s.MaxAttempts, func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, url.String(), bytes.NewBuffer(reqBody))
+		if err != nil {
+			return nil, err
+		}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.Token.Value)
-	req.Header.Set("x-locale", "en_US")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+s.Token.Value)
+		req.Header.Set("x-locale", "en_US")
 
-	res, err := s.Client.Do(req)
+		return s.Client.Do(req)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -111,6 +140,9 @@ func (s *FedexService) TrackRaw(trackingNumbers []string) (*TrackingResponse, er
 	if err != nil {
 		return nil, err
 	}
+	if err := envoy.CheckJSONResponse(envoy.CarrierFedEx, res, body); err != nil {
+		return nil, err
+	}
 
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
@@ -129,44 +161,310 @@ func (s *FedexService) Track(trackingNumbers []string) ([]*envoy.Parcel, error)
 		return nil, err
 	}
 
+	output := trackingRes.Output
+	if output == nil {
+		return nil, nil
+	}
+	if len(output.CompleteTrackResults) == 0 && len(output.Alerts) > 0 {
+		errs := make([]error, 0, len(output.Alerts))
+		for _, a := range output.Alerts {
+			errs = append(errs, a)
+		}
+		return nil, errors.Join(errs...)
+	}
+
 	var parcels []*envoy.Parcel
-	for _, r := range trackingRes.Output.CompleteTrackResults {
-		parcel := envoy.Parcel{
-			Name:           r.TrackingNumer, // TODO: derive name
-			Carrier:        envoy.CarrierFedEx,
-			TrackingNumber: r.TrackingNumer,
-			TrackingURL: fmt.Sprintf(
-				"https://www.fedex.com/apps/fedextrack/?tracknumbers=%s",
-				r.TrackingNumer,
-			),
-			Data: &envoy.ParcelData{},
+	for _, r := range output.CompleteTrackResults {
+		p := r.parcel()
+		// Alerts concern the whole request, so are noted on every parcel
+		for _, a := range output.Alerts {
+			p.Data.Notices = append(p.Data.Notices, envoy.ParcelNotice{Code: a.Code, Message: a.Message})
+		}
+		parcels = append(parcels, p)
+	}
+
+	return parcels, nil
+}
+
+// ProofOfDelivery fetches the signature proof of delivery letter of a
+// shipment, if FedEx lists one among its available images
+func (s *FedexService) ProofOfDelivery(trackingNumber string) (*envoy.ProofOfDelivery, error) {
+	const endpoint = "/track/v1/trackingdocuments"
+
+	trackingRes, err := s.TrackRaw([]string{trackingNumber})
+	if err != nil {
+		return nil, err
+	}
+	if !trackingRes.hasProofOfDelivery() {
+		return nil, envoy.ErrNoProofOfDelivery
+	}
+
+	reqBody, err := json.Marshal(newDocumentRequest(trackingNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	url := BaseURL.JoinPath(endpoint)
+	res, err := retry.Do(context.Background(), s.MaxAttempts, func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, url.String(), bytes.NewBuffer(reqBody))
+		if err != nil {
+			return nil, err
 		}
 
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+s.Token.Value)
+		req.Header.Set("x-locale", "en_US")
+
+		return s.Client.Do(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := envoy.CheckJSONResponse(envoy.CarrierFedEx, res, body); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var docRes documentResponse
+	if err := json.Unmarshal(body, &docRes); err != nil {
+		return nil, err
+	}
+	if docRes.Output == nil {
+		return nil, envoy.ErrNoProofOfDelivery
+	}
+	for _, doc := range docRes.Output.Document {
+		if doc != "" {
+			return envoy.DecodeProofOfDelivery(doc)
+		}
+	}
+	return nil, envoy.ErrNoProofOfDelivery
+}
+
+// hasProofOfDelivery reports whether any result lists a proof of delivery
+// among its available images
+func (r *TrackingResponse) hasProofOfDelivery() bool {
+	if r.Output == nil {
+		return false
+	}
+	for _, c := range r.Output.CompleteTrackResults {
+		for _, tr := range c.TrackResults {
+			for _, img := range tr.AvailableImages {
+				if img != nil && img.Type == ImageTypeProodOfDelivery {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (r *CompleteTrackResult) parcel() *envoy.Parcel {
+	parcel := envoy.Parcel{
+		Name:           r.name(),
+		Carrier:        envoy.CarrierFedEx,
+		TrackingNumber: r.TrackingNumer,
+		TrackingURL:    envoy.TrackingURL(envoy.CarrierFedEx, r.TrackingNumer),
+		Data:           &envoy.ParcelData{},
+		Raw:            envoy.RawJSON(r),
+	}
+
+	delivered := false
+	for _, r := range r.TrackResults {
+		// A number FedEx could not track has a result holding only the error
+		if r.Error != nil && parcel.Error == nil {
+			parcel.Error = r.Error
+		}
+		if parcel.ShipmentID == "" && r.TrackingNumberInfo != nil {
+			parcel.ShipmentID = r.TrackingNumberInfo.TrackingNumberUniqueId
+		}
+		parcel.Data.Notices = append(parcel.Data.Notices, r.notices()...)
+		if parcel.Data.DeliveryProjection == nil {
+			parcel.Data.DeliveryProjection = r.deliveryProjection()
+		}
+		if parcel.Data.Weight == nil && parcel.Data.Dimensions == nil {
+			parcel.Data.Weight, parcel.Data.Dimensions = r.weightAndDimensions()
+		}
+		if parcel.Data.ServiceLevel == "" && r.ServiceDetail != nil {
+			parcel.Data.ServiceLevel = r.ServiceDetail.serviceLevel()
+		}
+		if r.ScanEvents == nil || len(r.ScanEvents) == 0 {
+			continue
+		}
+		for _, e := range r.ScanEvents {
+			if e.isDelivered() {
+				delivered = true
+			}
+			parcel.Data.Events = append(parcel.Data.Events, envoy.ParcelEvent{
+				Timestamp:     e.Date.Time,
+				Description:   e.EventDescription,
+				Location:      e.ScanLocation.String(),
+				Type:          e.EventType.ParcelEventType(),
+				SourceCarrier: envoy.CarrierFedEx,
+			})
+		}
+	}
+	envoy.DefaultDeliveredStrategy.Resolve(&parcel, delivered)
+	if parcel.Data.Delivered {
 		for _, r := range r.TrackResults {
-			if r.ScanEvents == nil || len(r.ScanEvents) == 0 {
-				continue
+			if d := r.DeliveryDetails; d != nil {
+				parcel.Data.ReceivedBy = strings.TrimSpace(d.ReceivedByName)
+				parcel.Data.SignedBy = strings.TrimSpace(d.SignedByName)
+				break
 			}
-			var lastEvent *ScanEvent
-			for _, e := range r.ScanEvents {
-				if lastEvent == nil || e.Date.Time.After(lastEvent.Date.Time) {
-					lastEvent = e
-				}
-				if e.EventType == "DL" {
-					parcel.Data.Delivered = true
-				}
-				parcel.Data.Events = append(parcel.Data.Events, envoy.ParcelEvent{
-					Timestamp:   e.Date.Time,
-					Description: e.EventDescription,
-					Location:    e.ScanLocation.String(),
-					Type:        e.EventType.ParcelEventType(),
-				})
+		}
+		for _, r := range r.TrackResults {
+			if name, ok := r.accessPoint(); ok {
+				parcel.Data.DeliveredToAccessPoint = true
+				parcel.Data.AccessPoint = name
+				break
 			}
 		}
+	}
+
+	return &parcel
+}
+
+// name returns the nickname the shipper gave the package, falling back to the
+// service it was shipped with and then the tracking number
+func (r *CompleteTrackResult) name() string {
+	for _, res := range r.TrackResults {
+		if i := res.AdditionalTrackingInfo; i != nil && strings.TrimSpace(i.Nickname) != "" {
+			return strings.TrimSpace(i.Nickname)
+		}
+	}
+	for _, res := range r.TrackResults {
+		if d := res.ServiceDetail; d != nil && strings.TrimSpace(d.Description) != "" {
+			return strings.TrimSpace(d.Description)
+		}
+	}
+	return r.TrackingNumer
+}
 
-		parcels = append(parcels, &parcel)
+// deliveryProjection returns the estimated delivery time, falling back to the
+// end of the estimated delivery window, or nil if neither is known
+func (r *TrackResults) deliveryProjection() *time.Time {
+	for _, dt := range r.DateAndTimes {
+		if dt == nil || dt.Type != TrackingEventTypeEstimatedDelivery {
+			continue
+		}
+		if t, err := dt.Time(); err == nil {
+			return &t
+		}
+		envoy.Debugf("error parsing estimated delivery %q", dt.DateTime)
 	}
 
-	return parcels, nil
+	if w := r.EstimatedDeliveryTimeWindow; w != nil {
+		for _, t := range []time.Time{w.Window.Ends, w.Window.Begins} {
+			if !t.IsZero() {
+				return &t
+			}
+		}
+	}
+	return nil
+}
+
+// accessPoint reports whether the shipment was delivered to a FedEx location
+// or held for pickup instead of to the recipient, along with the name of the
+// location if it is known
+func (r *TrackResults) accessPoint() (string, bool) {
+	if d := r.DeliveryDetails; d != nil && d.LocationType == LocationTypeFedexLocation {
+		return strings.TrimSpace(d.LocationDescription), true
+	}
+	if h := r.HoldAtLocation; h != nil {
+		if a := h.LocationContactAndAddress.Address; a != nil {
+			return a.String(), true
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// weightAndDimensions returns the first weight and dimensions reported for
+// the package, falling back to the weight of the whole shipment, or nil for
+// either if it is not known
+func (r *TrackResults) weightAndDimensions() (*envoy.Dimensioned, *envoy.Size) {
+	var weights []envoy.Dimensioned
+	var dimensions []envoy.Size
+	if d := r.PackageDetails; d != nil && d.WeightAndDimensions != nil {
+		weights = d.WeightAndDimensions.Weight
+		dimensions = d.WeightAndDimensions.Dimensions
+	}
+	if len(weights) == 0 && r.ShipmentDetails != nil {
+		weights = r.ShipmentDetails.Weight
+	}
+
+	var weight *envoy.Dimensioned
+	for _, w := range weights {
+		if w.Value != "" {
+			weight = &w
+			break
+		}
+	}
+	var size *envoy.Size
+	for _, d := range dimensions {
+		if d.Length > 0 && d.Width > 0 && d.Height > 0 {
+			size = &d
+			break
+		}
+	}
+	return weight, size
+}
+
+func (r *TrackResults) notices() []envoy.ParcelNotice {
+	var notices []envoy.ParcelNotice
+	if r.ServiceCommitMessage.Message != "" {
+		notices = append(notices, envoy.ParcelNotice{
+			Code:    string(r.ServiceCommitMessage.Type),
+			Message: r.ServiceCommitMessage.Message,
+		})
+	}
+	for _, n := range r.InformationNotes {
+		if n != nil && n.Description != "" {
+			notices = append(notices, envoy.ParcelNotice{Code: n.Code, Message: n.Description})
+		}
+	}
+	// Explain why a parcel is held, excepted, or returned
+	if d := r.ReasonDetail; d != nil && d.Description != "" {
+		code := d.Type
+		if code == "" {
+			code = "REASON"
+		}
+		notices = append(notices, envoy.ParcelNotice{Code: code, Message: d.Description})
+	}
+	if d := r.ReturnDetail; d != nil && d.ReasonDetail.Description != "" {
+		msg := d.ReasonDetail.Description
+		if d.AuthorizationName != "" {
+			msg += " (authorized by " + d.AuthorizationName + ")"
+		}
+		notices = append(notices, envoy.ParcelNotice{Code: "RETURN", Message: msg})
+	}
+	if s := r.LastStatusDetail; s != nil {
+		for _, a := range s.AncillaryDetails {
+			if a == nil {
+				continue
+			}
+			var parts []string
+			for _, p := range []string{a.ReasonDesctiption, a.ActionDescription} {
+				if p = strings.TrimSpace(p); p != "" {
+					parts = append(parts, p)
+				}
+			}
+			if len(parts) > 0 {
+				notices = append(notices, envoy.ParcelNotice{Code: "EXCEPTION", Message: strings.Join(parts, " — ")})
+			}
+		}
+	}
+	return notices
 }
 
 type request struct {
@@ -205,6 +503,41 @@ func newTrackingRequest(trackingNumbers []string) *request {
 	return tr
 }
 
+// https://developer.fedex.com/api/en-us/catalog/track/v1/docs.html#operation/Track%20Document
+type documentRequest struct {
+	TrackDocumentDetail        *trackDocumentDetail `json:"trackDocumentDetail"`
+	TrackDocumentSpecification []*trackingInfo      `json:"trackDocumentSpecification"`
+}
+
+type trackDocumentDetail struct {
+	DocumentType   string `json:"documentType"`
+	DocumentFormat string `json:"documentFormat"`
+}
+
+func newDocumentRequest(trackingNumber string) *documentRequest {
+	return &documentRequest{
+		TrackDocumentDetail: &trackDocumentDetail{
+			DocumentType:   "SIGNATURE_PROOF_OF_DELIVERY",
+			DocumentFormat: "PNG",
+		},
+		TrackDocumentSpecification: []*trackingInfo{
+			{TrackingNumberInfo: &TrackingNumberInfo{TrackingNumber: trackingNumber}},
+		},
+	}
+}
+
+type documentResponse struct {
+	TransactionId string          `json:"transactionId"`
+	Output        *documentOutput `json:"output"`
+}
+
+type documentOutput struct {
+	DocumentType   string `json:"documentType"`
+	DocumentFormat string `json:"documentFormat"`
+	// Base64 encoded documents
+	Document []string `json:"document"`
+}
+
 // https://developer.fedex.com/api/en-us/catalog/track/v1/docs.html#operation/Track%20by%20Tracking%20Number
 type TrackingResponse struct {
 	TransactionId         string          `json:"transactionId"`
@@ -365,6 +698,15 @@ type ServiceDetail struct {
 	Type             ServiceType `json:"type"`
 }
 
+// serviceLevel returns the name FedEx markets the service under, falling back
+// to the description FedEx gave it
+func (d *ServiceDetail) serviceLevel() string {
+	if name, ok := serviceTypeNames[d.Type]; ok {
+		return name
+	}
+	return strings.TrimSpace(d.Description)
+}
+
 type ServiceType string
 
 // https://developer.fedex.com/api/en-us/guides/api-reference.html#servicetypes
@@ -396,6 +738,34 @@ const (
 	ServiceTypeFedexSameDayCity                       ServiceType = "SAME_DAY_CITY"
 )
 
+var serviceTypeNames = map[ServiceType]string{
+	ServiceTypeFedexInternationalPriorityExpress:      "FedEx International Priority Express",
+	ServiceTypeFedexInternationalFirst:                "FedEx International First",
+	ServiceTypeFedexInternationalPriority:             "FedEx International Priority",
+	ServiceTypeFedexInternationalEconomy:              "FedEx International Economy",
+	ServiceTypeFedexGround:                            "FedEx Ground",
+	ServiceTypeFedexFirstOvernight:                    "FedEx First Overnight",
+	ServiceTypeFedexFirstOvernightFreight:             "FedEx First Overnight Freight",
+	ServiceTypeFedex1DayFreight:                       "FedEx 1Day Freight",
+	ServiceTypeFedex2DayFreight:                       "FedEx 2Day Freight",
+	ServiceTypeFedex3DayFreight:                       "FedEx 3Day Freight",
+	ServiceTypeFedexInternationalPriorityFreight:      "FedEx International Priority Freight",
+	ServiceTypeFedexInternationalEconomyFreight:       "FedEx International Economy Freight",
+	ServiceTypeFedexInternationalDeferredFreight:      "FedEx International Deferred Freight",
+	ServiceTypeFedexInternationalPriorityDistribution: "FedEx International Priority DirectDistribution",
+	ServiceTypeFedexInternationalDistributionFreight:  "FedEx International Priority DirectDistribution Freight",
+	ServiceTypeInternationalGroundDistribution:        "FedEx International Ground Distribution",
+	ServiceTypeFedexHomeDelivery:                      "FedEx Home Delivery",
+	ServiceTypeFedexGroundEconomy:                     "FedEx Ground Economy",
+	ServiceTypeFedexPriorityOvernight:                 "FedEx Priority Overnight",
+	ServiceTypeFedexStandardOvernight:                 "FedEx Standard Overnight",
+	ServiceTypeFedex2Day:                              "FedEx 2Day",
+	ServiceTypeFedex2DayAM:                            "FedEx 2Day A.M.",
+	ServiceTypeFedexExpressSaver:                      "FedEx Express Saver",
+	ServiceTypeFedexSameDay:                           "FedEx SameDay",
+	ServiceTypeFedexSameDayCity:                       "FedEx SameDay City",
+}
+
 type DestinationLocation struct {
 	LocationId                string                     `json:"locationId"`
 	LocationContactAndAddress *LocationContactAndAddress `json:"locationContactAndAddress"`
@@ -479,30 +849,7 @@ type Address struct {
 }
 
 func (a *Address) String() string {
-	sb := strings.Builder{}
-	if a.City != "" {
-		sb.WriteString(a.City)
-		if a.StateOrProvinceCode != "" {
-			sb.WriteString(", ")
-		}
-	}
-	sb.WriteString(a.StateOrProvinceCode)
-	if a.PostalCode != "" {
-		if sb.Len() > 0 {
-			sb.WriteString(" ")
-		}
-		sb.WriteString(a.PostalCode)
-	}
-	if a.CountryCode != "US" {
-		if sb.Len() > 0 {
-			sb.WriteString(", ")
-		}
-		sb.WriteString(a.CountryCode)
-	}
-	if sb.Len() == 0 {
-		return "—"
-	}
-	return strings.ToUpper(sb.String())
+	return envoy.FormatLocality(a.City, a.StateOrProvinceCode, a.PostalCode, a.CountryCode)
 }
 
 type AncillaryDetail struct {
@@ -579,6 +926,10 @@ type ErrorInfo struct {
 	Message       string         `json:"message"`
 }
 
+func (e *ErrorInfo) Error() string {
+	return fmt.Sprintf("FedEx error %s: %s", e.Code, e.Message)
+}
+
 type SpecialHandling struct {
 	Type        string `json:"type"`
 	PaymentType string `json:"paymentType"`
@@ -669,6 +1020,10 @@ type ScanEvent struct {
 	DelayDetail          *DelayDetail        `json:"delayDetail"`
 }
 
+func (e *ScanEvent) isDelivered() bool {
+	return e.EventType == "DL"
+}
+
 type ScanLocationType string
 
 const (
@@ -700,6 +1055,14 @@ type DateAndTime struct {
 	Type     TrackingEventType `json:"type"`
 }
 
+// Time parses the date and time, which may omit its offset
+func (d *DateAndTime) Time() (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, d.DateTime); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05", d.DateTime)
+}
+
 type TrackingEventType string
 
 const (
@@ -850,13 +1213,30 @@ type Alert struct {
 	Message string `json:"message"`
 }
 
+func (a *Alert) Error() string {
+	return fmt.Sprintf("FedEx alert %s: %s", a.Code, a.Message)
+}
+
 type Token struct {
 	Value      string
 	Expiration time.Time
 }
 
+// IsValid reports whether the token can still be sent, and is safe to call on
+// a nil token before the first authentication
 func (t *Token) IsValid() bool {
-	return t.Expiration.After(time.Now())
+	return t != nil && t.Value != "" && t.Expiration.After(time.Now())
+}
+
+func (s *FedexService) CachedToken() *envoy.CachedToken {
+	if s.Token == nil {
+		return nil
+	}
+	return &envoy.CachedToken{Value: s.Token.Value, Expiration: s.Token.Expiration}
+}
+
+func (s *FedexService) SetCachedToken(token *envoy.CachedToken) {
+	s.Token = &Token{Value: token.Value, Expiration: token.Expiration}
 }
 
 func (t *Token) UnmarshalJSON(data []byte) error {
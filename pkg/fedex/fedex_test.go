@@ -0,0 +1,460 @@
+package fedex
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/retry"
+	"github.com/rektdeckard/envoy/pkg/testutil"
+)
+
+func TestCompleteTrackResultDelivered(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		eventTypes []EventType
+		want       bool
+	}{
+		{"in transit", []EventType{"OC", "PU", "IT"}, false},
+		{"delivered", []EventType{"OC", "PU", "OD", "DL"}, true},
+		{"exception", []EventType{"OC", "SE"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []*ScanEvent
+			for i, et := range tt.eventTypes {
+				events = append(events, &ScanEvent{
+					Date:         envoy.LocalDateTime{Time: timeNow.Add(time.Duration(i) * time.Hour)},
+					EventType:    et,
+					ScanLocation: &Address{},
+				})
+			}
+			r := &CompleteTrackResult{
+				TrackingNumer: "441259201412",
+				TrackResults:  []*TrackResults{{ScanEvents: events}},
+			}
+
+			if got := r.parcel().Data.Delivered; got != tt.want {
+				t.Errorf("Delivered = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackRawRetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"output":{"completeTrackResults":[{"trackingNumber":"441259201412"}]}}`))
+	}))
+	defer srv.Close()
+
+	baseURL, baseDelay := BaseURL, retry.BaseDelay
+	BaseURL, _ = url.Parse(srv.URL)
+	retry.BaseDelay = time.Millisecond
+	defer func() { BaseURL, retry.BaseDelay = baseURL, baseDelay }()
+
+	s := NewFedexService(srv.Client(), "key", "secret")
+	s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+	res, err := s.TrackRaw([]string{"441259201412"})
+	if err != nil {
+		t.Fatalf("TrackRaw() error = %v", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected 3 requests, got %d", got)
+	}
+	if len(res.Output.CompleteTrackResults) != 1 {
+		t.Errorf("Expected 1 result, got %d", len(res.Output.CompleteTrackResults))
+	}
+}
+
+func TestTrackRawHTMLResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<!DOCTYPE html><html><body><h1>Scheduled maintenance</h1></body></html>`))
+	}))
+	defer srv.Close()
+
+	baseURL := BaseURL
+	BaseURL, _ = url.Parse(srv.URL)
+	defer func() { BaseURL = baseURL }()
+
+	s := NewFedexService(srv.Client(), "key", "secret")
+	s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+	_, err := s.TrackRaw([]string{"441259201412"})
+	if !errors.Is(err, envoy.ErrUpstream) {
+		t.Fatalf("TrackRaw() error = %v, want %v", err, envoy.ErrUpstream)
+	}
+	if !strings.Contains(err.Error(), "Scheduled maintenance") {
+		t.Errorf("Expected body snippet in error, got %q", err)
+	}
+}
+
+func TestTrackRawFirstRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenBody string
+		wantErr   error
+	}{
+		{"issued", `{"access_token":"token","expires_in":3600}`, nil},
+		{"missing access token", `{"expires_in":3600}`, envoy.ErrAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tracked atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/oauth/token" {
+					w.Write([]byte(tt.tokenBody))
+					return
+				}
+				tracked.Add(1)
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					t.Errorf("Expected the issued token to be sent, got %q", got)
+				}
+				w.Write([]byte(`{"output":{"completeTrackResults":[{"trackingNumber":"441259201412"}]}}`))
+			}))
+			defer srv.Close()
+
+			baseURL := BaseURL
+			BaseURL, _ = url.Parse(srv.URL)
+			defer func() { BaseURL = baseURL }()
+
+			// No token is cached on the very first run
+			s := NewFedexService(srv.Client(), "key", "secret")
+
+			_, err := s.TrackRaw([]string{"441259201412"})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("TrackRaw() error = %v", err)
+				}
+				if !s.Token.IsValid() {
+					t.Errorf("Expected a valid token after authenticating, got %+v", s.Token)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TrackRaw() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := tracked.Load(); got != 0 {
+				t.Errorf("Expected no tracking requests without a token, got %d", got)
+			}
+		})
+	}
+}
+
+func TestTrackResultsDeliveryProjection(t *testing.T) {
+	data, err := os.ReadFile("testdata/estimated_delivery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res TrackingResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	est := time.FixedZone("EST", -5*60*60)
+	want := map[string]*time.Time{
+		"794843185271": ptr(time.Date(2025, 2, 27, 20, 0, 0, 0, est)),
+		"794843185282": ptr(time.Date(2025, 2, 28, 17, 0, 0, 0, est)),
+		"794843185293": nil,
+	}
+
+	for _, r := range res.Output.CompleteTrackResults {
+		t.Run(r.TrackingNumer, func(t *testing.T) {
+			got := r.parcel().Data.DeliveryProjection
+			if w := want[r.TrackingNumer]; (got == nil) != (w == nil) || (got != nil && !got.Equal(*w)) {
+				t.Errorf("DeliveryProjection = %v, want %v", got, w)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestTrackResultsExceptionNotices(t *testing.T) {
+	data, err := os.ReadFile("testdata/exception.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res TrackingResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	p := res.Output.CompleteTrackResults[0].parcel()
+	want := []envoy.ParcelNotice{
+		{Code: "DELIVERY_EXCEPTION", Message: "Recipient not in"},
+		{Code: "RETURN", Message: "Refused by recipient (authorized by J. SMITH)"},
+		{Code: "EXCEPTION", Message: "Customer not available or business closed — We will attempt delivery on the next business day"},
+	}
+	if len(p.Data.Notices) != len(want) {
+		t.Fatalf("Notices = %+v, want %+v", p.Data.Notices, want)
+	}
+	for i, n := range p.Data.Notices {
+		if n != want[i] {
+			t.Errorf("Notices[%d] = %+v, want %+v", i, n, want[i])
+		}
+	}
+}
+
+func TestCompleteTrackResultRecipient(t *testing.T) {
+	data, err := os.ReadFile("testdata/delivered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res TrackingResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	want := map[string][2]string{
+		"441259201412": {"J.DOE", "J. DOE"},
+		// Recipients are only reported once delivered
+		"794843185304": {"", ""},
+	}
+	for _, r := range res.Output.CompleteTrackResults {
+		t.Run(r.TrackingNumer, func(t *testing.T) {
+			d := r.parcel().Data
+			if got := [2]string{d.ReceivedBy, d.SignedBy}; got != want[r.TrackingNumer] {
+				t.Errorf("ReceivedBy, SignedBy = %q, want %q", got, want[r.TrackingNumer])
+			}
+		})
+	}
+}
+
+func TestCompleteTrackResultAccessPoint(t *testing.T) {
+	data, err := os.ReadFile("testdata/access_point.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res TrackingResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	want := map[string]struct {
+		accessPoint bool
+		name        string
+	}{
+		"441259201412":    {true, "FedEx Office Print & Ship Center"},
+		"794843185304":    {true, "DENVER, CO 80202"},
+		"449044304137821": {false, ""},
+	}
+	for _, r := range res.Output.CompleteTrackResults {
+		t.Run(r.TrackingNumer, func(t *testing.T) {
+			d := r.parcel().Data
+			w := want[r.TrackingNumer]
+			if d.DeliveredToAccessPoint != w.accessPoint || d.AccessPoint != w.name {
+				t.Errorf("DeliveredToAccessPoint, AccessPoint = %v, %q, want %v, %q", d.DeliveredToAccessPoint, d.AccessPoint, w.accessPoint, w.name)
+			}
+		})
+	}
+}
+
+func TestCompleteTrackResultWeightAndDimensions(t *testing.T) {
+	data, err := os.ReadFile("testdata/delivered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res TrackingResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	want := map[string]struct {
+		weight     *envoy.Dimensioned
+		dimensions *envoy.Size
+	}{
+		"441259201412": {
+			&envoy.Dimensioned{Units: "LB", Value: "5.1"},
+			&envoy.Size{Length: 12, Width: 8, Height: 4, Units: "IN"},
+		},
+		// Falls back to the weight of the shipment
+		"794843185304": {&envoy.Dimensioned{Units: "KG", Value: "12.0"}, nil},
+	}
+	for _, r := range res.Output.CompleteTrackResults {
+		t.Run(r.TrackingNumer, func(t *testing.T) {
+			d := r.parcel().Data
+			w := want[r.TrackingNumer]
+			if d.Weight == nil || *d.Weight != *w.weight {
+				t.Errorf("Weight = %+v, want %+v", d.Weight, w.weight)
+			}
+			if (d.Dimensions == nil) != (w.dimensions == nil) || d.Dimensions != nil && *d.Dimensions != *w.dimensions {
+				t.Errorf("Dimensions = %+v, want %+v", d.Dimensions, w.dimensions)
+			}
+		})
+	}
+}
+
+func TestTrackFixtures(t *testing.T) {
+	tests := []struct {
+		fixture         string
+		trackingNumbers []string
+		want            []testutil.WantParcel
+	}{
+		{
+			fixture:         "estimated_delivery.json",
+			trackingNumbers: []string{"794843185271", "794843185282", "794843185293"},
+			want: []testutil.WantParcel{
+				{TrackingNumber: "794843185271", Delivered: false, Events: 1, LastType: envoy.ParcelEventTypePickedUp, LastLocation: "NEWARK, NJ", LastAt: time.Date(2025, 2, 24, 15, 38, 0, 0, time.UTC)},
+				{TrackingNumber: "794843185282"},
+				{TrackingNumber: "794843185293"},
+			},
+		},
+		{
+			fixture:         "delivered.json",
+			trackingNumbers: []string{"441259201412", "794843185304"},
+			want: []testutil.WantParcel{
+				{TrackingNumber: "441259201412", Delivered: true, Events: 2, LastType: envoy.ParcelEventTypeDelivered, LastLocation: "DENVER, CO", LastAt: time.Date(2025, 2, 26, 21, 35, 0, 0, time.UTC)},
+				{TrackingNumber: "794843185304", Delivered: false, Events: 1, LastType: envoy.ParcelEventTypeArrived, LastLocation: "MEMPHIS, TN", LastAt: time.Date(2025, 2, 25, 23, 40, 0, 0, time.UTC)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			body := testutil.Fixture(t, "testdata/"+tt.fixture)
+			client := testutil.Client(func(req *http.Request) (*http.Response, error) {
+				return testutil.JSONResponse(req, http.StatusOK, body), nil
+			})
+			s := NewFedexService(client, "key", "secret")
+			s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+			parcels, err := s.Track(tt.trackingNumbers)
+			if err != nil {
+				t.Fatalf("Track() error = %v", err)
+			}
+			testutil.CheckParcels(t, parcels, tt.want)
+		})
+	}
+}
+
+func TestTrackReportsFailedNumbers(t *testing.T) {
+	body := testutil.Fixture(t, "testdata/not_found.json")
+	client := testutil.Client(func(req *http.Request) (*http.Response, error) {
+		return testutil.JSONResponse(req, http.StatusOK, body), nil
+	})
+	s := NewFedexService(client, "key", "secret")
+	s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+	parcels, err := s.Track([]string{"794843185271", "123456789012"})
+	if err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+	if len(parcels) != 2 {
+		t.Fatalf("Expected 2 parcels, got %d", len(parcels))
+	}
+
+	tracked, missing := parcels[0], parcels[1]
+	if tracked.HasError() || len(tracked.Data.Events) != 1 {
+		t.Errorf("Expected the found number to be tracked, got %+v", tracked)
+	}
+	if !missing.HasError() || !strings.Contains(missing.Error.Error(), "Tracking number cannot be found") {
+		t.Errorf("Expected the FedEx error on the missing number, got %v", missing.Error)
+	}
+	wantNotice := envoy.ParcelNotice{Code: "TRACKING.DATA.NOTFOUND", Message: "Tracking data unavailable for one or more tracking numbers"}
+	if !slices.Contains(tracked.Data.Notices, wantNotice) {
+		t.Errorf("Expected the alert as a notice, got %+v", tracked.Data.Notices)
+	}
+}
+
+func TestTrackAlertsWithoutResults(t *testing.T) {
+	client := testutil.Client(func(req *http.Request) (*http.Response, error) {
+		return testutil.JSONResponse(req, http.StatusOK, `{"output":{"alerts":[{"code":"TRACKING.SERVICE.UNAVAILABLE","message":"Tracking is unavailable"}]}}`), nil
+	})
+	s := NewFedexService(client, "key", "secret")
+	s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+	if _, err := s.Track([]string{"794843185271"}); err == nil || !strings.Contains(err.Error(), "Tracking is unavailable") {
+		t.Errorf("Expected the alert as an error, got %v", err)
+	}
+}
+
+func TestCompleteTrackResultName(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []*TrackResults
+		want    string
+	}{
+		{
+			"nickname",
+			[]*TrackResults{{
+				AdditionalTrackingInfo: &AdditionalTrackingInfo{Nickname: " Birthday gift "},
+				ServiceDetail:          &ServiceDetail{Description: "FedEx Ground"},
+			}},
+			"Birthday gift",
+		},
+		{
+			"service",
+			[]*TrackResults{{
+				AdditionalTrackingInfo: &AdditionalTrackingInfo{},
+				ServiceDetail:          &ServiceDetail{Description: "FedEx Ground"},
+			}},
+			"FedEx Ground",
+		},
+		{"tracking number", []*TrackResults{{}}, "794843185271"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &CompleteTrackResult{TrackingNumer: "794843185271", TrackResults: tt.results}
+			if got := r.parcel().Name; got != tt.want {
+				t.Errorf("Name = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompleteTrackResultServiceLevel(t *testing.T) {
+	tests := []struct {
+		detail *ServiceDetail
+		want   string
+	}{
+		{&ServiceDetail{Type: ServiceTypeFedex2Day, Description: "FedEx 2Day"}, "FedEx 2Day"},
+		{&ServiceDetail{Type: ServiceTypeFedexHomeDelivery, Description: "Home Delivery"}, "FedEx Home Delivery"},
+		{&ServiceDetail{Type: ServiceTypeFedexGroundEconomy}, "FedEx Ground Economy"},
+		{&ServiceDetail{Type: "FEDEX_CUSTOM_CRITICAL", Description: " FedEx Custom Critical "}, "FedEx Custom Critical"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		r := &CompleteTrackResult{
+			TrackingNumer: "794843185271",
+			TrackResults:  []*TrackResults{{ServiceDetail: tt.detail}},
+		}
+		if got := r.parcel().Data.ServiceLevel; got != tt.want {
+			t.Errorf("ServiceLevel for %+v = %q, want %q", tt.detail, got, tt.want)
+		}
+	}
+}
+
+func TestAddressString(t *testing.T) {
+	if got := (&Address{}).String(); got != envoy.EmDash {
+		t.Errorf("String() of an empty address = %q, want %q", got, envoy.EmDash)
+	}
+	a := &Address{City: "Memphis", StateOrProvinceCode: "TN", PostalCode: "38118", CountryCode: "US"}
+	if got := a.String(); got != "MEMPHIS, TN 38118" {
+		t.Errorf("String() = %q, want %q", got, "MEMPHIS, TN 38118")
+	}
+}
@@ -0,0 +1,57 @@
+// Package notify shows desktop notifications using the tools native to each
+// platform: osascript on macOS, notify-send on Linux, and PowerShell on
+// Windows.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// ErrUnsupported is returned on platforms without a notification tool
+var ErrUnsupported = errors.New("notifications are not supported on this platform")
+
+// The title and body are passed as arguments or environment variables rather
+// than interpolated into scripts, so that no quoting is needed
+const (
+	appleScript = `on run argv
+	display notification (item 2 of argv) with title (item 1 of argv)
+end run`
+	powerShellScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$x = $t.GetElementsByTagName('text')
+$x.Item(0).AppendChild($t.CreateTextNode($env:ENVOY_NOTIFY_TITLE)) > $null
+$x.Item(1).AppendChild($t.CreateTextNode($env:ENVOY_NOTIFY_BODY)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('envoy').Show([Windows.UI.Notifications.ToastNotification]::new($t))`
+)
+
+// Notify shows a desktop notification with the given title and body
+func Notify(title, body string) error {
+	cmd, err := command(runtime.GOOS, title, body)
+	if err != nil {
+		return err
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Path, err, out)
+	}
+	return nil
+}
+
+// Constructs the command that shows a notification on the given platform
+func command(goos, title, body string) (*exec.Cmd, error) {
+	switch goos {
+	case "darwin":
+		return exec.Command("osascript", "-e", appleScript, title, body), nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return exec.Command("notify-send", "--app-name=envoy", "--", title, body), nil
+	case "windows":
+		cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", powerShellScript)
+		cmd.Env = append(os.Environ(), "ENVOY_NOTIFY_TITLE="+title, "ENVOY_NOTIFY_BODY="+body)
+		return cmd, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, goos)
+	}
+}
@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		goos string
+		name string
+		args []string
+		env  []string
+	}{
+		{goos: "darwin", name: "osascript", args: []string{"-e", appleScript, `"Title"`, "Body; rm -rf /"}},
+		{goos: "linux", name: "notify-send", args: []string{"--app-name=envoy", "--", `"Title"`, "Body; rm -rf /"}},
+		{
+			goos: "windows",
+			name: "powershell",
+			args: []string{"-NoProfile", "-NonInteractive", "-Command", powerShellScript},
+			env:  []string{`ENVOY_NOTIFY_TITLE="Title"`, "ENVOY_NOTIFY_BODY=Body; rm -rf /"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			cmd, err := command(tt.goos, `"Title"`, "Body; rm -rf /")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cmd.Args[0] != tt.name {
+				t.Errorf("Expected %s, got %s", tt.name, cmd.Args[0])
+			}
+			if !slices.Equal(cmd.Args[1:], tt.args) {
+				t.Errorf("Expected args %q, got %q", tt.args, cmd.Args[1:])
+			}
+			for _, e := range tt.env {
+				if !slices.Contains(cmd.Env, e) {
+					t.Errorf("Expected env to contain %q", e)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandUnsupported(t *testing.T) {
+	if _, err := command("plan9", "Title", "Body"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
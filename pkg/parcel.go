@@ -1,6 +1,13 @@
 package envoy
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
 
 type Parcel struct {
 	Name           string  `storm:"index"`
@@ -9,12 +16,81 @@ type Parcel struct {
 	TrackingURL    string
 	Data           *ParcelData
 	Error          error
+	// When the parcel is next due to be refreshed by a polling loop
+	NextRefreshAt time.Time
+	// When the parcel was last fetched from its carrier
+	LastSyncedAt time.Time
+	// Carrier-assigned identifier distinguishing shipments which reuse the
+	// same tracking number, if the carrier provides one
+	ShipmentID string
+	// When the current shipment under this tracking number began
+	ShipmentStartedAt time.Time
+	// Histories of earlier shipments which reused this tracking number
+	PreviousShipments []*ParcelData
+	// Other tracking numbers the carriers report for the same parcel, such as
+	// the USPS number of a UPS parcel handed off for final delivery
+	AlternateTrackingNumbers []string
+	// Labels the user attached to organize the parcel, such as "work". Like
+	// Name, they are never reported by carriers, so survive refreshes.
+	Tags []string
+	// A freeform note from the user, such as what the parcel is for
+	Note string
+	// The carrier response the parcel was built from, for debugging. It is
+	// not persisted, so is empty for parcels loaded from the database.
+	Raw json.RawMessage `json:"-"`
 }
 
 type ParcelData struct {
 	Events             []ParcelEvent
 	Delivered          bool
 	DeliveryProjection *time.Time
+	// Alerts and messages from the carrier, as of the latest fetch
+	Notices []ParcelNotice
+	// Where the proof of delivery was last downloaded to, if it has been
+	ProofOfDeliveryPath string
+	// Who accepted and who signed for the parcel, when it has been delivered
+	// and the carrier reports them
+	ReceivedBy string
+	SignedBy   string
+	// Whether the parcel was delivered to a locker, access point, carrier
+	// location, or neighbor, from which it still has to be collected
+	DeliveredToAccessPoint bool
+	// The name of the access point, if the carrier reports it
+	AccessPoint string
+	// The last day the parcel can be collected from the access point, if the
+	// carrier reports it
+	PickupBy *time.Time
+	// The weight and dimensions of the package, in the units reported by the
+	// carrier (e.g. "LB" or "KG", and "IN" or "CM")
+	Weight     *Dimensioned
+	Dimensions *Size
+	// The service the parcel was shipped with, normalized to how the carrier
+	// markets it (e.g. "Priority Mail", "UPS Ground", or "FedEx 2Day")
+	ServiceLevel string
+	// Whether the stored events were timestamped by an older way of reading
+	// the carrier's times, so that the same events fetched again would not
+	// match them. The next fetch replaces them instead of merging with them.
+	StaleEventTimes bool
+}
+
+// DropStaleEvents removes the events reported by a carrier, or by no carrier
+// in particular, if they are marked as having stale timestamps, so that they
+// can be replaced by freshly fetched ones
+func (d *ParcelData) DropStaleEvents(carrier Carrier) {
+	if !d.StaleEventTimes {
+		return
+	}
+	d.Events = slices.DeleteFunc(d.Events, func(e ParcelEvent) bool {
+		return e.SourceCarrier == carrier || e.SourceCarrier == ""
+	})
+	d.StaleEventTimes = false
+}
+
+// ParcelNotice is an alert or message from a carrier about a parcel, which
+// often explains a delay or an action required of the recipient
+type ParcelNotice struct {
+	Code    string
+	Message string
 }
 
 func NewParcel(name string, carrier Carrier, trackingNumber, trackingURL string) *Parcel {
@@ -26,6 +102,40 @@ func NewParcel(name string, carrier Carrier, trackingNumber, trackingURL string)
 	}
 }
 
+// parcelJSON has the fields of Parcel without its JSON methods
+type parcelJSON Parcel
+
+// MarshalJSON encodes the parcel with its error as a message, as errors
+// otherwise encode as empty objects
+func (p Parcel) MarshalJSON() ([]byte, error) {
+	var msg string
+	if p.Error != nil {
+		msg = p.Error.Error()
+	}
+	return json.Marshal(struct {
+		*parcelJSON
+		Error string `json:",omitempty"`
+	}{(*parcelJSON)(&p), msg})
+}
+
+// UnmarshalJSON decodes a parcel encoded by MarshalJSON
+func (p *Parcel) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*parcelJSON
+		Error json.RawMessage
+	}{parcelJSON: (*parcelJSON)(p)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	// Errors stored before they were encoded as messages are dropped
+	var msg string
+	if err := json.Unmarshal(aux.Error, &msg); err == nil && msg != "" {
+		p.Error = errors.New(msg)
+	}
+	return nil
+}
+
 func (p *Parcel) HasData() bool {
 	return p.Data != nil
 }
@@ -34,6 +144,71 @@ func (p *Parcel) HasError() bool {
 	return p.Error != nil
 }
 
+// AlternateNumbers returns the other tracking numbers reported for the parcel,
+// normalized and without duplicates or its own tracking number
+func (p *Parcel) AlternateNumbers() []string {
+	var numbers []string
+	for _, n := range p.AlternateTrackingNumbers {
+		n = NormalizeTrackingNumber(n)
+		if n == "" || n == p.TrackingNumber || slices.Contains(numbers, n) {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// NormalizeTag trims and lowercases a tag, so that tags match regardless of
+// case
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// HasTag reports whether the parcel is labeled with the tag
+func (p *Parcel) HasTag(tag string) bool {
+	return slices.Contains(p.Tags, NormalizeTag(tag))
+}
+
+// AddTags labels the parcel with the tags, ignoring empty tags and those it
+// already has. It reports whether any tags were added.
+func (p *Parcel) AddTags(tags ...string) bool {
+	added := false
+	for _, t := range tags {
+		t = NormalizeTag(t)
+		if t == "" || slices.Contains(p.Tags, t) {
+			continue
+		}
+		p.Tags = append(p.Tags, t)
+		added = true
+	}
+	return added
+}
+
+// RemoveTags removes the tags from the parcel, reporting whether it had any
+// of them
+func (p *Parcel) RemoveTags(tags ...string) bool {
+	n := len(p.Tags)
+	p.Tags = slices.DeleteFunc(p.Tags, func(t string) bool {
+		return slices.ContainsFunc(tags, func(r string) bool {
+			return NormalizeTag(r) == t
+		})
+	})
+	return len(p.Tags) != n
+}
+
+// HasDeliveredEvent reports whether any tracking event marks the parcel delivered
+func (p *Parcel) HasDeliveredEvent() bool {
+	if !p.HasData() {
+		return false
+	}
+	for _, e := range p.Data.Events {
+		if e.Type == ParcelEventTypeDelivered {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Parcel) LastTrackingEvent() *ParcelEvent {
 	if !p.HasData() {
 		return nil
@@ -42,20 +217,206 @@ func (p *Parcel) LastTrackingEvent() *ParcelEvent {
 		return nil
 	}
 
-	var lastEvent *ParcelEvent
-	for _, event := range p.Data.Events {
-		if lastEvent == nil || event.Timestamp.After(lastEvent.Timestamp) {
-			lastEvent = &event
+	// Index the slice so that the event returned is the parcel's own, rather
+	// than a copy held by the loop variable
+	last := 0
+	for i := range p.Data.Events {
+		if p.Data.Events[i].Timestamp.After(p.Data.Events[last].Timestamp) {
+			last = i
 		}
 	}
-	return lastEvent
+	return &p.Data.Events[last]
+}
+
+// DeliveredStrategy determines how a parcel's delivered status is derived from
+// the signals reported by its carrier.
+type DeliveredStrategy string
+
+const (
+	// Delivered if either the carrier or any event says so
+	DeliveredStrategyAny DeliveredStrategy = "any"
+	// Delivered only if the carrier's own delivered signal says so
+	DeliveredStrategyCarrier DeliveredStrategy = "carrier"
+	// Delivered only if a delivered event is present
+	DeliveredStrategyEvents DeliveredStrategy = "events"
+)
+
+// DefaultDeliveredStrategy is the strategy used by the carrier services.
+var DefaultDeliveredStrategy = DeliveredStrategyAny
+
+func ParseDeliveredStrategy(s string) (DeliveredStrategy, error) {
+	switch DeliveredStrategy(s) {
+	case "":
+		return DeliveredStrategyAny, nil
+	case DeliveredStrategyAny, DeliveredStrategyCarrier, DeliveredStrategyEvents:
+		return DeliveredStrategy(s), nil
+	default:
+		return "", fmt.Errorf("unknown delivered strategy: %q", s)
+	}
+}
+
+// Resolve sets the delivered status of p from the carrier's own delivered
+// signal, cross-checked against the parcel's events.
+func (s DeliveredStrategy) Resolve(p *Parcel, carrierSignal bool) {
+	if !p.HasData() {
+		p.Data = &ParcelData{}
+	}
+
+	eventSignal := p.HasDeliveredEvent()
+	if carrierSignal != eventSignal {
+		Debugf(
+			"%s %s delivered status disagrees: carrier=%t events=%t",
+			p.Carrier, p.TrackingNumber, carrierSignal, eventSignal,
+		)
+	}
+
+	switch s {
+	case DeliveredStrategyCarrier:
+		p.Data.Delivered = carrierSignal
+	case DeliveredStrategyEvents:
+		p.Data.Delivered = eventSignal
+	default:
+		p.Data.Delivered = carrierSignal || eventSignal
+	}
 }
 
 type ParcelEvent struct {
-	Type        ParcelEventType
-	Description string
-	Location    string
-	Timestamp   time.Time
+	Type          ParcelEventType
+	Description   string
+	Location      string
+	Timestamp     time.Time
+	SourceCarrier Carrier
+}
+
+// mergeEventTolerance is the maximum distance between two event timestamps
+// for them to be considered the same event when merging.
+const mergeEventTolerance = 5 * time.Minute
+
+// isDuplicateOf reports whether e and other describe the same event, allowing
+// for the small clock differences carriers report across a handoff.
+func (e *ParcelEvent) isDuplicateOf(other *ParcelEvent) bool {
+	if !strings.EqualFold(strings.TrimSpace(e.Description), strings.TrimSpace(other.Description)) {
+		return false
+	}
+	d := e.Timestamp.Sub(other.Timestamp)
+	if d < 0 {
+		d = -d
+	}
+	return d <= mergeEventTolerance
+}
+
+// MergeEvents unions the event streams reported by one or more carriers into
+// a single de-duplicated timeline sorted from oldest to newest.
+func MergeEvents(streams ...[]ParcelEvent) []ParcelEvent {
+	var merged []ParcelEvent
+	for _, stream := range streams {
+	next:
+		for _, e := range stream {
+			for _, m := range merged {
+				if e.isDuplicateOf(&m) {
+					continue next
+				}
+			}
+			merged = append(merged, e)
+		}
+	}
+
+	slices.SortStableFunc(merged, func(a, b ParcelEvent) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return merged
+}
+
+// eventKey identifies an event exactly, unlike isDuplicateOf, which tolerates
+// the differences between the reports of two carriers
+type eventKey struct {
+	timestamp   int64
+	eventType   ParcelEventType
+	description string
+	location    string
+}
+
+func (e *ParcelEvent) key() eventKey {
+	return eventKey{e.Timestamp.UnixNano(), e.Type, e.Description, e.Location}
+}
+
+// UnionEvents accumulates the events of a stored timeline and a fresh fetch
+// from the same carrier, dropping only exact duplicates, so that events the
+// carrier has since truncated are kept. The union is sorted from oldest to
+// newest, keeping the order of events at the same time.
+func UnionEvents(stored, fetched []ParcelEvent) []ParcelEvent {
+	union := make([]ParcelEvent, 0, len(stored)+len(fetched))
+	seen := make(map[eventKey]struct{}, len(stored)+len(fetched))
+	for _, events := range [][]ParcelEvent{stored, fetched} {
+		for _, e := range events {
+			if _, ok := seen[e.key()]; ok {
+				continue
+			}
+			seen[e.key()] = struct{}{}
+			union = append(union, e)
+		}
+	}
+
+	slices.SortStableFunc(union, func(a, b ParcelEvent) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return union
+}
+
+// Merge folds the tracking data of other, typically the same tracking number
+// reported by a second carrier after a handoff, into p.
+func (p *Parcel) Merge(other *Parcel) {
+	if other == nil {
+		return
+	}
+	for _, n := range other.AlternateNumbers() {
+		if !slices.Contains(p.AlternateTrackingNumbers, n) {
+			p.AlternateTrackingNumbers = append(p.AlternateTrackingNumbers, n)
+		}
+	}
+	p.AddTags(other.Tags...)
+	if p.Note == "" {
+		p.Note = other.Note
+	}
+	if !other.HasData() {
+		return
+	}
+	if !p.HasData() {
+		p.Data = &ParcelData{}
+	}
+
+	p.Data.Events = MergeEvents(p.Data.Events, other.Data.Events)
+	p.Data.Delivered = p.Data.Delivered || other.Data.Delivered
+	if p.Data.DeliveryProjection == nil {
+		p.Data.DeliveryProjection = other.Data.DeliveryProjection
+	}
+	if p.Data.ReceivedBy == "" {
+		p.Data.ReceivedBy = other.Data.ReceivedBy
+	}
+	if p.Data.SignedBy == "" {
+		p.Data.SignedBy = other.Data.SignedBy
+	}
+	p.Data.DeliveredToAccessPoint = p.Data.DeliveredToAccessPoint || other.Data.DeliveredToAccessPoint
+	if p.Data.AccessPoint == "" {
+		p.Data.AccessPoint = other.Data.AccessPoint
+	}
+	if other.Data.PickupBy != nil {
+		p.Data.PickupBy = other.Data.PickupBy
+	}
+	if p.Data.Weight == nil {
+		p.Data.Weight = other.Data.Weight
+	}
+	if p.Data.Dimensions == nil {
+		p.Data.Dimensions = other.Data.Dimensions
+	}
+	if p.Data.ServiceLevel == "" {
+		p.Data.ServiceLevel = other.Data.ServiceLevel
+	}
+	for _, n := range other.Data.Notices {
+		if !slices.Contains(p.Data.Notices, n) {
+			p.Data.Notices = append(p.Data.Notices, n)
+		}
+	}
 }
 
 type ParcelEventType string
@@ -82,3 +443,192 @@ const (
 	ParcelEventTypeReturnedToSender       ParcelEventType = "RETURNED TO SENDER"
 	ParcelEventTypeUnknown                ParcelEventType = "UNKNOWN"
 )
+
+// ParcelEventTypes lists every known event type
+var ParcelEventTypes = []ParcelEventType{
+	ParcelEventTypeOrderConfirmed,
+	ParcelEventTypeDeliveryUpdated,
+	ParcelEventTypeAssertOnTime,
+	ParcelEventTypePickedUp,
+	ParcelEventTypeDeparted,
+	ParcelEventTypeProcessing,
+	ParcelEventTypeInTransit,
+	ParcelEventTypeArrived,
+	ParcelEventTypeOnVehicle,
+	ParcelEventTypeOutForDelivery,
+	ParcelEventTypeDelivered,
+	ParcelEventTypeDelayed,
+	ParcelEventTypeParcelHeld,
+	ParcelEventTypeAwaitingCustomerAction,
+	ParcelEventTypeAwaitingCustomerPickup,
+	ParcelEventTypeTransferredToLocal,
+	ParcelEventTypeException,
+	ParcelEventTypeUndeliverable,
+	ParcelEventTypeReturnedToSender,
+	ParcelEventTypeUnknown,
+}
+
+// ParseParcelEventType parses an event type by its value, case-insensitively
+func ParseParcelEventType(s string) (ParcelEventType, error) {
+	s = strings.TrimSpace(s)
+	for _, t := range ParcelEventTypes {
+		if strings.EqualFold(s, string(t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type %q", s)
+}
+
+// Returns the time of the earliest event, or the zero time if there are none
+func (p *Parcel) firstEventTime() time.Time {
+	var first time.Time
+	if !p.HasData() {
+		return first
+	}
+	for _, e := range p.Data.Events {
+		if first.IsZero() || e.Timestamp.Before(first) {
+			first = e.Timestamp
+		}
+	}
+	return first
+}
+
+// DeliveredAt returns the time of the latest delivered event, or the zero time
+// if there are none
+func (p *Parcel) DeliveredAt() time.Time {
+	var at time.Time
+	if !p.HasData() {
+		return at
+	}
+	for _, e := range p.Data.Events {
+		if e.Type == ParcelEventTypeDelivered && e.Timestamp.After(at) {
+			at = e.Timestamp
+		}
+	}
+	return at
+}
+
+// IsNewShipmentOf reports whether p is a new shipment reusing the tracking
+// number of the stored parcel, either because the carrier assigned it a
+// different shipment ID, or because its history begins after the stored
+// parcel was delivered.
+func (p *Parcel) IsNewShipmentOf(stored *Parcel) bool {
+	if stored == nil || !p.HasData() || !stored.HasData() {
+		return false
+	}
+	if p.ShipmentID != "" && stored.ShipmentID != "" {
+		return p.ShipmentID != stored.ShipmentID
+	}
+
+	delivered := stored.DeliveredAt()
+	if delivered.IsZero() {
+		return false
+	}
+	return p.firstEventTime().After(delivered)
+}
+
+// Refresh updates a stored parcel with freshly fetched data. Events of the
+// same shipment are merged into the stored history, but when the tracking
+// number has been reused for a new shipment the history is reset instead,
+// optionally archiving the previous one.
+func (p *Parcel) Refresh(fetched *Parcel, archive bool) {
+	if fetched == nil {
+		return
+	}
+	p.Error = fetched.Error
+	if !fetched.HasData() {
+		return
+	}
+	if fetched.TrackingURL != "" {
+		p.TrackingURL = fetched.TrackingURL
+	}
+	// A name derived from carrier details is adopted only in place of the
+	// default, so that names the user gave are kept
+	if fetched.Name != "" && (p.Name == "" || p.Name == p.TrackingNumber) {
+		p.Name = fetched.Name
+	}
+
+	if fetched.IsNewShipmentOf(p) {
+		Debugf("%s: tracking number reused for a new shipment, resetting history", p.TrackingNumber)
+		if archive {
+			p.PreviousShipments = append(p.PreviousShipments, p.Data)
+		}
+		p.Data = fetched.Data
+		p.ShipmentID = fetched.ShipmentID
+		p.ShipmentStartedAt = fetched.firstEventTime()
+		return
+	}
+
+	if p.ShipmentID == "" {
+		p.ShipmentID = fetched.ShipmentID
+	}
+	if p.HasData() && p.Data.StaleEventTimes && len(fetched.Data.Events) > 0 {
+		p.Data.DropStaleEvents(fetched.Carrier)
+		p.ShipmentStartedAt = time.Time{}
+	}
+	p.Merge(fetched)
+	// Notices and projections describe the parcel as it is now, so stale
+	// ones are replaced
+	p.Data.Notices = fetched.Data.Notices
+	if fetched.Data.DeliveryProjection != nil {
+		p.Data.DeliveryProjection = fetched.Data.DeliveryProjection
+	}
+	if p.ShipmentStartedAt.IsZero() {
+		p.ShipmentStartedAt = p.firstEventTime()
+	}
+}
+
+// ParcelDiff describes what changed between a stored parcel and a fresh fetch
+type ParcelDiff struct {
+	// Events which were not present in the stored parcel, oldest first
+	NewEvents []ParcelEvent
+	// Whether the tracking number was reused for a new shipment
+	NewShipment bool
+	// Whether the parcel was delivered since it was stored
+	Delivered bool
+	// Whether the delivery projection changed, and its previous value
+	ETAChanged  bool
+	PreviousETA *time.Time
+	ETA         *time.Time
+}
+
+// IsEmpty reports whether nothing changed
+func (d *ParcelDiff) IsEmpty() bool {
+	return len(d.NewEvents) == 0 && !d.NewShipment && !d.Delivered && !d.ETAChanged
+}
+
+// Diff returns what changed in the freshly fetched parcel since p was stored
+func (p *Parcel) Diff(fetched *Parcel) ParcelDiff {
+	var diff ParcelDiff
+	if fetched == nil || !fetched.HasData() {
+		return diff
+	}
+
+	var stored []ParcelEvent
+	wasDelivered := false
+	if p.HasData() && !fetched.IsNewShipmentOf(p) {
+		stored = p.Data.Events
+		wasDelivered = p.Data.Delivered
+		diff.PreviousETA = p.Data.DeliveryProjection
+	} else if p.HasData() {
+		diff.NewShipment = true
+	}
+
+	for _, e := range MergeEvents(fetched.Data.Events) {
+		if !slices.ContainsFunc(stored, func(s ParcelEvent) bool { return e.isDuplicateOf(&s) }) {
+			diff.NewEvents = append(diff.NewEvents, e)
+		}
+	}
+
+	diff.Delivered = fetched.Data.Delivered && !wasDelivered
+	diff.ETA = fetched.Data.DeliveryProjection
+	switch {
+	case diff.PreviousETA == nil && diff.ETA == nil:
+	case diff.PreviousETA == nil || diff.ETA == nil:
+		diff.ETAChanged = true
+	default:
+		diff.ETAChanged = !diff.PreviousETA.Equal(*diff.ETA)
+	}
+
+	return diff
+}
@@ -0,0 +1,427 @@
+package envoy
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParcelMerge(t *testing.T) {
+	base := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+
+	ups := NewParcel("Handoff", CarrierUPS, "92001903104186015180053869", "")
+	ups.Data = &ParcelData{
+		Events: []ParcelEvent{
+			{
+				Type:          ParcelEventTypeDeparted,
+				Description:   "Departed from Facility",
+				Location:      "HODGKINS, IL",
+				Timestamp:     base.Add(2 * time.Hour),
+				SourceCarrier: CarrierUPS,
+			},
+			{
+				Type:          ParcelEventTypeOrderConfirmed,
+				Description:   "Shipper created a label",
+				Location:      "ALTOONA, PA",
+				Timestamp:     base,
+				SourceCarrier: CarrierUPS,
+			},
+			{
+				Type:          ParcelEventTypeTransferredToLocal,
+				Description:   "Package transferred to post office",
+				Location:      "LOS ANGELES, CA",
+				Timestamp:     base.Add(24 * time.Hour),
+				SourceCarrier: CarrierUPS,
+			},
+		},
+	}
+
+	usps := NewParcel("", CarrierUSPS, "92001903104186015180053869", "")
+	usps.Data = &ParcelData{
+		Delivered: true,
+		Events: []ParcelEvent{
+			{
+				Type:          ParcelEventTypeDelivered,
+				Description:   "Delivered, In/At Mailbox",
+				Location:      "LOS ANGELES, CA 90026",
+				Timestamp:     base.Add(30 * time.Hour),
+				SourceCarrier: CarrierUSPS,
+			},
+			{
+				// Same event as reported by UPS, a couple of minutes apart
+				Type:          ParcelEventTypeArrived,
+				Description:   "package transferred to post office",
+				Location:      "LOS ANGELES, CA 90026",
+				Timestamp:     base.Add(24*time.Hour + 2*time.Minute),
+				SourceCarrier: CarrierUSPS,
+			},
+		},
+	}
+
+	ups.Merge(usps)
+
+	want := []struct {
+		description string
+		source      Carrier
+	}{
+		{"Shipper created a label", CarrierUPS},
+		{"Departed from Facility", CarrierUPS},
+		{"Package transferred to post office", CarrierUPS},
+		{"Delivered, In/At Mailbox", CarrierUSPS},
+	}
+
+	if len(ups.Data.Events) != len(want) {
+		t.Fatalf("expected %d events, got %d: %+v", len(want), len(ups.Data.Events), ups.Data.Events)
+	}
+	for i, w := range want {
+		e := ups.Data.Events[i]
+		if e.Description != w.description || e.SourceCarrier != w.source {
+			t.Errorf("event %d: expected %q from %s, got %q from %s", i, w.description, w.source, e.Description, e.SourceCarrier)
+		}
+	}
+	if !ups.Data.Delivered {
+		t.Error("expected merged parcel to be delivered")
+	}
+	if e := ups.LastTrackingEvent(); e == nil || e.Type != ParcelEventTypeDelivered {
+		t.Errorf("expected last event to be delivered, got %+v", e)
+	}
+}
+
+func TestLastTrackingEvent(t *testing.T) {
+	base := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+
+	p := NewParcel("Books", CarrierFedEx, "441259201412", "")
+	if e := p.LastTrackingEvent(); e != nil {
+		t.Errorf("Expected no event without data, got %+v", e)
+	}
+
+	p.Data = &ParcelData{
+		Events: []ParcelEvent{
+			{Type: ParcelEventTypeInTransit, Description: "In transit", Location: "FRESNO, CA", Timestamp: base.Add(time.Hour)},
+			{Type: ParcelEventTypeDelivered, Description: "Delivered", Location: "LOS ANGELES, CA", Timestamp: base.Add(3 * time.Hour)},
+			{Type: ParcelEventTypePickedUp, Description: "Picked up", Location: "SEATTLE, WA", Timestamp: base},
+			{Type: ParcelEventTypeOutForDelivery, Description: "Out for delivery", Location: "LOS ANGELES, CA", Timestamp: base.Add(2 * time.Hour)},
+		},
+	}
+
+	e := p.LastTrackingEvent()
+	if e != &p.Data.Events[1] {
+		t.Fatalf("Expected the latest event of the parcel itself, got %+v", e)
+	}
+	if e.Type != ParcelEventTypeDelivered || e.Description != "Delivered" || e.Location != "LOS ANGELES, CA" || !e.Timestamp.Equal(base.Add(3*time.Hour)) {
+		t.Errorf("Expected the delivered event intact, got %+v", e)
+	}
+}
+
+func TestDeliveredStrategyResolve(t *testing.T) {
+	newParcel := func(eventType ParcelEventType) *Parcel {
+		p := NewParcel("", CarrierFedEx, "441259201412", "")
+		p.Data = &ParcelData{Events: []ParcelEvent{{Type: eventType}}}
+		return p
+	}
+
+	tests := []struct {
+		strategy      DeliveredStrategy
+		eventType     ParcelEventType
+		carrierSignal bool
+		want          bool
+	}{
+		{DeliveredStrategyAny, ParcelEventTypeInTransit, false, false},
+		{DeliveredStrategyAny, ParcelEventTypeDelivered, false, true},
+		{DeliveredStrategyAny, ParcelEventTypeInTransit, true, true},
+		{DeliveredStrategyCarrier, ParcelEventTypeDelivered, false, false},
+		{DeliveredStrategyCarrier, ParcelEventTypeInTransit, true, true},
+		{DeliveredStrategyEvents, ParcelEventTypeDelivered, false, true},
+		{DeliveredStrategyEvents, ParcelEventTypeInTransit, true, false},
+	}
+
+	for _, tt := range tests {
+		p := newParcel(tt.eventType)
+		tt.strategy.Resolve(p, tt.carrierSignal)
+		if p.Data.Delivered != tt.want {
+			t.Errorf(
+				"%s with event %s and carrier=%t: Delivered = %v, want %v",
+				tt.strategy, tt.eventType, tt.carrierSignal, p.Data.Delivered, tt.want,
+			)
+		}
+	}
+}
+
+func TestParcelRefreshReusedTrackingNumber(t *testing.T) {
+	base := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	event := func(eventType ParcelEventType, description string, at time.Time) ParcelEvent {
+		return ParcelEvent{Type: eventType, Description: description, Timestamp: at, SourceCarrier: CarrierFedEx}
+	}
+
+	newStored := func() *Parcel {
+		p := NewParcel("Books", CarrierFedEx, "441259201412", "")
+		p.Data = &ParcelData{
+			Delivered: true,
+			Events: []ParcelEvent{
+				event(ParcelEventTypeOrderConfirmed, "Shipment information sent to FedEx", base),
+				event(ParcelEventTypeInTransit, "In transit", base.Add(24*time.Hour)),
+				event(ParcelEventTypeDelivered, "Delivered", base.Add(48*time.Hour)),
+			},
+		}
+		return p
+	}
+
+	// The same tracking number, reused a month later
+	reused := NewParcel("441259201412", CarrierFedEx, "441259201412", "")
+	reused.Data = &ParcelData{
+		Events: []ParcelEvent{
+			event(ParcelEventTypeOrderConfirmed, "Shipment information sent to FedEx", base.Add(30*24*time.Hour)),
+			event(ParcelEventTypePickedUp, "Picked up", base.Add(31*24*time.Hour)),
+		},
+	}
+
+	t.Run("resets history", func(t *testing.T) {
+		stored := newStored()
+		stored.Refresh(reused, false)
+
+		if len(stored.Data.Events) != 2 {
+			t.Fatalf("Expected history to reset to 2 events, got %+v", stored.Data.Events)
+		}
+		if stored.Data.Delivered {
+			t.Error("Expected new shipment not to inherit delivered status")
+		}
+		if e := stored.LastTrackingEvent(); e == nil || e.Type != ParcelEventTypePickedUp {
+			t.Errorf("Expected last event to be picked up, got %+v", e)
+		}
+		if !stored.ShipmentStartedAt.Equal(base.Add(30 * 24 * time.Hour)) {
+			t.Errorf("Expected shipment to start at first new event, got %v", stored.ShipmentStartedAt)
+		}
+		if stored.Name != "Books" {
+			t.Errorf("Expected stored name to be kept, got %q", stored.Name)
+		}
+		if len(stored.PreviousShipments) != 0 {
+			t.Errorf("Expected no archived shipments, got %d", len(stored.PreviousShipments))
+		}
+	})
+
+	t.Run("archives history", func(t *testing.T) {
+		stored := newStored()
+		stored.Refresh(reused, true)
+
+		if len(stored.PreviousShipments) != 1 || len(stored.PreviousShipments[0].Events) != 3 {
+			t.Errorf("Expected previous shipment to be archived, got %+v", stored.PreviousShipments)
+		}
+	})
+
+	t.Run("shipment ID change", func(t *testing.T) {
+		stored := newStored()
+		stored.ShipmentID = "12025~441259201412~FDEG"
+		fetched := NewParcel("", CarrierFedEx, "441259201412", "")
+		fetched.ShipmentID = "12026~441259201412~FDEG"
+		fetched.Data = &ParcelData{
+			Events: []ParcelEvent{event(ParcelEventTypeOrderConfirmed, "Shipment information sent to FedEx", base)},
+		}
+		stored.Refresh(fetched, false)
+
+		if len(stored.Data.Events) != 1 || stored.ShipmentID != fetched.ShipmentID {
+			t.Errorf("Expected history to reset for new shipment ID, got %+v", stored)
+		}
+	})
+
+	t.Run("same shipment merges", func(t *testing.T) {
+		stored := newStored()
+		stored.Data.Delivered = false
+		stored.Data.Events = stored.Data.Events[:2]
+		fetched := NewParcel("", CarrierFedEx, "441259201412", "")
+		fetched.Data = &ParcelData{
+			Events: []ParcelEvent{event(ParcelEventTypeDelivered, "Delivered", base.Add(48*time.Hour))},
+		}
+		stored.Refresh(fetched, false)
+
+		if len(stored.Data.Events) != 3 {
+			t.Errorf("Expected events to be merged, got %+v", stored.Data.Events)
+		}
+	})
+}
+
+func TestParcelRefreshStaleEvents(t *testing.T) {
+	base := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	event := func(eventType ParcelEventType, description string, at time.Time) ParcelEvent {
+		return ParcelEvent{Type: eventType, Description: description, Timestamp: at, SourceCarrier: CarrierUPS}
+	}
+
+	// Stored with local times read as UTC, five hours off
+	stored := NewParcel("Books", CarrierUPS, "1Z5R89390357567127", "")
+	stored.Data = &ParcelData{
+		Delivered:       true,
+		StaleEventTimes: true,
+		Events: []ParcelEvent{
+			event(ParcelEventTypePickedUp, "Picked up", base.Add(-5*time.Hour)),
+			event(ParcelEventTypeDelivered, "Delivered", base.Add(19*time.Hour)),
+		},
+	}
+	stored.ShipmentStartedAt = base.Add(-5 * time.Hour)
+
+	fetched := NewParcel("", CarrierUPS, "1Z5R89390357567127", "")
+	fetched.Data = &ParcelData{
+		Delivered: true,
+		Events: []ParcelEvent{
+			event(ParcelEventTypePickedUp, "Picked up", base),
+			event(ParcelEventTypeDelivered, "Delivered", base.Add(24*time.Hour)),
+		},
+	}
+	stored.Refresh(fetched, false)
+
+	if !slices.Equal(stored.Data.Events, fetched.Data.Events) {
+		t.Errorf("Expected stale events to be replaced, got %+v", stored.Data.Events)
+	}
+	if stored.Data.StaleEventTimes {
+		t.Error("Expected the stale mark to be cleared")
+	}
+	if !stored.ShipmentStartedAt.Equal(base) {
+		t.Errorf("Expected shipment to start at the first fetched event, got %v", stored.ShipmentStartedAt)
+	}
+}
+
+func TestParcelDiff(t *testing.T) {
+	base := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+	eta := base.Add(48 * time.Hour)
+	newETA := base.Add(24 * time.Hour)
+
+	stored := NewParcel("", CarrierUPS, "1Z5R89390357567127", "")
+	stored.Data = &ParcelData{
+		DeliveryProjection: &eta,
+		Events: []ParcelEvent{
+			{Type: ParcelEventTypePickedUp, Description: "Picked up", Timestamp: base},
+		},
+	}
+
+	fetched := NewParcel("", CarrierUPS, "1Z5R89390357567127", "")
+	fetched.Data = &ParcelData{
+		Delivered:          true,
+		DeliveryProjection: &newETA,
+		Events: []ParcelEvent{
+			{Type: ParcelEventTypeDelivered, Description: "Delivered", Timestamp: base.Add(24 * time.Hour)},
+			{Type: ParcelEventTypePickedUp, Description: "Picked up", Timestamp: base.Add(time.Minute)},
+		},
+	}
+
+	diff := stored.Diff(fetched)
+	if len(diff.NewEvents) != 1 || diff.NewEvents[0].Type != ParcelEventTypeDelivered {
+		t.Errorf("Expected only the delivered event to be new, got %+v", diff.NewEvents)
+	}
+	if !diff.Delivered {
+		t.Error("Expected delivered transition")
+	}
+	if !diff.ETAChanged || !diff.PreviousETA.Equal(eta) || !diff.ETA.Equal(newETA) {
+		t.Errorf("Expected ETA change from %v to %v, got %+v", eta, newETA, diff)
+	}
+
+	if diff := fetched.Diff(fetched); !diff.IsEmpty() {
+		t.Errorf("Expected no changes against itself, got %+v", diff)
+	}
+}
+
+func TestParcelJSONError(t *testing.T) {
+	p := NewParcel("Lamp", CarrierUSPS, "9400123456789012345674", "")
+	p.Error = errors.New("unexpected status code: 404")
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["Error"] != "unexpected status code: 404" {
+		t.Errorf("Expected error to encode as a string, got %#v", fields["Error"])
+	}
+
+	var decoded Parcel
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to decode parcel: %v", err)
+	}
+	if decoded.TrackingNumber != p.TrackingNumber || decoded.Error == nil || decoded.Error.Error() != p.Error.Error() {
+		t.Errorf("Expected parcel to round trip, got %+v", decoded)
+	}
+
+	// Errors stored as empty objects by earlier versions are ignored
+	if err := json.Unmarshal([]byte(`{"TrackingNumber":"1","Error":{}}`), &decoded); err != nil {
+		t.Errorf("Expected legacy error encoding to decode, got %v", err)
+	}
+
+	data, err = json.Marshal(NewParcel("Shoes", CarrierFedEx, "441259201412", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields = nil
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["Error"]; ok {
+		t.Errorf("Expected no error field without an error, got %s", data)
+	}
+	if _, ok := fields["Raw"]; ok {
+		t.Errorf("Expected raw response to be omitted, got %s", data)
+	}
+}
+
+func TestParcelTags(t *testing.T) {
+	p := NewParcel("Books", CarrierUPS, "1Z5R89390357567127", "")
+	if !p.AddTags("Work", " urgent ", "", "work") {
+		t.Fatal("Expected tags to be added")
+	}
+	if !slices.Equal(p.Tags, []string{"work", "urgent"}) {
+		t.Errorf("Expected normalized, deduplicated tags, got %q", p.Tags)
+	}
+	if p.AddTags("WORK") {
+		t.Error("Expected an existing tag not to be added again")
+	}
+	if !p.HasTag("Urgent") || p.HasTag("personal") {
+		t.Errorf("Expected tags to match case-insensitively, got %q", p.Tags)
+	}
+
+	if !p.RemoveTags("URGENT", "personal") || !slices.Equal(p.Tags, []string{"work"}) {
+		t.Errorf("Expected urgent to be removed, got %q", p.Tags)
+	}
+	if p.RemoveTags("personal") {
+		t.Error("Expected removing a missing tag to report no change")
+	}
+
+	other := NewParcel("", CarrierUPS, p.TrackingNumber, "")
+	other.Tags = []string{"gift"}
+	p.Merge(other)
+	if !slices.Equal(p.Tags, []string{"work", "gift"}) {
+		t.Errorf("Expected merged tags, got %q", p.Tags)
+	}
+
+	fetched := NewParcel(p.TrackingNumber, CarrierUPS, p.TrackingNumber, "")
+	fetched.Data = &ParcelData{Events: []ParcelEvent{{Type: ParcelEventTypeInTransit, Timestamp: time.Now()}}}
+	p.Refresh(fetched, false)
+	if !slices.Equal(p.Tags, []string{"work", "gift"}) {
+		t.Errorf("Expected tags to survive a refresh, got %q", p.Tags)
+	}
+}
+
+func TestParcelRefreshName(t *testing.T) {
+	const tn = "9400123456789012345674"
+	fetched := NewParcel("Priority Mail", CarrierUSPS, tn, "")
+	fetched.Data = &ParcelData{Events: []ParcelEvent{{Type: ParcelEventTypeInTransit, Timestamp: time.Now()}}}
+
+	tests := []struct {
+		name   string
+		stored string
+		want   string
+	}{
+		{"default name", tn, "Priority Mail"},
+		{"unnamed", "", "Priority Mail"},
+		{"user name", "Lamp", "Lamp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParcel(tt.stored, CarrierUSPS, tn, "")
+			p.Refresh(fetched, false)
+			if p.Name != tt.want {
+				t.Errorf("Name = %q, want %q", p.Name, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package envoy
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNoProofOfDelivery indicates that a carrier has no proof of delivery for a
+// parcel, such as when it has not yet been delivered
+var ErrNoProofOfDelivery = errors.New("no proof of delivery available")
+
+// ProofOfDelivery is a document captured by a carrier when a parcel was
+// delivered, such as a photo of the parcel or the recipient's signature
+type ProofOfDelivery struct {
+	Data []byte
+	// The file extension matching the format of the data, such as ".png"
+	Ext string
+}
+
+// ProofOfDeliveryService is implemented by services which can fetch proof of
+// delivery documents
+type ProofOfDeliveryService interface {
+	ProofOfDelivery(trackingNumber string) (*ProofOfDelivery, error)
+}
+
+// podExtensions maps detected content types to file extensions
+var podExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/bmp":       ".bmp",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"text/html":       ".html",
+}
+
+// DecodeProofOfDelivery decodes a base64 encoded proof of delivery document,
+// detecting its format from its contents
+func DecodeProofOfDelivery(encoded string) (*ProofOfDelivery, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, ErrNoProofOfDelivery
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding proof of delivery: %w", err)
+	}
+
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	ext, ok := podExtensions[contentType]
+	if !ok {
+		ext = ".bin"
+	}
+	return &ProofOfDelivery{Data: data, Ext: ext}, nil
+}
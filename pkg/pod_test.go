@@ -0,0 +1,35 @@
+package envoy
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// A 1x1 transparent PNG
+const testPODImage = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+func TestDecodeProofOfDelivery(t *testing.T) {
+	pod, err := DecodeProofOfDelivery(testPODImage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pod.Ext != ".png" {
+		t.Errorf("expected .png extension, got %q", pod.Ext)
+	}
+	if !bytes.HasPrefix(pod.Data, []byte("\x89PNG")) {
+		t.Errorf("expected PNG data, got % x", pod.Data[:8])
+	}
+}
+
+func TestDecodeProofOfDeliveryEmpty(t *testing.T) {
+	if _, err := DecodeProofOfDelivery(" "); !errors.Is(err, ErrNoProofOfDelivery) {
+		t.Errorf("expected ErrNoProofOfDelivery, got %v", err)
+	}
+}
+
+func TestDecodeProofOfDeliveryInvalid(t *testing.T) {
+	if _, err := DecodeProofOfDelivery("not base64!"); err == nil {
+		t.Error("expected an error decoding invalid base64")
+	}
+}
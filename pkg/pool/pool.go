@@ -0,0 +1,33 @@
+// Package pool runs tasks concurrently while bounding how many run at once,
+// so that fanning out over many tracking numbers does not flood a carrier.
+package pool
+
+import "sync"
+
+// DefaultLimit is the number of tasks run at once when no limit is given.
+// Programs may change it to apply their own limit to every service.
+var DefaultLimit = 4
+
+// Run calls fn with each index from 0 to n-1, with at most limit calls in
+// flight at once, and returns when all of them have. If limit is not
+// positive, DefaultLimit is used.
+func Run(limit, n int, fn func(i int)) {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
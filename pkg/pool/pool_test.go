@@ -0,0 +1,43 @@
+package pool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunLimitsConcurrency(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  int32
+	}{
+		{"limited", 3, 3},
+		{"default", 0, int32(DefaultLimit)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight, peak, calls atomic.Int32
+			Run(tt.limit, 20, func(i int) {
+				calls.Add(1)
+				n := inFlight.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				inFlight.Add(-1)
+			})
+
+			if got := calls.Load(); got != 20 {
+				t.Errorf("Expected 20 calls, got %d", got)
+			}
+			if got := peak.Load(); got > tt.want {
+				t.Errorf("Expected at most %d calls in flight, got %d", tt.want, got)
+			}
+		})
+	}
+}
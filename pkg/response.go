@@ -0,0 +1,62 @@
+package envoy
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Maximum length of the body snippet included in non-JSON response errors
+const snippetLength = 120
+
+var (
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+	secretLikePattern = regexp.MustCompile(`[A-Za-z0-9_\-\.=+/]{24,}`)
+	loginPagePattern  = regexp.MustCompile(`(?i)\b(log ?in|sign ?in|unauthori[sz]ed|access denied|forbidden)\b`)
+)
+
+// CheckJSONResponse reports whether a carrier response body looks like JSON,
+// returning an error wrapping ErrAuth or ErrUpstream with a redacted snippet
+// of the body when it is HTML or XML instead. This should be called before
+// unmarshaling so that a proxy or login page does not surface as a cryptic
+// parse error.
+func CheckJSONResponse(carrier Carrier, res *http.Response, body []byte) error {
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	markup := strings.Contains(mediaType, "html") || strings.Contains(mediaType, "xml")
+	if !markup && !bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		return nil
+	}
+
+	if mediaType == "" {
+		mediaType = "markup"
+	}
+	snippet := redactSnippet(body)
+
+	if res.StatusCode == http.StatusUnauthorized ||
+		res.StatusCode == http.StatusForbidden ||
+		loginPagePattern.MatchString(snippet) {
+		return fmt.Errorf(
+			"%w: %s returned %s instead of JSON (status %d), check credentials: %q",
+			ErrAuth, carrier, mediaType, res.StatusCode, snippet,
+		)
+	}
+	return fmt.Errorf(
+		"%w: %s returned %s instead of JSON (status %d): %q",
+		ErrUpstream, carrier, mediaType, res.StatusCode, snippet,
+	)
+}
+
+// redactSnippet reduces a markup body to a short run of its text, masking
+// anything long enough to be a token or key
+func redactSnippet(body []byte) string {
+	text := htmlTagPattern.ReplaceAllString(string(body), " ")
+	text = strings.Join(strings.Fields(text), " ")
+	text = secretLikePattern.ReplaceAllString(text, "[REDACTED]")
+	if len(text) > snippetLength {
+		text = strings.ToValidUTF8(text[:snippetLength], "") + "…"
+	}
+	return text
+}
@@ -0,0 +1,69 @@
+package envoy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckJSONResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        error
+	}{
+		{"json", http.StatusOK, "application/json", `{"output":{}}`, nil},
+		{"json without content type", http.StatusOK, "", `{"output":{}}`, nil},
+		{"json as text", http.StatusOK, "text/plain; charset=utf-8", `{"output":{}}`, nil},
+		{"html error page", http.StatusOK, "text/html", `<html><body><h1>Service Unavailable</h1></body></html>`, ErrUpstream},
+		{"html login page", http.StatusOK, "text/html; charset=utf-8", `<html><body><form>Please sign in</form></body></html>`, ErrAuth},
+		{"html unauthorized", http.StatusUnauthorized, "text/html", `<html><body>Oops</body></html>`, ErrAuth},
+		{"leading angle bracket", http.StatusOK, "application/json", "  <!DOCTYPE html><p>Maintenance</p>", ErrUpstream},
+		{"xml", http.StatusBadGateway, "application/xml", `<error>Bad Gateway</error>`, ErrUpstream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.contentType != "" {
+				res.Header.Set("Content-Type", tt.contentType)
+			}
+
+			err := CheckJSONResponse(CarrierFedEx, res, []byte(tt.body))
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("CheckJSONResponse() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("CheckJSONResponse() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckJSONResponseRedactsSnippet(t *testing.T) {
+	secret := "eyJhbGciOiJIUzI1NiJ9abcdefghijklmnop"
+	body := "<html><head><title>Error</title></head><body><p>Gateway error</p><p>token " + secret + "</p></body></html>" +
+		strings.Repeat("<p>padding</p>", 50)
+	res := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{"Content-Type": {"text/html"}}}
+
+	err := CheckJSONResponse(CarrierUSPS, res, []byte(body))
+	if err == nil {
+		t.Fatal("Expected an error for an HTML body")
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "<p>") || strings.Contains(msg, secret) {
+		t.Errorf("Expected tags and secrets to be stripped, got %q", msg)
+	}
+	if !strings.Contains(msg, "Gateway error") || !strings.Contains(msg, "USPS") {
+		t.Errorf("Expected carrier and page text in error, got %q", msg)
+	}
+	if !strings.Contains(msg, "…") {
+		t.Errorf("Expected snippet to be truncated, got %q", msg)
+	}
+}
@@ -0,0 +1,102 @@
+// Package retry retries HTTP requests which fail transiently, backing off
+// exponentially between attempts.
+package retry
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultAttempts is the number of attempts made when none is specified
+const DefaultAttempts = 3
+
+// Delays between attempts grow exponentially from BaseDelay, up to MaxDelay
+var (
+	BaseDelay = 500 * time.Millisecond
+	MaxDelay  = 30 * time.Second
+)
+
+// Do calls fn up to attempts times, until it returns a response that is not
+// rate limited (429) or a server error (5xx) and no error. Between attempts it
+// waits for the duration given by the Retry-After header, if any, or else an
+// exponentially growing delay with jitter. The last response or error is
+// returned once attempts are exhausted. fn must construct a new request on
+// every call, as request bodies cannot be replayed. If attempts is not
+// positive, DefaultAttempts is used.
+func Do(ctx context.Context, attempts int, fn func() (*http.Response, error)) (*http.Response, error) {
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+
+	for i := 0; ; i++ {
+		res, err := fn()
+		if i == attempts-1 || !shouldRetry(ctx, res, err) {
+			return res, err
+		}
+
+		delay := backoff(i)
+		if d, ok := retryAfter(res); ok {
+			delay = min(d, MaxDelay)
+		}
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Returns whether a request failed in a way that may succeed if retried
+func shouldRetry(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+// Returns the delay before the attempt following attempt i, which doubles
+// with every attempt and is jittered between half and all of its value
+func backoff(i int) time.Duration {
+	d := BaseDelay << i
+	if d <= 0 || d > MaxDelay {
+		d = MaxDelay
+	}
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// Returns the delay requested by a response's Retry-After header, given
+// either in seconds or as an HTTP date
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+	v := res.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,133 @@
+package retry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	BaseDelay = time.Millisecond
+}
+
+// Returns a server which responds with each status in turn, then 200
+func newFlakyServer(t *testing.T, header http.Header, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(calls.Add(1)) - 1
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		if i < len(statuses) {
+			w.WriteHeader(statuses[i])
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestDoRetriesTransientErrors(t *testing.T) {
+	srv, calls := newFlakyServer(t, nil, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	res, err := Do(context.Background(), 3, func() (*http.Response, error) {
+		return http.Get(srv.URL)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", res.StatusCode)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+}
+
+func TestDoGivesUpAfterAttempts(t *testing.T) {
+	srv, calls := newFlakyServer(t, nil, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests)
+
+	res, err := Do(context.Background(), 2, func() (*http.Response, error) {
+		return http.Get(srv.URL)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected the last 429 response, got %d", res.StatusCode)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected 2 attempts, got %d", n)
+	}
+}
+
+func TestDoDoesNotRetryClientErrors(t *testing.T) {
+	srv, calls := newFlakyServer(t, nil, http.StatusNotFound)
+
+	res, err := Do(context.Background(), 3, func() (*http.Response, error) {
+		return http.Get(srv.URL)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound || calls.Load() != 1 {
+		t.Errorf("Expected a single 404 attempt, got %d after %d attempts", res.StatusCode, calls.Load())
+	}
+}
+
+func TestDoHonorsRetryAfter(t *testing.T) {
+	header := http.Header{"Retry-After": []string{"0"}}
+	srv, calls := newFlakyServer(t, header, http.StatusTooManyRequests)
+
+	// A long base delay would time out the test unless Retry-After is honored
+	BaseDelay = time.Hour
+	defer func() { BaseDelay = time.Millisecond }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := Do(ctx, 3, func() (*http.Response, error) {
+		return http.Get(srv.URL)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Errorf("Expected 200 after 2 attempts, got %d after %d", res.StatusCode, calls.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	}
+
+	for _, tt := range tests {
+		res := &http.Response{Header: http.Header{}}
+		if tt.value != "" {
+			res.Header.Set("Retry-After", tt.value)
+		}
+		got, ok := retryAfter(res)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = (%v, %v), want (%v, %v)", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
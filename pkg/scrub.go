@@ -0,0 +1,97 @@
+package envoy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Scrubbed replaces personal information removed by Scrub
+const Scrubbed = "[REDACTED]"
+
+// Strings at least this long which decode as base64 are treated as embedded
+// files, such as signature images or photos, and scrubbed wherever they occur
+const minBlobLength = 128
+
+// Keys of JSON fields holding personal information in any carrier's responses,
+// in lowercase
+var scrubbedFields = []string{
+	"name", "email", "emailaddress", "phone", "phonenumber", "signature", "photo", "image",
+}
+
+// Keys of JSON fields holding personal information in each carrier's
+// responses, in lowercase, in addition to scrubbedFields
+var carrierScrubbedFields = map[Carrier][]string{
+	CarrierFedEx: {
+		"streetlines", "personname", "companyname", "phoneextension", "receivedbyname",
+		"signedbyname", "authorizationname", "nickname",
+	},
+	CarrierUPS: {
+		"addressline1", "addressline2", "addressline3", "attentionname", "receivedby",
+	},
+	CarrierUSPS: {
+		"firm", "recipientname", "streetaddress",
+	},
+	CarrierDHL: {
+		"streetaddress", "organizationname", "signatureurl", "documenturl", "signed",
+	},
+}
+
+// Scrub redacts personal information from a raw carrier response, such as
+// recipient names, street addresses, contact details, and signature or proof
+// of delivery images, so that it can be shared in bug reports or committed as
+// a test fixture. The structure of the response is preserved: string values
+// are replaced with Scrubbed, and empty ones are left empty. Responses which
+// are not JSON cannot be scrubbed, so nil is returned for them.
+func Scrub(raw []byte, carrier Carrier) []byte {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil
+	}
+
+	fields := make(map[string]bool)
+	for _, f := range scrubbedFields {
+		fields[f] = true
+	}
+	for _, f := range carrierScrubbedFields[carrier] {
+		fields[f] = true
+	}
+
+	scrubbed, err := json.Marshal(scrubValue(v, fields, false))
+	if err != nil {
+		return nil
+	}
+	return scrubbed
+}
+
+// Returns v with its string values scrubbed if within a scrubbed field, or
+// if they look like embedded files
+func scrubValue(v any, fields map[string]bool, scrub bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			v[k] = scrubValue(child, fields, scrub || fields[strings.ToLower(k)])
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = scrubValue(child, fields, scrub)
+		}
+	case string:
+		if v != "" && (scrub || isBlob(v)) {
+			return Scrubbed
+		}
+	}
+	return v
+}
+
+// Reports whether s looks like a base64 encoded file
+func isBlob(s string) bool {
+	if len(s) < minBlobLength || strings.ContainsAny(s, " \n") {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,80 @@
+package envoy
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	blob := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("signature image bytes ", 10)))
+
+	tests := []struct {
+		carrier Carrier
+		raw     string
+		pii     []string
+		kept    []string
+	}{
+		{
+			carrier: CarrierFedEx,
+			raw: `{"output":{"completeTrackResults":[{"trackResults":[{
+				"recipientInformation":{"contact":{"personName":"Jane Doe","phoneNumber":"5125550100","emailAddress":"jane@example.com"},
+					"address":{"streetLines":["1 Main St","Apt 2"],"city":"AUSTIN","stateOrProvinceCode":"TX"}},
+				"deliveryDetails":{"receivedByName":"J.DOE","signedByName":"JDOE","actualDeliveryAddress":{"streetLines":["1 Main St"],"city":"AUSTIN"}},
+				"scanEvents":[{"eventType":"DL","derivedStatusCode":"DL","scanLocation":{"streetLines":[""],"city":"AUSTIN"}}]}]}]}}`,
+			pii:  []string{"Jane Doe", "5125550100", "jane@example.com", "1 Main St", "Apt 2", "J.DOE", "JDOE"},
+			kept: []string{"AUSTIN", `"TX"`, `"DL"`, `"streetLines":[""]`},
+		},
+		{
+			carrier: CarrierUPS,
+			raw: `{"trackResponse":{"shipment":[{"package":[{
+				"packageAddress":[{"type":"DESTINATION","name":"JANE DOE","attentionName":"JANE","address":{"addressLine1":"1 MAIN ST","city":"AUSTIN"}}],
+				"deliveryInformation":{"receivedBy":"DOE","signature":{"image":"` + blob + `"},"deliveryPhoto":{"photo":"` + blob + `","photoCaptureIndicator":"Y"}},
+				"activity":[{"status":{"type":"D","code":"FS"},"location":{"address":{"city":"AUSTIN"}}}]}]}]}}`,
+			pii:  []string{"JANE", "1 MAIN ST", `"DOE"`, blob},
+			kept: []string{"DESTINATION", "AUSTIN", `"Y"`, `"FS"`},
+		},
+		{
+			carrier: CarrierUSPS,
+			raw: `{"trackingNumber":"9400100000000000000000","destinationCity":"AUSTIN",
+				"trackingEvents":[{"eventType":"Delivered","eventCity":"AUSTIN","firm":"ACME CORP","name":"J DOE","eventCode":"01"}],
+				"enabledNotificationRequests":{"EMail":{"futureDelivery":true}}}`,
+			pii:  []string{"ACME CORP", "J DOE"},
+			kept: []string{"9400100000000000000000", "AUSTIN", `"futureDelivery":true`, `"01"`},
+		},
+		{
+			carrier: CarrierDHL,
+			raw: `{"shipments":[{"id":"1234567890","destination":{"address":{"addressLocality":"BERLIN","streetAddress":"Hauptstr. 1"}},
+				"details":{"proofOfDelivery":{"signatureUrl":"https://example.com/sig","signed":{"name":"Erika Mustermann"}},
+					"receiver":{"organizationName":"Muster GmbH"}},
+				"events":[{"statusCode":"delivered","location":{"address":{"addressLocality":"BERLIN"}}}]}]}`,
+			pii:  []string{"Hauptstr. 1", "https://example.com/sig", "Erika Mustermann", "Muster GmbH"},
+			kept: []string{"1234567890", "BERLIN", "delivered"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.carrier), func(t *testing.T) {
+			scrubbed := string(Scrub([]byte(tt.raw), tt.carrier))
+			if scrubbed == "" {
+				t.Fatal("Expected a scrubbed response")
+			}
+			for _, s := range tt.pii {
+				if strings.Contains(scrubbed, s) {
+					t.Errorf("Expected %q to be scrubbed from %s", s, scrubbed)
+				}
+			}
+			for _, s := range tt.kept {
+				if !strings.Contains(scrubbed, s) {
+					t.Errorf("Expected %q to be kept in %s", s, scrubbed)
+				}
+			}
+		})
+	}
+}
+
+func TestScrubNotJSON(t *testing.T) {
+	if scrubbed := Scrub([]byte("<html>Jane Doe</html>"), CarrierFedEx); scrubbed != nil {
+		t.Errorf("Expected nil for a response which is not JSON, got %s", scrubbed)
+	}
+}
@@ -1,15 +1,49 @@
 package envoy
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 )
 
+// ErrAuth indicates that a carrier rejected or could not issue credentials.
+var ErrAuth = errors.New("authentication failed")
+
+// ErrUpstream indicates that a carrier returned something other than the
+// expected API response, such as an HTML error or proxy page.
+var ErrUpstream = errors.New("unexpected response from carrier")
+
 type Service interface {
 	Track(trackingNumbers []string) ([]*Parcel, error)
 	Reauthenticate() error
 }
 
+// CachedToken is an access token in a form which can be persisted, so that it
+// can be reused by later runs until it expires
+type CachedToken struct {
+	Value      string    `json:"value"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// IsValid reports whether the token has not yet expired
+func (t *CachedToken) IsValid() bool {
+	return t != nil && t.Value != "" && t.Expiration.After(time.Now())
+}
+
+// TokenCacher is implemented by services which authenticate with expiring
+// access tokens
+type TokenCacher interface {
+	// CachedToken returns the current access token, or nil if there is none
+	CachedToken() *CachedToken
+	// SetCachedToken replaces the access token, so that it is used instead of
+	// reauthenticating until it expires
+	SetCachedToken(token *CachedToken)
+}
+
 type Carrier string
 
 const (
@@ -23,306 +57,446 @@ const (
 	CarrierUnknown   Carrier = "Unknown"
 )
 
-// DetectCarrier determines the carrier based on tracking number format
-func DetectCarrier(trackingNumber string) Carrier {
-	// Remove any spaces, hyphens, or other common separators
+// NormalizeTrackingNumber removes any spaces, hyphens, or other common
+// separators and uppercases the tracking number
+func NormalizeTrackingNumber(trackingNumber string) string {
+	trackingNumber = strings.TrimSpace(trackingNumber)
 	trackingNumber = strings.ReplaceAll(trackingNumber, " ", "")
 	trackingNumber = strings.ReplaceAll(trackingNumber, "-", "")
-	trackingNumber = strings.ToUpper(trackingNumber)
+	return strings.ToUpper(trackingNumber)
+}
+
+// TrackingURL returns the public tracking page for a tracking number, falling
+// back to a web search when the carrier has no known tracking page
+func TrackingURL(carrier Carrier, trackingNumber string) string {
+	tn := url.QueryEscape(trackingNumber)
+	switch carrier {
+	case CarrierFedEx:
+		return "https://www.fedex.com/apps/fedextrack/?tracknumbers=" + tn
+	case CarrierUPS:
+		return "https://www.ups.com/track?tracknum=" + tn
+	case CarrierUSPS:
+		return "https://tools.usps.com/go/TrackConfirmAction?tLabels=" + tn
+	case CarrierDHL:
+		return "https://www.dhl.com/global-en/home/tracking/tracking-parcel.html?submit=1&tracking-id=" + tn
+	case CarrierOnTrac, CarrierLaserShip:
+		return "https://www.ontrac.com/tracking/?number=" + tn
+	default:
+		return "https://www.google.com/search?q=" + url.QueryEscape(trackingNumber+" tracking")
+	}
+}
+
+// DetectCarrier determines the carrier based on tracking number format
+func DetectCarrier(trackingNumber string) Carrier {
+	carrier, _, _ := DetectCarrierDetail(trackingNumber)
+	return carrier
+}
 
+// carrierMatchers recognize the tracking number formats of each carrier,
+// returning the name of the matched format
+var carrierMatchers = map[Carrier]func(trackingNumber string) (string, bool){
+	CarrierDHL:   isDHL,
+	CarrierFedEx: isFedEx,
+	CarrierUPS:   isUPS,
+	CarrierUSPS:  isUSPS,
+}
+
+// defaultDetectionOrder is the order of precedence in which carriers are
+// matched when a tracking number fits the formats of more than one
+var defaultDetectionOrder = []Carrier{
 	// First try to determine carrier by distinctive patterns
-	if isDHL(trackingNumber) {
-		return CarrierDHL
+	CarrierDHL,
+	CarrierFedEx,
+	CarrierUPS,
+	// USPS check comes last as it has many formats, some similar to other carriers
+	CarrierUSPS,
+}
+
+// DetectionOrder is the order of precedence used when detecting carriers.
+var DetectionOrder = defaultDetectionOrder
+
+// ParseDetectionOrder validates an order of precedence for carrier detection.
+// Carrier names are case-insensitive, and carriers which are not listed keep
+// their default precedence after those that are.
+func ParseDetectionOrder(names []string) ([]Carrier, error) {
+	order := make([]Carrier, 0, len(defaultDetectionOrder))
+	for _, name := range names {
+		idx := slices.IndexFunc(defaultDetectionOrder, func(c Carrier) bool {
+			return strings.EqualFold(string(c), strings.TrimSpace(name))
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown carrier %q, expected one of %v", name, defaultDetectionOrder)
+		}
+		if slices.Contains(order, defaultDetectionOrder[idx]) {
+			return nil, fmt.Errorf("duplicate carrier %q", name)
+		}
+		order = append(order, defaultDetectionOrder[idx])
 	}
 
-	if isFedEx(trackingNumber) {
-		return CarrierFedEx
+	for _, c := range defaultDetectionOrder {
+		if !slices.Contains(order, c) {
+			order = append(order, c)
+		}
 	}
+	return order, nil
+}
 
-	if isUPS(trackingNumber) {
-		return CarrierUPS
+// DetectCarrierDetail determines the carrier based on tracking number format,
+// along with the name of the matched format and a confidence between 0 and 1.
+// The confidence is 1 when no other carrier's formats match, and is shared
+// equally between carriers when the number is ambiguous.
+func DetectCarrierDetail(trackingNumber string) (Carrier, string, float64) {
+	matches := matchCarriers(trackingNumber)
+	if len(matches) == 0 {
+		return CarrierUnknown, "", 0
 	}
+	return matches[0].carrier, matches[0].format, 1 / float64(len(matches))
+}
 
-	// USPS check comes last as it has many formats, some similar to other carriers
-	if _, isUSPS := isUSPS(trackingNumber); isUSPS {
-		return CarrierUSPS
+// DetectCarriers returns every carrier whose formats match the tracking
+// number, ordered from most to least likely
+func DetectCarriers(trackingNumber string) []Carrier {
+	matches := matchCarriers(trackingNumber)
+	carriers := make([]Carrier, 0, len(matches))
+	for _, m := range matches {
+		carriers = append(carriers, m.carrier)
 	}
+	return carriers
+}
 
-	return CarrierUnknown
+type carrierMatch struct {
+	carrier Carrier
+	format  string
+}
+
+// matchCarriers returns the carriers and formats matching the tracking
+// number, in order of precedence
+func matchCarriers(trackingNumber string) []carrierMatch {
+	trackingNumber = NormalizeTrackingNumber(trackingNumber)
+
+	var matches []carrierMatch
+	for _, carrier := range DetectionOrder {
+		if name, ok := carrierMatchers[carrier](trackingNumber); ok {
+			matches = append(matches, carrierMatch{carrier: carrier, format: name})
+		}
+	}
+	return matches
 }
 
 // isDHL checks if the tracking number is a valid DHL tracking number
-func isDHL(trackingNumber string) bool {
-	patterns := []string{
+// Returns the format name and a boolean indicating validity
+func isDHL(trackingNumber string) (string, bool) {
+	formats := []struct{ pattern, name string }{
 		// Standard DHL Express: 10 digits
-		`^\d{10}$`,
+		{`^\d{10}$`, "DHL Express"},
 
 		// DHL Express with JJD/JJD01/JJD00 prefix: 10 or 11 digits
-		`^JJD0?1?\d{10,11}$`,
+		{`^JJD0?1?\d{10,11}$`, "DHL Express (JJD)"},
 
 		// DHL Express starting with 1 and 10 digits
-		`^1\d{9}$`,
+		{`^1\d{9}$`, "DHL Express"},
 
 		// Standard DHL eCommerce: Several fixed formats
-		`^\d{4}[- ]?\d{4}[- ]?\d{2}$`,
-		`^[A-Z]{3}\d{7}$`,
-		`^[A-Z]{5}\d{10}$`,
-		`^420\d{27}$`,
+		{`^\d{4}[- ]?\d{4}[- ]?\d{2}$`, "DHL eCommerce"},
+		{`^[A-Z]{3}\d{7}$`, "DHL eCommerce"},
+		{`^[A-Z]{5}\d{10}$`, "DHL eCommerce"},
+		{`^420\d{27}$`, "DHL eCommerce"},
 
 		// German DHL: always 20 chars; either all numbers or starts with "JJD" followed by 18 digits
-		`^(JJD\d{18}|\d{20})$`,
+		{`^(JJD\d{18}|\d{20})$`, "DHL Germany"},
 
 		// International DHL: always numeric and 10 or 11 digits
-		`^\d{10,11}$`,
+		{`^\d{10,11}$`, "DHL International"},
 	}
 
 	// DHL patterns that could overlap with other carriers are further disambiguated
-	overlappingPatterns := map[string]bool{
+	overlappingPatterns := map[string]string{
 		// 10-digit DHL that overlaps with USPS money orders
 		// DHL format always starts with numbers >= 5
-		`^[5-9]\d{9}$`: true,
+		`^[5-9]\d{9}$`: "DHL Express",
 	}
 
 	// Check non-overlapping patterns first
-	for _, pattern := range patterns {
-		matched, _ := regexp.MatchString(pattern, trackingNumber)
+	for _, f := range formats {
+		matched, _ := regexp.MatchString(f.pattern, trackingNumber)
 		if matched {
 			// For 10-11 digit patterns, ensure it doesn't match UPS or FedEx specific patterns
 			if len(trackingNumber) == 10 || len(trackingNumber) == 11 {
 				if strings.HasPrefix(trackingNumber, "1Z") {
-					return false // This is likely a UPS tracking number
+					return "", false // This is likely a UPS tracking number
 				}
 			}
-			return true
+			return f.name, true
 		}
 	}
 
 	// Check potentially overlapping patterns
-	for pattern := range overlappingPatterns {
+	for pattern, formatName := range overlappingPatterns {
 		matched, _ := regexp.MatchString(pattern, trackingNumber)
 		if matched {
 			// DHL 10-digit tracking usually starts with 5-9
 			firstDigit := int(trackingNumber[0] - '0')
 			if firstDigit >= 5 {
-				return true
+				return formatName, true
 			}
 		}
 	}
 
-	return false
+	return "", false
 }
 
 // isUPS checks if the tracking number is a valid UPS tracking number
-func isUPS(trackingNumber string) bool {
-	patterns := []string{
+// Returns the format name and a boolean indicating validity
+func isUPS(trackingNumber string) (string, bool) {
+	formats := []struct{ pattern, name string }{
 		// UPS tracking number format: 1Z + 6 alphanumeric + 2 digits + 8 digits
-		`^1Z[A-Z0-9]{6}\d{2}\d{8}$`,
+		{`^1Z[A-Z0-9]{6}\d{2}\d{8}$`, "UPS 1Z"},
 
 		// UPS Mail Innovations: starts with MI, YW, or UP prefix followed by digits
-		`^(MI|YW|UP)\d{15,22}$`,
+		{`^(MI|YW|UP)\d{15,22}$`, "UPS Mail Innovations"},
 
 		// UPS Freight: starts with H followed by 9 or 10 digits
-		`^H\d{9,10}$`,
+		{`^H\d{9,10}$`, "UPS Freight"},
 
 		// UPS alternative format (rare but exists): 9 digits
-		`^T\d{10}$`,
-		`^\d{9}$`,
+		{`^T\d{10}$`, "UPS Alternative"},
+		{`^\d{9}$`, "UPS Alternative"},
 
 		// UPS SurePost: Start with 92 but have specific handling and can often be verified by character count
-		`^92\d{17,20}$`,
+		{`^92\d{17,20}$`, "UPS SurePost"},
 
 		// UPS Next Day Air & 2nd Day Air
-		`^[0-9]{12}$`,
+		{`^[0-9]{12}$`, "UPS Air"},
 
 		// UPS Innovations (USPS delivery for Last Mile)
-		`^[0-9]{18}$`,
+		{`^[0-9]{18}$`, "UPS Innovations"},
 	}
 
-	for _, pattern := range patterns {
-		matched, _ := regexp.MatchString(pattern, trackingNumber)
+	for _, f := range formats {
+		matched, _ := regexp.MatchString(f.pattern, trackingNumber)
 		if matched {
 			// Special handling for the 92-prefix format
 			// UPS SurePost deliveries vs USPS
 			if strings.HasPrefix(trackingNumber, "92") {
 				// UPS SurePost typically has 20 digits total, but need more logic for certainty
 				// This is a simplified check, more sophisticated checks would consider check digits
-				return len(trackingNumber) == 20
+				return f.name, len(trackingNumber) == 20
 			}
 
-			// 1Z is a distinctive UPS prefix
+			// 1Z is a distinctive UPS prefix, but must also carry a valid check digit
 			if strings.HasPrefix(trackingNumber, "1Z") {
-				return true
+				return f.name, validateUPSCheckDigit(trackingNumber)
 			}
 
 			// For 9-digit formats, verify it's not a USPS format
 			if len(trackingNumber) == 9 && regexp.MustCompile(`^\d{9}$`).MatchString(trackingNumber) {
 				// This would need additional logic to be certain
-				return true
+				return f.name, true
 			}
 
-			return true
+			return f.name, true
+		}
+	}
+
+	return "", false
+}
+
+// validateUPSCheckDigit verifies the mod-10 check digit of a 1Z tracking number.
+// Letters in the body are mapped to digits, then odd positions are weighted by
+// 1 and even positions by 2.
+func validateUPSCheckDigit(tn string) bool {
+	if len(tn) != 18 || !strings.HasPrefix(tn, "1Z") {
+		return false
+	}
+
+	body := tn[2:17]
+	sum := 0
+	for i, c := range body {
+		var v int
+		switch {
+		case c >= '0' && c <= '9':
+			v = int(c - '0')
+		case c >= 'A' && c <= 'Z':
+			v = int(c-3) % 10
+		default:
+			return false
+		}
+		if i%2 == 1 {
+			v *= 2
 		}
+		sum += v
 	}
 
-	return false
+	check := tn[17]
+	if check < '0' || check > '9' {
+		return false
+	}
+	return (10-sum%10)%10 == int(check-'0')
 }
 
 // isFedEx checks if the tracking number is a valid FedEx tracking number
-func isFedEx(trackingNumber string) bool {
-	patterns := []string{
+// Returns the format name and a boolean indicating validity
+func isFedEx(trackingNumber string) (string, bool) {
+	formats := []struct{ pattern, name string }{
 		// FedEx Express (air): 12 digits
-		`^\d{12}$`,
+		{`^\d{12}$`, "FedEx Express"},
 
 		// FedEx Ground: 15 digits, starts with 96 or 98
-		`^(96|98)\d{13}$`,
+		{`^(96|98)\d{13}$`, "FedEx Ground"},
 
 		// FedEx SmartPost: 20 digits
 		// Can start with 92 (shared with USPS) but specific length
-		`^92\d{18}$`,
+		{`^92\d{18}$`, "FedEx SmartPost"},
 
 		// FedEx Express (international): 12 digits
-		`^\d{12}$`,
+		{`^\d{12}$`, "FedEx Express (international)"},
 
 		// FedEx Ground (96...)
-		`^96\d{20}$`,
+		{`^96\d{20}$`, "FedEx Ground (96)"},
 
 		// FedEx Ground Home Delivery
-		`^9\d{11}$`,
+		{`^9\d{11}$`, "FedEx Ground Home Delivery"},
 
 		// FedEx Ground 15-digit barcode format (all numeric)
-		`^\d{15}$`,
+		{`^\d{15}$`, "FedEx Ground"},
 
 		// FedEx 2D tracking codes - typically 14 alpha/numeric
-		`^[A-Z0-9]{14}$`,
+		{`^[A-Z0-9]{14}$`, "FedEx 2D"},
 
 		// FedEx Ground SSCC-18 barcode format
-		`^\d{18}$`,
+		{`^\d{18}$`, "FedEx Ground SSCC-18"},
 
 		// FedEx door tag number
-		`^DT\d{12}$`,
+		{`^DT\d{12}$`, "FedEx Door Tag"},
 	}
 
-	for _, pattern := range patterns {
-		matched, _ := regexp.MatchString(pattern, trackingNumber)
+	for _, f := range formats {
+		matched, _ := regexp.MatchString(f.pattern, trackingNumber)
 		if matched {
 			// For 12-digit format (which could be shared with UPS),
 			// we need additional check logic
 			if len(trackingNumber) == 12 && regexp.MustCompile(`^\d{12}$`).MatchString(trackingNumber) {
 				// Certain FedEx patterns have check digit validation
 				// (simplified example - real validation would involve more complex math)
-				return true
+				return f.name, true
 			}
 
 			// For SSCC-18 format (shared with other carriers), verify it's FedEx
 			if len(trackingNumber) == 18 && regexp.MustCompile(`^\d{18}$`).MatchString(trackingNumber) {
 				// Would need additional logic to be certain
-				return true
+				return f.name, true
 			}
 
 			// 92-prefix formats with length 20 can be FedEx SmartPost
 			if strings.HasPrefix(trackingNumber, "92") && len(trackingNumber) == 20 {
 				// This would need additional verification for certainty
-				return true
+				return f.name, true
 			}
 
 			// 96/98 prefixes are distinctive to FedEx Ground
 			if strings.HasPrefix(trackingNumber, "96") || strings.HasPrefix(trackingNumber, "98") {
-				return true
+				return f.name, true
 			}
 
 			// DT prefix is distinctive to FedEx door tags
 			if strings.HasPrefix(trackingNumber, "DT") {
-				return true
+				return f.name, true
 			}
 
-			return true
+			return f.name, true
 		}
 	}
 
-	return false
+	return "", false
 }
 
 // isUSPS checks if the tracking number is a valid USPS tracking number
 // Returns the format name and a boolean indicating validity
 func isUSPS(trackingNumber string) (string, bool) {
+	// GS1-128 and IMpb numbers with a 91-94 prefix always carry a check digit,
+	// which rules out most collisions with UPS SurePost and FedEx SmartPost
+	if hasUSPSCheckDigit(trackingNumber) && !validateUSPSMod10(trackingNumber) {
+		return "", false
+	}
+
 	// Define patterns for different USPS tracking number formats with their format names
-	formats := map[string]string{
+	formats := []struct{ pattern, name string }{
 		// GS1-128 Formats with 91 prefix (USPS specific)
-		`^91\d{18}$`: "USPS GS1-128 (91)",
+		{`^91\d{18}$`, "USPS GS1-128 (91)"},
 
 		// For 92, 93, 94 prefixes, we need to be selective since they're shared with other carriers
 		// 92-prefix that is distinctly USPS and not UPS/FedEx
-		`^92[1-7]\d{17}$`: "USPS GS1-128 (92)",
-		`^93\d{18}$`:      "USPS GS1-128 (93)",
-		`^94\d{18}$`:      "USPS GS1-128 (94)",
+		{`^92[1-7]\d{17}$`, "USPS GS1-128 (92)"},
+		{`^93\d{18}$`, "USPS GS1-128 (93)"},
+		{`^94\d{18}$`, "USPS GS1-128 (94)"},
 
 		// 22-digit format (91 prefix - USPS specific)
-		`^91\d{20}$`: "USPS 22-digit",
+		{`^91\d{20}$`, "USPS 22-digit"},
 
 		// 30-digit format with ZIP Code (USPS specific)
-		`^420\d{5}91\d{18}$`: "USPS ZIP+GS1",
+		{`^420\d{5}91\d{18}$`, "USPS ZIP+GS1"},
 
 		// Format with 420 (ZIP) + S.T.I. - USPS specific
-		`^420\d{5}[0-9]{2}\d{12}$`: "USPS ZIP+STI",
+		{`^420\d{5}[0-9]{2}\d{12}$`, "USPS ZIP+STI"},
 
 		// 34-digit USPS Electronic Shipping Info
-		`^420\d{5}91\d{27}$`: "USPS Electronic Shipping",
+		{`^420\d{5}91\d{27}$`, "USPS Electronic Shipping"},
 
 		// Legacy and Special USPS-specific Formats
-		`^[A-Z]{2}\d{9}US$`: "USPS International",
+		{`^[A-Z]{2}\d{9}US$`, "USPS International"},
 
 		// 13-character domestic format (USPS-specific)
-		`^\d{4}\d{9}$`: "USPS 13-char Domestic",
+		{`^\d{4}\d{9}$`, "USPS 13-char Domestic"},
 
 		// 20-character format (USPS-specific international)
-		`^[A-Z]{2}\d{9}[A-Z0-9]{9}$`: "USPS 20-char International",
+		{`^[A-Z]{2}\d{9}[A-Z0-9]{9}$`, "USPS 20-char International"},
 
 		// Priority Mail Express (USPS-specific)
-		`^E[A-Z]\d{9}[A-Z]$`: "USPS Priority Express A",
-		`^E[A-Z]\d{9}$`:      "USPS Priority Express B",
+		{`^E[A-Z]\d{9}[A-Z]$`, "USPS Priority Express A"},
+		{`^E[A-Z]\d{9}$`, "USPS Priority Express B"},
 
 		// Certified Mail (USPS-specific)
-		`^9407\d{16}$`: "USPS Certified Mail",
+		{`^9407\d{16}$`, "USPS Certified Mail"},
 
 		// Registered Mail (USPS-specific)
-		`^9208\d{16}$`: "USPS Registered Mail",
+		{`^9208\d{16}$`, "USPS Registered Mail"},
 
 		// Express Mail International (USPS-specific)
-		`^EC\d{9}[A-Z]{2}$`: "USPS Express Int'l",
+		{`^EC\d{9}[A-Z]{2}$`, "USPS Express Int'l"},
 
 		// Money Order (USPS-specific)
-		`^[1-4]\d{9,10}$`: "USPS Money Order",
+		{`^[1-4]\d{9,10}$`, "USPS Money Order"},
 
 		// Military Mail (USPS-specific)
-		`^[A-Z]{2}\d{9}$`: "USPS Military Mail",
+		{`^[A-Z]{2}\d{9}$`, "USPS Military Mail"},
 
 		// International inbound (USPS-specific)
-		`^[A-Z]{2}\d{9}[A-Z]{2}$`: "USPS Int'l Inbound",
+		{`^[A-Z]{2}\d{9}[A-Z]{2}$`, "USPS Int'l Inbound"},
 
 		// Signature Confirmation (USPS-specific)
-		`^9202\d{16}$`: "USPS Signature Conf A",
-		`^9202\d{20}$`: "USPS Signature Conf B",
+		{`^9202\d{16}$`, "USPS Signature Conf A"},
+		{`^9202\d{20}$`, "USPS Signature Conf B"},
 
 		// Standard post package (USPS-specific)
-		`^03\d{18}$`: "USPS Standard Post",
+		{`^03\d{18}$`, "USPS Standard Post"},
 
 		// COD tracking (USPS-specific)
-		`^9303\d{16}$`: "USPS COD",
+		{`^9303\d{16}$`, "USPS COD"},
 
 		// Insured mail (USPS-specific)
-		`^92[0-9][0-9]\d{16}$`: "USPS Insured Mail",
+		{`^92[0-9][0-9]\d{16}$`, "USPS Insured Mail"},
 
 		// First-Class Package (USPS-specific)
-		`^9400\d{16}$`: "USPS First-Class",
+		{`^9400\d{16}$`, "USPS First-Class"},
 
 		// Return Receipt (USPS-specific)
-		`^9590\d{16}$`: "USPS Return Receipt",
+		{`^9590\d{16}$`, "USPS Return Receipt"},
 
 		// Not sure??
-		`^92\d{20}$`:    "USPS Unknown",
-		`^93\d{18,20}$`: "USPS Unknown",
-		`^94\d{18,20}$`: "USPS Unknown",
-		`^95\d{18,20}$`: "USPS Unknown",
+		{`^92\d{20}$`, "USPS Unknown"},
+		{`^93\d{18,20}$`, "USPS Unknown"},
+		{`^94\d{18,20}$`, "USPS Unknown"},
+		{`^95\d{18,20}$`, "USPS Unknown"},
 	}
 
 	// Special case formats that need additional checks to avoid overlapping with other carriers
@@ -343,8 +517,9 @@ func isUSPS(trackingNumber string) (string, bool) {
 	}
 
 	// Check standard formats first
-	for pattern, formatName := range formats {
-		matched, _ := regexp.MatchString(pattern, trackingNumber)
+	for _, f := range formats {
+		formatName := f.name
+		matched, _ := regexp.MatchString(f.pattern, trackingNumber)
 		if matched {
 			// For 92-prefix, verify it's not a UPS SurePost or FedEx SmartPost
 			if strings.HasPrefix(trackingNumber, "92") {
@@ -378,3 +553,45 @@ func isUSPS(trackingNumber string) (string, bool) {
 
 	return "", false
 }
+
+// hasUSPSCheckDigit reports whether the tracking number is a 91-94 prefixed
+// GS1-128 or IMpb number, which ends in a mod-10 check digit
+func hasUSPSCheckDigit(tn string) bool {
+	if len(tn) < 20 {
+		return false
+	}
+	for _, c := range tn {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return tn[0] == '9' && tn[1] >= '1' && tn[1] <= '4'
+}
+
+// validateUSPSMod10 verifies the USPS/GS1 mod-10 check digit. Starting from
+// the digit just left of the check digit, digits are alternately weighted by
+// 3 and 1.
+func validateUSPSMod10(tn string) bool {
+	if len(tn) < 2 {
+		return false
+	}
+
+	sum := 0
+	for i := len(tn) - 2; i >= 0; i-- {
+		c := tn[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		v := int(c - '0')
+		if (len(tn)-2-i)%2 == 0 {
+			v *= 3
+		}
+		sum += v
+	}
+
+	check := tn[len(tn)-1]
+	if check < '0' || check > '9' {
+		return false
+	}
+	return (10-sum%10)%10 == int(check-'0')
+}
@@ -1,6 +1,10 @@
 package envoy
 
 import (
+	"io"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -12,7 +16,7 @@ func TestDetectCarrier(t *testing.T) {
 	}{
 		{
 			name:     "USPS GS1-128 (91)",
-			tracking: "9102001234567890123456",
+			tracking: "9102001234567890123452",
 			want:     CarrierUSPS,
 		},
 		{
@@ -22,12 +26,12 @@ func TestDetectCarrier(t *testing.T) {
 		},
 		{
 			name:     "USPS GS1-128 (93)",
-			tracking: "9302001234567890123456",
+			tracking: "9302001234567890123450",
 			want:     CarrierUSPS,
 		},
 		{
 			name:     "USPS First-Class",
-			tracking: "9400123456789012345678",
+			tracking: "9400123456789012345674",
 			want:     CarrierUSPS,
 		},
 		{
@@ -55,11 +59,26 @@ func TestDetectCarrier(t *testing.T) {
 			tracking: "92184903716531000000100565",
 			want:     CarrierUSPS,
 		},
+		{
+			name:     "USPS bad check digit",
+			tracking: "9400123456789012345678",
+			want:     CarrierUnknown,
+		},
+		{
+			name:     "USPS realworld example bad check digit",
+			tracking: "92184903716531000000100566",
+			want:     CarrierUnknown,
+		},
 		{
 			name:     "UPS 1Z",
-			tracking: "1Z1234567890123456",
+			tracking: "1Z5R89390357567127",
 			want:     CarrierUPS,
 		},
+		{
+			name:     "UPS 1Z bad check digit",
+			tracking: "1ZAAAAAA0000000000",
+			want:     CarrierUnknown,
+		},
 		{
 			name:     "UPS Mail Innovations",
 			tracking: "MI1234567890123456",
@@ -125,3 +144,178 @@ func TestDetectCarrier(t *testing.T) {
 		})
 	}
 }
+
+func TestDetectCarrierWritesNoOutput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	for _, tn := range []string{"1Z5R89390357567127", "441259201412", "92001903104186015180053869", "UNKNOWN"} {
+		DetectCarrier(tn)
+	}
+
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > 0 {
+		t.Errorf("Expected no output during detection, got %q", out)
+	}
+}
+
+func TestDetectCarrierDetail(t *testing.T) {
+	tests := []struct {
+		tracking   string
+		carrier    Carrier
+		format     string
+		confidence float64
+	}{
+		{"1Z5R89390357567127", CarrierUPS, "UPS 1Z", 1},
+		{"9102001234567890123452", CarrierUSPS, "USPS 22-digit", 1},
+		{"JJD123456789012345678", CarrierDHL, "DHL Germany", 1},
+		{"441259201412", CarrierFedEx, "FedEx Express", 0.5},
+		{"1ZAAAAAA0000000000", CarrierUnknown, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tracking, func(t *testing.T) {
+			carrier, format, confidence := DetectCarrierDetail(tt.tracking)
+			if carrier != tt.carrier || format != tt.format || confidence != tt.confidence {
+				t.Errorf(
+					"DetectCarrierDetail() = (%v, %q, %v), want (%v, %q, %v)",
+					carrier, format, confidence, tt.carrier, tt.format, tt.confidence,
+				)
+			}
+		})
+	}
+}
+
+func TestDetectCarriers(t *testing.T) {
+	tests := []struct {
+		name     string
+		tracking string
+		want     []Carrier
+	}{
+		{"12-digit overlap", "441259201412", []Carrier{CarrierFedEx, CarrierUPS}},
+		{"18-digit overlap", "123456789012345678", []Carrier{CarrierFedEx, CarrierUPS}},
+		{"UPS 1Z", "1Z5R89390357567127", []Carrier{CarrierUPS}},
+		{"unknown", "NOTATRACKINGNUMBER", []Carrier{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectCarriers(tt.tracking); !slices.Equal(got, tt.want) {
+				t.Errorf("DetectCarriers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUPSCheckDigit(t *testing.T) {
+	tests := []struct {
+		tracking string
+		want     bool
+	}{
+		{"1Z5R89390357567127", true},
+		{"1ZW701150378674373", true},
+		{"1Z999AA10123456784", true},
+		{"1Z5R89390357567120", false},
+		{"1ZW701150378674374", false},
+		{"1Z1234567890123456", false},
+		{"1ZAAAAAA0000000000", false},
+		{"1Z5R8939035756712", false},
+		{"1Z5R8939035756712A", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tracking, func(t *testing.T) {
+			if got := validateUPSCheckDigit(tt.tracking); got != tt.want {
+				t.Errorf("validateUPSCheckDigit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUSPSMod10(t *testing.T) {
+	tests := []struct {
+		tracking string
+		want     bool
+	}{
+		{"92001903104186015180053869", true},
+		{"92184903716531000000100565", true},
+		{"9261290339741308689554", true},
+		{"9208123456789012345678", true},
+		{"9405511899223197428490", true},
+		{"92001903104186015180053868", false},
+		{"92184903716531000000100556", false},
+		{"9261290339741308689545", false},
+		{"9400111899223197428490", false},
+		{"940551189922319742849A", false},
+		{"9", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tracking, func(t *testing.T) {
+			if got := validateUSPSMod10(tt.tracking); got != tt.want {
+				t.Errorf("validateUSPSMod10() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDetectionOrder(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    []Carrier
+		wantErr bool
+	}{
+		{nil, []Carrier{CarrierDHL, CarrierFedEx, CarrierUPS, CarrierUSPS}, false},
+		{[]string{"USPS", "UPS", "FedEx", "DHL"}, []Carrier{CarrierUSPS, CarrierUPS, CarrierFedEx, CarrierDHL}, false},
+		{[]string{"ups"}, []Carrier{CarrierUPS, CarrierDHL, CarrierFedEx, CarrierUSPS}, false},
+		{[]string{"UPS", "Amazon"}, nil, true},
+		{[]string{"UPS", "ups"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.names, ","), func(t *testing.T) {
+			got, err := ParseDetectionOrder(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDetectionOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseDetectionOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectCarrierWithDetectionOrder(t *testing.T) {
+	defer func(order []Carrier) { DetectionOrder = order }(DetectionOrder)
+
+	const ambiguous = "441259201412"
+	if got := DetectCarrier(ambiguous); got != CarrierFedEx {
+		t.Fatalf("DetectCarrier() = %v with the default order, want %v", got, CarrierFedEx)
+	}
+
+	order, err := ParseDetectionOrder([]string{"USPS", "UPS", "FedEx", "DHL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DetectionOrder = order
+
+	if got := DetectCarrier(ambiguous); got != CarrierUPS {
+		t.Errorf("DetectCarrier() = %v with UPS preferred, want %v", got, CarrierUPS)
+	}
+	if got := DetectCarriers(ambiguous); !slices.Equal(got, []Carrier{CarrierUPS, CarrierFedEx}) {
+		t.Errorf("DetectCarriers() = %v, want [UPS FedEx]", got)
+	}
+	// Unambiguous numbers are unaffected
+	if got := DetectCarrier("1Z5R89390357567127"); got != CarrierUPS {
+		t.Errorf("DetectCarrier() = %v, want %v", got, CarrierUPS)
+	}
+}
@@ -0,0 +1,88 @@
+package envoy
+
+// Stage is a milestone in a parcel's journey, in the order they are reached.
+type Stage int
+
+const (
+	StageLabelCreated Stage = iota
+	StagePickedUp
+	StageInTransit
+	StageOutForDelivery
+	StageDelivered
+)
+
+// Stages lists every stage in the order they are reached
+var Stages = []Stage{
+	StageLabelCreated,
+	StagePickedUp,
+	StageInTransit,
+	StageOutForDelivery,
+	StageDelivered,
+}
+
+func (s Stage) String() string {
+	switch s {
+	case StageLabelCreated:
+		return "Label Created"
+	case StagePickedUp:
+		return "Picked Up"
+	case StageInTransit:
+		return "In Transit"
+	case StageOutForDelivery:
+		return "Out for Delivery"
+	case StageDelivered:
+		return "Delivered"
+	default:
+		return "Unknown"
+	}
+}
+
+// Stage returns the stage an event shows a parcel has reached, or false if
+// the event does not show progress, such as an exception
+func (t ParcelEventType) Stage() (Stage, bool) {
+	switch t {
+	case ParcelEventTypeOrderConfirmed:
+		return StageLabelCreated, true
+	case ParcelEventTypePickedUp:
+		return StagePickedUp, true
+	case ParcelEventTypeDeparted,
+		ParcelEventTypeProcessing,
+		ParcelEventTypeInTransit,
+		ParcelEventTypeArrived,
+		ParcelEventTypeTransferredToLocal:
+		return StageInTransit, true
+	case ParcelEventTypeOnVehicle,
+		ParcelEventTypeOutForDelivery,
+		ParcelEventTypeAwaitingCustomerPickup:
+		return StageOutForDelivery, true
+	case ParcelEventTypeDelivered:
+		return StageDelivered, true
+	default:
+		return 0, false
+	}
+}
+
+// Stage returns the furthest stage that the parcel's events show it has
+// reached, and whether its latest event is a problem, such as a delay or
+// exception, which is holding it there.
+func (p *Parcel) Stage() (Stage, bool) {
+	if !p.HasData() {
+		return StageLabelCreated, false
+	}
+
+	stage := StageLabelCreated
+	for _, e := range p.Data.Events {
+		if s, ok := e.Type.Stage(); ok && s > stage {
+			stage = s
+		}
+	}
+	if p.Data.Delivered {
+		stage = StageDelivered
+	}
+	if stage == StageDelivered {
+		return stage, false
+	}
+
+	last := p.LastTrackingEvent()
+	return stage, last != nil && last.Type.Severity() == SeverityError
+}
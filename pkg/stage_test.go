@@ -0,0 +1,47 @@
+package envoy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParcelStage(t *testing.T) {
+	start := time.Date(2025, 2, 20, 9, 0, 0, 0, time.UTC)
+	events := func(types ...ParcelEventType) *ParcelData {
+		data := &ParcelData{}
+		for i, typ := range types {
+			data.Events = append(data.Events, ParcelEvent{Type: typ, Timestamp: start.Add(time.Duration(i) * time.Hour)})
+		}
+		return data
+	}
+
+	tests := []struct {
+		name      string
+		data      *ParcelData
+		stage     Stage
+		exception bool
+	}{
+		{"no data", nil, StageLabelCreated, false},
+		{"label created", events(ParcelEventTypeOrderConfirmed), StageLabelCreated, false},
+		{"picked up", events(ParcelEventTypeOrderConfirmed, ParcelEventTypePickedUp), StagePickedUp, false},
+		{"in transit", events(ParcelEventTypePickedUp, ParcelEventTypeDeparted, ParcelEventTypeArrived), StageInTransit, false},
+		{"unknown scans keep the stage", events(ParcelEventTypePickedUp, ParcelEventTypeInTransit, ParcelEventTypeUnknown), StageInTransit, false},
+		{"out for delivery", events(ParcelEventTypeInTransit, ParcelEventTypeOnVehicle), StageOutForDelivery, false},
+		{"delayed", events(ParcelEventTypePickedUp, ParcelEventTypeInTransit, ParcelEventTypeDelayed), StageInTransit, true},
+		{"recovered from exception", events(ParcelEventTypeInTransit, ParcelEventTypeException, ParcelEventTypeOutForDelivery), StageOutForDelivery, false},
+		{"failed delivery", events(ParcelEventTypeOutForDelivery, ParcelEventTypeUndeliverable), StageOutForDelivery, true},
+		{"delivered", events(ParcelEventTypePickedUp, ParcelEventTypeOutForDelivery, ParcelEventTypeDelivered), StageDelivered, false},
+		{"delivered by carrier", &ParcelData{Delivered: true, Events: events(ParcelEventTypeInTransit).Events}, StageDelivered, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParcel("test", CarrierUPS, "1Z999AA10123456784", "")
+			p.Data = tt.data
+			stage, exception := p.Stage()
+			if stage != tt.stage || exception != tt.exception {
+				t.Errorf("Stage() = %v, %v, want %v, %v", stage, exception, tt.stage, tt.exception)
+			}
+		})
+	}
+}
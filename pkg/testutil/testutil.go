@@ -0,0 +1,99 @@
+// Package testutil helps test carrier services against recorded responses
+// rather than the live APIs.
+package testutil
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+)
+
+// RoundTripFunc answers requests with a function, so that a service's HTTP
+// client can be pointed at canned responses, including for endpoints which are
+// not relative to the service's BaseURL
+type RoundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Client returns an HTTP client whose requests are all answered by fn
+func Client(fn RoundTripFunc) *http.Client {
+	return &http.Client{Transport: fn}
+}
+
+// JSONResponse builds a response to req with the status and JSON body
+func JSONResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// Fixture returns the contents of a recorded response, failing the test if it
+// cannot be read
+func Fixture(tb testing.TB, name string) string {
+	tb.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return string(b)
+}
+
+// WantParcel describes the parcel expected from tracking a number
+type WantParcel struct {
+	TrackingNumber string
+	Delivered      bool
+	// The number of events, and the type, location, and time of the latest
+	Events       int
+	LastType     envoy.ParcelEventType
+	LastLocation string
+	LastAt       time.Time
+}
+
+// CheckParcels reports each parcel which differs from the one expected in the
+// same position
+func CheckParcels(tb testing.TB, got []*envoy.Parcel, want []WantParcel) {
+	tb.Helper()
+	if len(got) != len(want) {
+		tb.Fatalf("Expected %d parcels, got %d", len(want), len(got))
+	}
+	for i, w := range want {
+		p := got[i]
+		if p.TrackingNumber != w.TrackingNumber {
+			tb.Errorf("Expected parcel %d to be %s, got %s", i, w.TrackingNumber, p.TrackingNumber)
+			continue
+		}
+		if p.HasError() || !p.HasData() {
+			tb.Errorf("%s: expected tracking data, got error %v", w.TrackingNumber, p.Error)
+			continue
+		}
+		if p.Data.Delivered != w.Delivered {
+			tb.Errorf("%s: Delivered = %v, want %v", w.TrackingNumber, p.Data.Delivered, w.Delivered)
+		}
+		if len(p.Data.Events) != w.Events {
+			tb.Errorf("%s: expected %d events, got %d", w.TrackingNumber, w.Events, len(p.Data.Events))
+		}
+		e := p.LastTrackingEvent()
+		if e == nil {
+			if w.LastType != "" {
+				tb.Errorf("%s: expected a latest event of %s, got none", w.TrackingNumber, w.LastType)
+			}
+			continue
+		}
+		if e.Type != w.LastType || e.Location != w.LastLocation || !e.Timestamp.Equal(w.LastAt) {
+			tb.Errorf(
+				"%s: expected latest event %s at %s on %s, got %s at %s on %s",
+				w.TrackingNumber, w.LastType, w.LastLocation, w.LastAt, e.Type, e.Location, e.Timestamp,
+			)
+		}
+	}
+}
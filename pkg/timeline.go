@@ -0,0 +1,141 @@
+package envoy
+
+import (
+	"slices"
+)
+
+// TimelinePosition is the place of a node within a parcel's timeline.
+type TimelinePosition string
+
+const (
+	TimelinePositionOnly   TimelinePosition = "ONLY"
+	TimelinePositionFirst  TimelinePosition = "FIRST"
+	TimelinePositionMiddle TimelinePosition = "MIDDLE"
+	TimelinePositionLast   TimelinePosition = "LAST"
+)
+
+// Severity classifies how noteworthy an event is to the recipient.
+type Severity string
+
+const (
+	SeverityNormal  Severity = "NORMAL"
+	SeveritySuccess Severity = "SUCCESS"
+	SeverityError   Severity = "ERROR"
+)
+
+func (t ParcelEventType) Severity() Severity {
+	switch t {
+	case ParcelEventTypeDelivered:
+		return SeveritySuccess
+	case ParcelEventTypeParcelHeld,
+		ParcelEventTypeReturnedToSender,
+		ParcelEventTypeUndeliverable,
+		ParcelEventTypeDelayed,
+		ParcelEventTypeException:
+		return SeverityError
+	default:
+		// Events the carrier mappings do not cover yet are usually routine
+		// scans, so unknown events are not treated as problems
+		return SeverityNormal
+	}
+}
+
+// TimelineNode is a single event placed within a parcel's timeline.
+type TimelineNode struct {
+	Event    ParcelEvent
+	Position TimelinePosition
+	Severity Severity
+}
+
+// Timeline returns the parcel's events as nodes ordered from oldest to newest,
+// so that renderers can share the layout logic.
+func (p *Parcel) Timeline() []TimelineNode {
+	if !p.HasData() || len(p.Data.Events) == 0 {
+		return nil
+	}
+
+	events := slices.Clone(p.Data.Events)
+	slices.SortStableFunc(events, func(a, b ParcelEvent) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	nodes := make([]TimelineNode, 0, len(events))
+	for i, e := range events {
+		pos := TimelinePositionMiddle
+		if len(events) == 1 {
+			pos = TimelinePositionOnly
+		} else if i == 0 {
+			pos = TimelinePositionFirst
+		} else if i == len(events)-1 {
+			pos = TimelinePositionLast
+		}
+		nodes = append(nodes, TimelineNode{
+			Event:    e,
+			Position: pos,
+			Severity: e.Type.Severity(),
+		})
+	}
+	return nodes
+}
+
+// TimelineGroup is a run of consecutive timeline nodes at the same location,
+// such as the arrival, processing, and departure scans at one facility.
+type TimelineGroup struct {
+	Nodes    []TimelineNode
+	Position TimelinePosition
+	// The most severe severity of the grouped nodes
+	Severity Severity
+}
+
+// First returns the oldest node in the group.
+func (g *TimelineGroup) First() *TimelineNode {
+	return &g.Nodes[0]
+}
+
+// Last returns the newest node in the group.
+func (g *TimelineGroup) Last() *TimelineNode {
+	return &g.Nodes[len(g.Nodes)-1]
+}
+
+// severityRank orders severities from least to most severe
+var severityRank = map[Severity]int{
+	SeverityNormal:  0,
+	SeveritySuccess: 1,
+	SeverityError:   2,
+}
+
+// CollapseTimeline groups consecutive nodes at the same location, so that a
+// long timeline can be shown with one line per stop. Nodes without a location
+// are never grouped. Positions are recomputed over the groups.
+func CollapseTimeline(nodes []TimelineNode) []TimelineGroup {
+	var groups []TimelineGroup
+	for _, n := range nodes {
+		if len(groups) > 0 {
+			g := &groups[len(groups)-1]
+			if loc := g.Last().Event.Location; loc != "" && loc == n.Event.Location {
+				g.Nodes = append(g.Nodes, n)
+				if severityRank[n.Severity] > severityRank[g.Severity] {
+					g.Severity = n.Severity
+				}
+				continue
+			}
+		}
+		groups = append(groups, TimelineGroup{
+			Nodes:    []TimelineNode{n},
+			Severity: n.Severity,
+		})
+	}
+
+	for i := range groups {
+		pos := TimelinePositionMiddle
+		if len(groups) == 1 {
+			pos = TimelinePositionOnly
+		} else if i == 0 {
+			pos = TimelinePositionFirst
+		} else if i == len(groups)-1 {
+			pos = TimelinePositionLast
+		}
+		groups[i].Position = pos
+	}
+	return groups
+}
@@ -0,0 +1,103 @@
+package envoy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParcelTimeline(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+
+	parcel := NewParcel("Test Parcel", CarrierFedEx, "441259201412", "")
+	parcel.Data = &ParcelData{
+		// Carriers typically report the newest event first
+		Events: []ParcelEvent{
+			{Type: ParcelEventTypeDelivered, Description: "Delivered", Timestamp: timeNow.Add(26 * time.Hour)},
+			{Type: ParcelEventTypeDelayed, Description: "Delayed", Timestamp: timeNow.Add(2 * time.Hour)},
+			{Type: ParcelEventTypeArrived, Description: "Arrived", Timestamp: timeNow.Add(1 * time.Hour)},
+			{Type: ParcelEventTypeOrderConfirmed, Description: "Label created", Timestamp: timeNow},
+		},
+	}
+
+	want := []struct {
+		description string
+		position    TimelinePosition
+		severity    Severity
+	}{
+		{"Label created", TimelinePositionFirst, SeverityNormal},
+		{"Arrived", TimelinePositionMiddle, SeverityNormal},
+		{"Delayed", TimelinePositionMiddle, SeverityError},
+		{"Delivered", TimelinePositionLast, SeveritySuccess},
+	}
+
+	nodes := parcel.Timeline()
+	if len(nodes) != len(want) {
+		t.Fatalf("expected %d nodes, got %d", len(want), len(nodes))
+	}
+	for i, w := range want {
+		n := nodes[i]
+		if n.Event.Description != w.description || n.Position != w.position || n.Severity != w.severity {
+			t.Errorf(
+				"node %d: expected %s/%s/%s, got %s/%s/%s",
+				i, w.description, w.position, w.severity,
+				n.Event.Description, n.Position, n.Severity,
+			)
+		}
+	}
+
+	parcel.Data.Events = parcel.Data.Events[:1]
+	if nodes := parcel.Timeline(); len(nodes) != 1 || nodes[0].Position != TimelinePositionOnly {
+		t.Errorf("expected a single node positioned ONLY, got %+v", nodes)
+	}
+}
+
+func TestCollapseTimeline(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+
+	parcel := NewParcel("Test Parcel", CarrierFedEx, "441259201412", "")
+	parcel.Data = &ParcelData{
+		Events: []ParcelEvent{
+			{Type: ParcelEventTypeOrderConfirmed, Description: "Label created", Timestamp: timeNow},
+			{Type: ParcelEventTypeArrived, Description: "Arrived", Location: "MEMPHIS, TN", Timestamp: timeNow.Add(1 * time.Hour)},
+			{Type: ParcelEventTypeDelayed, Description: "Delayed", Location: "MEMPHIS, TN", Timestamp: timeNow.Add(2 * time.Hour)},
+			{Type: ParcelEventTypeDeparted, Description: "Departed", Location: "MEMPHIS, TN", Timestamp: timeNow.Add(3 * time.Hour)},
+			{Type: ParcelEventTypeArrived, Description: "Arrived", Location: "DENVER, CO", Timestamp: timeNow.Add(9 * time.Hour)},
+			{Type: ParcelEventTypeDelivered, Description: "Delivered", Location: "DENVER, CO", Timestamp: timeNow.Add(26 * time.Hour)},
+		},
+	}
+
+	want := []struct {
+		description string
+		count       int
+		position    TimelinePosition
+		severity    Severity
+	}{
+		{"Label created", 1, TimelinePositionFirst, SeverityNormal},
+		{"Departed", 3, TimelinePositionMiddle, SeverityError},
+		{"Delivered", 2, TimelinePositionLast, SeveritySuccess},
+	}
+
+	groups := CollapseTimeline(parcel.Timeline())
+	if len(groups) != len(want) {
+		t.Fatalf("expected %d groups, got %d", len(want), len(groups))
+	}
+	for i, w := range want {
+		g := groups[i]
+		if g.Last().Event.Description != w.description || len(g.Nodes) != w.count || g.Position != w.position || g.Severity != w.severity {
+			t.Errorf(
+				"group %d: expected %s/%d/%s/%s, got %s/%d/%s/%s",
+				i, w.description, w.count, w.position, w.severity,
+				g.Last().Event.Description, len(g.Nodes), g.Position, g.Severity,
+			)
+		}
+	}
+
+	// Events without a location are never grouped
+	parcel.Data.Events = []ParcelEvent{
+		{Type: ParcelEventTypeOrderConfirmed, Description: "Label created", Timestamp: timeNow},
+		{Type: ParcelEventTypeOrderConfirmed, Description: "Label updated", Timestamp: timeNow.Add(time.Hour)},
+	}
+	if groups := CollapseTimeline(parcel.Timeline()); len(groups) != 2 {
+		t.Errorf("expected events without a location to stay apart, got %+v", groups)
+	}
+}
@@ -0,0 +1,136 @@
+// Package tracking tracks parcels with whichever carrier they belong to, for
+// programs which use envoy as a library rather than running the envoy binary.
+package tracking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/dhl"
+	"github.com/rektdeckard/envoy/pkg/fedex"
+	"github.com/rektdeckard/envoy/pkg/ups"
+	"github.com/rektdeckard/envoy/pkg/usps"
+)
+
+// ErrUnsupportedCarrier is returned for carriers envoy has no service for
+var ErrUnsupportedCarrier = errors.New("unsupported carrier")
+
+// ErrNotFound is returned when no carrier has data for a tracking number
+var ErrNotFound = errors.New("no tracking data found")
+
+// HTTPClient is used by Track for all carrier requests. Replace it to use a
+// proxy or custom transport.
+var HTTPClient = &http.Client{}
+
+// CarrierCredentials authenticate with a carrier's API. DHL only uses Key.
+type CarrierCredentials struct {
+	Key    string
+	Secret string
+}
+
+// Credentials hold the API credentials of each carrier. Carriers without
+// credentials are skipped.
+type Credentials struct {
+	FedEx CarrierCredentials
+	UPS   CarrierCredentials
+	USPS  CarrierCredentials
+	DHL   CarrierCredentials
+}
+
+// For returns the credentials of a carrier, and false if it is not supported
+func (c Credentials) For(carrier envoy.Carrier) (CarrierCredentials, bool) {
+	switch carrier {
+	case envoy.CarrierFedEx:
+		return c.FedEx, true
+	case envoy.CarrierUPS:
+		return c.UPS, true
+	case envoy.CarrierUSPS:
+		return c.USPS, true
+	case envoy.CarrierDHL:
+		return c.DHL, true
+	default:
+		return CarrierCredentials{}, false
+	}
+}
+
+// NewService constructs the tracking service of a carrier
+func NewService(client *http.Client, carrier envoy.Carrier, creds CarrierCredentials) (envoy.Service, error) {
+	switch carrier {
+	case envoy.CarrierFedEx:
+		return fedex.NewFedexService(client, creds.Key, creds.Secret), nil
+	case envoy.CarrierUPS:
+		return ups.NewUPSService(client, creds.Key, creds.Secret), nil
+	case envoy.CarrierUSPS:
+		return usps.NewUSPSService(client, creds.Key, creds.Secret), nil
+	case envoy.CarrierDHL:
+		return dhl.NewDHLService(client, creds.Key), nil
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedCarrier, carrier)
+	}
+}
+
+// Track detects the carrier of a tracking number and returns its parcel. When
+// the number could belong to several carriers, each with credentials is tried
+// in order of likelihood until one has data. Cancelling ctx aborts any
+// request in flight.
+func Track(ctx context.Context, trackingNumber string, creds Credentials) (*envoy.Parcel, error) {
+	tn := envoy.NormalizeTrackingNumber(trackingNumber)
+	client := &http.Client{
+		Timeout:   HTTPClient.Timeout,
+		Transport: &contextTransport{ctx: ctx, base: HTTPClient.Transport},
+	}
+
+	var errs []error
+	for _, carrier := range envoy.DetectCarriers(tn) {
+		c, ok := creds.For(carrier)
+		if !ok || c.Key == "" {
+			continue
+		}
+		svc, err := NewService(client, carrier, c)
+		if err != nil {
+			return nil, err
+		}
+
+		parcels, err := svc.Track([]string{tn})
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", carrier, err))
+			continue
+		}
+		for _, p := range parcels {
+			if p.TrackingNumber != tn {
+				continue
+			}
+			if p.HasError() {
+				errs = append(errs, fmt.Errorf("%s: %w", carrier, p.Error))
+			} else if p.HasData() {
+				return p, nil
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, fmt.Errorf("%w for %s", ErrNotFound, tn)
+}
+
+// contextTransport makes requests with a context, as the carrier services
+// construct their requests without one
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req.WithContext(t.ctx))
+}
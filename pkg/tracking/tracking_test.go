@@ -0,0 +1,159 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/dhl"
+	"github.com/rektdeckard/envoy/pkg/fedex"
+	"github.com/rektdeckard/envoy/pkg/ups"
+	"github.com/rektdeckard/envoy/pkg/usps"
+)
+
+// carrierTransport answers requests with canned responses for each carrier's
+// API host, keyed by a substring of the request path
+type carrierTransport map[string]map[string]string
+
+func (t carrierTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for path, body := range t[req.URL.Host] {
+		if strings.Contains(req.URL.Path, path) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+		Request:    req,
+	}, nil
+}
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestTrack(t *testing.T) {
+	defer func(c *http.Client) { HTTPClient = c }(HTTPClient)
+	HTTPClient = &http.Client{Transport: carrierTransport{
+		"apis.fedex.com": {
+			"/oauth/token": `{"access_token":"token","expires_in":3600}`,
+			"/track/":      readFixture(t, "../fedex/testdata/estimated_delivery.json"),
+		},
+		"onlinetools.ups.com": {
+			"/oauth/token": `{"access_token":"token","expires_in":"3600"}`,
+			"/track/":      readFixture(t, "../ups/testdata/estimated_delivery.json"),
+		},
+		"apis.usps.com": {
+			"/oauth2/v3/token": `{"access_token":"token","expires_in":3600,"status":"approved","scope":"tracking"}`,
+			// Labels are tracked in batches, answered with an array
+			"/tracking/": "[" + readFixture(t, "../usps/testdata/estimated_delivery.json") + "]",
+		},
+		"api-eu.dhl.com": {
+			"/track/shipments": readFixture(t, "../dhl/testdata/shipment.json"),
+		},
+	}}
+
+	creds := Credentials{
+		FedEx: CarrierCredentials{Key: "key", Secret: "secret"},
+		UPS:   CarrierCredentials{Key: "key", Secret: "secret"},
+		USPS:  CarrierCredentials{Key: "key", Secret: "secret"},
+		DHL:   CarrierCredentials{Key: "key"},
+	}
+	tests := []struct {
+		trackingNumber string
+		carrier        envoy.Carrier
+	}{
+		{"7948 4318 5271", envoy.CarrierFedEx},
+		{"1Z5R89390357567127", envoy.CarrierUPS},
+		{"9400123456789012345674", envoy.CarrierUSPS},
+		{"7777777770", envoy.CarrierDHL},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.carrier), func(t *testing.T) {
+			p, err := Track(context.Background(), tt.trackingNumber, creds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Carrier != tt.carrier || p.TrackingNumber != envoy.NormalizeTrackingNumber(tt.trackingNumber) {
+				t.Errorf("Expected a %s parcel for %s, got %s %s", tt.carrier, tt.trackingNumber, p.Carrier, p.TrackingNumber)
+			}
+		})
+	}
+}
+
+func TestTrackWithoutCredentials(t *testing.T) {
+	_, err := Track(context.Background(), "1Z5R89390357567127", Credentials{})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without credentials, got %v", err)
+	}
+}
+
+func TestTrackCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Track(ctx, "7777777770", Credentials{DHL: CarrierCredentials{Key: "key"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNewService(t *testing.T) {
+	client := &http.Client{}
+	creds := CarrierCredentials{Key: "key", Secret: "secret"}
+
+	tests := []struct {
+		carrier envoy.Carrier
+		check   func(envoy.Service) bool
+	}{
+		{envoy.CarrierFedEx, func(s envoy.Service) bool {
+			f, ok := s.(*fedex.FedexService)
+			return ok && f.Client == client && f.APIKey == "key" && f.APISecret == "secret"
+		}},
+		{envoy.CarrierUPS, func(s envoy.Service) bool {
+			u, ok := s.(*ups.UPSService)
+			return ok && u.Client == client && u.APIKey == "key" && u.APISecret == "secret"
+		}},
+		{envoy.CarrierUSPS, func(s envoy.Service) bool {
+			u, ok := s.(*usps.USPSService)
+			return ok && u.Client == client && u.ConsumerKey == "key" && u.ConsumerSecret == "secret"
+		}},
+		{envoy.CarrierDHL, func(s envoy.Service) bool {
+			d, ok := s.(*dhl.DHLService)
+			return ok && d.Client == client && d.APIKey == "key"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.carrier), func(t *testing.T) {
+			svc, err := NewService(client, tt.carrier, creds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(svc) {
+				t.Errorf("Expected a %s service using the given client and credentials, got %#v", tt.carrier, svc)
+			}
+		})
+	}
+
+	for _, carrier := range []envoy.Carrier{envoy.CarrierAmazon, envoy.CarrierUnknown} {
+		if _, err := NewService(client, carrier, creds); !errors.Is(err, ErrUnsupportedCarrier) {
+			t.Errorf("Expected ErrUnsupportedCarrier for %s, got %v", carrier, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package envoy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UnitSystem is the system of measurement weights and dimensions are shown in.
+type UnitSystem string
+
+const (
+	// Shown in the units reported by the carrier
+	UnitSystemCarrier  UnitSystem = ""
+	UnitSystemImperial UnitSystem = "imperial"
+	UnitSystemMetric   UnitSystem = "metric"
+)
+
+const (
+	kilogramsPerPound  = 0.45359237
+	centimetersPerInch = 2.54
+	unitPound          = "LB"
+	unitKilogram       = "KG"
+	unitInch           = "IN"
+	unitCentimeter     = "CM"
+)
+
+// ParseUnitSystem parses a unit system, ignoring case. An empty string parses
+// as the carrier's units.
+func ParseUnitSystem(s string) (UnitSystem, error) {
+	switch system := UnitSystem(strings.ToLower(strings.TrimSpace(s))); system {
+	case UnitSystemCarrier, UnitSystemImperial, UnitSystemMetric:
+		return system, nil
+	default:
+		return "", fmt.Errorf("unknown unit system: %q", s)
+	}
+}
+
+// Canonical units of weight and length, by the spellings carriers use
+var (
+	weightUnits = map[string]string{
+		"LB": unitPound, "LBS": unitPound, "POUND": unitPound, "POUNDS": unitPound,
+		"KG": unitKilogram, "KGS": unitKilogram, "KILOGRAM": unitKilogram, "KILOGRAMS": unitKilogram,
+	}
+	lengthUnits = map[string]string{
+		"IN": unitInch, "INCH": unitInch, "INCHES": unitInch,
+		"CM": unitCentimeter, "CENTIMETER": unitCentimeter, "CENTIMETERS": unitCentimeter,
+	}
+)
+
+// Weight parses the value as a weight and converts it to the unit system,
+// returning it with its unit. Weights are left in the carrier's units when no
+// system is given or their units are not recognized.
+func (d Dimensioned) Weight(system UnitSystem) (float64, string, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(d.Value), 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid weight %q: %w", d.Value, err)
+	}
+	unit, ok := weightUnits[strings.ToUpper(strings.TrimSpace(d.Units))]
+	if !ok || system == UnitSystemCarrier {
+		return v, d.Units, nil
+	}
+
+	switch {
+	case system == UnitSystemMetric && unit == unitPound:
+		return v * kilogramsPerPound, unitKilogram, nil
+	case system == UnitSystemImperial && unit == unitKilogram:
+		return v / kilogramsPerPound, unitPound, nil
+	default:
+		return v, unit, nil
+	}
+}
+
+// Convert returns the length, width, and height converted to the unit
+// system, along with their unit. Sizes are left in the carrier's units when
+// no system is given or their units are not recognized.
+func (s Size) Convert(system UnitSystem) (length, width, height float64, units string) {
+	length, width, height = float64(s.Length), float64(s.Width), float64(s.Height)
+	unit, ok := lengthUnits[strings.ToUpper(strings.TrimSpace(s.Units))]
+	if !ok || system == UnitSystemCarrier {
+		return length, width, height, s.Units
+	}
+
+	factor := 1.0
+	switch {
+	case system == UnitSystemMetric && unit == unitInch:
+		factor, unit = centimetersPerInch, unitCentimeter
+	case system == UnitSystemImperial && unit == unitCentimeter:
+		factor, unit = 1/centimetersPerInch, unitInch
+	}
+	return length * factor, width * factor, height * factor, unit
+}
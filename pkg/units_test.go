@@ -0,0 +1,69 @@
+package envoy
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseUnitSystem(t *testing.T) {
+	for s, want := range map[string]UnitSystem{
+		"":         UnitSystemCarrier,
+		"imperial": UnitSystemImperial,
+		" Metric ": UnitSystemMetric,
+	} {
+		if got, err := ParseUnitSystem(s); err != nil || got != want {
+			t.Errorf("ParseUnitSystem(%q) = %q, %v, want %q", s, got, err, want)
+		}
+	}
+	if _, err := ParseUnitSystem("furlongs"); err == nil {
+		t.Error("Expected an error for an unknown unit system")
+	}
+}
+
+func TestDimensionedWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		weight Dimensioned
+		system UnitSystem
+		value  float64
+		units  string
+	}{
+		{"lb to kg", Dimensioned{Units: "LB", Value: "5.1"}, UnitSystemMetric, 2.3133, "KG"},
+		{"kg to lb", Dimensioned{Units: "KG", Value: "2.3"}, UnitSystemImperial, 5.0706, "LB"},
+		{"lbs to lb", Dimensioned{Units: "LBS", Value: "5.10"}, UnitSystemImperial, 5.1, "LB"},
+		{"carrier units", Dimensioned{Units: "LBS", Value: "5.10"}, UnitSystemCarrier, 5.1, "LBS"},
+		{"unknown units", Dimensioned{Units: "STONE", Value: "1"}, UnitSystemMetric, 1, "STONE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, units, err := tt.weight.Weight(tt.system)
+			if err != nil {
+				t.Fatalf("Weight() error = %v", err)
+			}
+			if math.Abs(value-tt.value) > 0.0001 || units != tt.units {
+				t.Errorf("Weight() = %v %s, want %v %s", value, units, tt.value, tt.units)
+			}
+		})
+	}
+
+	if _, _, err := (Dimensioned{Units: "LB", Value: "heavy"}).Weight(UnitSystemMetric); err == nil {
+		t.Error("Expected an error for an invalid weight")
+	}
+}
+
+func TestSizeConvert(t *testing.T) {
+	l, w, h, units := Size{Length: 12, Width: 8, Height: 4, Units: "IN"}.Convert(UnitSystemMetric)
+	if l != 30.48 || w != 20.32 || h != 10.16 || units != "CM" {
+		t.Errorf("Convert() = %vx%vx%v %s, want 30.48x20.32x10.16 CM", l, w, h, units)
+	}
+
+	l, w, h, units = Size{Length: 254, Width: 127, Height: 254, Units: "cm"}.Convert(UnitSystemImperial)
+	if math.Abs(l-100) > 0.0001 || math.Abs(w-50) > 0.0001 || math.Abs(h-100) > 0.0001 || units != "IN" {
+		t.Errorf("Convert() = %vx%vx%v %s, want 100x50x100 IN", l, w, h, units)
+	}
+
+	l, w, h, units = Size{Length: 30, Width: 20, Height: 10, Units: "CM"}.Convert(UnitSystemCarrier)
+	if l != 30 || w != 20 || h != 10 || units != "CM" {
+		t.Errorf("Convert() = %vx%vx%v %s, want 30x20x10 CM", l, w, h, units)
+	}
+}
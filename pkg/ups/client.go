@@ -63,7 +63,8 @@ func setHttpClientTimeouts(httpClient *http.Client) *http.Client {
 		transport.IdleConnTimeout = idleConnTimeout
 		transport.ResponseHeaderTimeout = responseHeaderTimeout
 		transport.ExpectContinueTimeout = expectContinueTimeout
-	} else {
+	} else if httpClient.Transport == nil {
+		// Keep any custom transport, such as one adding proxy headers
 		httpClient.Transport = &http.Transport{
 			TLSHandshakeTimeout:   tlsHandshakeTimeout,
 			IdleConnTimeout:       idleConnTimeout,
@@ -1,17 +1,21 @@
 package ups
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/pool"
+	"github.com/rektdeckard/envoy/pkg/retry"
 )
 
 var (
@@ -23,16 +27,27 @@ type UPSService struct {
 	APIKey    string
 	APISecret string
 	Token     *Token
+	// The number of attempts made for tracking requests which fail transiently
+	MaxAttempts int
+	// The number of tracking requests in flight at once
+	MaxConcurrency int
 }
 
-// Enforce that UPSService implements the Service interface
-var _ envoy.Service = &UPSService{}
+// Enforce that UPSService implements the Service, TokenCacher, and
+// ProofOfDeliveryService interfaces
+var (
+	_ envoy.Service                = &UPSService{}
+	_ envoy.TokenCacher            = &UPSService{}
+	_ envoy.ProofOfDeliveryService = &UPSService{}
+)
 
 func NewUPSService(client *http.Client, apiKey, apiSecret string) *UPSService {
 	return &UPSService{
-		Client:    client,
-		APIKey:    apiKey,
-		APISecret: apiSecret,
+		Client:         client,
+		APIKey:         apiKey,
+		APISecret:      apiSecret,
+		MaxAttempts:    retry.DefaultAttempts,
+		MaxConcurrency: pool.DefaultLimit,
 	}
 }
 
@@ -54,34 +69,100 @@ func (s *UPSService) Reauthenticate() error {
 	return nil
 }
 
-func (s *UPSService) Track(trackingNumbers []string) ([]*envoy.Parcel, error) {
-	const endpoint = "/api/track/v1/details/"
-
-	if s.Token == nil || !s.Token.isValid() {
-		if err := s.Reauthenticate(); err != nil {
-			return nil, err
-		}
+// authenticate requests a new token when none is cached or the cached one has
+// expired, guarding against a response which parsed without issuing one
+func (s *UPSService) authenticate() error {
+	if s.Token.isValid() {
+		return nil
+	}
+	if err := s.Reauthenticate(); err != nil {
+		return err
+	}
+	if s.Token == nil || s.Token.value == "" {
+		return fmt.Errorf("%w: UPS issued no access token", envoy.ErrAuth)
 	}
+	return nil
+}
 
+func (s *UPSService) Track(trackingNumbers []string) ([]*envoy.Parcel, error) {
 	params := url.Values{
 		"locale":           []string{"en_US"},
 		"returnSignature":  []string{"false"},
 		"returnMilestones": []string{"false"},
 		"returnPOD":        []string{"false"},
 	}
+
+	// Authenticate before fanning out, so that the requests share one token
+	if err := s.authenticate(); err != nil {
+		return nil, err
+	}
+
+	// Each tracking number gets its own slot, so parcels are returned in the
+	// order they were requested, and failures are recorded on a parcel rather
+	// than discarding those which were tracked
+	results := make([][]*envoy.Parcel, len(trackingNumbers))
+	pool.Run(s.MaxConcurrency, len(trackingNumbers), func(i int) {
+		tn := trackingNumbers[i]
+		trackingRes, err := s.details(tn, params)
+		if err != nil {
+			p := envoy.NewParcel(tn, envoy.CarrierUPS, tn, envoy.TrackingURL(envoy.CarrierUPS, tn))
+			p.Error = err
+			results[i] = []*envoy.Parcel{p}
+			return
+		}
+
+		for _, shipment := range trackingRes.TrackResponse.Shipment {
+			for _, p := range shipment.Package {
+				results[i] = append(results[i], p.parcel())
+			}
+		}
+	})
+
+	return slices.Concat(results...), nil
+}
+
+// ProofOfDelivery fetches the delivery photo, signature, or proof of delivery
+// letter of a delivered package, in that order of preference
+func (s *UPSService) ProofOfDelivery(trackingNumber string) (*envoy.ProofOfDelivery, error) {
+	params := url.Values{
+		"locale":           []string{"en_US"},
+		"returnSignature":  []string{"true"},
+		"returnMilestones": []string{"false"},
+		"returnPOD":        []string{"true"},
+	}
+
+	trackingRes, err := s.details(trackingNumber, params)
+	if err != nil {
+		return nil, err
+	}
+	for _, shipment := range trackingRes.TrackResponse.Shipment {
+		for _, p := range shipment.Package {
+			if p.TrackingNumber == trackingNumber || len(shipment.Package) == 1 {
+				return p.proofOfDelivery()
+			}
+		}
+	}
+	return nil, envoy.ErrNoProofOfDelivery
+}
+
+// details requests the tracking details of a single tracking number
+func (s *UPSService) details(trackingNumber string, params url.Values) (*response, error) {
+	const endpoint = "/api/track/v1/details/"
+
+	if err := s.authenticate(); err != nil {
+		return nil, err
+	}
+
 	headers := http.Header{
 		"Authorization":  []string{"Bearer " + s.Token.value},
 		"TransId":        []string{"1ZW701150378674373"},
 		"TransactionSrc": []string{"envoy"},
 	}
 
-	var parcels []*envoy.Parcel
-	// wg := sync.WaitGroup{}
-
-	for _, trackingNumber := range trackingNumbers {
-		url := BaseURL.ResolveReference(&url.URL{Path: endpoint + trackingNumber})
-		url.RawQuery = params.Encode()
+	url := BaseURL.ResolveReference(&url.URL{Path: endpoint + trackingNumber})
+	url.RawQuery = params.Encode()
 
+	res, err := retry.Do(context.Background(), s.MaxAttempts, func() (*http.Response, error) {
 		req, err := http.NewRequest(http.MethodGet, url.String(), nil)
 		if err != nil {
 			return nil, err
@@ -89,78 +170,127 @@ func (s *UPSService) Track(trackingNumbers []string) ([]*envoy.Parcel, error) {
 
 		req.Header = headers
 
-		res, err := s.Client.Do(req)
-		if err != nil {
-			return nil, err
-		}
+		return s.Client.Do(req)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		defer res.Body.Close()
+	defer res.Body.Close()
 
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, err
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := envoy.CheckJSONResponse(envoy.CarrierUPS, res, body); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var errRes errorResponse
+		if json.Unmarshal(body, &errRes) == nil && len(errRes.Response.Errors) > 0 {
+			e := errRes.Response.Errors[0]
+			return nil, fmt.Errorf("UPS error %s: %s (status %d)", e.Code, e.Message, res.StatusCode)
 		}
-		// fmt.Println(string(body))
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var trackingRes response
+	if err := json.Unmarshal(body, &trackingRes); err != nil {
+		return nil, err
+	}
+	return &trackingRes, nil
+}
 
-		if res.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+// name returns the name on the package's origin address, which is usually the
+// shipper, falling back to any named address and then the tracking number
+func (p *Package) name() string {
+	var fallback string
+	for _, a := range p.PackageAddress {
+		if a == nil || strings.TrimSpace(a.Name) == "" {
+			continue
 		}
+		if a.Type == "ORIGIN" {
+			return strings.TrimSpace(a.Name)
+		}
+		if fallback == "" {
+			fallback = strings.TrimSpace(a.Name)
+		}
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return p.TrackingNumber
+}
 
-		var trackingRes response
-		if err := json.Unmarshal(body, &trackingRes); err != nil {
-			return nil, err
+func (p *Package) parcel() *envoy.Parcel {
+	parcel := envoy.NewParcel(
+		p.name(),
+		envoy.CarrierUPS,
+		p.TrackingNumber,
+		envoy.TrackingURL(envoy.CarrierUPS, p.TrackingNumber),
+	)
+	parcel.Data = &envoy.ParcelData{}
+	parcel.Raw = envoy.RawJSON(p)
+	for _, alt := range p.AlternateTrackingNumber {
+		if alt != nil {
+			parcel.AlternateTrackingNumbers = append(parcel.AlternateTrackingNumbers, alt.Number)
 		}
-		// d, _ := json.MarshalIndent(trackingRes, "", "  ")
-		// fmt.Println(string(d))
+	}
 
-		for _, shipment := range trackingRes.TrackResponse.Shipment {
-			for _, p := range shipment.Package {
-				// TODO: figure out a default name for the parcel
-				name := p.TrackingNumber
-				parcel := envoy.NewParcel(
-					name,
-					envoy.CarrierUPS,
-					p.TrackingNumber,
-					fmt.Sprintf("https://www.ups.com/track?tracknum=%s", p.TrackingNumber),
-				)
-				parcel.Data = &envoy.ParcelData{}
-
-				for _, dd := range p.DeliveryDate {
-					if dd.Type != DeliveryDateTypeScheduled && dd.Type != DeliveryDateTypeRescheduled {
-						continue
-					}
-					d, err := time.Parse("20060102", dd.Date)
-					if err != nil {
-						log.Fatalf("error parsing delivery date: %v", err)
-						continue
-					}
-					if parcel.Data.DeliveryProjection != nil && d.After(*parcel.Data.DeliveryProjection) {
-						parcel.Data.DeliveryProjection = &d
-					}
-				}
-
-				var lastEvent *Activity
-				for _, a := range p.Activity {
-					if lastEvent == nil || a.Date > lastEvent.Date {
-						lastEvent = a
-					}
-					if a.Status.Type == "D" || a.Status.Code == "FS" {
-						parcel.Data.Delivered = true
-					}
-					parcel.Data.Events = append(parcel.Data.Events, envoy.ParcelEvent{
-						Timestamp:   a.Timestamp(),
-						Description: a.Status.Description,
-						Location:    a.Location.Address.String(),
-						Type:        a.Status.ParcelEventType(),
-					})
-				}
-
-				parcels = append(parcels, parcel)
-			}
+	parcel.Data.DeliveryProjection = p.deliveryProjection()
+	parcel.Data.Weight = p.Weight.dimensioned()
+	parcel.Data.Dimensions = p.Dimension.size()
+	if p.Service != nil {
+		parcel.Data.ServiceLevel = p.Service.serviceLevel()
+	}
+
+	delivered := false
+	for _, a := range p.Activity {
+		if a.isDelivered() {
+			delivered = true
+		}
+		parcel.Data.Events = append(parcel.Data.Events, envoy.ParcelEvent{
+			Timestamp:     a.Timestamp(),
+			Description:   a.Status.Description,
+			Location:      a.Location.Address.String(),
+			Type:          a.Status.ParcelEventType(),
+			SourceCarrier: envoy.CarrierUPS,
+		})
+	}
+	envoy.DefaultDeliveredStrategy.Resolve(parcel, delivered)
+	if parcel.Data.Delivered && p.DeliveryInformation != nil {
+		// UPS only returns the signature as an image, so there is no signer
+		parcel.Data.ReceivedBy = strings.TrimSpace(p.DeliveryInformation.ReceivedBy)
+	}
+	if parcel.Data.Delivered && p.AccessPointInformation != nil {
+		parcel.Data.DeliveredToAccessPoint = true
+		parcel.Data.PickupBy = p.AccessPointInformation.pickupBy()
+		if p.DeliveryInformation != nil {
+			parcel.Data.AccessPoint = strings.TrimSpace(p.DeliveryInformation.Location)
 		}
 	}
 
-	return parcels, nil
+	return parcel
+}
+
+// proofOfDelivery decodes the first delivery photo, signature, or proof of
+// delivery letter included with the package
+func (p *Package) proofOfDelivery() (*envoy.ProofOfDelivery, error) {
+	info := p.DeliveryInformation
+	if info == nil {
+		return nil, envoy.ErrNoProofOfDelivery
+	}
+	if info.DeliveryPhoto != nil && info.DeliveryPhoto.Photo != "" {
+		return envoy.DecodeProofOfDelivery(info.DeliveryPhoto.Photo)
+	}
+	if info.Signature != nil && info.Signature.Image != "" {
+		return envoy.DecodeProofOfDelivery(info.Signature.Image)
+	}
+	if info.POD != nil && info.POD.Content != "" {
+		return envoy.DecodeProofOfDelivery(info.POD.Content)
+	}
+	return nil, envoy.ErrNoProofOfDelivery
 }
 
 type Token struct {
@@ -168,8 +298,31 @@ type Token struct {
 	expiration time.Time
 }
 
+// isValid reports whether the token can still be sent, and is safe to call on
+// a nil token before the first authentication
 func (t *Token) isValid() bool {
-	return t.expiration.After(time.Now())
+	return t != nil && t.value != "" && t.expiration.After(time.Now())
+}
+
+// Value returns the bearer token sent with requests
+func (t *Token) Value() string {
+	return t.value
+}
+
+// Expiration returns when the token stops being accepted
+func (t *Token) Expiration() time.Time {
+	return t.expiration
+}
+
+func (s *UPSService) CachedToken() *envoy.CachedToken {
+	if s.Token == nil {
+		return nil
+	}
+	return &envoy.CachedToken{Value: s.Token.value, Expiration: s.Token.expiration}
+}
+
+func (s *UPSService) SetCachedToken(token *envoy.CachedToken) {
+	s.Token = &Token{value: token.Value, expiration: token.Expiration}
 }
 
 type response struct {
@@ -178,6 +331,17 @@ type response struct {
 	} `json:"trackResponse"`
 }
 
+// errorResponse is returned in place of tracking details when a request fails,
+// such as for a tracking number UPS does not know
+type errorResponse struct {
+	Response struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"response"`
+}
+
 type Shipment struct {
 	InquiryNumber string     `json:"inquiryNumber"`
 	Package       []*Package `json:"package"`
@@ -195,7 +359,9 @@ type Package struct {
 	// Populated only when the package is delivered.
 	DeliveryInformation *DeliveryInformation `json:"deliveryInformation"`
 	Dimension           Dimension            `json:"dimension"`
+	Weight              *Weight              `json:"weight"`
 	PackageAddress      []*PackageAddress    `json:"packageAddress"`
+	Service             *Service             `json:"service"`
 	// The total number of packages in the shipment.
 	// Note that this number may be greater than the number of returned packages in the
 	// response. In such cases subsequent calls are needed to get additional packages.
@@ -226,12 +392,88 @@ type AlternateTrackingNumber struct {
 	Type string `json:"type"`
 }
 
+// The service the package was shipped with
+type Service struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	LevelCode   string `json:"levelCode"`
+}
+
+// serviceLevelNames maps UPS service level codes, without leading zeros, to
+// the names the services are marketed under
+var serviceLevelNames = map[string]string{
+	"1":  "UPS Next Day Air",
+	"2":  "UPS 2nd Day Air",
+	"3":  "UPS Ground",
+	"7":  "UPS Worldwide Express",
+	"8":  "UPS Worldwide Expedited",
+	"11": "UPS Standard",
+	"12": "UPS 3 Day Select",
+	"13": "UPS Next Day Air Saver",
+	"14": "UPS Next Day Air Early",
+	"54": "UPS Worldwide Express Plus",
+	"59": "UPS 2nd Day Air A.M.",
+	"65": "UPS Worldwide Saver",
+	"92": "UPS SurePost",
+	"93": "UPS SurePost",
+}
+
+// serviceLevel returns the name UPS markets the service under, falling back
+// to the description UPS gave it
+func (s *Service) serviceLevel() string {
+	if name, ok := serviceLevelNames[strings.TrimLeft(strings.TrimSpace(s.LevelCode), "0")]; ok {
+		return name
+	}
+	return strings.TrimSpace(s.Description)
+}
+
 // The container that has all the information related to the access point where the package is destined for/delivered to.
 type AccessPointInformation struct {
 	// Format: "YYYYMMDD"
 	PickupByDate string `json:"pickupByDate"`
 }
 
+// pickupBy returns the last day the package can be collected from the access
+// point, or nil if it is not known
+func (a *AccessPointInformation) pickupBy() *time.Time {
+	if a.PickupByDate == "" {
+		return nil
+	}
+	t, err := time.Parse("20060102", a.PickupByDate)
+	if err != nil {
+		envoy.Debugf("error parsing UPS pickup by date %q: %v", a.PickupByDate, err)
+		return nil
+	}
+	return &t
+}
+
+// deliveryProjection returns the latest scheduled delivery date, preferring a
+// rescheduled date over the originally scheduled one, or nil if there is none
+func (p *Package) deliveryProjection() *time.Time {
+	var (
+		projection *time.Time
+		projType   DeliveryDateType
+	)
+	for _, dd := range p.DeliveryDate {
+		if dd.Type != DeliveryDateTypeScheduled && dd.Type != DeliveryDateTypeRescheduled {
+			continue
+		}
+		d, err := time.Parse("20060102", dd.Date)
+		if err != nil {
+			envoy.Debugf("error parsing delivery date %q: %v", dd.Date, err)
+			continue
+		}
+
+		switch {
+		case projection == nil,
+			dd.Type == DeliveryDateTypeRescheduled && projType == DeliveryDateTypeScheduled,
+			dd.Type == projType && d.After(*projection):
+			projection, projType = &d, dd.Type
+		}
+	}
+	return projection
+}
+
 type DeliveryDate struct {
 	Type DeliveryDateType `json:"type"`
 	// The date of this delivery detail. Format: YYYYMMDD
@@ -311,6 +553,39 @@ type Dimension struct {
 	UnitOfDimension string `json:"unitOfDimension"`
 }
 
+// size parses the dimensions, rounded to whole units, or returns nil if any
+// are missing or invalid
+func (d *Dimension) size() *envoy.Size {
+	var sides [3]int
+	for i, v := range []string{d.Length, d.Width, d.Height} {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || f <= 0 {
+			return nil
+		}
+		sides[i] = int(math.Round(f))
+	}
+	return &envoy.Size{
+		Length: sides[0],
+		Width:  sides[1],
+		Height: sides[2],
+		Units:  d.UnitOfDimension,
+	}
+}
+
+type Weight struct {
+	UnitOfMeasurement string `json:"unitOfMeasurement"`
+	Weight            string `json:"weight"`
+}
+
+// dimensioned returns the weight in the units reported, or nil if it is
+// missing
+func (w *Weight) dimensioned() *envoy.Dimensioned {
+	if w == nil || strings.TrimSpace(w.Weight) == "" {
+		return nil
+	}
+	return &envoy.Dimensioned{Units: w.UnitOfMeasurement, Value: strings.TrimSpace(w.Weight)}
+}
+
 type Activity struct {
 	Location *Location `json:"location"`
 	Status   *Status   `json:"status"`
@@ -326,11 +601,72 @@ type Activity struct {
 	GMTOffset string `json:"gmtOffset"`
 }
 
+func (a *Activity) isDelivered() bool {
+	return a.Status != nil && (a.Status.Type == "D" || a.Status.Code == "FS")
+}
+
+// Timestamp returns the time of the activity, in the zone of its GMT offset
+// when UPS reports one. The GMT fields are preferred, falling back to the local
+// date and time only when there is no GMT date. A missing time defaults to
+// midnight, and unparseable activities yield the zero time.
 func (a *Activity) Timestamp() time.Time {
-	t, _ := time.Parse("20060102150405", a.Date+a.Time)
+	if a.GMTDate != "" {
+		return a.gmtTimestamp()
+	}
+	if a.Date == "" {
+		envoy.Debugf("UPS activity has no date: %+v", a)
+		return time.Time{}
+	}
+
+	// Without an offset the local time is all there is, so it is read as UTC
+	loc := a.location()
+	if loc == nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation("20060102150405", a.Date+padTime(a.Time), loc)
+	if err != nil {
+		envoy.Debugf("error parsing UPS activity time %q %q: %v", a.Date, a.Time, err)
+		return time.Time{}
+	}
+	return t
+}
+
+func (a *Activity) gmtTimestamp() time.Time {
+	clock := padTime(strings.ReplaceAll(a.GMTTime, ":", ""))
+	t, err := time.Parse("20060102150405", a.GMTDate+clock)
+	if err != nil {
+		envoy.Debugf("error parsing UPS activity GMT time %q %q: %v", a.GMTDate, a.GMTTime, err)
+		return time.Time{}
+	}
+	if loc := a.location(); loc != nil {
+		t = t.In(loc)
+	}
 	return t
 }
 
+// location returns the fixed zone of the activity's GMT offset, or nil if it
+// has none or it cannot be parsed
+func (a *Activity) location() *time.Location {
+	if a.GMTOffset == "" {
+		return nil
+	}
+	offset, err := time.Parse("-07:00", a.GMTOffset)
+	if err != nil {
+		envoy.Debugf("error parsing UPS activity GMT offset %q: %v", a.GMTOffset, err)
+		return nil
+	}
+	_, secs := offset.Zone()
+	return time.FixedZone("", secs)
+}
+
+// padTime right-pads a possibly empty or truncated HHMMSS time with zeros
+func padTime(hhmmss string) string {
+	if len(hhmmss) >= 6 {
+		return hhmmss
+	}
+	return hhmmss + strings.Repeat("0", 6-len(hhmmss))
+}
+
 type Milestone struct {
 	Code string `json:"code"`
 	// The milestone category. This will be present only when a milestone is in a COMPLETE state.
@@ -376,30 +712,7 @@ type Address struct {
 }
 
 func (a *Address) String() string {
-	sb := strings.Builder{}
-	if a.City != "" {
-		sb.WriteString(a.City)
-		if a.StateProvince != "" {
-			sb.WriteString(", ")
-		}
-	}
-	sb.WriteString(a.StateProvince)
-	if a.PostalCode != "" {
-		if sb.Len() > 0 {
-			sb.WriteString(" ")
-		}
-		sb.WriteString(a.PostalCode)
-	}
-	if a.CountryCode != "US" {
-		if sb.Len() > 0 {
-			sb.WriteString(", ")
-		}
-		sb.WriteString(a.CountryCode)
-	}
-	if sb.Len() == 0 {
-		return "—"
-	}
-	return strings.ToUpper(sb.String())
+	return envoy.FormatLocality(a.City, a.StateProvince, a.PostalCode, a.CountryCode)
 }
 
 type Status struct {
@@ -449,6 +762,10 @@ func (s *Status) ParcelEventType() envoy.ParcelEventType {
 		case "4X":
 			return envoy.ParcelEventTypeTransferredToLocal
 		}
+		// Delivery scans carry many codes, but are all of the delivered type
+		if s.Type == "D" {
+			return envoy.ParcelEventTypeDelivered
+		}
 		return envoy.ParcelEventTypeUnknown
 	}
 }
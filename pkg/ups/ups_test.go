@@ -0,0 +1,534 @@
+package ups
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	envoy "github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/testutil"
+)
+
+func TestActivityTimestamp(t *testing.T) {
+	tests := []struct {
+		name     string
+		activity Activity
+		want     time.Time
+	}{
+		{
+			name:     "date and time",
+			activity: Activity{Date: "20250225", Time: "114800"},
+			want:     time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC),
+		},
+		{
+			name:     "date only",
+			activity: Activity{Date: "20250225"},
+			want:     time.Date(2025, 2, 25, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "short time",
+			activity: Activity{Date: "20250225", Time: "1148"},
+			want:     time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC),
+		},
+		{
+			name: "GMT without local fields",
+			activity: Activity{
+				GMTDate:   "20250225",
+				GMTTime:   "19:48:00",
+				GMTOffset: "-08:00",
+			},
+			want: time.Date(2025, 2, 25, 19, 48, 0, 0, time.UTC),
+		},
+		{
+			name: "GMT preferred over local fields",
+			activity: Activity{
+				Date:      "20250225",
+				Time:      "114800",
+				GMTDate:   "20250225",
+				GMTTime:   "19:48:00",
+				GMTOffset: "-08:00",
+			},
+			want: time.Date(2025, 2, 25, 19, 48, 0, 0, time.UTC),
+		},
+		{
+			name:     "local fields with offset",
+			activity: Activity{Date: "20250225", Time: "114800", GMTOffset: "-08:00"},
+			want:     time.Date(2025, 2, 25, 19, 48, 0, 0, time.UTC),
+		},
+		{
+			name:     "fully empty",
+			activity: Activity{},
+			want:     time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.activity.Timestamp(); !got.Equal(tt.want) {
+				t.Errorf("Timestamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivityTimestampOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		activity Activity
+		want     time.Time
+		offset   int
+	}{
+		{
+			name: "negative offset",
+			activity: Activity{
+				Date:      "20250225",
+				Time:      "114800",
+				GMTDate:   "20250225",
+				GMTTime:   "19:48:00",
+				GMTOffset: "-08:00",
+			},
+			want:   time.Date(2025, 2, 25, 19, 48, 0, 0, time.UTC),
+			offset: -8 * 60 * 60,
+		},
+		{
+			name: "positive offset across midnight",
+			activity: Activity{
+				Date:      "20250226",
+				Time:      "011800",
+				GMTDate:   "20250225",
+				GMTTime:   "19:48:00",
+				GMTOffset: "+05:30",
+			},
+			want:   time.Date(2025, 2, 25, 19, 48, 0, 0, time.UTC),
+			offset: 5*60*60 + 30*60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.activity.Timestamp()
+			if !got.Equal(tt.want) {
+				t.Errorf("Timestamp() = %v, want %v", got, tt.want)
+			}
+			if _, offset := got.Zone(); offset != tt.offset {
+				t.Errorf("Timestamp() offset = %d, want %d", offset, tt.offset)
+			}
+			// The local fields name the same instant as the GMT ones
+			local := Activity{Date: tt.activity.Date, Time: tt.activity.Time, GMTOffset: tt.activity.GMTOffset}
+			if l := local.Timestamp(); !l.Equal(got) {
+				t.Errorf("Timestamp() from local fields = %v, want %v", l, got)
+			}
+		})
+	}
+}
+
+func TestPackageDelivered(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []*Status
+		want     bool
+	}{
+		{"in transit", []*Status{{Type: "I", Code: "OR"}, {Type: "I", Code: "DP"}}, false},
+		{"delivered by type", []*Status{{Type: "I", Code: "OT"}, {Type: "D", Code: "KB"}}, true},
+		{"delivered by code", []*Status{{Type: "I", Code: "OT"}, {Type: "I", Code: "FS"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Package{TrackingNumber: "1ZW701150378674373"}
+			for i, s := range tt.statuses {
+				p.Activity = append(p.Activity, &Activity{
+					Location: &Location{Address: &Address{}},
+					Status:   s,
+					Date:     "20250225",
+					Time:     fmt.Sprintf("%02d0000", i),
+				})
+			}
+
+			if got := p.parcel().Data.Delivered; got != tt.want {
+				t.Errorf("Delivered = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackageDeliveryProjection(t *testing.T) {
+	data, err := os.ReadFile("testdata/estimated_delivery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res response
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	want := map[string]string{
+		// The rescheduled date wins over the originally scheduled one
+		"1Z5R89390357567127": "20250226",
+		"1ZW701150378674373": "20250228",
+		// Actual delivery dates are not projections
+		"1Z999AA10123456784": "",
+	}
+
+	for _, p := range res.TrackResponse.Shipment[0].Package {
+		t.Run(p.TrackingNumber, func(t *testing.T) {
+			got := p.parcel().Data.DeliveryProjection
+			if want[p.TrackingNumber] == "" {
+				if got != nil {
+					t.Errorf("DeliveryProjection = %v, want nil", got)
+				}
+				return
+			}
+			w, _ := time.Parse("20060102", want[p.TrackingNumber])
+			if got == nil || !got.Equal(w) {
+				t.Errorf("DeliveryProjection = %v, want %v", got, w)
+			}
+		})
+	}
+}
+
+func TestPackageDeliveryProjectionDates(t *testing.T) {
+	sdd := func(date string) *DeliveryDate { return &DeliveryDate{Type: DeliveryDateTypeScheduled, Date: date} }
+	rdd := func(date string) *DeliveryDate { return &DeliveryDate{Type: DeliveryDateTypeRescheduled, Date: date} }
+	del := func(date string) *DeliveryDate { return &DeliveryDate{Type: DeliveryDateTypeActual, Date: date} }
+
+	tests := []struct {
+		name  string
+		dates []*DeliveryDate
+		want  string
+	}{
+		{"single scheduled", []*DeliveryDate{sdd("20250226")}, "20250226"},
+		{"latest scheduled", []*DeliveryDate{sdd("20250226"), sdd("20250228"), sdd("20250227")}, "20250228"},
+		{"rescheduled earlier", []*DeliveryDate{sdd("20250227"), rdd("20250226")}, "20250226"},
+		{"rescheduled later", []*DeliveryDate{sdd("20250226"), rdd("20250228")}, "20250228"},
+		{"rescheduled first", []*DeliveryDate{rdd("20250226"), sdd("20250227")}, "20250226"},
+		{"invalid date skipped", []*DeliveryDate{sdd("bogus"), sdd("20250227")}, "20250227"},
+		{"actual only", []*DeliveryDate{del("20250225")}, ""},
+		{"none", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := (&Package{DeliveryDate: tt.dates}).deliveryProjection()
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("deliveryProjection() = %v, want nil", got)
+				}
+				return
+			}
+			want, _ := time.Parse("20060102", tt.want)
+			if got == nil || !got.Equal(want) {
+				t.Errorf("deliveryProjection() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCachedToken(t *testing.T) {
+	s := NewUPSService(nil, "key", "secret")
+	if s.CachedToken() != nil {
+		t.Error("Expected no token before authenticating")
+	}
+
+	expiration := time.Now().Add(time.Hour)
+	s.SetCachedToken(&envoy.CachedToken{Value: "token", Expiration: expiration})
+	if !s.Token.isValid() || s.Token.Value() != "token" || !s.Token.Expiration().Equal(expiration) {
+		t.Errorf("Expected the cached token to be used, got %+v", s.Token)
+	}
+	if got := s.CachedToken(); got == nil || got.Value != "token" || !got.Expiration.Equal(expiration) {
+		t.Errorf("Expected the token to round trip, got %+v", got)
+	}
+}
+
+func TestTrackFirstRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenBody string
+		wantErr   error
+	}{
+		{"issued", `{"access_token":"token","expires_in":"3600"}`, nil},
+		{"missing access token", `{"expires_in":"3600"}`, envoy.ErrAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracked := false
+			// The token endpoint is not relative to BaseURL, so requests are
+			// answered by the transport
+			client := testutil.Client(func(req *http.Request) (*http.Response, error) {
+				body := `{"trackResponse":{"shipment":[{"package":[{"trackingNumber":"1Z5R89390357567127"}]}]}}`
+				if strings.HasSuffix(req.URL.Path, "/oauth/token") {
+					body = tt.tokenBody
+				} else {
+					tracked = true
+					if got := req.Header.Get("Authorization"); got != "Bearer token" {
+						t.Errorf("Expected the issued token to be sent, got %q", got)
+					}
+				}
+				return testutil.JSONResponse(req, http.StatusOK, body), nil
+			})
+
+			// No token is cached on the very first run
+			s := NewUPSService(client, "key", "secret")
+
+			_, err := s.Track([]string{"1Z5R89390357567127"})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Track() error = %v", err)
+				}
+				if !s.Token.isValid() {
+					t.Errorf("Expected a valid token after authenticating, got %+v", s.Token)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Track() error = %v, want %v", err, tt.wantErr)
+			}
+			if tracked {
+				t.Error("Expected no tracking requests without a token")
+			}
+		})
+	}
+}
+
+func TestTrackReportsFailedNumbers(t *testing.T) {
+	const missing = "1ZW701150378674373"
+	client := testutil.Client(func(req *http.Request) (*http.Response, error) {
+		tn := path.Base(req.URL.Path)
+		if tn == missing {
+			return testutil.JSONResponse(req, http.StatusNotFound, `{"response":{"errors":[{"code":"TW0001","message":"Tracking Information Not Found"}]}}`), nil
+		}
+		return testutil.JSONResponse(req, http.StatusOK, `{"trackResponse":{"shipment":[{"package":[{"trackingNumber":"`+tn+`"}]}]}}`), nil
+	})
+
+	s := NewUPSService(client, "key", "secret")
+	s.Token = &Token{value: "token", expiration: time.Now().Add(time.Hour)}
+
+	trackingNumbers := []string{"1Z5R89390357567127", missing, "1Z0000000000000001", "1Z0000000000000002"}
+	parcels, err := s.Track(trackingNumbers)
+	if err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+	if len(parcels) != len(trackingNumbers) {
+		t.Fatalf("Expected %d parcels, got %d", len(trackingNumbers), len(parcels))
+	}
+	for i, p := range parcels {
+		if p.TrackingNumber != trackingNumbers[i] {
+			t.Errorf("Expected parcel %d to be %s, got %s", i, trackingNumbers[i], p.TrackingNumber)
+		}
+		if wantErr := p.TrackingNumber == missing; p.HasError() != wantErr {
+			t.Errorf("%s: HasError() = %v, want %v (error %v)", p.TrackingNumber, p.HasError(), wantErr, p.Error)
+		}
+	}
+	if err := parcels[1].Error; !strings.Contains(err.Error(), "Tracking Information Not Found") {
+		t.Errorf("Expected the error to carry the UPS message, got %q", err)
+	}
+}
+
+func TestPackageProofOfDelivery(t *testing.T) {
+	// A 1x1 transparent PNG and a GIF header
+	const png = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+	const gif = "R0lGODlhAQABAAAAACw="
+
+	p := &Package{DeliveryInformation: &DeliveryInformation{
+		Signature:     &Signature{Image: gif},
+		DeliveryPhoto: &DeliveryPhoto{Photo: png},
+	}}
+	pod, err := p.proofOfDelivery()
+	if err != nil {
+		t.Fatalf("proofOfDelivery() error = %v", err)
+	}
+	if pod.Ext != ".png" {
+		t.Errorf("Expected the delivery photo to be preferred, got %q", pod.Ext)
+	}
+
+	p.DeliveryInformation.DeliveryPhoto = nil
+	if pod, err := p.proofOfDelivery(); err != nil || pod.Ext != ".gif" {
+		t.Errorf("Expected the signature without a photo, got %+v, %v", pod, err)
+	}
+
+	if _, err := (&Package{}).proofOfDelivery(); !errors.Is(err, envoy.ErrNoProofOfDelivery) {
+		t.Errorf("Expected ErrNoProofOfDelivery before delivery, got %v", err)
+	}
+}
+
+func TestPackageRecipient(t *testing.T) {
+	data, err := os.ReadFile("testdata/delivered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res response
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	want := map[string]string{
+		"1Z5R89390357567127": "DOE",
+		// Recipients are only reported once delivered
+		"1ZW701150378674373": "",
+	}
+	for _, p := range res.TrackResponse.Shipment[0].Package {
+		t.Run(p.TrackingNumber, func(t *testing.T) {
+			d := p.parcel().Data
+			if d.ReceivedBy != want[p.TrackingNumber] {
+				t.Errorf("ReceivedBy = %q, want %q", d.ReceivedBy, want[p.TrackingNumber])
+			}
+			if d.SignedBy != "" {
+				t.Errorf("SignedBy = %q, want none", d.SignedBy)
+			}
+		})
+	}
+}
+
+func TestPackageAccessPoint(t *testing.T) {
+	data, err := os.ReadFile("testdata/access_point.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res response
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	d := res.TrackResponse.Shipment[0].Package[0].parcel().Data
+	if !d.DeliveredToAccessPoint || d.AccessPoint != "UPS Access Point™" {
+		t.Errorf("DeliveredToAccessPoint, AccessPoint = %v, %q, want true, %q", d.DeliveredToAccessPoint, d.AccessPoint, "UPS Access Point™")
+	}
+	want := time.Date(2025, 3, 7, 0, 0, 0, 0, time.UTC)
+	if d.PickupBy == nil || !d.PickupBy.Equal(want) {
+		t.Errorf("PickupBy = %v, want %v", d.PickupBy, want)
+	}
+
+	// Packages delivered to the recipient are not at an access point
+	data, err = os.ReadFile("testdata/delivered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delivered response
+	if err := json.Unmarshal(data, &delivered); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+	if d := delivered.TrackResponse.Shipment[0].Package[0].parcel().Data; d.DeliveredToAccessPoint {
+		t.Errorf("Expected a delivery to the recipient, got access point %q", d.AccessPoint)
+	}
+}
+
+func TestPackageWeightAndDimensions(t *testing.T) {
+	data, err := os.ReadFile("testdata/delivered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res response
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	d := res.TrackResponse.Shipment[0].Package[0].parcel().Data
+	if want := (envoy.Dimensioned{Units: "LBS", Value: "5.10"}); d.Weight == nil || *d.Weight != want {
+		t.Errorf("Weight = %+v, want %+v", d.Weight, want)
+	}
+	// Dimensions are rounded to whole units
+	if want := (envoy.Size{Length: 12, Width: 8, Height: 4, Units: "IN"}); d.Dimensions == nil || *d.Dimensions != want {
+		t.Errorf("Dimensions = %+v, want %+v", d.Dimensions, want)
+	}
+
+	d = res.TrackResponse.Shipment[0].Package[1].parcel().Data
+	if d.Weight != nil || d.Dimensions != nil {
+		t.Errorf("Expected no weight or dimensions when unreported, got %+v, %+v", d.Weight, d.Dimensions)
+	}
+}
+
+func TestTrackFixtures(t *testing.T) {
+	tests := []struct {
+		fixture         string
+		trackingNumbers []string
+		want            []testutil.WantParcel
+	}{
+		{
+			fixture:         "delivered.json",
+			trackingNumbers: []string{"1Z5R89390357567127"},
+			want: []testutil.WantParcel{
+				{TrackingNumber: "1Z5R89390357567127", Delivered: true, Events: 2, LastType: envoy.ParcelEventTypeDelivered, LastLocation: "DENVER, CO", LastAt: time.Date(2025, 2, 26, 14, 35, 0, 0, time.UTC)},
+				{TrackingNumber: "1ZW701150378674373", Delivered: false, Events: 1, LastType: envoy.ParcelEventTypeDeparted, LastLocation: "MEMPHIS, TN", LastAt: time.Date(2025, 2, 25, 22, 10, 0, 0, time.UTC)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			body := testutil.Fixture(t, "testdata/"+tt.fixture)
+			client := testutil.Client(func(req *http.Request) (*http.Response, error) {
+				return testutil.JSONResponse(req, http.StatusOK, body), nil
+			})
+			s := NewUPSService(client, "key", "secret")
+			s.Token = &Token{value: "token", expiration: time.Now().Add(time.Hour)}
+
+			parcels, err := s.Track(tt.trackingNumbers)
+			if err != nil {
+				t.Fatalf("Track() error = %v", err)
+			}
+			testutil.CheckParcels(t, parcels, tt.want)
+		})
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []*PackageAddress
+		want      string
+	}{
+		{
+			"origin",
+			[]*PackageAddress{{Type: "DESTINATION", Name: "JANE DOE"}, {Type: "ORIGIN", Name: "ACME SUPPLY CO"}},
+			"ACME SUPPLY CO",
+		},
+		{"any named address", []*PackageAddress{{Type: "ORIGIN"}, {Type: "DESTINATION", Name: "JANE DOE"}}, "JANE DOE"},
+		{"tracking number", nil, "1Z5R89390357567127"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Package{TrackingNumber: "1Z5R89390357567127", PackageAddress: tt.addresses}
+			if got := p.parcel().Name; got != tt.want {
+				t.Errorf("Name = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackageServiceLevel(t *testing.T) {
+	tests := []struct {
+		service *Service
+		want    string
+	}{
+		{&Service{Code: "003", LevelCode: "003", Description: "UPS GROUND"}, "UPS Ground"},
+		{&Service{LevelCode: "002"}, "UPS 2nd Day Air"},
+		{&Service{LevelCode: "59"}, "UPS 2nd Day Air A.M."},
+		{&Service{LevelCode: "999", Description: " UPS Ground Saver "}, "UPS Ground Saver"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		p := &Package{TrackingNumber: "1Z5R89390357567127", Service: tt.service}
+		if got := p.parcel().Data.ServiceLevel; got != tt.want {
+			t.Errorf("ServiceLevel for %+v = %q, want %q", tt.service, got, tt.want)
+		}
+	}
+}
+
+func TestAddressString(t *testing.T) {
+	if got := (&Address{}).String(); got != envoy.EmDash {
+		t.Errorf("String() of an empty address = %q, want %q", got, envoy.EmDash)
+	}
+	a := &Address{City: "Louisville", StateProvince: "KY", PostalCode: "40213", CountryCode: "US"}
+	if got := a.String(); got != "LOUISVILLE, KY 40213" {
+		t.Errorf("String() = %q, want %q", got, "LOUISVILLE, KY 40213")
+	}
+}
@@ -2,38 +2,54 @@ package usps
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/pool"
+	"github.com/rektdeckard/envoy/pkg/retry"
 )
 
 var (
 	BaseURL, _ = url.Parse("https://apis.usps.com")
 )
 
+// The number of labels requested together when none is configured
+const DefaultBatchSize = 10
+
 type USPSService struct {
 	Client         *http.Client
 	ConsumerKey    string
 	ConsumerSecret string
 	Token          *Token
+	// The number of attempts made for tracking requests which fail transiently
+	MaxAttempts int
+	// The number of labels requested together in each tracking request
+	BatchSize int
+	// The number of tracking requests in flight at once
+	MaxConcurrency int
 }
 
-// Enforce that USPSService implements the Service interface
-var _ envoy.Service = &USPSService{}
+// Enforce that USPSService implements the Service and TokenCacher interfaces
+var (
+	_ envoy.Service     = &USPSService{}
+	_ envoy.TokenCacher = &USPSService{}
+)
 
 func NewUSPSService(client *http.Client, consumerKey, consumerSecret string) *USPSService {
 	return &USPSService{
 		Client:         client,
 		ConsumerKey:    consumerKey,
 		ConsumerSecret: consumerSecret,
+		MaxAttempts:    retry.DefaultAttempts,
+		BatchSize:      DefaultBatchSize,
+		MaxConcurrency: pool.DefaultLimit,
 	}
 }
 
@@ -74,7 +90,13 @@ func (s *USPSService) Reauthenticate() error {
 	if err != nil {
 		return err
 	}
+	if err := envoy.CheckJSONResponse(envoy.CarrierUSPS, res, body); err != nil {
+		return err
+	}
 
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: USPS rejected the consumer key or secret (status %d)", envoy.ErrAuth, res.StatusCode)
+	}
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
 	}
@@ -88,6 +110,21 @@ func (s *USPSService) Reauthenticate() error {
 	return nil
 }
 
+// authenticate requests a new token when none is cached or the cached one has
+// expired, guarding against a response which parsed without issuing one
+func (s *USPSService) authenticate() error {
+	if s.Token.IsValid() {
+		return nil
+	}
+	if err := s.Reauthenticate(); err != nil {
+		return err
+	}
+	if s.Token == nil || s.Token.Value == "" {
+		return fmt.Errorf("%w: USPS issued no access token", envoy.ErrAuth)
+	}
+	return nil
+}
+
 func (s *USPSService) Track(trackingNumbers []string) ([]*envoy.Parcel, error) {
 	responses, err := s.TrackRaw(trackingNumbers)
 	if err != nil {
@@ -96,36 +133,118 @@ func (s *USPSService) Track(trackingNumbers []string) ([]*envoy.Parcel, error) {
 
 	parcels := make([]*envoy.Parcel, 0, len(responses))
 	for _, res := range responses {
-		p := &envoy.Parcel{
-			Name:           res.TrackingNumber,
-			Carrier:        envoy.CarrierUSPS,
-			TrackingNumber: res.TrackingNumber,
-			TrackingURL:    "https://tools.usps.com/go/TrackConfirmAction?tLabels=" + res.TrackingNumber,
-			Data: &envoy.ParcelData{
-				Delivered: strings.ToUpper(string(res.StatusCategory)) == "DELIVERED",
-			},
+		parcels = append(parcels, res.parcel())
+	}
+
+	return parcels, nil
+}
+
+func (res *TrackingResponse) parcel() *envoy.Parcel {
+	p := &envoy.Parcel{
+		Name:           res.name(),
+		Carrier:        envoy.CarrierUSPS,
+		TrackingNumber: res.TrackingNumber,
+		TrackingURL:    envoy.TrackingURL(envoy.CarrierUSPS, res.TrackingNumber),
+		Data:           &envoy.ParcelData{},
+		Raw:            envoy.RawJSON(res),
+		Error:          res.Error,
+	}
+	for _, event := range res.TrackingEvents {
+		p.Data.Events = append(p.Data.Events, envoy.ParcelEvent{
+			Type:          event.ParcelEventType(),
+			Description:   string(event.EventType),
+			Location:      event.LocationString(),
+			Timestamp:     event.EventTimestamp.Time,
+			SourceCarrier: envoy.CarrierUSPS,
+		})
+	}
+	p.Data.DeliveryProjection = res.deliveryProjection()
+	p.Data.ServiceLevel = res.MailClass.name()
+	for _, alt := range []string{res.AssociatedLabel, res.UniqueMailPieceID} {
+		if alt != "" {
+			p.AlternateTrackingNumbers = append(p.AlternateTrackingNumbers, alt)
 		}
-		for _, event := range res.TrackingEvents {
-			p.Data.Events = append(p.Data.Events, envoy.ParcelEvent{
-				Type:        event.ParcelEventType(),
-				Description: string(event.EventType),
-				Location:    event.LocationString(),
-				Timestamp:   event.EventTimestamp.Time,
-			})
+	}
+	if res.ReturnDateNotice != "" {
+		p.Data.Notices = append(p.Data.Notices, envoy.ParcelNotice{
+			Code:    "RETURN_DATE",
+			Message: res.ReturnDateNotice,
+		})
+	}
+	envoy.DefaultDeliveredStrategy.Resolve(p, res.isDelivered())
+	if p.Data.Delivered {
+		if name, ok := res.accessPoint(); ok {
+			p.Data.DeliveredToAccessPoint = true
+			p.Data.AccessPoint = name
 		}
-		parcels = append(parcels, p)
 	}
 
-	return parcels, nil
+	return p
 }
 
-func (s *USPSService) TrackRaw(trackingNumbers []string) ([]*TrackingResponse, error) {
-	const endpoint = "/tracking/v3/tracking"
+// name returns the mail class of the label, falling back to its tracking
+// number
+func (res *TrackingResponse) name() string {
+	if n := res.MailClass.name(); n != "" {
+		return n
+	}
+	return res.TrackingNumber
+}
 
-	if s.Token == nil || !s.Token.IsValid() {
-		if err := s.Reauthenticate(); err != nil {
-			return nil, err
+// accessPoint reports whether the latest delivery was to a parcel locker or an
+// agent such as a neighbor, instead of to the recipient, along with the name
+// of the locker or agent if it is known
+func (res *TrackingResponse) accessPoint() (string, bool) {
+	var latest *TrackingEvent
+	for _, e := range res.TrackingEvents {
+		if e == nil || e.ParcelEventType() != envoy.ParcelEventTypeDelivered {
+			continue
 		}
+		if latest == nil || e.EventTimestamp.After(latest.EventTimestamp.Time) {
+			latest = e
+		}
+	}
+	if latest == nil {
+		return "", false
+	}
+
+	name := strings.TrimSpace(latest.Firm)
+	if name == "" {
+		name = strings.TrimSpace(latest.Name)
+	}
+	switch {
+	case strings.Contains(strings.ToUpper(string(latest.EventType)), "PARCEL LOCKER"):
+		if name == "" {
+			name = "Parcel Locker"
+		}
+		return name, true
+	case bool(latest.AuthorizedAgent):
+		return name, true
+	default:
+		return "", false
+	}
+}
+
+// deliveryProjection returns the expected delivery time, falling back to the
+// deprecated predicted delivery time, or nil if neither is known
+func (res *TrackingResponse) deliveryProjection() *time.Time {
+	for _, t := range []time.Time{res.ExpectedDeliveryTimestamp, res.PredictedDeliveryTimestamp} {
+		if !t.IsZero() {
+			return &t
+		}
+	}
+	return nil
+}
+
+func (res *TrackingResponse) isDelivered() bool {
+	return strings.ToUpper(string(res.StatusCategory)) == "DELIVERED"
+}
+
+func (s *USPSService) TrackRaw(trackingNumbers []string) ([]*TrackingResponse, error) {
+	const endpoint = "/tracking/v3r2/tracking"
+
+	if err := s.authenticate(); err != nil {
+		return nil, err
 	}
 
 	params := url.Values{
@@ -133,58 +252,108 @@ func (s *USPSService) TrackRaw(trackingNumbers []string) ([]*TrackingResponse, e
 	}
 	headers := http.Header{
 		"Authorization": []string{"Bearer " + s.Token.Value},
+		"Content-Type":  []string{"application/json"},
+	}
+	size := s.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
 	}
 
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-	var trackingResponses []*TrackingResponse
+	// Each label gets a response, with failures recorded on it rather than
+	// dropped, so that callers can report them
+	trackingResponses := make([]*TrackingResponse, len(trackingNumbers))
 
-	for _, trackingNumber := range trackingNumbers {
-		wg.Add(1)
-		go func(tn string) {
-			defer wg.Done()
+	batches := (len(trackingNumbers) + size - 1) / size
+	pool.Run(s.MaxConcurrency, batches, func(b int) {
+		start := b * size
+		batch := trackingNumbers[start:min(start+size, len(trackingNumbers))]
 
-			u := BaseURL.JoinPath(endpoint, tn)
-			u.RawQuery = params.Encode()
-			req, err := http.NewRequest("GET", u.String(), nil)
-			if err != nil {
-				log.Printf("failed to create request: %v", err)
+		responses, err := s.track(batch, endpoint, params, headers)
+		byNumber := make(map[string]*TrackingResponse, len(responses))
+		for _, res := range responses {
+			if res != nil {
+				byNumber[res.TrackingNumber] = res
+			}
+		}
+		for i, tn := range batch {
+			trackingRes, ok := byNumber[tn]
+			switch {
+			case err != nil:
+				trackingRes = &TrackingResponse{TrackingNumber: tn, Error: err}
+			case !ok:
+				trackingRes = &TrackingResponse{TrackingNumber: tn, Error: fmt.Errorf("no tracking information returned")}
+			case trackingRes.Failure != nil:
+				trackingRes.Error = trackingRes.Failure
 			}
+			trackingResponses[start+i] = trackingRes
+		}
+	})
 
-			req.Header = headers
+	return trackingResponses, nil
+}
 
-			res, err := s.Client.Do(req)
-			if err != nil {
-				log.Printf("failed to make request: %v", err)
-			}
+// Request the labels together, returning a response for each label USPS
+// reported on
+func (s *USPSService) track(trackingNumbers []string, endpoint string, params url.Values, headers http.Header) ([]*TrackingResponse, error) {
+	type label struct {
+		TrackingNumber string `json:"trackingNumber"`
+	}
+	labels := make([]label, 0, len(trackingNumbers))
+	for _, tn := range trackingNumbers {
+		labels = append(labels, label{TrackingNumber: tn})
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request data: %w", err)
+	}
 
-			defer res.Body.Close()
+	u := BaseURL.JoinPath(endpoint)
+	u.RawQuery = params.Encode()
+	res, err := retry.Do(context.Background(), s.MaxAttempts, func() (*http.Response, error) {
+		req, err := http.NewRequest("POST", u.String(), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
 
-			body, err := io.ReadAll(res.Body)
-			if err != nil {
-				log.Printf("failed to read response body: %v", err)
-			}
-			if res.StatusCode != http.StatusOK {
-				// log.Printf("unexpected status code: %d", res.StatusCode)
-			}
+		req.Header = headers
 
-			var trackingRes TrackingResponse
-			if err := json.Unmarshal(body, &trackingRes); err != nil {
-				log.Printf("failed to unmarshal response: %v", err)
-				// TODO: return errors so TUI can display them
-			} else {
-				mu.Lock()
-				trackingResponses = append(trackingResponses, &trackingRes)
-				mu.Unlock()
-			}
+		return s.Client.Do(req)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	defer res.Body.Close()
 
-		}(trackingNumber)
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if err := envoy.CheckJSONResponse(envoy.CarrierUSPS, res, body); err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
 	}
 
-	wg.Wait()
+	var trackingResponses []*TrackingResponse
+	if err := json.Unmarshal(body, &trackingResponses); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
 	return trackingResponses, nil
 }
 
+// TrackingError is reported in place of tracking information for a label
+// which could not be tracked
+type TrackingError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *TrackingError) Error() string {
+	return fmt.Sprintf("USPS error %s: %s", e.Code, e.Message)
+}
+
 // https://developers.usps.com/trackingv3#tag/Resources/operation/get-package-tracking
 type TrackingResponse struct {
 	TrackingNumber              string           `json:"trackingNumber"`
@@ -243,6 +412,10 @@ type TrackingResponse struct {
 	ExtendedRetentionPurchasedCode           string                      `json:"extendedRetentionPurchasedCode"`
 	ExtendedRetentionExtraServiceCodeOptions []*ExtendedRetentionOptions `json:"extendedRetentionExtraServiceCodeOptions"`
 	TrackingEvents                           []*TrackingEvent            `json:"trackingEvents"`
+	// Failure is reported by USPS when the label could not be tracked
+	Failure *TrackingError `json:"error,omitempty"`
+	// Error is set when the label could not be tracked
+	Error error `json:"-"`
 }
 
 type MailClass string
@@ -268,6 +441,25 @@ const (
 	MailClassUSPSRetailGround                 MailClass = "USPS_RETAIL_GROUND"
 )
 
+// name returns the mail class as it is usually written, e.g. "Priority Mail"
+func (c MailClass) name() string {
+	words := strings.Fields(strings.ToLower(strings.ReplaceAll(string(c), "_", " ")))
+	for i, w := range words {
+		if w == "usps" {
+			words[i] = "USPS"
+			continue
+		}
+		parts := strings.Split(w, "-")
+		for j, part := range parts {
+			if part != "" {
+				parts[j] = strings.ToUpper(part[:1]) + part[1:]
+			}
+		}
+		words[i] = strings.Join(parts, "-")
+	}
+	return strings.Join(words, " ")
+}
+
 type ItemShape string
 
 const (
@@ -430,30 +622,7 @@ func (e *TrackingEvent) ParcelEventType() envoy.ParcelEventType {
 }
 
 func (e *TrackingEvent) LocationString() string {
-	sb := strings.Builder{}
-	if e.EventCity != "" {
-		sb.WriteString(e.EventCity)
-		if e.EventState != "" {
-			sb.WriteString(", ")
-		}
-	}
-	sb.WriteString(e.EventState)
-	if e.EventZIP != "" {
-		if sb.Len() > 0 {
-			sb.WriteString(" ")
-		}
-		sb.WriteString(e.EventZIP)
-	}
-	if e.EventCountry != "" && e.EventCountry != "US" {
-		if sb.Len() > 0 {
-			sb.WriteString(", ")
-		}
-		sb.WriteString(e.EventCountry)
-	}
-	if sb.Len() == 0 {
-		return "—"
-	}
-	return strings.ToUpper(sb.String())
+	return envoy.FormatLocality(e.EventCity, e.EventState, e.EventZIP, e.EventCountry)
 }
 
 type ActionCode string
@@ -470,8 +639,22 @@ type Token struct {
 	Expiration time.Time
 }
 
+// IsValid reports whether the token can still be sent, and is safe to call on
+// a nil token before the first authentication
 func (t *Token) IsValid() bool {
-	return t.Expiration.After(time.Now())
+	return t != nil && t.Value != "" && t.Expiration.After(time.Now())
+}
+
+func (s *USPSService) CachedToken() *envoy.CachedToken {
+	if s.Token == nil {
+		return nil
+	}
+	return &envoy.CachedToken{Value: s.Token.Value, Expiration: s.Token.Expiration}
+}
+
+// The public key is not cached, as it is not needed to track
+func (s *USPSService) SetCachedToken(token *envoy.CachedToken) {
+	s.Token = &Token{Value: token.Value, Expiration: token.Expiration}
 }
 
 func (t *Token) UnmarshalJSON(data []byte) error {
@@ -494,11 +677,19 @@ func (t *Token) UnmarshalJSON(data []byte) error {
 	}
 
 	if raw.Status != "approved" {
-		return fmt.Errorf("token status is not approved: %s", raw.Status)
+		return fmt.Errorf(
+			"%w: USPS token not approved (status %q) — check API enrollment",
+			envoy.ErrAuth,
+			raw.Status,
+		)
 	}
 
 	if !strings.Contains(raw.Scope, "tracking") {
-		return fmt.Errorf("token scope does not include tracking: %s", raw.Scope)
+		return fmt.Errorf(
+			"%w: USPS tracking scope not granted (scope %q) — add the Tracking API product to your app",
+			envoy.ErrAuth,
+			raw.Scope,
+		)
 	}
 
 	expiration := time.Now().Add(time.Duration(raw.ExpiresIn) * time.Second)
@@ -0,0 +1,490 @@
+package usps
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rektdeckard/envoy/pkg"
+	"github.com/rektdeckard/envoy/pkg/testutil"
+)
+
+func TestTrackingResponseDelivered(t *testing.T) {
+	timeNow := time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		statusCategory StatusCategory
+		eventCodes     []TrackingEventCode
+		want           bool
+	}{
+		{"in transit", "In Transit", []TrackingEventCode{"ARRIVAL", "DEPARTURE"}, false},
+		{"delivered", "Delivered", []TrackingEventCode{"ARRIVAL", "DELIVERY"}, true},
+		// The carrier status lags behind the delivered event
+		{"disagreement", "In Transit", []TrackingEventCode{"OUT_FOR_DELIVERY", "DELIVERY"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &TrackingResponse{
+				TrackingNumber: "9405511105503530533479",
+				StatusCategory: tt.statusCategory,
+			}
+			for i, code := range tt.eventCodes {
+				res.TrackingEvents = append(res.TrackingEvents, &TrackingEvent{
+					EventCode:      code,
+					EventTimestamp: envoy.LocalDateTime{Time: timeNow.Add(time.Duration(i) * time.Hour)},
+				})
+			}
+
+			if got := res.parcel().Data.Delivered; got != tt.want {
+				t.Errorf("Delivered = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenUnmarshalAuthErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "approved",
+			body:    `{"access_token":"abc","status":"approved","scope":"addresses tracking","expires_in":3600}`,
+			wantErr: "",
+		},
+		{
+			name:    "not approved",
+			body:    `{"access_token":"abc","status":"pending","scope":"tracking","expires_in":3600}`,
+			wantErr: "token not approved",
+		},
+		{
+			name:    "missing scope",
+			body:    `{"access_token":"abc","status":"approved","scope":"addresses prices","expires_in":3600}`,
+			wantErr: "tracking scope not granted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var token Token
+			err := json.Unmarshal([]byte(tt.body), &token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if token.Value != "abc" || !token.IsValid() {
+					t.Errorf("expected a valid token, got %+v", token)
+				}
+				return
+			}
+
+			if !errors.Is(err, envoy.ErrAuth) {
+				t.Fatalf("expected ErrAuth, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error to mention %q, got %q", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// Answer batched tracking requests with the JSON object given for each label
+func labelsHandler(t *testing.T, respond func(tn string) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var labels []struct {
+			TrackingNumber string `json:"trackingNumber"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&labels); err != nil {
+			t.Errorf("Expected a JSON array of labels, got error %v", err)
+		}
+		objects := make([]string, 0, len(labels))
+		for _, l := range labels {
+			objects = append(objects, respond(l.TrackingNumber))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[" + strings.Join(objects, ",") + "]"))
+	}
+}
+
+func TestTrackReportsFailedLabels(t *testing.T) {
+	const missing = "9400123456789012345674"
+	srv := httptest.NewServer(labelsHandler(t, func(tn string) string {
+		if tn == missing {
+			return `{"trackingNumber":"` + tn + `","error":{"code":"404","message":"Not Found"}}`
+		}
+		return `{"trackingNumber":"` + tn + `","statusCategory":"Delivered","trackingEvents":[{"eventType":"Delivered"}]}`
+	}))
+	defer srv.Close()
+
+	baseURL := BaseURL
+	BaseURL, _ = url.Parse(srv.URL)
+	defer func() { BaseURL = baseURL }()
+
+	s := NewUSPSService(srv.Client(), "key", "secret")
+	s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+	trackingNumbers := []string{"9102001234567890123452", missing, "9302001234567890123450"}
+	parcels, err := s.Track(trackingNumbers)
+	if err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+	if len(parcels) != len(trackingNumbers) {
+		t.Fatalf("Expected %d parcels, got %d", len(trackingNumbers), len(parcels))
+	}
+
+	for i, p := range parcels {
+		if p.TrackingNumber != trackingNumbers[i] {
+			t.Errorf("Expected parcel %d to be %s, got %s", i, trackingNumbers[i], p.TrackingNumber)
+		}
+		if wantErr := p.TrackingNumber == missing; p.HasError() != wantErr {
+			t.Errorf("%s: HasError() = %v, want %v (error %v)", p.TrackingNumber, p.HasError(), wantErr, p.Error)
+		}
+	}
+	if err := parcels[1].Error; !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected error to mention the status, got %q", err)
+	}
+}
+
+func TestTrackFirstRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenBody string
+		wantErr   error
+	}{
+		{"issued", `{"access_token":"token","expires_in":3600,"status":"approved","scope":"tracking"}`, nil},
+		{"missing access token", `{"expires_in":3600,"status":"approved","scope":"tracking"}`, envoy.ErrAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracked := false
+			track := labelsHandler(t, func(tn string) string {
+				return `{"trackingNumber":"` + tn + `","statusCategory":"In Transit"}`
+			})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/oauth2/v3/token" {
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(tt.tokenBody))
+					return
+				}
+				tracked = true
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					t.Errorf("Expected the issued token to be sent, got %q", got)
+				}
+				track(w, r)
+			}))
+			defer srv.Close()
+
+			baseURL := BaseURL
+			BaseURL, _ = url.Parse(srv.URL)
+			defer func() { BaseURL = baseURL }()
+
+			// No token is cached on the very first run
+			s := NewUSPSService(srv.Client(), "key", "secret")
+
+			_, err := s.Track([]string{"9400123456789012345674"})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Track() error = %v", err)
+				}
+				if !s.Token.IsValid() {
+					t.Errorf("Expected a valid token after authenticating, got %+v", s.Token)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Track() error = %v, want %v", err, tt.wantErr)
+			}
+			if tracked {
+				t.Error("Expected no tracking requests without a token")
+			}
+		})
+	}
+}
+
+// countingTransport counts the requests made through it
+type countingTransport struct {
+	next     http.RoundTripper
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return c.next.RoundTrip(req)
+}
+
+func TestTrackBatchesLabels(t *testing.T) {
+	srv := httptest.NewServer(labelsHandler(t, func(tn string) string {
+		if tn == "9400000000000000000013" {
+			return `{"trackingNumber":"` + tn + `","error":{"code":"404","message":"Not Found"}}`
+		}
+		return `{"trackingNumber":"` + tn + `","statusCategory":"In Transit"}`
+	}))
+	defer srv.Close()
+
+	baseURL := BaseURL
+	BaseURL, _ = url.Parse(srv.URL)
+	defer func() { BaseURL = baseURL }()
+
+	transport := &countingTransport{next: srv.Client().Transport}
+	s := NewUSPSService(&http.Client{Transport: transport}, "key", "secret")
+	s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+	trackingNumbers := make([]string, 25)
+	for i := range trackingNumbers {
+		trackingNumbers[i] = fmt.Sprintf("94000000000000000000%02d", i)
+	}
+	parcels, err := s.Track(trackingNumbers)
+	if err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+
+	if got := transport.requests.Load(); got != 3 {
+		t.Errorf("Expected 3 requests for 25 labels in batches of %d, got %d", DefaultBatchSize, got)
+	}
+	if len(parcels) != len(trackingNumbers) {
+		t.Fatalf("Expected %d parcels, got %d", len(trackingNumbers), len(parcels))
+	}
+	for i, p := range parcels {
+		if p.TrackingNumber != trackingNumbers[i] {
+			t.Errorf("Expected parcel %d to be %s, got %s", i, trackingNumbers[i], p.TrackingNumber)
+		}
+		if wantErr := i == 13; p.HasError() != wantErr {
+			t.Errorf("%s: HasError() = %v, want %v (error %v)", p.TrackingNumber, p.HasError(), wantErr, p.Error)
+		}
+	}
+}
+
+// inFlightTransport records the most requests made through it at once
+type inFlightTransport struct {
+	next     http.RoundTripper
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (f *inFlightTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		p := f.peak.Load()
+		if n <= p || f.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	// Hold the request open so that concurrent ones overlap
+	time.Sleep(10 * time.Millisecond)
+	return f.next.RoundTrip(req)
+}
+
+func TestTrackLimitsConcurrency(t *testing.T) {
+	srv := httptest.NewServer(labelsHandler(t, func(tn string) string {
+		return `{"trackingNumber":"` + tn + `","statusCategory":"In Transit"}`
+	}))
+	defer srv.Close()
+
+	baseURL := BaseURL
+	BaseURL, _ = url.Parse(srv.URL)
+	defer func() { BaseURL = baseURL }()
+
+	transport := &inFlightTransport{next: srv.Client().Transport}
+	s := NewUSPSService(&http.Client{Transport: transport}, "key", "secret")
+	s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+	s.BatchSize = 1
+	s.MaxConcurrency = 2
+
+	trackingNumbers := make([]string, 10)
+	for i := range trackingNumbers {
+		trackingNumbers[i] = fmt.Sprintf("94000000000000000000%02d", i)
+	}
+	parcels, err := s.Track(trackingNumbers)
+	if err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+	if len(parcels) != len(trackingNumbers) {
+		t.Fatalf("Expected %d parcels, got %d", len(trackingNumbers), len(parcels))
+	}
+	if got := transport.peak.Load(); got > 2 {
+		t.Errorf("Expected at most 2 requests in flight, got %d", got)
+	}
+}
+
+func TestTrackFailedBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":"400","message":"Bad Request"}}`))
+	}))
+	defer srv.Close()
+
+	baseURL := BaseURL
+	BaseURL, _ = url.Parse(srv.URL)
+	defer func() { BaseURL = baseURL }()
+
+	s := NewUSPSService(srv.Client(), "key", "secret")
+	s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+	trackingNumbers := []string{"9102001234567890123452", "9302001234567890123450"}
+	parcels, err := s.Track(trackingNumbers)
+	if err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+	for i, p := range parcels {
+		if p.TrackingNumber != trackingNumbers[i] || !p.HasError() {
+			t.Errorf("Expected %s to report the failed request, got %+v", trackingNumbers[i], p)
+		}
+	}
+}
+
+func TestTrackingResponseDeliveryProjection(t *testing.T) {
+	data, err := os.ReadFile("testdata/estimated_delivery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res TrackingResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	want := time.Date(2025, 2, 27, 21, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	if got := res.parcel().Data.DeliveryProjection; got == nil || !got.Equal(want) {
+		t.Errorf("DeliveryProjection = %v, want %v", got, want)
+	}
+
+	if got := (&TrackingResponse{}).parcel().Data.DeliveryProjection; got != nil {
+		t.Errorf("Expected no projection without an expected delivery, got %v", got)
+	}
+}
+
+func TestTrackingResponseAccessPoint(t *testing.T) {
+	data, err := os.ReadFile("testdata/access_point.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responses []*TrackingResponse
+	if err := json.Unmarshal(data, &responses); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	want := map[string]struct {
+		accessPoint bool
+		name        string
+	}{
+		"9400123456789012345674": {true, "Parcel Locker"},
+		"9405511105503530533479": {true, "ACME APARTMENTS"},
+		"9400111899223197428490": {false, ""},
+	}
+	for _, res := range responses {
+		t.Run(res.TrackingNumber, func(t *testing.T) {
+			d := res.parcel().Data
+			w := want[res.TrackingNumber]
+			if d.DeliveredToAccessPoint != w.accessPoint || d.AccessPoint != w.name {
+				t.Errorf("DeliveredToAccessPoint, AccessPoint = %v, %q, want %v, %q", d.DeliveredToAccessPoint, d.AccessPoint, w.accessPoint, w.name)
+			}
+		})
+	}
+}
+
+func TestTrackFixtures(t *testing.T) {
+	tests := []struct {
+		fixture         string
+		trackingNumbers []string
+		want            []testutil.WantParcel
+	}{
+		{
+			fixture:         "estimated_delivery.json",
+			trackingNumbers: []string{"9400123456789012345674"},
+			want: []testutil.WantParcel{
+				{TrackingNumber: "9400123456789012345674", Delivered: false, Events: 1, LastType: envoy.ParcelEventTypeArrived, LastLocation: "DENVER, CO", LastAt: time.Date(2025, 2, 25, 11, 48, 0, 0, time.UTC)},
+			},
+		},
+		{
+			fixture:         "access_point.json",
+			trackingNumbers: []string{"9400123456789012345674", "9405511105503530533479", "9400111899223197428490"},
+			want: []testutil.WantParcel{
+				{TrackingNumber: "9400123456789012345674", Delivered: true, Events: 2, LastType: envoy.ParcelEventTypeDelivered, LastLocation: "DENVER, CO", LastAt: time.Date(2025, 2, 26, 14, 35, 0, 0, time.UTC)},
+				{TrackingNumber: "9405511105503530533479", Delivered: true, Events: 1, LastType: envoy.ParcelEventTypeDelivered, LastLocation: "BOULDER, CO", LastAt: time.Date(2025, 2, 26, 15, 2, 0, 0, time.UTC)},
+				{TrackingNumber: "9400111899223197428490", Delivered: true, Events: 1, LastType: envoy.ParcelEventTypeDelivered, LastLocation: "GOLDEN, CO", LastAt: time.Date(2025, 2, 26, 12, 40, 0, 0, time.UTC)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			// Labels are tracked in batches, answered with an array
+			labels := testutil.Fixture(t, "testdata/"+tt.fixture)
+			if !strings.HasPrefix(strings.TrimSpace(labels), "[") {
+				labels = "[" + labels + "]"
+			}
+			client := testutil.Client(func(req *http.Request) (*http.Response, error) {
+				return testutil.JSONResponse(req, http.StatusOK, labels), nil
+			})
+			s := NewUSPSService(client, "key", "secret")
+			s.Token = &Token{Value: "token", Expiration: time.Now().Add(time.Hour)}
+
+			parcels, err := s.Track(tt.trackingNumbers)
+			if err != nil {
+				t.Fatalf("Track() error = %v", err)
+			}
+			testutil.CheckParcels(t, parcels, tt.want)
+		})
+	}
+}
+
+func TestTrackingResponseName(t *testing.T) {
+	tests := []struct {
+		class MailClass
+		want  string
+	}{
+		{MailClassPriorityMail, "Priority Mail"},
+		{MailClassFirstClassMail, "First-Class Mail"},
+		{MailClassUSPSRetailGround, "USPS Retail Ground"},
+		{"", "9400123456789012345674"},
+	}
+
+	for _, tt := range tests {
+		res := &TrackingResponse{TrackingNumber: "9400123456789012345674", MailClass: tt.class}
+		if got := res.parcel().Name; got != tt.want {
+			t.Errorf("Name for %q = %q, want %q", tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestTrackingResponseServiceLevel(t *testing.T) {
+	tests := []struct {
+		class MailClass
+		want  string
+	}{
+		{MailClassPriorityMail, "Priority Mail"},
+		{MailClassPriorityMailExpress, "Priority Mail Express"},
+		{MailClassUSPSRetailGround, "USPS Retail Ground"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		res := &TrackingResponse{TrackingNumber: "9400123456789012345674", MailClass: tt.class}
+		if got := res.parcel().Data.ServiceLevel; got != tt.want {
+			t.Errorf("ServiceLevel for %q = %q, want %q", tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestTrackingEventLocationString(t *testing.T) {
+	if got := (&TrackingEvent{}).LocationString(); got != envoy.EmDash {
+		t.Errorf("LocationString() of an event without a location = %q, want %q", got, envoy.EmDash)
+	}
+	e := &TrackingEvent{EventCity: "Seattle", EventState: "WA", EventZIP: "98101"}
+	if got := e.LocationString(); got != "SEATTLE, WA 98101" {
+		t.Errorf("LocationString() = %q, want %q", got, "SEATTLE, WA 98101")
+	}
+}